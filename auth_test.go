@@ -0,0 +1,346 @@
+package kvd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/gnossen/kvd/client"
+	pb "github.com/gnossen/kvd/kvd"
+	"github.com/gnossen/kvd/server"
+)
+
+// dialAs connects to the test server with the given credentials, over
+// transport or else unencrypted.
+func dialAs(t *testing.T, transport grpc.DialOption, opts ...grpc.DialOption) (*grpc.ClientConn, *client.Client, pb.AuthClient) {
+	if transport == nil {
+		transport = grpc.WithInsecure()
+	}
+	conn, err := grpc.Dial("localhost:1234", append(opts, transport)...)
+	if err != nil {
+		t.Fatalf("fail to dial: %v", err)
+	}
+	return conn, client.NewClient(pb.NewKeyValueStoreClient(conn)), pb.NewAuthClient(conn)
+}
+
+func basicAuth(name string, password string) grpc.DialOption {
+	return grpc.WithPerRPCCredentials(client.BasicAuth(name, password))
+}
+
+func expectCode(t *testing.T, err error, code codes.Code) {
+	if status.Code(err) != code {
+		t.Fatalf("Expected %v, got %v", code, err)
+	}
+}
+
+// setUpUsers gives alice read and write access to app/, and bob read and
+// watch access.
+func setUpUsers(t *testing.T, admin pb.AuthClient) {
+	ctx := context.Background()
+	roles := []*pb.Role{
+		{Name: "app", Permissions: []*pb.Permission{{Prefix: "app/", Read: true, Write: true}}},
+		{Name: "viewer", Permissions: []*pb.Permission{{Prefix: "app/", Read: true, Watch: true}}},
+	}
+	for _, role := range roles {
+		if _, err := admin.PutRole(ctx, &pb.PutRoleRequest{Role: role}); err != nil {
+			t.Fatalf("PutRole failed: %v", err)
+		}
+	}
+	if _, err := admin.PutUser(ctx, &pb.PutUserRequest{Name: "alice", Password: "alice's", Roles: []string{"app"}}); err != nil {
+		t.Fatalf("PutUser failed: %v", err)
+	}
+	if _, err := admin.PutUser(ctx, &pb.PutUserRequest{Name: "bob", Password: "bob's", Roles: []string{"viewer"}}); err != nil {
+		t.Fatalf("PutUser failed: %v", err)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	s := startServer(t, server.WithAuth("secret"))
+	defer s.Stop()
+	ctx := context.Background()
+
+	conn, anonymous, anonymousAuth := dialAs(t, nil)
+	defer conn.Close()
+	if _, err := anonymous.Get(ctx, "app/x"); !errors.Is(err, client.ErrUnauthenticated) {
+		t.Fatalf("Expected ErrUnauthenticated, got %v", err)
+	}
+	_, err := anonymousAuth.ListUsers(ctx, &pb.ListUsersRequest{})
+	expectCode(t, err, codes.Unauthenticated)
+	conn, _, wrong := dialAs(t, nil, basicAuth("root", "wrong"))
+	defer conn.Close()
+	_, err = wrong.ListUsers(ctx, &pb.ListUsersRequest{})
+	expectCode(t, err, codes.Unauthenticated)
+
+	conn, root, admin := dialAs(t, nil, basicAuth("root", "secret"))
+	defer conn.Close()
+	setUpUsers(t, admin)
+	if _, err := root.Create(ctx, "other", "1"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	users, err := admin.ListUsers(ctx, &pb.ListUsersRequest{})
+	if err != nil || len(users.Users) != 2 {
+		t.Fatalf("Expected alice and bob, got %v, %v", users, err)
+	}
+
+	conn, alice, aliceAuth := dialAs(t, nil, basicAuth("alice", "alice's"))
+	defer conn.Close()
+	if _, err := alice.Create(ctx, "app/x", "1"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := alice.Create(ctx, "other/x", "1"); !errors.Is(err, client.ErrPermissionDenied) {
+		t.Fatalf("Expected ErrPermissionDenied, got %v", err)
+	}
+	if _, err := alice.Get(ctx, "other"); !errors.Is(err, client.ErrPermissionDenied) {
+		t.Fatalf("Expected ErrPermissionDenied, got %v", err)
+	}
+	_, err = alice.Txn(ctx, &pb.TxnRequest{Failure: []*pb.TxnOp{{Op: &pb.TxnOp_Delete{Delete: "other"}}}})
+	expectCode(t, err, codes.PermissionDenied)
+	if records, err := alice.List(ctx, &pb.ListRecordsRequest{Prefix: "app/"}); err != nil || len(records) != 1 {
+		t.Fatalf("Expected to list app/x, got %v, %v", records, err)
+	}
+	_, err = alice.List(ctx, &pb.ListRecordsRequest{})
+	expectCode(t, err, codes.PermissionDenied)
+	_, err = alice.Watch(ctx, &pb.WatchRecordRequest{Name: "app/", Prefix: true})
+	expectCode(t, err, codes.PermissionDenied)
+	_, err = aliceAuth.ListUsers(ctx, &pb.ListUsersRequest{})
+	expectCode(t, err, codes.PermissionDenied)
+
+	// Bob may watch, but not write.
+	conn, bob, _ := dialAs(t, nil, basicAuth("bob", "bob's"))
+	defer conn.Close()
+	w, err := bob.Watch(ctx, &pb.WatchRecordRequest{Name: "app/", Prefix: true})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer w.Close()
+	_, err = bob.Update(ctx, "app/x", "2")
+	expectCode(t, err, codes.PermissionDenied)
+	alice.Update(ctx, "app/x", "2")
+	expectEvent(t, w, pb.Event_PUT, "app/x", "2")
+
+	// Tokens authenticate until they are revoked.
+	token, err := aliceAuth.CreateToken(ctx, &pb.CreateTokenRequest{})
+	if err != nil {
+		t.Fatalf("CreateToken failed: %v", err)
+	}
+	conn, bearer, _ := dialAs(t, nil, grpc.WithPerRPCCredentials(client.BearerToken(token.Token)))
+	defer conn.Close()
+	if _, err := bearer.Get(ctx, "app/x"); err != nil {
+		t.Fatalf("Get with a token failed: %v", err)
+	}
+	if _, err := admin.PutUser(ctx, &pb.PutUserRequest{Name: "alice", Roles: []string{"app"}, RevokeTokens: true}); err != nil {
+		t.Fatalf("PutUser failed: %v", err)
+	}
+	_, err = bearer.Get(ctx, "app/x")
+	expectCode(t, err, codes.Unauthenticated)
+	if _, err := alice.Get(ctx, "app/x"); err != nil {
+		t.Fatalf("Expected alice's password to be kept, got %v", err)
+	}
+
+	// Users lose the permissions of deleted roles.
+	if _, err := admin.DeleteRole(ctx, &pb.DeleteRoleRequest{Name: "app"}); err != nil {
+		t.Fatalf("DeleteRole failed: %v", err)
+	}
+	_, err = alice.Get(ctx, "app/x")
+	expectCode(t, err, codes.PermissionDenied)
+	if _, err := admin.DeleteUser(ctx, &pb.DeleteUserRequest{Name: "alice"}); err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+	_, err = alice.Get(ctx, "app/x")
+	expectCode(t, err, codes.Unauthenticated)
+}
+
+func TestAuthLeases(t *testing.T) {
+	s := startServer(t, server.WithAuth("secret"))
+	defer s.Stop()
+	ctx := context.Background()
+	conn, root, admin := dialAs(t, nil, basicAuth("root", "secret"))
+	defer conn.Close()
+	setUpUsers(t, admin)
+	conn, alice, _ := dialAs(t, nil, basicAuth("alice", "alice's"))
+	defer conn.Close()
+
+	// Alice may not revoke a lease holding keys she may not write, nor list
+	// its keys.
+	rootLease, err := root.GrantLease(ctx, 60)
+	if err != nil {
+		t.Fatalf("GrantLease failed: %v", err)
+	}
+	if _, err := root.CreateWithLease(ctx, "other", "1", rootLease); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := alice.RevokeLease(ctx, rootLease); !errors.Is(err, client.ErrPermissionDenied) {
+		t.Fatalf("Expected ErrPermissionDenied, got %v", err)
+	}
+	if _, err := alice.LeaseTimeToLive(ctx, rootLease); !errors.Is(err, client.ErrPermissionDenied) {
+		t.Fatalf("Expected ErrPermissionDenied, got %v", err)
+	}
+	if _, err := root.Get(ctx, "other"); err != nil {
+		t.Fatalf("Expected other to be kept, got %v", err)
+	}
+
+	// She may manage leases holding only her keys.
+	lease, err := alice.GrantLease(ctx, 60)
+	if err != nil {
+		t.Fatalf("GrantLease failed: %v", err)
+	}
+	if _, err := alice.CreateWithLease(ctx, "app/x", "1", lease); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	response, err := alice.LeaseTimeToLive(ctx, lease)
+	if err != nil || len(response.Names) != 1 || response.Names[0] != "app/x" {
+		t.Fatalf("Expected app/x, got %v, %v", response, err)
+	}
+	if err := alice.RevokeLease(ctx, lease); err != nil {
+		t.Fatalf("RevokeLease failed: %v", err)
+	}
+	if _, err := alice.Get(ctx, "app/x"); !errors.Is(err, client.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+
+	// Only the user that granted a lease, or root, may use it, even while it
+	// holds no keys.
+	conn, bob, _ := dialAs(t, nil, basicAuth("bob", "bob's"))
+	defer conn.Close()
+	empty, err := alice.GrantLease(ctx, 60)
+	if err != nil {
+		t.Fatalf("GrantLease failed: %v", err)
+	}
+	if err := bob.RevokeLease(ctx, empty); !errors.Is(err, client.ErrPermissionDenied) {
+		t.Fatalf("Expected ErrPermissionDenied, got %v", err)
+	}
+	if _, err := bob.LeaseTimeToLive(ctx, empty); !errors.Is(err, client.ErrPermissionDenied) {
+		t.Fatalf("Expected ErrPermissionDenied, got %v", err)
+	}
+	if _, err := alice.CreateWithLease(ctx, "app/y", "1", rootLease); !errors.Is(err, client.ErrPermissionDenied) {
+		t.Fatalf("Expected ErrPermissionDenied attaching to another user's lease, got %v", err)
+	}
+	if _, err := root.LeaseTimeToLive(ctx, empty); err != nil {
+		t.Fatalf("LeaseTimeToLive failed: %v", err)
+	}
+	if err := root.RevokeLease(ctx, empty); err != nil {
+		t.Fatalf("RevokeLease failed: %v", err)
+	}
+}
+
+func TestReservedKeyspace(t *testing.T) {
+	withServer(t, nil, func(cl pb.KeyValueStoreClient) {
+		ctx := context.Background()
+		c := client.NewClient(cl)
+		conn, _, admin := dialAs(t, nil)
+		defer conn.Close()
+		_, err := admin.PutUser(ctx, &pb.PutUserRequest{Name: "alice"})
+		expectCode(t, err, codes.FailedPrecondition)
+		_, err = c.Create(ctx, "\x00auth/user/alice", "")
+		expectCode(t, err, codes.PermissionDenied)
+	})
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	s := startServer(t, server.WithAuth("secret"), server.WithDataDir(dir))
+	ctx := context.Background()
+	conn, root, admin := dialAs(t, nil, basicAuth("root", "secret"))
+	defer conn.Close()
+	setUpUsers(t, admin)
+	root.Create(ctx, "app/x", "1")
+	if records, err := root.List(ctx, &pb.ListRecordsRequest{}); err != nil || len(records) != 1 {
+		t.Fatalf("Expected the reserved records to be hidden, got %v, %v", records, err)
+	}
+	_, err := root.Get(ctx, "\x00auth/user/alice")
+	expectCode(t, err, codes.PermissionDenied)
+
+	// Users are persisted like any other record.
+	s.Stop()
+	s = startServer(t, server.WithAuth("secret"), server.WithDataDir(dir))
+	defer s.Stop()
+	conn, alice, _ := dialAs(t, nil, basicAuth("alice", "alice's"))
+	defer conn.Close()
+	if _, err := alice.Get(ctx, "app/x"); err != nil {
+		t.Fatalf("Get after restart failed: %v", err)
+	}
+}
+
+func TestAuthFrontEnds(t *testing.T) {
+	withServer(t, []server.ServerOption{server.WithAuth("secret"), server.WithHTTPPort(1235), server.WithRESPPort(1236)}, func(pb.KeyValueStoreClient) {
+		conn, _, admin := dialAs(t, nil, basicAuth("root", "secret"))
+		defer conn.Close()
+		setUpUsers(t, admin)
+
+		send := func(path string, name string, password string, expected int) {
+			req, err := http.NewRequest("POST", gatewayURL+path, nil)
+			if err != nil {
+				t.Fatalf("Bad request: %v", err)
+			}
+			if name != "" {
+				req.SetBasicAuth(name, password)
+			}
+			response, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("POST %s failed: %v", path, err)
+			}
+			response.Body.Close()
+			if response.StatusCode != expected {
+				t.Fatalf("Expected POST %s to return %d, got %d", path, expected, response.StatusCode)
+			}
+		}
+		send("/v1/keys/app/x", "", "", http.StatusUnauthorized)
+		send("/v1/keys/app/x", "alice", "wrong", http.StatusUnauthorized)
+		send("/v1/keys/other", "alice", "alice's", http.StatusForbidden)
+		send("/v1/keys/app/x", "alice", "alice's", http.StatusCreated)
+
+		c := dialRESP(t)
+		defer c.conn.Close()
+		for _, args := range [][]string{{"GET", "app/x"}, {"AUTH", "alice", "wrong"}} {
+			c.send(args...)
+			if _, ok := c.read().(error); !ok {
+				t.Fatalf("Expected %v to fail", args)
+			}
+		}
+		c.expect("OK", "AUTH", "bob", "bob's")
+		c.expect("", "GET", "app/x")
+		c.send("SET", "app/x", "1")
+		if _, ok := c.read().(error); !ok {
+			t.Fatalf("Expected bob's SET to be refused")
+		}
+		c.expect("OK", "AUTH", "secret")
+		c.expect("OK", "SET", "app/x", "1")
+	})
+}
+
+func TestAuthClientCertificate(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, "ca")
+	certPEM, keyPEM := ca.issue(t, "server")
+	s := startServer(t,
+		server.WithTLS(writeFile(t, dir, "server.pem", certPEM), writeFile(t, dir, "server.key", keyPEM), writeFile(t, dir, "ca.pem", ca.pem)),
+		server.WithAuth(""))
+	defer s.Stop()
+	ctx := context.Background()
+
+	conn, _, admin := dialAs(t, withTLS(ca.pool, ca.keyPair(t, "root")))
+	defer conn.Close()
+	setUpUsers(t, admin)
+	conn, alice, _ := dialAs(t, withTLS(ca.pool, ca.keyPair(t, "alice")))
+	defer conn.Close()
+	if _, err := alice.Create(ctx, "app/x", "1"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	_, err := alice.Create(ctx, "other", "1")
+	expectCode(t, err, codes.PermissionDenied)
+	conn, mallory, _ := dialAs(t, withTLS(ca.pool, ca.keyPair(t, "mallory")))
+	defer conn.Close()
+	_, err = mallory.Get(ctx, "app/x")
+	expectCode(t, err, codes.Unauthenticated)
+	// Root has no password, so only its certificate authenticates it.
+	conn, _, admin = dialAs(t, withTLS(ca.pool, ca.keyPair(t, "alice")), basicAuth("root", ""))
+	defer conn.Close()
+	_, err = admin.ListUsers(ctx, &pb.ListUsersRequest{})
+	expectCode(t, err, codes.Unauthenticated)
+}
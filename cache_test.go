@@ -0,0 +1,139 @@
+package kvd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/gnossen/kvd/client"
+	pb "github.com/gnossen/kvd/kvd"
+	"github.com/gnossen/kvd/server"
+)
+
+func expectCached(t *testing.T, cache *client.Cache, name string, value string) {
+	record, err := cache.Get(context.Background(), name)
+	if err != nil {
+		t.Fatalf("Get '%s' failed: %v", name, err)
+	}
+	if record.Value != value {
+		t.Fatalf("Expected '%s' at '%s', got '%s'", value, name, record.Value)
+	}
+}
+
+func expectStats(t *testing.T, cache *client.Cache, expected client.CacheStats) {
+	if stats := cache.Stats(); stats != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, stats)
+	}
+}
+
+func TestCache(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	start := func() func() {
+		return startServer(t, server.WithDataDir(dir)).Stop
+	}
+	stop := start()
+	defer func() { stop() }()
+	conn, err := grpc.Dial("localhost:1234", grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("fail to dial: %v", err)
+	}
+	defer conn.Close()
+	c := client.NewClient(pb.NewKeyValueStoreClient(conn))
+	ctx := context.Background()
+	config := backoff.Config{BaseDelay: 50 * time.Millisecond, Multiplier: 1.6, Jitter: 0.2, MaxDelay: time.Second}
+	for _, name := range []string{"foo", "bar", "baz", "p/1", "p/2"} {
+		if _, err := c.Create(ctx, name, "1"); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+
+	cache, err := client.NewCache(ctx, c, "", client.WithMaxEntries(2), client.WithCacheBackoff(config))
+	if err != nil {
+		t.Fatalf("NewCache failed: %v", err)
+	}
+	defer cache.Close()
+	expectCached(t, cache, "foo", "1")
+	expectCached(t, cache, "foo", "1")
+	expectStats(t, cache, client.CacheStats{Hits: 1, Misses: 1, Entries: 1})
+
+	// The watch keeps cached records current.
+	if _, err := c.Update(ctx, "foo", "2"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	eventually(t, func() error {
+		if record, err := cache.Get(ctx, "foo"); err != nil || record.Value != "2" {
+			return fmt.Errorf("expected '2' at 'foo', got %v, %v", record, err)
+		}
+		return nil
+	})
+	if misses := cache.Stats().Misses; misses != 1 {
+		t.Fatalf("Expected updates to be read from the cache, got %d misses", misses)
+	}
+
+	// The least recently read record is evicted.
+	expectCached(t, cache, "bar", "1")
+	expectCached(t, cache, "baz", "1")
+	if stats := cache.Stats(); stats.Evictions != 1 || stats.Entries != 2 {
+		t.Fatalf("Expected 'foo' to be evicted, got %+v", stats)
+	}
+	if _, err := c.Delete(ctx, "baz"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	eventually(t, func() error {
+		if entries := cache.Stats().Entries; entries != 1 {
+			return fmt.Errorf("expected the deleted record to leave the cache, got %d entries", entries)
+		}
+		return nil
+	})
+	if _, err := cache.Get(ctx, "baz"); status.Code(err) != codes.NotFound {
+		t.Fatalf("Expected NotFound, got %v", err)
+	}
+
+	prefixed, err := client.NewCache(ctx, c, "p/", client.WithCacheBackoff(config))
+	if err != nil {
+		t.Fatalf("NewCache failed: %v", err)
+	}
+	defer prefixed.Close()
+	if err := prefixed.Preload(ctx, "q/"); err == nil {
+		t.Fatalf("Expected an error preloading outside the cache's prefix")
+	}
+	if err := prefixed.Preload(ctx, "p/"); err != nil {
+		t.Fatalf("Preload failed: %v", err)
+	}
+	expectCached(t, prefixed, "p/1", "1")
+	expectStats(t, prefixed, client.CacheStats{Hits: 1, Entries: 2})
+
+	// Losing the watch drops everything, and the preloaded records are
+	// loaded again once it is back.
+	stop()
+	stop = start()
+	eventually(t, func() error {
+		if stats := prefixed.Stats(); stats.Invalidations != 1 || stats.Entries != 2 {
+			return fmt.Errorf("expected the preloaded records to be reloaded, got %+v", stats)
+		}
+		return nil
+	})
+	eventually(t, func() error {
+		if stats := cache.Stats(); stats.Invalidations != 1 {
+			return fmt.Errorf("expected the cache to be invalidated, got %+v", stats)
+		}
+		return nil
+	})
+	if _, err := c.Update(ctx, "bar", "2"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	eventually(t, func() error {
+		if record, err := cache.Get(ctx, "bar"); err != nil || record.Value != "2" {
+			return fmt.Errorf("expected '2' at 'bar', got %v, %v", record, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,142 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+
+	pb "github.com/gnossen/kvd/kvd"
+)
+
+// authorization sends fixed "authorization" metadata with each call. It does
+// not require transport security, so credentials sent without TLS can be
+// read by anyone on the network.
+type authorization string
+
+func (a authorization) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": string(a)}, nil
+}
+
+func (a authorization) RequireTransportSecurity() bool {
+	return false
+}
+
+// BasicAuth returns credentials that authenticate each call as the user name
+// with password. Pass them to grpc.WithPerRPCCredentials.
+func BasicAuth(name string, password string) credentials.PerRPCCredentials {
+	return authorization("Basic " + base64.StdEncoding.EncodeToString([]byte(name+":"+password)))
+}
+
+// BearerToken returns credentials that authenticate each call with a token
+// from CreateToken. Pass them to grpc.WithPerRPCCredentials.
+func BearerToken(token string) credentials.PerRPCCredentials {
+	return authorization("Bearer " + token)
+}
+
+func PutUser(client pb.AuthClient, request *pb.PutUserRequest) *pb.User {
+	user, err := client.PutUser(context.Background(), request)
+	if err != nil {
+		log.Fatalf("Putting user failed: %v", err)
+	}
+	return user
+}
+
+func DeleteUser(client pb.AuthClient, name string) *pb.User {
+	user, err := client.DeleteUser(context.Background(), &pb.DeleteUserRequest{Name: name})
+	if err != nil {
+		log.Fatalf("Deleting user failed: %v", err)
+	}
+	return user
+}
+
+func ListUsers(client pb.AuthClient) []*pb.User {
+	response, err := client.ListUsers(context.Background(), &pb.ListUsersRequest{})
+	if err != nil {
+		log.Fatalf("Listing users failed: %v", err)
+	}
+	return response.Users
+}
+
+func PutRole(client pb.AuthClient, role *pb.Role) *pb.Role {
+	role, err := client.PutRole(context.Background(), &pb.PutRoleRequest{Role: role})
+	if err != nil {
+		log.Fatalf("Putting role failed: %v", err)
+	}
+	return role
+}
+
+func DeleteRole(client pb.AuthClient, name string) *pb.Role {
+	role, err := client.DeleteRole(context.Background(), &pb.DeleteRoleRequest{Name: name})
+	if err != nil {
+		log.Fatalf("Deleting role failed: %v", err)
+	}
+	return role
+}
+
+func ListRoles(client pb.AuthClient) []*pb.Role {
+	response, err := client.ListRoles(context.Background(), &pb.ListRolesRequest{})
+	if err != nil {
+		log.Fatalf("Listing roles failed: %v", err)
+	}
+	return response.Roles
+}
+
+// CreateToken issues a bearer token to the user name, or to the caller if
+// name is empty.
+func CreateToken(client pb.AuthClient, name string) string {
+	response, err := client.CreateToken(context.Background(), &pb.CreateTokenRequest{Name: name})
+	if err != nil {
+		log.Fatalf("Creating token failed: %v", err)
+	}
+	return response.Token
+}
+
+// ParsePermission parses a permission like "rw:prefix", granting read (r),
+// write (w) and watch (x) access to the records whose names begin with
+// prefix.
+func ParsePermission(s string) (*pb.Permission, error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return nil, fmt.Errorf("permission '%s' is not of the form access:prefix", s)
+	}
+	permission := &pb.Permission{Prefix: s[i+1:]}
+	for _, access := range s[:i] {
+		switch access {
+		case 'r':
+			permission.Read = true
+		case 'w':
+			permission.Write = true
+		case 'x':
+			permission.Watch = true
+		default:
+			return nil, fmt.Errorf("unknown access '%c' in permission '%s'", access, s)
+		}
+	}
+	return permission, nil
+}
+
+func PrintUser(user *pb.User) {
+	fmt.Printf("%s: roles %s\n", user.Name, strings.Join(user.Roles, ", "))
+}
+
+func PrintRole(role *pb.Role) {
+	var permissions []string
+	for _, permission := range role.Permissions {
+		access := ""
+		if permission.Read {
+			access += "r"
+		}
+		if permission.Write {
+			access += "w"
+		}
+		if permission.Watch {
+			access += "x"
+		}
+		permissions = append(permissions, fmt.Sprintf("%s:'%s'", access, permission.Prefix))
+	}
+	fmt.Printf("%s: %s\n", role.Name, strings.Join(permissions, ", "))
+}
@@ -0,0 +1,294 @@
+package client
+
+import (
+	list "container/list"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/backoff"
+
+	pb "github.com/gnossen/kvd/kvd"
+)
+
+// CacheStats counts what a Cache has done.
+type CacheStats struct {
+	// Reads served from the cache.
+	Hits int64
+	// Reads sent to the server.
+	Misses int64
+	// Records dropped to keep the cache within its maximum size.
+	Evictions int64
+	// Times the whole cache was dropped because its watch missed events.
+	Invalidations int64
+	// Records cached now.
+	Entries int
+}
+
+type cacheOptions struct {
+	maxEntries int
+	backoff    backoff.Config
+}
+
+type CacheOption func(*cacheOptions)
+
+// WithMaxEntries keeps at most n records, evicting the least recently read
+// first. Zero means no limit.
+func WithMaxEntries(n int) CacheOption {
+	return func(o *cacheOptions) {
+		o.maxEntries = n
+	}
+}
+
+// WithCacheBackoff reconnects the cache's watch with config instead of
+// backoff.DefaultConfig.
+func WithCacheBackoff(config backoff.Config) CacheOption {
+	return func(o *cacheOptions) {
+		o.backoff = config
+	}
+}
+
+// cacheEntry is a cached record, which is current as of revision.
+type cacheEntry struct {
+	record   *pb.Record
+	revision int64
+}
+
+// Cache serves reads of the records under a prefix from a local copy, which
+// a watch keeps up to date. Records are cached when they are first read or
+// when their prefix is preloaded. Whenever the watch may have missed events,
+// because its stream failed or the server called for a resync, the whole
+// cache is dropped, and reads go to the server until the watch is back.
+//
+// Writes made through the client reach the cache once the watch delivers
+// them, so a read just after a write may return the old value. Records
+// returned by the cache are shared, and must not be modified.
+type Cache struct {
+	client  *Client
+	prefix  string
+	options cacheOptions
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // List[*cacheEntry], most recently read first.
+	lru     *list.List
+	// The revision up to which the watch has delivered events. Reads older
+	// than it may have missed an event, and are not cached.
+	revision int64
+	// Whether the watch is up; if not, nothing is cached.
+	connected bool
+	preloaded []string
+	stats     CacheStats
+}
+
+// NewCache returns a cache of the records under prefix, or of every record
+// if prefix is empty, which lasts until ctx ends or it is closed. It fails
+// if the watch cannot be started. If the watch is later rejected, for
+// example for lack of permission, reads go to the server.
+func NewCache(ctx context.Context, client *Client, prefix string, opts ...CacheOption) (*Cache, error) {
+	options := cacheOptions{backoff: backoff.DefaultConfig}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	c := &Cache{
+		client:  client,
+		prefix:  prefix,
+		options: options,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+	w, err := c.watch(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go c.run(ctx, w)
+	return c, nil
+}
+
+// Close stops the cache's watch. Reads still work, but go to the server.
+func (c *Cache) Close() error {
+	c.cancel()
+	<-c.done
+	return nil
+}
+
+// Get reads the record at name, from the cache if it is there.
+func (c *Cache) Get(ctx context.Context, name string) (*pb.Record, error) {
+	c.mu.Lock()
+	if elem, exists := c.entries[name]; exists {
+		c.lru.MoveToFront(elem)
+		c.stats.Hits++
+		record := elem.Value.(*cacheEntry).record
+		c.mu.Unlock()
+		return record, nil
+	}
+	c.stats.Misses++
+	c.mu.Unlock()
+	record, revision, err := c.client.GetWithRequest(ctx, &pb.GetRecordRequest{Name: name})
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.addLocked(record, revision)
+	c.mu.Unlock()
+	return record, nil
+}
+
+// Preload caches every record under prefix, which must be within the
+// cache's prefix, and caches them again whenever the cache is dropped.
+func (c *Cache) Preload(ctx context.Context, prefix string) error {
+	if !strings.HasPrefix(prefix, c.prefix) {
+		return fmt.Errorf("prefix '%s' is not within the cache's prefix '%s'", prefix, c.prefix)
+	}
+	c.mu.Lock()
+	c.preloaded = append(c.preloaded, prefix)
+	c.mu.Unlock()
+	return c.load(ctx, prefix)
+}
+
+// Stats returns what the cache has done so far.
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	return stats
+}
+
+// load caches every record under prefix.
+func (c *Cache) load(ctx context.Context, prefix string) error {
+	request := &pb.ListRecordsRequest{Prefix: prefix}
+	for {
+		response, err := c.client.kv.ListRecords(ctx, request)
+		if err != nil {
+			return convertError(err)
+		}
+		c.mu.Lock()
+		for _, record := range response.Records {
+			c.addLocked(record, response.Revision)
+		}
+		c.mu.Unlock()
+		if response.NextPageToken == "" {
+			return nil
+		}
+		request.PageToken = response.NextPageToken
+	}
+}
+
+// addLocked caches record, which was read at revision, unless the watch
+// may already have skipped a newer version of it.
+func (c *Cache) addLocked(record *pb.Record, revision int64) {
+	if !c.connected || revision < c.revision || !strings.HasPrefix(record.Name, c.prefix) {
+		return
+	}
+	if elem, exists := c.entries[record.Name]; exists {
+		entry := elem.Value.(*cacheEntry)
+		if entry.revision < revision {
+			entry.record, entry.revision = record, revision
+		}
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[record.Name] = c.lru.PushFront(&cacheEntry{record: record, revision: revision})
+	for c.options.maxEntries > 0 && c.lru.Len() > c.options.maxEntries {
+		oldest := c.lru.Remove(c.lru.Back()).(*cacheEntry)
+		delete(c.entries, oldest.record.Name)
+		c.stats.Evictions++
+	}
+}
+
+// apply updates the cache with event, reporting whether the watch is still
+// in step with the server.
+func (c *Cache) apply(event *pb.Event) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if event.Type == pb.Event_RESYNC {
+		return false
+	}
+	revision := event.Record.ModRevision
+	if revision > c.revision {
+		c.revision = revision
+	}
+	elem, exists := c.entries[event.Record.Name]
+	if !exists || elem.Value.(*cacheEntry).revision >= revision {
+		return true
+	}
+	switch event.Type {
+	case pb.Event_PUT:
+		elem.Value = &cacheEntry{record: event.Record, revision: revision}
+	case pb.Event_DELETE:
+		c.lru.Remove(elem)
+		delete(c.entries, event.Record.Name)
+	}
+	return true
+}
+
+// disconnect drops every cached record, and caches no more until the watch
+// is back.
+func (c *Cache) disconnect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.connected = false
+}
+
+// watch starts the cache's watch, and caches records from then on.
+func (c *Cache) watch(ctx context.Context) (*Watcher, error) {
+	w, err := c.client.Watch(ctx, &pb.WatchRecordRequest{Name: c.prefix, Prefix: true})
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.revision = w.Revision()
+	c.connected = true
+	preloaded := append([]string(nil), c.preloaded...)
+	c.mu.Unlock()
+	for _, prefix := range preloaded {
+		// A failed load leaves those records to be read on demand.
+		c.load(ctx, prefix)
+	}
+	return w, nil
+}
+
+// run applies the watch's events until ctx ends, starting a new watch
+// whenever it falls out of step.
+func (c *Cache) run(ctx context.Context, w *Watcher) {
+	defer close(c.done)
+	for {
+		for event := range w.Events() {
+			if !c.apply(event) {
+				break
+			}
+		}
+		w.Close()
+		err := w.Err()
+		c.disconnect()
+		if ctx.Err() != nil || (err != nil && !retryable(err)) {
+			return
+		}
+		c.mu.Lock()
+		c.stats.Invalidations++
+		c.mu.Unlock()
+		for retries := 0; ; retries++ {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoffDelay(c.options.backoff, retries)):
+			}
+			if w, err = c.watch(ctx); err == nil {
+				break
+			}
+			if !retryable(err) {
+				return
+			}
+		}
+	}
+}
@@ -6,6 +6,11 @@ import (
 	"io"
 	"log"
 	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	pb "github.com/gnossen/kvd/kvd"
 )
@@ -20,6 +25,17 @@ func Create(client pb.KeyValueStoreClient, name string, value string) *pb.Record
 	return record
 }
 
+// CreateWithLease creates a record that is deleted when lease ends.
+func CreateWithLease(client pb.KeyValueStoreClient, name string, value string, lease int64) *pb.Record {
+	request := pb.CreateRecordRequest{Record: &pb.Record{Name: name, Value: value, Lease: lease}}
+	var record *pb.Record
+	var err error
+	if record, err = client.CreateRecord(context.Background(), &request); err != nil {
+		log.Fatalf("Creation failed: %v", err)
+	}
+	return record
+}
+
 func Update(client pb.KeyValueStoreClient, name string, value string) *pb.Record {
 	request := pb.UpdateRecordRequest{Record: &pb.Record{Name: name, Value: value}}
 	var record *pb.Record
@@ -30,43 +46,223 @@ func Update(client pb.KeyValueStoreClient, name string, value string) *pb.Record
 	return record
 }
 
+// CompareAndSwap updates the record at name to value only if its
+// mod_revision is still modRevision. It returns false if the record has
+// changed in the meantime.
+func CompareAndSwap(client pb.KeyValueStoreClient, name string, value string, modRevision int64) (*pb.Record, bool) {
+	request := pb.UpdateRecordRequest{
+		Record:              &pb.Record{Name: name, Value: value},
+		ExpectedModRevision: modRevision,
+	}
+	record, err := client.UpdateRecord(context.Background(), &request)
+	if status.Code(err) == codes.Aborted {
+		return nil, false
+	}
+	if err != nil {
+		log.Fatalf("Update failed: %v", err)
+	}
+	return record, true
+}
+
 func Get(client pb.KeyValueStoreClient, name string) *pb.Record {
-	request := pb.GetRecordRequest{Name: name}
+	record, _ := GetWithRequest(client, &pb.GetRecordRequest{Name: name})
+	return record
+}
+
+// GetWithRequest reads a record with the consistency given in request, and
+// returns it with the store revision it was read at.
+func GetWithRequest(client pb.KeyValueStoreClient, request *pb.GetRecordRequest) (*pb.Record, int64) {
+	record, revision, err := NewClient(client).GetWithRequest(context.Background(), request)
+	if err != nil {
+		log.Fatalf("Get operation failed: %v", err)
+	}
+	return record, revision
+}
+
+func Delete(client pb.KeyValueStoreClient, name string) *pb.Record {
+	request := pb.DeleteRecordRequest{Name: name}
 	var record *pb.Record
 	var err error
-	if record, err = client.GetRecord(context.Background(), &request); err != nil {
-		log.Fatalf("Get operation failed: %v", err)
+	if record, err = client.DeleteRecord(context.Background(), &request); err != nil {
+		log.Fatalf("Delete operation failed: %v", err)
 	}
 	return record
 }
 
-func Watch(client pb.KeyValueStoreClient, name string, watchCount int) chan *pb.Record{
-	c := make(chan *pb.Record)
+// CompareAndDelete deletes the record at name only if its mod_revision is
+// still modRevision. It returns false if the record has changed in the
+// meantime.
+func CompareAndDelete(client pb.KeyValueStoreClient, name string, modRevision int64) (*pb.Record, bool) {
+	request := pb.DeleteRecordRequest{Name: name, ExpectedModRevision: modRevision}
+	record, err := client.DeleteRecord(context.Background(), &request)
+	if status.Code(err) == codes.Aborted {
+		return nil, false
+	}
+	if err != nil {
+		log.Fatalf("Delete operation failed: %v", err)
+	}
+	return record, true
+}
+
+// List returns every record matching request, following pages as needed.
+func List(client pb.KeyValueStoreClient, request *pb.ListRecordsRequest) []*pb.Record {
+	request = proto.Clone(request).(*pb.ListRecordsRequest)
+	var records []*pb.Record
+	for {
+		response, err := client.ListRecords(context.Background(), request)
+		if err != nil {
+			log.Fatalf("List operation failed: %v", err)
+		}
+		records = append(records, response.Records...)
+		if response.NextPageToken == "" {
+			return records
+		}
+		request.PageToken = response.NextPageToken
+	}
+}
+
+// Count returns the number of records matching request.
+func Count(client pb.KeyValueStoreClient, request *pb.ListRecordsRequest) int64 {
+	request = proto.Clone(request).(*pb.ListRecordsRequest)
+	request.CountOnly = true
+	response, err := client.ListRecords(context.Background(), request)
+	if err != nil {
+		log.Fatalf("Count operation failed: %v", err)
+	}
+	return response.Count
+}
+
+// GrantLease returns the ID of a new lease that ends ttlSeconds from now
+// unless it is kept alive.
+func GrantLease(client pb.KeyValueStoreClient, ttlSeconds int64) int64 {
+	response, err := client.GrantLease(context.Background(), &pb.GrantLeaseRequest{TtlSeconds: ttlSeconds})
+	if err != nil {
+		log.Fatalf("Lease grant failed: %v", err)
+	}
+	return response.Id
+}
+
+// RevokeLease ends lease id, deleting every record attached to it.
+func RevokeLease(client pb.KeyValueStoreClient, id int64) {
+	if _, err := client.RevokeLease(context.Background(), &pb.RevokeLeaseRequest{Id: id}); err != nil {
+		log.Fatalf("Lease revocation failed: %v", err)
+	}
+}
+
+// KeepAlive renews lease id every interval until the returned function is
+// called or the lease ends.
+func KeepAlive(client pb.KeyValueStoreClient, id int64, interval time.Duration) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.KeepAlive(ctx)
+	if err != nil {
+		log.Fatalf("Failed to keep lease %d alive: %v", id, err)
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			var response *pb.LeaseResponse
+			err := stream.Send(&pb.KeepAliveRequest{Id: id})
+			if err == nil {
+				response, err = stream.Recv()
+			} else {
+				// Send fails once the stream is done; Recv reports why.
+				_, err = stream.Recv()
+			}
+			if status.Code(err) == codes.Canceled {
+				return
+			}
+			if err != nil {
+				log.Fatalf("Failed to keep lease %d alive: %v", id, err)
+			}
+			if response.TtlSeconds == 0 {
+				log.Printf("Lease %d has ended.", id)
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return cancel
+}
+
+// LeaseTimeToLive describes lease id, including the names attached to it.
+func LeaseTimeToLive(client pb.KeyValueStoreClient, id int64) *pb.LeaseTimeToLiveResponse {
+	request := pb.LeaseTimeToLiveRequest{Id: id, Names: true}
+	response, err := client.LeaseTimeToLive(context.Background(), &request)
+	if err != nil {
+		log.Fatalf("Lease lookup failed: %v", err)
+	}
+	return response
+}
+
+// Txn applies request atomically and reports which branch was taken.
+func Txn(client pb.KeyValueStoreClient, request *pb.TxnRequest) *pb.TxnResponse {
+	response, err := client.Txn(context.Background(), request)
+	if err != nil {
+		log.Fatalf("Transaction failed: %v", err)
+	}
+	return response
+}
+
+func Watch(client pb.KeyValueStoreClient, name string, watchCount int) chan *pb.Event {
+	return WatchWithRequest(client, &pb.WatchRecordRequest{Name: name}, watchCount)
+}
+
+// WatchPrefix watches every record whose name begins with prefix.
+func WatchPrefix(client pb.KeyValueStoreClient, prefix string, watchCount int) chan *pb.Event {
+	return WatchWithRequest(client, &pb.WatchRecordRequest{Name: prefix, Prefix: true}, watchCount)
+}
+
+// WatchRange watches every record with start <= name < end.
+func WatchRange(client pb.KeyValueStoreClient, start string, end string, watchCount int) chan *pb.Event {
+	return WatchWithRequest(client, &pb.WatchRecordRequest{Name: start, RangeEnd: end}, watchCount)
+}
+
+// IsCompacted reports whether err means that a watch could not resume from
+// its start revision because the server no longer retains those events.
+func IsCompacted(err error) bool {
+	return status.Code(err) == codes.OutOfRange
+}
+
+// WatchWithRequest delivers up to watchCount events matching request, or
+// every event if watchCount is negative.
+func WatchWithRequest(client pb.KeyValueStoreClient, request *pb.WatchRecordRequest, watchCount int) chan *pb.Event {
+	c := make(chan *pb.Event)
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
-		request := pb.WatchRecordRequest{Name: name}
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
-		stream, err := client.WatchRecord(ctx, &request)
+		stream, err := client.WatchRecord(ctx, request)
+		if err == nil {
+			// The server sends headers once the watch is registered.
+			_, err = stream.Header()
+		}
 		wg.Done()
+		if IsCompacted(err) {
+			log.Fatalf("Cannot resume watch on key '%s'. Re-read it and watch again: %v", request.Name, err)
+		}
 		if err != nil {
-			log.Fatalf("Failed to watch key '%s': %v", name, err)
+			log.Fatalf("Failed to watch key '%s': %v", request.Name, err)
 		}
-		var record *pb.Record
-		recordCount := 0
+		var event *pb.Event
+		eventCount := 0
 		for {
-			if watchCount >= 0 && recordCount == watchCount {
+			if watchCount >= 0 && eventCount == watchCount {
 				break
 			}
-			if record, err = stream.Recv(); err == io.EOF {
+			if event, err = stream.Recv(); err == io.EOF {
 				break
 			}
 			if err != nil {
 				log.Fatalf("Encountered error: %v", err)
 			}
-			c <- record
-			recordCount += 1
+			c <- event
+			eventCount += 1
 		}
 		close(c)
 	}()
@@ -75,6 +271,21 @@ func Watch(client pb.KeyValueStoreClient, name string, watchCount int) chan *pb.
 }
 
 func PrintRecord(record *pb.Record) {
-	fmt.Printf("'%s': '%s'\n", record.Name, record.Value)
+	fmt.Printf("'%s': '%s' (version %d, mod_revision %d)\n",
+		record.Name, record.Value, record.Version, record.ModRevision)
+}
+
+func PrintEvent(event *pb.Event) {
+	switch event.Type {
+	case pb.Event_PUT:
+		fmt.Printf("PUT '%s': '%s' (version %d, mod_revision %d)\n",
+			event.Record.Name, event.Record.Value, event.Record.Version, event.Record.ModRevision)
+	case pb.Event_DELETE:
+		fmt.Printf("DELETE '%s' (mod_revision %d)\n", event.Record.Name, event.Record.ModRevision)
+	case pb.Event_RESYNC:
+		fmt.Printf("RESYNC '%s'\n", event.Record.Name)
+	case pb.Event_PROGRESS:
+		fmt.Printf("PROGRESS (mod_revision %d)\n", event.Record.ModRevision)
+	}
 }
 
@@ -1,60 +1,306 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"github.com/gnossen/kvd/client"
 	"log"
+	"strings"
+	"time"
 	pb "github.com/gnossen/kvd/kvd"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 )
 
 var (
 	serverAddr = flag.String("server_addr", "localhost:50051", "The server address in the format of host:port")
+	useTLS     = flag.Bool("tls", false, "Connect using TLS. Implied by the other TLS flags.")
+	caFile     = flag.String("ca_file", "", "The CAs trusted to sign the server's certificate. The system's CAs if empty.")
+	certFile   = flag.String("cert_file", "", "The client certificate to present to the server.")
+	keyFile    = flag.String("key_file", "", "The private key of -cert_file.")
+	serverName = flag.String("server_name", "", "The name to verify the server's certificate against. The host of -server_addr if empty.")
+	user       = flag.String("user", "", "Authenticate as this user, with -password.")
+	password   = flag.String("password", "", "The password of -user.")
+	token      = flag.String("token", "", "Authenticate with this bearer token.")
 )
 
+// dialOption returns the credentials selected by the TLS flags.
+func dialOption() grpc.DialOption {
+	if !*useTLS && *caFile == "" && *certFile == "" && *serverName == "" {
+		return grpc.WithInsecure()
+	}
+	config := &tls.Config{ServerName: *serverName}
+	if *caFile != "" {
+		data, err := ioutil.ReadFile(*caFile)
+		if err != nil {
+			log.Fatalf("invalid -ca_file: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			log.Fatalf("invalid -ca_file: no certificates found in '%s'", *caFile)
+		}
+	}
+	if *certFile != "" {
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			log.Fatalf("invalid -cert_file: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(config))
+}
+
+// dialOptions returns the credentials selected by the TLS and
+// authentication flags.
+func dialOptions() []grpc.DialOption {
+	opts := []grpc.DialOption{dialOption()}
+	if *token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(client.BearerToken(*token)))
+	} else if *user != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(client.BasicAuth(*user, *password)))
+	}
+	return opts
+}
+
+// splitList splits a comma-separated list, which may be empty.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func parseConsistency(name string) pb.Consistency {
+	consistency, ok := pb.Consistency_value[strings.ToUpper(name)]
+	if !ok {
+		log.Fatalf("Unknown consistency '%s'", name)
+	}
+	return pb.Consistency(consistency)
+}
+
 func main() {
 	createCmd := flag.NewFlagSet("create", flag.ExitOnError)
 	createName := createCmd.String("name", "", "The name to create.")
 	createValue := createCmd.String("value", "", "The value with which to create.")
+	createLease := createCmd.Int64("lease", 0, "If nonzero, delete the record when this lease ends.")
 
 	updateCmd := flag.NewFlagSet("update", flag.ExitOnError)
 	updateName := updateCmd.String("name", "", "The name to update.")
 	updateValue := updateCmd.String("value", "", "The value to update.")
+	updateLease := updateCmd.Int64("lease", 0, "If nonzero, delete the record when this lease ends.")
+	updateModRevision := updateCmd.Int64("mod_revision", 0, "If nonzero, update only if the record is still at this mod_revision.")
 
 	getCmd := flag.NewFlagSet("get", flag.ExitOnError)
 	getName := getCmd.String("name", "", "The name to get.")
+	getConsistency := getCmd.String("consistency", "linearizable", "linearizable, serializable or bounded_staleness.")
+	getMaxRevisionLag := getCmd.Int64("max_revision_lag", 0, "The number of revisions a bounded_staleness read may lag by.")
+
+	deleteCmd := flag.NewFlagSet("delete", flag.ExitOnError)
+	deleteName := deleteCmd.String("name", "", "The name to delete.")
+	deleteModRevision := deleteCmd.Int64("mod_revision", 0, "If nonzero, delete only if the record is still at this mod_revision.")
+
+	listCmd := flag.NewFlagSet("list", flag.ExitOnError)
+	listPrefix := listCmd.String("prefix", "", "List only names with this prefix.")
+	listStart := listCmd.String("start", "", "The first name to list, inclusive.")
+	listEnd := listCmd.String("end", "", "The name at which to stop listing, exclusive.")
+	listPageSize := listCmd.Int("page_size", 0, "The number of records to fetch per request.")
+	listKeysOnly := listCmd.Bool("keys_only", false, "List only names.")
+	listCount := listCmd.Bool("count", false, "Print only the number of matching records.")
+	listConsistency := listCmd.String("consistency", "linearizable", "linearizable, serializable or bounded_staleness.")
+	listMaxRevisionLag := listCmd.Int64("max_revision_lag", 0, "The number of revisions a bounded_staleness read may lag by.")
 
 	watchCmd := flag.NewFlagSet("watch", flag.ExitOnError)
-	watchName := watchCmd.String("name", "", "The name to watch.")
+	watchName := watchCmd.String("name", "", "The name to watch, or the start of the range to watch.")
+	watchPrefix := watchCmd.Bool("prefix", false, "Watch every name beginning with -name.")
+	watchRangeEnd := watchCmd.String("range_end", "", "Watch every name from -name up to, but excluding, this one.")
+	watchStartRevision := watchCmd.Int64("start_revision", 0, "If nonzero, first replay the changes since this revision.")
+
+	grantCmd := flag.NewFlagSet("grant", flag.ExitOnError)
+	grantTTL := grantCmd.Int64("ttl", 60, "The number of seconds the lease lasts without a keepalive.")
+
+	revokeCmd := flag.NewFlagSet("revoke", flag.ExitOnError)
+	revokeLease := revokeCmd.Int64("lease", 0, "The lease to revoke.")
+
+	keepAliveCmd := flag.NewFlagSet("keepalive", flag.ExitOnError)
+	keepAliveLease := keepAliveCmd.Int64("lease", 0, "The lease to keep alive.")
+	keepAliveInterval := keepAliveCmd.Duration("interval", time.Second, "How often to renew the lease.")
+
+	ttlCmd := flag.NewFlagSet("ttl", flag.ExitOnError)
+	ttlLease := ttlCmd.Int64("lease", 0, "The lease to describe.")
+
+	userCmd := flag.NewFlagSet("user", flag.ExitOnError)
+	userName := userCmd.String("name", "", "The user to create or replace.")
+	userPassword := userCmd.String("password", "", "The user's new password. Empty keeps the current password.")
+	userRoles := userCmd.String("roles", "", "The comma-separated roles granted to the user.")
+	userRevokeTokens := userCmd.Bool("revoke_tokens", false, "Invalidate every token issued to the user.")
+
+	deleteUserCmd := flag.NewFlagSet("deleteuser", flag.ExitOnError)
+	deleteUserName := deleteUserCmd.String("name", "", "The user to delete.")
+
+	roleCmd := flag.NewFlagSet("role", flag.ExitOnError)
+	roleName := roleCmd.String("name", "", "The role to create or replace.")
+	rolePermissions := roleCmd.String("permissions", "", "Comma-separated permissions like rwx:prefix, granting read (r), write (w) and watch (x) access to names beginning with prefix.")
+
+	deleteRoleCmd := flag.NewFlagSet("deleterole", flag.ExitOnError)
+	deleteRoleName := deleteRoleCmd.String("name", "", "The role to delete.")
+
+	tokenCmd := flag.NewFlagSet("token", flag.ExitOnError)
+	tokenName := tokenCmd.String("name", "", "The user to issue a token to. Empty means the caller.")
 
 	flag.Parse()
-	conn, err := grpc.Dial(*serverAddr, []grpc.DialOption{grpc.WithInsecure()}...)
+	conn, err := grpc.Dial(*serverAddr, dialOptions()...)
 	if err != nil {
 		log.Fatalf("fail to dial: %v", err)
 	}
 	defer conn.Close()
 	cl := pb.NewKeyValueStoreClient(conn)
+	auth := pb.NewAuthClient(conn)
 
-	if len(flag.Args()) < 2 {
+	if len(flag.Args()) < 1 {
 		log.Fatalf("Expected a command.")
 	}
 
 	switch flag.Args()[0] {
 	case "create":
 		createCmd.Parse(flag.Args()[1:])
-		client.PrintRecord(client.Create(cl, *createName, *createValue))
+		client.PrintRecord(client.CreateWithLease(cl, *createName, *createValue, *createLease))
 	case "update":
 		updateCmd.Parse(flag.Args()[1:])
-		client.PrintRecord(client.Update(cl, *updateName, *updateValue))
+		request := pb.UpdateRecordRequest{
+			Record:              &pb.Record{Name: *updateName, Value: *updateValue, Lease: *updateLease},
+			ExpectedModRevision: *updateModRevision,
+		}
+		record, err := cl.UpdateRecord(context.Background(), &request)
+		if status.Code(err) == codes.Aborted {
+			log.Fatalf("Record '%s' is no longer at mod_revision %d.", *updateName, *updateModRevision)
+		}
+		if err != nil {
+			log.Fatalf("Update failed: %v", err)
+		}
+		client.PrintRecord(record)
 	case "get":
 		getCmd.Parse(flag.Args()[1:])
-		client.PrintRecord(client.Get(cl, *getName))
+		request := pb.GetRecordRequest{
+			Name:           *getName,
+			Consistency:    parseConsistency(*getConsistency),
+			MaxRevisionLag: *getMaxRevisionLag,
+		}
+		record, revision := client.GetWithRequest(cl, &request)
+		client.PrintRecord(record)
+		fmt.Printf("Read at revision %d\n", revision)
+	case "delete":
+		deleteCmd.Parse(flag.Args()[1:])
+		if *deleteModRevision == 0 {
+			client.PrintRecord(client.Delete(cl, *deleteName))
+			break
+		}
+		record, ok := client.CompareAndDelete(cl, *deleteName, *deleteModRevision)
+		if !ok {
+			log.Fatalf("Record '%s' is no longer at mod_revision %d.", *deleteName, *deleteModRevision)
+		}
+		client.PrintRecord(record)
+	case "list":
+		listCmd.Parse(flag.Args()[1:])
+		request := pb.ListRecordsRequest{
+			Prefix:         *listPrefix,
+			Start:          *listStart,
+			End:            *listEnd,
+			PageSize:       int32(*listPageSize),
+			KeysOnly:       *listKeysOnly,
+			Consistency:    parseConsistency(*listConsistency),
+			MaxRevisionLag: *listMaxRevisionLag,
+		}
+		if *listCount {
+			fmt.Println(client.Count(cl, &request))
+			break
+		}
+		for _, record := range client.List(cl, &request) {
+			if *listKeysOnly {
+				fmt.Printf("'%s'\n", record.Name)
+			} else {
+				client.PrintRecord(record)
+			}
+		}
 	case "watch":
 		watchCmd.Parse(flag.Args()[1:])
-		for record := range client.Watch(cl, *watchName, -1) {
-			client.PrintRecord(record)
+		request := pb.WatchRecordRequest{
+			Name:          *watchName,
+			Prefix:        *watchPrefix,
+			RangeEnd:      *watchRangeEnd,
+			StartRevision: *watchStartRevision,
+		}
+		// Keep watching through server restarts.
+		w, err := client.NewClient(cl).ReconnectingWatch(context.Background(), &request, backoff.DefaultConfig)
+		if err != nil {
+			log.Fatalf("Failed to watch key '%s': %v", request.Name, err)
+		}
+		for event := range w.Events() {
+			client.PrintEvent(event)
+		}
+		if err := w.Err(); err != nil {
+			log.Fatalf("Watch failed: %v", err)
+		}
+	case "grant":
+		grantCmd.Parse(flag.Args()[1:])
+		fmt.Println(client.GrantLease(cl, *grantTTL))
+	case "revoke":
+		revokeCmd.Parse(flag.Args()[1:])
+		client.RevokeLease(cl, *revokeLease)
+	case "keepalive":
+		keepAliveCmd.Parse(flag.Args()[1:])
+		client.KeepAlive(cl, *keepAliveLease, *keepAliveInterval)
+		select {}
+	case "ttl":
+		ttlCmd.Parse(flag.Args()[1:])
+		response := client.LeaseTimeToLive(cl, *ttlLease)
+		fmt.Printf("Lease %d: %ds remaining of %ds\n", response.Id, response.TtlSeconds, response.GrantedTtlSeconds)
+		for _, name := range response.Names {
+			fmt.Printf("'%s'\n", name)
+		}
+	case "user":
+		userCmd.Parse(flag.Args()[1:])
+		client.PrintUser(client.PutUser(auth, &pb.PutUserRequest{
+			Name:         *userName,
+			Password:     *userPassword,
+			Roles:        splitList(*userRoles),
+			RevokeTokens: *userRevokeTokens,
+		}))
+	case "deleteuser":
+		deleteUserCmd.Parse(flag.Args()[1:])
+		client.PrintUser(client.DeleteUser(auth, *deleteUserName))
+	case "users":
+		for _, user := range client.ListUsers(auth) {
+			client.PrintUser(user)
+		}
+	case "role":
+		roleCmd.Parse(flag.Args()[1:])
+		role := &pb.Role{Name: *roleName}
+		for _, s := range splitList(*rolePermissions) {
+			permission, err := client.ParsePermission(s)
+			if err != nil {
+				log.Fatalf("invalid -permissions: %v", err)
+			}
+			role.Permissions = append(role.Permissions, permission)
+		}
+		client.PrintRole(client.PutRole(auth, role))
+	case "deleterole":
+		deleteRoleCmd.Parse(flag.Args()[1:])
+		client.PrintRole(client.DeleteRole(auth, *deleteRoleName))
+	case "roles":
+		for _, role := range client.ListRoles(auth) {
+			client.PrintRole(role)
 		}
+	case "token":
+		tokenCmd.Parse(flag.Args()[1:])
+		fmt.Println(client.CreateToken(auth, *tokenName))
 	default:
-		log.Fatalf("Unsupported command '%s'", flag.Args()[1])
+		log.Fatalf("Unsupported command '%s'", flag.Args()[0])
 	}
 }
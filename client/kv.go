@@ -0,0 +1,362 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/gnossen/kvd/kvd"
+)
+
+// Errors returned by Client. They can be matched with errors.Is, and
+// status.Code still reports the gRPC code they came from.
+var (
+	// ErrNotFound means the record or lease does not exist.
+	ErrNotFound = errors.New("not found")
+
+	// ErrAlreadyExists means a record being created already exists.
+	ErrAlreadyExists = errors.New("already exists")
+
+	// ErrConflict means a record changed since the expected version or
+	// mod_revision.
+	ErrConflict = errors.New("conflict")
+
+	// ErrCompacted means a watch could not resume from its start revision
+	// because the server no longer retains those events.
+	ErrCompacted = errors.New("compacted")
+
+	// ErrPermissionDenied means the caller's roles do not allow the request.
+	ErrPermissionDenied = errors.New("permission denied")
+
+	// ErrUnauthenticated means the server requires credentials and those
+	// given, if any, are invalid.
+	ErrUnauthenticated = errors.New("unauthenticated")
+)
+
+var sentinels = map[codes.Code]error{
+	codes.NotFound:         ErrNotFound,
+	codes.AlreadyExists:    ErrAlreadyExists,
+	codes.Aborted:          ErrConflict,
+	codes.OutOfRange:       ErrCompacted,
+	codes.PermissionDenied: ErrPermissionDenied,
+	codes.Unauthenticated:  ErrUnauthenticated,
+}
+
+// statusError is a gRPC error that matches a sentinel error.
+type statusError struct {
+	sentinel error
+	status   *status.Status
+}
+
+func (e *statusError) Error() string {
+	return e.status.Message()
+}
+
+func (e *statusError) Unwrap() error {
+	return e.sentinel
+}
+
+func (e *statusError) GRPCStatus() *status.Status {
+	return e.status
+}
+
+// convertError wraps err in the matching sentinel error, if there is one.
+func convertError(err error) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	if sentinel, ok := sentinels[st.Code()]; ok {
+		return &statusError{sentinel: sentinel, status: st}
+	}
+	return err
+}
+
+// Client is a KeyValueStoreClient whose methods return errors instead of
+// exiting, for use in long-running programs.
+type Client struct {
+	kv pb.KeyValueStoreClient
+}
+
+func NewClient(kv pb.KeyValueStoreClient) *Client {
+	return &Client{kv: kv}
+}
+
+// Get reads the record at name with linearizable consistency.
+func (c *Client) Get(ctx context.Context, name string) (*pb.Record, error) {
+	record, _, err := c.GetWithRequest(ctx, &pb.GetRecordRequest{Name: name})
+	return record, err
+}
+
+// GetWithRequest reads a record with the consistency given in request, and
+// returns it with the store revision it was read at.
+func (c *Client) GetWithRequest(ctx context.Context, request *pb.GetRecordRequest) (*pb.Record, int64, error) {
+	var header metadata.MD
+	record, err := c.kv.GetRecord(ctx, request, grpc.Header(&header))
+	if err != nil {
+		return nil, 0, convertError(err)
+	}
+	values := header.Get("read-revision")
+	if len(values) == 0 {
+		return nil, 0, status.Errorf(codes.Internal, "Server did not send the read revision.")
+	}
+	revision, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return nil, 0, status.Errorf(codes.Internal, "Invalid read revision '%s'.", values[0])
+	}
+	return record, revision, nil
+}
+
+// Create creates a record, failing with ErrAlreadyExists if it exists.
+func (c *Client) Create(ctx context.Context, name string, value string) (*pb.Record, error) {
+	return c.CreateWithLease(ctx, name, value, 0)
+}
+
+// CreateWithLease creates a record that is deleted when lease ends.
+func (c *Client) CreateWithLease(ctx context.Context, name string, value string, lease int64) (*pb.Record, error) {
+	request := pb.CreateRecordRequest{Record: &pb.Record{Name: name, Value: value, Lease: lease}}
+	record, err := c.kv.CreateRecord(ctx, &request)
+	return record, convertError(err)
+}
+
+// Update replaces the value of a record, failing with ErrNotFound if it
+// does not exist.
+func (c *Client) Update(ctx context.Context, name string, value string) (*pb.Record, error) {
+	request := pb.UpdateRecordRequest{Record: &pb.Record{Name: name, Value: value}}
+	record, err := c.kv.UpdateRecord(ctx, &request)
+	return record, convertError(err)
+}
+
+// CompareAndSwap updates the record at name to value only if its
+// mod_revision is still modRevision, failing with ErrConflict otherwise.
+func (c *Client) CompareAndSwap(ctx context.Context, name string, value string, modRevision int64) (*pb.Record, error) {
+	request := pb.UpdateRecordRequest{
+		Record:              &pb.Record{Name: name, Value: value},
+		ExpectedModRevision: modRevision,
+	}
+	record, err := c.kv.UpdateRecord(ctx, &request)
+	return record, convertError(err)
+}
+
+// Delete deletes a record and returns it as it was.
+func (c *Client) Delete(ctx context.Context, name string) (*pb.Record, error) {
+	record, err := c.kv.DeleteRecord(ctx, &pb.DeleteRecordRequest{Name: name})
+	return record, convertError(err)
+}
+
+// CompareAndDelete deletes the record at name only if its mod_revision is
+// still modRevision, failing with ErrConflict otherwise.
+func (c *Client) CompareAndDelete(ctx context.Context, name string, modRevision int64) (*pb.Record, error) {
+	request := pb.DeleteRecordRequest{Name: name, ExpectedModRevision: modRevision}
+	record, err := c.kv.DeleteRecord(ctx, &request)
+	return record, convertError(err)
+}
+
+// List returns every record matching request, following pages as needed.
+func (c *Client) List(ctx context.Context, request *pb.ListRecordsRequest) ([]*pb.Record, error) {
+	request = proto.Clone(request).(*pb.ListRecordsRequest)
+	var records []*pb.Record
+	for {
+		response, err := c.kv.ListRecords(ctx, request)
+		if err != nil {
+			return nil, convertError(err)
+		}
+		records = append(records, response.Records...)
+		if response.NextPageToken == "" {
+			return records, nil
+		}
+		request.PageToken = response.NextPageToken
+	}
+}
+
+// Count returns the number of records matching request.
+func (c *Client) Count(ctx context.Context, request *pb.ListRecordsRequest) (int64, error) {
+	request = proto.Clone(request).(*pb.ListRecordsRequest)
+	request.CountOnly = true
+	response, err := c.kv.ListRecords(ctx, request)
+	if err != nil {
+		return 0, convertError(err)
+	}
+	return response.Count, nil
+}
+
+// Txn applies request atomically and reports which branch was taken.
+func (c *Client) Txn(ctx context.Context, request *pb.TxnRequest) (*pb.TxnResponse, error) {
+	response, err := c.kv.Txn(ctx, request)
+	return response, convertError(err)
+}
+
+// GrantLease returns the ID of a new lease that ends ttlSeconds from now
+// unless it is kept alive.
+func (c *Client) GrantLease(ctx context.Context, ttlSeconds int64) (int64, error) {
+	response, err := c.kv.GrantLease(ctx, &pb.GrantLeaseRequest{TtlSeconds: ttlSeconds})
+	if err != nil {
+		return 0, convertError(err)
+	}
+	return response.Id, nil
+}
+
+// RevokeLease ends lease id, deleting every record attached to it.
+func (c *Client) RevokeLease(ctx context.Context, id int64) error {
+	_, err := c.kv.RevokeLease(ctx, &pb.RevokeLeaseRequest{Id: id})
+	return convertError(err)
+}
+
+// LeaseTimeToLive describes lease id, including the names attached to it.
+func (c *Client) LeaseTimeToLive(ctx context.Context, id int64) (*pb.LeaseTimeToLiveResponse, error) {
+	response, err := c.kv.LeaseTimeToLive(ctx, &pb.LeaseTimeToLiveRequest{Id: id, Names: true})
+	return response, convertError(err)
+}
+
+// KeepAlive renews lease id every interval until ctx is done, when it returns
+// nil, or the lease ends, when it returns ErrNotFound.
+func (c *Client) KeepAlive(ctx context.Context, id int64, interval time.Duration) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := c.kv.KeepAlive(ctx)
+	if err != nil {
+		return convertError(err)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		var response *pb.LeaseResponse
+		err := stream.Send(&pb.KeepAliveRequest{Id: id})
+		if err == nil {
+			response, err = stream.Recv()
+		} else {
+			// Send fails once the stream is done; Recv reports why.
+			_, err = stream.Recv()
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return convertError(err)
+		}
+		if response.TtlSeconds == 0 {
+			return &statusError{
+				sentinel: ErrNotFound,
+				status:   status.Newf(codes.NotFound, "Lease %d has ended.", id),
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Watcher delivers the events of a WatchRecord stream until it is closed.
+type Watcher struct {
+	events   chan *pb.Event
+	revision int64
+	cancel   context.CancelFunc
+	done     chan struct{}
+	err      error
+}
+
+// openWatch starts a WatchRecord stream and waits for it to be registered.
+func (c *Client) openWatch(ctx context.Context, request *pb.WatchRecordRequest) (pb.KeyValueStore_WatchRecordClient, int64, error) {
+	stream, err := c.kv.WatchRecord(ctx, request)
+	if err != nil {
+		return nil, 0, err
+	}
+	revision, err := watchRevision(stream)
+	return stream, revision, err
+}
+
+// watchRevision waits for the watch to be registered and returns the
+// revision the server sent then. A stream that ends without that header
+// failed before it was registered.
+func watchRevision(stream pb.KeyValueStore_WatchRecordClient) (int64, error) {
+	header, err := stream.Header()
+	if err != nil {
+		return 0, err
+	}
+	values := header.Get("watch-revision")
+	if len(values) == 0 {
+		_, err := stream.Recv()
+		if err == nil || err == io.EOF {
+			err = status.Errorf(codes.Internal, "Watch ended before it was registered.")
+		}
+		return 0, err
+	}
+	revision, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return 0, status.Errorf(codes.Internal, "Invalid watch revision '%s'.", values[0])
+	}
+	return revision, nil
+}
+
+// Watch starts watching the records matching request. It fails with
+// ErrCompacted if the request's start revision is no longer retained.
+func (c *Client) Watch(ctx context.Context, request *pb.WatchRecordRequest) (*Watcher, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stream, revision, err := c.openWatch(ctx, request)
+	if err != nil {
+		cancel()
+		return nil, convertError(err)
+	}
+	w := &Watcher{
+		events:   make(chan *pb.Event),
+		revision: revision,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go func() {
+		defer close(w.done)
+		defer close(w.events)
+		for {
+			event, err := stream.Recv()
+			if err != nil {
+				if err != io.EOF && ctx.Err() == nil {
+					w.err = convertError(err)
+				}
+				return
+			}
+			select {
+			case w.events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return w, nil
+}
+
+// Revision returns a revision after which every matching event is delivered.
+func (w *Watcher) Revision() int64 {
+	return w.revision
+}
+
+// Events returns the watched events. It is closed when the watch ends.
+func (w *Watcher) Events() <-chan *pb.Event {
+	return w.events
+}
+
+// Err returns why the watch ended once Events is closed. It is nil if the
+// watch was closed or its context ended.
+func (w *Watcher) Err() error {
+	<-w.done
+	return w.err
+}
+
+// Close ends the watch.
+func (w *Watcher) Close() error {
+	w.cancel()
+	<-w.done
+	return nil
+}
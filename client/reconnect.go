@@ -0,0 +1,212 @@
+package client
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/gnossen/kvd/kvd"
+)
+
+// retryableCodes are the stream errors a reconnecting watch recovers from.
+var retryableCodes = map[codes.Code]bool{
+	codes.Canceled:          true,
+	codes.Unknown:           true,
+	codes.DeadlineExceeded:  true,
+	codes.ResourceExhausted: true,
+	codes.Aborted:           true,
+	codes.Internal:          true,
+	codes.Unavailable:       true,
+}
+
+// maxUnexpectedFailures is how many times in a row a reconnecting watch
+// retries after codes.Internal or codes.Unknown, which may come from a fault
+// that retrying will not fix, before it ends with the error.
+const maxUnexpectedFailures = 5
+
+func retryable(err error) bool {
+	return err == io.EOF || retryableCodes[status.Code(err)]
+}
+
+func unexpected(err error) bool {
+	code := status.Code(err)
+	return code == codes.Internal || code == codes.Unknown
+}
+
+// backoffDelay returns how long to wait before the given retry, counting
+// from 0.
+func backoffDelay(config backoff.Config, retries int) time.Duration {
+	delay, max := float64(config.BaseDelay), float64(config.MaxDelay)
+	for ; delay < max && retries > 0; retries-- {
+		delay *= config.Multiplier
+	}
+	if delay > max {
+		delay = max
+	}
+	delay *= 1 + config.Jitter*(rand.Float64()*2-1)
+	if delay < 0 {
+		return 0
+	}
+	return time.Duration(delay)
+}
+
+// watchCursor tracks the events a reconnecting watch has delivered, so that
+// it can resume after them. A transaction may change several records at one
+// revision, so the names delivered at the latest revision are kept too.
+type watchCursor struct {
+	revision int64
+	// Whether every event at revision has been delivered.
+	complete bool
+	names    map[string]bool
+}
+
+func newWatchCursor(revision int64) *watchCursor {
+	return &watchCursor{revision: revision, complete: true}
+}
+
+// deliver reports whether event is new, recording it if so.
+func (c *watchCursor) deliver(event *pb.Event) bool {
+	switch event.Type {
+	case pb.Event_RESYNC:
+		return true
+	case pb.Event_PROGRESS:
+		if event.Record.ModRevision < c.revision {
+			return false
+		}
+		c.revision = event.Record.ModRevision
+		c.complete = true
+		return true
+	}
+	revision := event.Record.ModRevision
+	if revision < c.revision || (revision == c.revision && (c.complete || c.names[event.Record.Name])) {
+		return false
+	}
+	if revision > c.revision {
+		c.revision = revision
+		c.complete = false
+		c.names = make(map[string]bool)
+	}
+	c.names[event.Record.Name] = true
+	return true
+}
+
+// startRevision returns the revision to resume watching from.
+func (c *watchCursor) startRevision() int64 {
+	if c.complete {
+		return c.revision + 1
+	}
+	return c.revision
+}
+
+// ReconnectingWatch is like Watch, except that when the stream fails it
+// reconnects with exponential backoff and resumes after the last event
+// delivered, so that events arrive in order without gaps or repeats. If the
+// server no longer retains the missed events, or has lost its state, a
+// RESYNC event is delivered instead, after which the watched records must be
+// re-read. PROGRESS events are only delivered if request asks for them. The
+// watch only ends with an error if the server rejects it, for example with
+// codes.InvalidArgument, or fails with codes.Internal or codes.Unknown more
+// than maxUnexpectedFailures times without an event in between.
+func (c *Client) ReconnectingWatch(ctx context.Context, request *pb.WatchRecordRequest, config backoff.Config) (*Watcher, error) {
+	// Progress events tell when the latest revision has been delivered in
+	// full, so that the watch can resume after it.
+	progressNotify := request.ProgressNotify
+	request = proto.Clone(request).(*pb.WatchRecordRequest)
+	request.ProgressNotify = true
+	ctx, cancel := context.WithCancel(ctx)
+	stream, revision, err := c.openWatch(ctx, request)
+	if err != nil {
+		cancel()
+		return nil, convertError(err)
+	}
+	w := &Watcher{
+		events:   make(chan *pb.Event),
+		revision: revision,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	cursor := newWatchCursor(revision)
+	if request.StartRevision != 0 {
+		cursor = newWatchCursor(request.StartRevision - 1)
+	}
+	send := func(event *pb.Event) bool {
+		select {
+		case w.events <- event:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	resync := func() bool {
+		return send(&pb.Event{Type: pb.Event_RESYNC, Record: &pb.Record{Name: request.Name}})
+	}
+	go func() {
+		defer close(w.done)
+		defer close(w.events)
+		// Internal and Unknown errors since the latest event.
+		failures := 0
+		for {
+			var err error
+			for {
+				var event *pb.Event
+				if event, err = stream.Recv(); err != nil {
+					break
+				}
+				failures = 0
+				if !cursor.deliver(event) || (event.Type == pb.Event_PROGRESS && !progressNotify) {
+					continue
+				}
+				if !send(event) {
+					return
+				}
+			}
+			for retries := 0; ; retries++ {
+				if ctx.Err() != nil {
+					return
+				}
+				if unexpected(err) {
+					failures++
+				}
+				if !retryable(err) || failures > maxUnexpectedFailures {
+					w.err = convertError(err)
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoffDelay(config, retries)):
+				}
+				resume := proto.Clone(request).(*pb.WatchRecordRequest)
+				resume.StartRevision = cursor.startRevision()
+				stream, revision, err = c.openWatch(ctx, resume)
+				if status.Code(err) == codes.OutOfRange {
+					// The missed events are gone, so start over from now.
+					resume.StartRevision = 0
+					if stream, revision, err = c.openWatch(ctx, resume); err == nil {
+						if !resync() {
+							return
+						}
+						cursor = newWatchCursor(revision)
+					}
+				} else if err == nil && revision < cursor.revision {
+					// The store went back in time, having lost the events
+					// already delivered.
+					if !resync() {
+						return
+					}
+					cursor = newWatchCursor(revision)
+				}
+				if err == nil {
+					break
+				}
+			}
+		}
+	}()
+	return w, nil
+}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const tracerName = "github.com/gnossen/kvd/client"
+
+// propagator sends the trace context of calls in their gRPC metadata, in the
+// W3C Trace Context and Baggage formats that the server reads.
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// metadataCarrier adapts gRPC metadata to the propagator.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if values := metadata.MD(c).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key string, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// WithTracing returns dial options that trace each call with a span from
+// provider, and send the span's context to the server so that its spans
+// join the caller's trace. Streams' spans end with the stream.
+func WithTracing(provider trace.TracerProvider) []grpc.DialOption {
+	tracer := provider.Tracer(tracerName)
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, request, reply interface{},
+			cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			ctx, span := startSpan(ctx, tracer, method)
+			err := invoker(ctx, method, request, reply, cc, opts...)
+			endSpan(span, err)
+			return err
+		}),
+		grpc.WithChainStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc,
+			cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			ctx, span := startSpan(ctx, tracer, method)
+			stream, err := streamer(ctx, desc, cc, method, opts...)
+			if err != nil {
+				endSpan(span, err)
+				return nil, err
+			}
+			s := &tracedStream{ClientStream: stream, span: span}
+			go func() {
+				// The stream's context ends with the stream, however it ends.
+				<-stream.Context().Done()
+				s.end(status.FromContextError(stream.Context().Err()).Err())
+			}()
+			return s, nil
+		}),
+	}
+}
+
+func startSpan(ctx context.Context, tracer trace.Tracer, method string) (context.Context, trace.Span) {
+	name := strings.TrimPrefix(method, "/")
+	attributes := []attribute.KeyValue{attribute.String("rpc.system", "grpc")}
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		attributes = append(attributes,
+			attribute.String("rpc.service", name[:i]),
+			attribute.String("rpc.method", name[i+1:]))
+	}
+	ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attributes...))
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	propagator.Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md), span
+}
+
+func endSpan(span trace.Span, err error) {
+	span.SetAttributes(attribute.Int64("rpc.grpc.status_code", int64(status.Code(err))))
+	if err != nil {
+		span.SetStatus(otelcodes.Error, status.Convert(err).Message())
+	}
+	span.End()
+}
+
+// tracedStream ends its span when the stream ends.
+type tracedStream struct {
+	grpc.ClientStream
+	span trace.Span
+	once sync.Once
+}
+
+func (s *tracedStream) end(err error) {
+	s.once.Do(func() {
+		endSpan(s.span, err)
+	})
+}
+
+func (s *tracedStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == io.EOF {
+		s.end(nil)
+	} else if err != nil {
+		s.end(err)
+	}
+	return err
+}
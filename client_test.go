@@ -0,0 +1,103 @@
+package kvd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/gnossen/kvd/client"
+	pb "github.com/gnossen/kvd/kvd"
+	"github.com/gnossen/kvd/server"
+)
+
+func TestClientErrors(t *testing.T) {
+	withServer(t, nil, func(cl pb.KeyValueStoreClient) {
+		c := client.NewClient(cl)
+		ctx := context.Background()
+		if _, err := c.Get(ctx, "foo"); !errors.Is(err, client.ErrNotFound) {
+			t.Fatalf("Expected ErrNotFound, got %v", err)
+		}
+		if _, err := c.Update(ctx, "foo", "oof"); !errors.Is(err, client.ErrNotFound) || status.Code(err) != codes.NotFound {
+			t.Fatalf("Expected ErrNotFound, got %v", err)
+		}
+		record, err := c.Create(ctx, "foo", "oof")
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		if _, err := c.Create(ctx, "foo", "oof"); !errors.Is(err, client.ErrAlreadyExists) {
+			t.Fatalf("Expected ErrAlreadyExists, got %v", err)
+		}
+		if _, err := c.CompareAndSwap(ctx, "foo", "bar", record.ModRevision+1); !errors.Is(err, client.ErrConflict) {
+			t.Fatalf("Expected ErrConflict, got %v", err)
+		}
+		if _, err := c.CompareAndSwap(ctx, "foo", "bar", record.ModRevision); err != nil {
+			t.Fatalf("CompareAndSwap failed: %v", err)
+		}
+		if record, err := c.Get(ctx, "foo"); err != nil || record.Value != "bar" {
+			t.Fatalf("Expected 'bar' at 'foo', got %v, %v", record, err)
+		}
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, err := c.Get(canceled, "foo"); status.Code(err) != codes.Canceled {
+			t.Fatalf("Expected Canceled, got %v", err)
+		}
+		if _, err := c.Delete(ctx, "foo"); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if err := c.RevokeLease(ctx, 42); !errors.Is(err, client.ErrNotFound) {
+			t.Fatalf("Expected ErrNotFound, got %v", err)
+		}
+	})
+}
+
+func TestClientWatch(t *testing.T) {
+	withServer(t, []server.ServerOption{server.WithHistory(1, 0)}, func(cl pb.KeyValueStoreClient) {
+		c := client.NewClient(cl)
+		ctx := context.Background()
+		w, err := c.Watch(ctx, &pb.WatchRecordRequest{Name: "foo"})
+		if err != nil {
+			t.Fatalf("Watch failed: %v", err)
+		}
+		c.Create(ctx, "foo", "oof")
+		if event := <-w.Events(); event.Type != pb.Event_PUT || event.Record.Value != "oof" {
+			t.Fatalf("Expected foo to be created, got %v", event)
+		}
+		w.Close()
+		if _, ok := <-w.Events(); ok {
+			t.Fatalf("Expected no events after Close")
+		}
+		if err := w.Err(); err != nil {
+			t.Fatalf("Expected a closed watch to have no error, got %v", err)
+		}
+
+		// Ending the watch's context also ends the watch.
+		watchCtx, cancel := context.WithCancel(ctx)
+		w, err = c.Watch(watchCtx, &pb.WatchRecordRequest{Name: "foo"})
+		if err != nil {
+			t.Fatalf("Watch failed: %v", err)
+		}
+		if w.Revision() != 1 {
+			t.Fatalf("Expected the watch to start after revision 1, got %d", w.Revision())
+		}
+		cancel()
+		select {
+		case <-w.Events():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for the watch to end")
+		}
+		if err := w.Err(); err != nil {
+			t.Fatalf("Expected a canceled watch to have no error, got %v", err)
+		}
+
+		for i := 0; i < 3; i++ {
+			c.Update(ctx, "foo", "bar")
+		}
+		if _, err := c.Watch(ctx, &pb.WatchRecordRequest{Name: "foo", StartRevision: 1}); !errors.Is(err, client.ErrCompacted) {
+			t.Fatalf("Expected ErrCompacted, got %v", err)
+		}
+	})
+}
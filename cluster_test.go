@@ -0,0 +1,530 @@
+package kvd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/gnossen/kvd/client"
+	pb "github.com/gnossen/kvd/kvd"
+	"github.com/gnossen/kvd/server"
+)
+
+// link forwards connections from one node of a test cluster to another on
+// loopback. Cutting it simulates a network partition.
+type link struct {
+	lis    net.Listener
+	mu     sync.Mutex
+	target string
+	cut    bool
+	conns  []net.Conn
+}
+
+func newLink(t *testing.T) *link {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	l := &link{lis: lis}
+	go l.serve()
+	return l
+}
+
+func (l *link) serve() {
+	for {
+		conn, err := l.lis.Accept()
+		if err != nil {
+			return
+		}
+		l.mu.Lock()
+		target := l.target
+		l.mu.Unlock()
+		upstream, err := net.Dial("tcp", target)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		l.mu.Lock()
+		if l.cut {
+			conn.Close()
+			upstream.Close()
+		} else {
+			l.conns = append(l.conns, conn, upstream)
+			go pipe(conn, upstream)
+			go pipe(upstream, conn)
+		}
+		l.mu.Unlock()
+	}
+}
+
+func pipe(dst net.Conn, src net.Conn) {
+	io.Copy(dst, src)
+	dst.Close()
+	src.Close()
+}
+
+func (l *link) setTarget(target string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.target = target
+}
+
+func (l *link) setCut(cut bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cut = cut
+	if cut {
+		for _, conn := range l.conns {
+			conn.Close()
+		}
+		l.conns = nil
+	}
+}
+
+func (l *link) close() {
+	l.lis.Close()
+	l.setCut(true)
+}
+
+// testCluster runs the nodes of a Raft cluster in this process. Nodes reach
+// each other only through links, so that they can be partitioned.
+type testCluster struct {
+	t       *testing.T
+	dirs    []string
+	servers []*server.Server
+	addrs   []string
+	links   [][]*link // links[from][to]
+}
+
+func newTestCluster(t *testing.T, size int) *testCluster {
+	c := &testCluster{
+		t:       t,
+		dirs:    make([]string, size),
+		servers: make([]*server.Server, size),
+		addrs:   make([]string, size),
+		links:   make([][]*link, size),
+	}
+	for i := range c.links {
+		c.dirs[i] = tempDir(t)
+		c.links[i] = make([]*link, size)
+		for j := range c.links[i] {
+			if i != j {
+				c.links[i][j] = newLink(t)
+			}
+		}
+	}
+	for i := range c.servers {
+		c.start(i)
+	}
+	return c
+}
+
+func (c *testCluster) start(i int) {
+	peers := make(map[uint64]string)
+	for j := range c.links {
+		if i == j {
+			peers[uint64(j+1)] = "localhost:0"
+		} else {
+			peers[uint64(j+1)] = c.links[i][j].lis.Addr().String()
+		}
+	}
+	s, err := server.New(
+		server.WithAddress("tcp", "localhost:0"),
+		server.WithCluster(uint64(i+1), peers),
+		server.WithDataDir(c.dirs[i]),
+		server.WithSnapshotInterval(5))
+	if err != nil {
+		c.t.Fatalf("failed to start node %d: %v", i+1, err)
+	}
+	go s.Serve()
+	c.servers[i] = s
+	c.addrs[i] = s.Addr().String()
+	for j := range c.links {
+		if i != j {
+			c.links[j][i].setTarget(s.RaftAddr().String())
+		}
+	}
+}
+
+func (c *testCluster) stop(i int) {
+	c.servers[i].Stop()
+}
+
+func (c *testCluster) partition(i int, cut bool) {
+	for j := range c.links {
+		if i != j {
+			c.links[i][j].setCut(cut)
+			c.links[j][i].setCut(cut)
+		}
+	}
+}
+
+func (c *testCluster) close() {
+	for i := range c.servers {
+		c.stop(i)
+		for _, l := range c.links[i] {
+			if l != nil {
+				l.close()
+			}
+		}
+		os.RemoveAll(c.dirs[i])
+	}
+}
+
+func (c *testCluster) dial(i int) (*grpc.ClientConn, pb.KeyValueStoreClient) {
+	conn, err := grpc.Dial(c.addrs[i], grpc.WithInsecure())
+	if err != nil {
+		c.t.Fatalf("fail to dial: %v", err)
+	}
+	return conn, pb.NewKeyValueStoreClient(conn)
+}
+
+// eventually retries f until it succeeds, failing the test if it keeps
+// failing for too long.
+func eventually(t *testing.T, f func() error) {
+	deadline := time.Now().Add(20 * time.Second)
+	for {
+		err := f()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Gave up: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// put writes a record whether or not it exists, so that it can be retried.
+func put(cl pb.KeyValueStoreClient, name string, value string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, err := cl.Txn(ctx, &pb.TxnRequest{
+		Success: []*pb.TxnOp{{Op: &pb.TxnOp_Put{Put: &pb.Record{Name: name, Value: value}}}},
+	})
+	return err
+}
+
+func putEventually(t *testing.T, cl pb.KeyValueStoreClient, name string, value string) {
+	eventually(t, func() error {
+		return put(cl, name, value, 2*time.Second)
+	})
+}
+
+func expectEventually(t *testing.T, cl pb.KeyValueStoreClient, name string, value string) {
+	eventually(t, func() error {
+		response, err := cl.GetRecord(context.Background(), &pb.GetRecordRequest{Name: name, Consistency: pb.Consistency_SERIALIZABLE})
+		if err != nil {
+			return err
+		}
+		if response.Value != value {
+			return fmt.Errorf("expected '%s' at '%s', got '%s'", value, name, response.Value)
+		}
+		return nil
+	})
+}
+
+func TestClusterReplication(t *testing.T) {
+	c := newTestCluster(t, 3)
+	defer c.close()
+	var clients []pb.KeyValueStoreClient
+	for i := 0; i < 3; i++ {
+		conn, cl := c.dial(i)
+		defer conn.Close()
+		clients = append(clients, cl)
+	}
+	// Writes to followers are forwarded to the leader.
+	for i, cl := range clients {
+		putEventually(t, cl, fmt.Sprintf("node-%d", i), "written")
+	}
+	for _, cl := range clients {
+		for i := range clients {
+			expectEventually(t, cl, fmt.Sprintf("node-%d", i), "written")
+		}
+	}
+	// Raft messages are only accepted on the Raft address.
+	conn, err := grpc.Dial(c.addrs[0], grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("fail to dial: %v", err)
+	}
+	defer conn.Close()
+	_, err = pb.NewRaftClient(conn).Step(context.Background(), &pb.RaftMessage{})
+	if status.Code(err) != codes.Unimplemented {
+		t.Fatalf("Expected Unimplemented, got %v", err)
+	}
+}
+
+func TestClusterPartition(t *testing.T) {
+	c := newTestCluster(t, 3)
+	defer c.close()
+	var clients []pb.KeyValueStoreClient
+	for i := 0; i < 3; i++ {
+		conn, cl := c.dial(i)
+		defer conn.Close()
+		clients = append(clients, cl)
+	}
+	putEventually(t, clients[0], "before", "1")
+	expectEventually(t, clients[0], "before", "1")
+
+	c.partition(0, true)
+	if err := put(clients[0], "minority", "1", time.Second); err == nil {
+		t.Fatalf("Expected a write to a partitioned node to fail")
+	}
+	putEventually(t, clients[1], "during", "1")
+	expectEventually(t, clients[2], "during", "1")
+	// The minority still serves stale reads, but not linearizable ones.
+	_, err := clients[0].GetRecord(context.Background(), &pb.GetRecordRequest{Name: "during", Consistency: pb.Consistency_SERIALIZABLE})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("Expected a stale read to miss 'during', got %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := clients[0].GetRecord(ctx, &pb.GetRecordRequest{Name: "before"}); err == nil {
+		t.Fatalf("Expected a linearizable read from a partitioned node to fail")
+	}
+
+	c.partition(0, false)
+	expectEventually(t, clients[0], "during", "1")
+	putEventually(t, clients[0], "after", "1")
+	expectEventually(t, clients[1], "after", "1")
+}
+
+func TestClusterSnapshotInstall(t *testing.T) {
+	c := newTestCluster(t, 3)
+	defer c.close()
+	var clients []pb.KeyValueStoreClient
+	for i := 0; i < 3; i++ {
+		conn, cl := c.dial(i)
+		defer conn.Close()
+		clients = append(clients, cl)
+	}
+	putEventually(t, clients[0], "warmup", "1")
+	expectEventually(t, clients[2], "warmup", "1")
+
+	// The log is compacted every 5 entries, so node 3 can only catch up
+	// from a snapshot.
+	c.partition(2, true)
+	for i := 0; i < 30; i++ {
+		putEventually(t, clients[0], fmt.Sprintf("key-%02d", i), "1")
+	}
+	c.partition(2, false)
+	expectEventually(t, clients[2], "key-29", "1")
+	eventually(t, func() error {
+		response, err := clients[2].ListRecords(context.Background(), &pb.ListRecordsRequest{CountOnly: true})
+		if err != nil {
+			return err
+		}
+		if response.Count != 31 {
+			return fmt.Errorf("expected 31 records, got %d", response.Count)
+		}
+		return nil
+	})
+
+	// Restarted nodes recover their state and catch up on what they missed.
+	c.stop(1)
+	putEventually(t, clients[0], "while-down", "1")
+	c.start(1)
+	conn, cl := c.dial(1)
+	defer conn.Close()
+	expectEventually(t, cl, "key-29", "1")
+	expectEventually(t, cl, "while-down", "1")
+}
+
+func TestClusterLinearizableRead(t *testing.T) {
+	c := newTestCluster(t, 3)
+	defer c.close()
+	var clients []pb.KeyValueStoreClient
+	for i := 0; i < 3; i++ {
+		conn, cl := c.dial(i)
+		defer conn.Close()
+		clients = append(clients, cl)
+	}
+	putEventually(t, clients[0], "counter", "0")
+	for i := 1; i <= 10; i++ {
+		value := fmt.Sprintf("%d", i)
+		response, err := clients[i%3].Txn(context.Background(), &pb.TxnRequest{
+			Success: []*pb.TxnOp{{Op: &pb.TxnOp_Put{Put: &pb.Record{Name: "counter", Value: value}}}},
+		})
+		if err != nil {
+			t.Fatalf("Txn failed: %v", err)
+		}
+		// A read from any other node sees the write as soon as it completes.
+		read, revision, err := client.NewClient(clients[(i+1)%3]).GetWithRequest(context.Background(), &pb.GetRecordRequest{Name: "counter"})
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if read.Value != value || revision < response.Revision {
+			t.Fatalf("Expected '%s' at revision %d or later, got '%s' at revision %d",
+				value, response.Revision, read.Value, revision)
+		}
+		_, err = clients[(i+2)%3].GetRecord(context.Background(), &pb.GetRecordRequest{
+			Name:           "counter",
+			Consistency:    pb.Consistency_BOUNDED_STALENESS,
+			MaxRevisionLag: 5,
+		})
+		if err != nil {
+			t.Fatalf("Bounded staleness read failed: %v", err)
+		}
+	}
+}
+
+func TestClusterLeaseKeepAlive(t *testing.T) {
+	c := newTestCluster(t, 3)
+	defer c.close()
+	var clients []pb.KeyValueStoreClient
+	for i := 0; i < 3; i++ {
+		conn, cl := c.dial(i)
+		defer conn.Close()
+		clients = append(clients, cl)
+	}
+	var lease int64
+	eventually(t, func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		response, err := clients[0].GrantLease(ctx, &pb.GrantLeaseRequest{TtlSeconds: 60})
+		if err != nil {
+			return err
+		}
+		lease = response.Id
+		return nil
+	})
+	// Followers send keep-alives and queries to the leader, which alone
+	// tracks when leases expire.
+	for i, cl := range clients {
+		eventually(t, func() error {
+			stream, err := cl.KeepAlive(context.Background())
+			if err != nil {
+				return err
+			}
+			defer stream.CloseSend()
+			if err := stream.Send(&pb.KeepAliveRequest{Id: lease}); err != nil {
+				return err
+			}
+			response, err := stream.Recv()
+			if err != nil {
+				return err
+			}
+			if response.TtlSeconds != 60 {
+				return fmt.Errorf("expected node %d to renew the lease for 60s, got %d", i+1, response.TtlSeconds)
+			}
+			ttl, err := cl.LeaseTimeToLive(context.Background(), &pb.LeaseTimeToLiveRequest{Id: lease})
+			if err != nil {
+				return err
+			}
+			if ttl.GrantedTtlSeconds != 60 {
+				return fmt.Errorf("expected node %d to report a 60s lease, got %v", i+1, ttl)
+			}
+			return nil
+		})
+	}
+}
+
+func TestClusterCorruptRaftLog(t *testing.T) {
+	// Offsets into the first frame: a byte of its payload, and the low byte
+	// of its length.
+	for _, offset := range []int64{14, 0} {
+		t.Run(fmt.Sprintf("offset %d", offset), func(t *testing.T) {
+			c := newTestCluster(t, 1)
+			defer c.close()
+			conn, cl := c.dial(0)
+			for i := 0; i < 3; i++ {
+				putEventually(t, cl, fmt.Sprintf("key-%d", i), "1")
+			}
+			conn.Close()
+			c.stop(0)
+			path := filepath.Join(c.dirs[0], "raft")
+			before, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("failed to stat log: %v", err)
+			}
+			log, err := os.OpenFile(path, os.O_RDWR, 0644)
+			if err != nil {
+				t.Fatalf("failed to open log: %v", err)
+			}
+			var b [1]byte
+			log.ReadAt(b[:], offset)
+			b[0] ^= 0xff
+			log.WriteAt(b[:], offset)
+			log.Close()
+			s, err := server.New(
+				server.WithAddress("tcp", "localhost:0"),
+				server.WithCluster(1, map[uint64]string{1: "localhost:0"}),
+				server.WithDataDir(c.dirs[0]))
+			if err == nil {
+				s.Stop()
+				t.Fatalf("Expected recovery to fail on a corrupt entry before acknowledged ones")
+			}
+			after, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("failed to stat log: %v", err)
+			}
+			if after.Size() != before.Size() {
+				t.Fatalf("Expected the log to be left alone, but it went from %d to %d bytes", before.Size(), after.Size())
+			}
+		})
+	}
+}
+
+func TestClusterNodeFailure(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	// Compacting the Raft log writes it to raft.tmp first, which fails while
+	// a directory is in the way.
+	if err := os.Mkdir(filepath.Join(dir, "raft.tmp"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	s, err := server.New(
+		server.WithAddress("tcp", "localhost:0"),
+		server.WithCluster(1, map[uint64]string{1: "localhost:0"}),
+		server.WithDataDir(dir),
+		server.WithSnapshotInterval(5))
+	if err != nil {
+		t.Fatalf("failed to start node: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve()
+	}()
+	conn, err := grpc.Dial(s.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("fail to dial: %v", err)
+	}
+	defer conn.Close()
+	cl := pb.NewKeyValueStoreClient(conn)
+	putEventually(t, cl, "key-0", "1")
+	for i := 1; ; i++ {
+		err := put(cl, fmt.Sprintf("key-%d", i), "1", 2*time.Second)
+		if err == nil {
+			if i == 20 {
+				t.Fatalf("Expected the node to fail once it compacted its log")
+			}
+			continue
+		}
+		if status.Code(err) != codes.Unavailable {
+			t.Fatalf("Expected Unavailable once the node failed, got %v", err)
+		}
+		break
+	}
+	// The failure ends Serve instead of the process.
+	select {
+	case err := <-served:
+		if err == nil {
+			t.Fatalf("Expected Serve to return the node's failure")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Serve did not return after the node failed")
+	}
+	if err := s.Close(); err == nil {
+		t.Fatalf("Expected Close to return the node's failure")
+	}
+}
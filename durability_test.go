@@ -0,0 +1,201 @@
+package kvd
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/gnossen/kvd/client"
+	pb "github.com/gnossen/kvd/kvd"
+	"github.com/gnossen/kvd/server"
+)
+
+const helperDataDirEnv = "KVD_HELPER_DATA_DIR"
+
+func TestRecovery(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	opts := []server.ServerOption{server.WithDataDir(dir)}
+	withServer(t, opts, func(cl pb.KeyValueStoreClient) {
+		client.Create(cl, "foo", "1")
+		client.Update(cl, "foo", "2")
+		client.Create(cl, "bar", "3")
+		client.Delete(cl, "bar")
+	})
+	withServer(t, opts, func(cl pb.KeyValueStoreClient) {
+		expectValue(t, cl, "foo", "2")
+		expectMissing(t, cl, "bar")
+		record := client.Get(cl, "foo")
+		if record.CreateRevision != 1 || record.ModRevision != 2 || record.Version != 2 {
+			t.Fatalf("Expected revisions to survive a restart, got '%v'", record)
+		}
+	})
+}
+
+func TestSnapshotRecovery(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	opts := []server.ServerOption{server.WithDataDir(dir), server.WithSnapshotInterval(3)}
+	withServer(t, opts, func(cl pb.KeyValueStoreClient) {
+		for i := 0; i < 10; i++ {
+			client.Create(cl, fmt.Sprintf("key%d", i), fmt.Sprintf("%d", i))
+		}
+		client.Delete(cl, "key0")
+	})
+	if _, err := os.Stat(filepath.Join(dir, "snapshot")); err != nil {
+		t.Fatalf("Expected a snapshot: %v", err)
+	}
+	withServer(t, opts, func(cl pb.KeyValueStoreClient) {
+		expectMissing(t, cl, "key0")
+		for i := 1; i < 10; i++ {
+			expectValue(t, cl, fmt.Sprintf("key%d", i), fmt.Sprintf("%d", i))
+		}
+		client.Delete(cl, "key9")
+	})
+	withServer(t, opts, func(cl pb.KeyValueStoreClient) {
+		// The latest revision belonged to a deleted record and must still
+		// not be reused.
+		if record := client.Create(cl, "new", "x"); record.ModRevision != 13 {
+			t.Fatalf("Expected revision 13 after restart, got %d", record.ModRevision)
+		}
+	})
+}
+
+func TestTornWrite(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	opts := []server.ServerOption{server.WithDataDir(dir)}
+	withServer(t, opts, func(cl pb.KeyValueStoreClient) {
+		client.Create(cl, "foo", "1")
+	})
+	wal, err := os.OpenFile(filepath.Join(dir, "wal"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	// A frame header promising more bytes than were written, and then a
+	// header cut short.
+	header := []byte{0x40, 0x00, 0x00, 0x00, 0xde, 0xad, 0xbe, 0xef, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(header[8:], crc32.Checksum(header[:8], crc32.MakeTable(crc32.Castagnoli)))
+	wal.Write(append(header, 0x01))
+	wal.Close()
+	withServer(t, opts, func(cl pb.KeyValueStoreClient) {
+		expectValue(t, cl, "foo", "1")
+	})
+	wal, err = os.OpenFile(filepath.Join(dir, "wal"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	wal.Write(header[:5])
+	wal.Close()
+	withServer(t, opts, func(cl pb.KeyValueStoreClient) {
+		expectValue(t, cl, "foo", "1")
+		client.Create(cl, "bar", "2")
+	})
+	withServer(t, opts, func(cl pb.KeyValueStoreClient) {
+		expectValue(t, cl, "foo", "1")
+		expectValue(t, cl, "bar", "2")
+	})
+}
+
+func TestCorruptMiddleFrame(t *testing.T) {
+	// Offsets into the first frame: a byte of its payload, and the low and
+	// high bytes of its length, which could otherwise pass for a frame cut
+	// short at the end of the log.
+	for _, offset := range []int64{14, 0, 3} {
+		t.Run(fmt.Sprintf("offset %d", offset), func(t *testing.T) {
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+			opts := []server.ServerOption{server.WithDataDir(dir)}
+			withServer(t, opts, func(cl pb.KeyValueStoreClient) {
+				client.Create(cl, "foo", "1")
+				client.Create(cl, "bar", "2")
+				client.Create(cl, "baz", "3")
+			})
+			path := filepath.Join(dir, "wal")
+			before, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("failed to stat log: %v", err)
+			}
+			wal, err := os.OpenFile(path, os.O_RDWR, 0644)
+			if err != nil {
+				t.Fatalf("failed to open log: %v", err)
+			}
+			var b [1]byte
+			wal.ReadAt(b[:], offset)
+			b[0] ^= 0xff
+			wal.WriteAt(b[:], offset)
+			wal.Close()
+			s, err := server.New(append(opts, server.WithAddress("tcp", "localhost:0"))...)
+			if err == nil {
+				s.Stop()
+				t.Fatalf("Expected recovery to fail on a corrupt entry before acknowledged writes")
+			}
+			after, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("failed to stat log: %v", err)
+			}
+			if after.Size() != before.Size() {
+				t.Fatalf("Expected the log to be left alone, but it went from %d to %d bytes", before.Size(), after.Size())
+			}
+		})
+	}
+}
+
+// TestHelperServer is not a real test. It runs a persistent server in a
+// subprocess so that TestKillMidWrite can kill it without warning.
+func TestHelperServer(t *testing.T) {
+	dir := os.Getenv(helperDataDirEnv)
+	if dir == "" {
+		t.Skip("only run as a subprocess")
+	}
+	s, err := server.New(server.WithAddress("tcp", ":1234"), server.WithDataDir(dir), server.WithSnapshotInterval(16))
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	s.Serve()
+}
+
+func TestKillMidWrite(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperServer$")
+	cmd.Env = append(os.Environ(), helperDataDirEnv+"="+dir)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	conn, cl := dial(t)
+	acked := make(chan int, 1000)
+	go func() {
+		defer close(acked)
+		for i := 0; ; i++ {
+			request := pb.CreateRecordRequest{Record: &pb.Record{Name: fmt.Sprintf("key%d", i), Value: fmt.Sprintf("%d", i)}}
+			if _, err := cl.CreateRecord(context.Background(), &request); err != nil {
+				return
+			}
+			acked <- i
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		<-acked
+	}
+	cmd.Process.Kill()
+	cmd.Wait()
+	var acknowledged []int
+	for i := range acked {
+		acknowledged = append(acknowledged, i)
+	}
+	conn.Close()
+	withServer(t, []server.ServerOption{server.WithDataDir(dir)}, func(cl pb.KeyValueStoreClient) {
+		for i := 0; i < 100; i++ {
+			expectValue(t, cl, fmt.Sprintf("key%d", i), fmt.Sprintf("%d", i))
+		}
+		for _, i := range acknowledged {
+			expectValue(t, cl, fmt.Sprintf("key%d", i), fmt.Sprintf("%d", i))
+		}
+	})
+}
@@ -0,0 +1,149 @@
+package kvd
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/gnossen/kvd/kvd"
+	"github.com/gnossen/kvd/server"
+)
+
+// withEmbeddedServer runs a server from server.New on a free port.
+func withEmbeddedServer(t *testing.T, opts []server.ServerOption, f func(*server.Server, pb.KeyValueStoreClient)) {
+	s, err := server.New(append([]server.ServerOption{server.WithAddress("tcp", "localhost:0")}, opts...)...)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	go s.Serve()
+	defer s.Stop()
+	conn, err := grpc.Dial(s.Addr().String(), grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		t.Fatalf("fail to dial: %v", err)
+	}
+	defer conn.Close()
+	f(s, pb.NewKeyValueStoreClient(conn))
+}
+
+func TestEmbeddedServer(t *testing.T) {
+	var intercepted int32
+	opts := []server.ServerOption{
+		server.WithUnaryInterceptors(func(ctx context.Context, request interface{},
+			info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			atomic.AddInt32(&intercepted, 1)
+			return handler(ctx, request)
+		}),
+		server.WithMaxRecordSize(16),
+		server.WithMaxWatchers(1),
+	}
+	withEmbeddedServer(t, opts, func(s *server.Server, cl pb.KeyValueStoreClient) {
+		if port := s.Addr().(*net.TCPAddr).Port; port == 0 {
+			t.Fatalf("Expected the bound port, got %v", s.Addr())
+		}
+		ctx := context.Background()
+		if _, err := cl.CreateRecord(ctx, &pb.CreateRecordRequest{Record: &pb.Record{Name: "foo", Value: "oof"}}); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		expectValue(t, cl, "foo", "oof")
+		if n := atomic.LoadInt32(&intercepted); n != 2 {
+			t.Fatalf("Expected the interceptor to see 2 requests, got %d", n)
+		}
+
+		large := &pb.Record{Name: "bar", Value: strings.Repeat("x", 16)}
+		if _, err := cl.CreateRecord(ctx, &pb.CreateRecordRequest{Record: large}); status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("Expected InvalidArgument for a large record, got %v", err)
+		}
+		txn := &pb.TxnRequest{Success: []*pb.TxnOp{{Op: &pb.TxnOp_Put{Put: large}}}}
+		if _, err := cl.Txn(ctx, txn); status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("Expected InvalidArgument for a large record in a transaction, got %v", err)
+		}
+		expectMissing(t, cl, "bar")
+
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		first, err := cl.WatchRecord(watchCtx, &pb.WatchRecordRequest{Name: "foo"})
+		if err != nil {
+			t.Fatalf("Watch failed: %v", err)
+		}
+		if _, err := first.Header(); err != nil {
+			t.Fatalf("Watch failed: %v", err)
+		}
+		second, err := cl.WatchRecord(watchCtx, &pb.WatchRecordRequest{Name: "foo"})
+		if err != nil {
+			t.Fatalf("Watch failed: %v", err)
+		}
+		if _, err := second.Recv(); status.Code(err) != codes.ResourceExhausted {
+			t.Fatalf("Expected ResourceExhausted past the watcher limit, got %v", err)
+		}
+	})
+}
+
+func TestEmbeddedServerUnixSocket(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "kvd.sock")
+	s, err := server.New(server.WithAddress("unix", socket))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	go s.Serve()
+	defer s.Stop()
+	conn, err := grpc.Dial("unix://"+socket, grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		t.Fatalf("fail to dial: %v", err)
+	}
+	defer conn.Close()
+	cl := pb.NewKeyValueStoreClient(conn)
+	if _, err := cl.CreateRecord(context.Background(), &pb.CreateRecordRequest{Record: &pb.Record{Name: "foo", Value: "oof"}}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	expectValue(t, cl, "foo", "oof")
+}
+
+func TestEmbeddedServerErrors(t *testing.T) {
+	withEmbeddedServer(t, nil, func(s *server.Server, cl pb.KeyValueStoreClient) {
+		if _, err := server.New(server.WithAddress("tcp", s.Addr().String())); err == nil {
+			t.Fatalf("Expected an error listening on a port in use")
+		}
+	})
+	if _, err := server.New(server.WithAllowedClients("alice")); err == nil {
+		t.Fatalf("Expected an error for allowed clients without TLS")
+	}
+	for _, size := range []int{0, -1} {
+		if _, err := server.New(server.WithWatchQueueSize(size)); err == nil {
+			t.Fatalf("Expected an error for a watch queue size of %d", size)
+		}
+	}
+	if _, err := server.New(server.WithStorage(server.NewMemoryStorage()), server.WithCluster(1, map[uint64]string{1: "localhost:0"})); err == nil {
+		t.Fatalf("Expected an error for storage given to a cluster")
+	}
+	if _, err := server.New(server.WithAuth("secret"), server.WithCluster(1, map[uint64]string{1: "localhost:0"})); err == nil {
+		t.Fatalf("Expected an error for authentication in a cluster without allowed clients")
+	}
+	if _, err := server.New(server.WithCluster(1, map[uint64]string{2: "localhost:0"})); err == nil {
+		t.Fatalf("Expected an error for a node ID missing from the peers")
+	}
+	// A failed start releases the port it listened on.
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+	if _, err := server.New(server.WithAddress("tcp", addr), server.WithAllowedClients("alice")); err == nil {
+		t.Fatalf("Expected an error for allowed clients without TLS")
+	}
+	s, err := server.New(server.WithAddress("tcp", addr))
+	if err != nil {
+		t.Fatalf("Expected the port to be free again, got %v", err)
+	}
+	s.Stop()
+}
@@ -0,0 +1,130 @@
+package kvd
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	pb "github.com/gnossen/kvd/kvd"
+	"github.com/gnossen/kvd/server"
+)
+
+const gatewayURL = "http://localhost:1235"
+
+// request sends an HTTP request to the gateway, failing the test unless it
+// responds with the expected status. It returns the response body.
+func request(t *testing.T, method string, path string, body string, expected int) string {
+	req, err := http.NewRequest(method, gatewayURL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Bad request: %v", err)
+	}
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	if response.StatusCode != expected {
+		t.Fatalf("Expected %s %s to return %d, got %d: %s", method, path, expected, response.StatusCode, data)
+	}
+	return string(data)
+}
+
+func TestGateway(t *testing.T) {
+	withServer(t, []server.ServerOption{server.WithHTTPPort(1235)}, func(cl pb.KeyValueStoreClient) {
+		request(t, "POST", "/v1/keys/a/1", `{"value": "one"}`, http.StatusCreated)
+		request(t, "POST", "/v1/keys/a/2", `{"value": "two"}`, http.StatusCreated)
+		request(t, "POST", "/v1/keys/b", `{"value": "three"}`, http.StatusCreated)
+		request(t, "POST", "/v1/keys/b", `{"value": "three"}`, http.StatusConflict)
+		request(t, "POST", "/v1/keys/c", `not json`, http.StatusBadRequest)
+
+		var got struct {
+			Name        string `json:"name"`
+			Value       string `json:"value"`
+			ModRevision string `json:"mod_revision"`
+		}
+		if err := json.Unmarshal([]byte(request(t, "GET", "/v1/keys/a/1", "", http.StatusOK)), &got); err != nil {
+			t.Fatalf("Bad response: %v", err)
+		}
+		if got.Name != "a/1" || got.Value != "one" {
+			t.Fatalf("Expected 'one' at 'a/1', got %+v", got)
+		}
+		response, err := http.Get(gatewayURL + "/v1/keys/a/1")
+		if err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		response.Body.Close()
+		if revision := response.Header.Get("Read-Revision"); revision != "3" {
+			t.Fatalf("Expected a read at revision 3, got '%s'", revision)
+		}
+		request(t, "GET", "/v1/keys/missing", "", http.StatusNotFound)
+		request(t, "GET", "/v1/keys/a/1?consistency=bogus", "", http.StatusBadRequest)
+
+		request(t, "PUT", "/v1/keys/a/1?expected_mod_revision=2", `{"value": "uno"}`, http.StatusConflict)
+		request(t, "PUT", "/v1/keys/a/1?expected_mod_revision="+got.ModRevision, `{"value": "uno"}`, http.StatusOK)
+		expectValue(t, cl, "a/1", "uno")
+
+		var list struct {
+			Records []struct {
+				Name string `json:"name"`
+			} `json:"records"`
+		}
+		if err := json.Unmarshal([]byte(request(t, "GET", "/v1/keys?prefix=a/", "", http.StatusOK)), &list); err != nil {
+			t.Fatalf("Bad response: %v", err)
+		}
+		if len(list.Records) != 2 || list.Records[0].Name != "a/1" || list.Records[1].Name != "a/2" {
+			t.Fatalf("Expected records 'a/1' and 'a/2', got %+v", list.Records)
+		}
+
+		request(t, "DELETE", "/v1/keys/b", "", http.StatusOK)
+		request(t, "DELETE", "/v1/keys/b", "", http.StatusNotFound)
+		expectMissing(t, cl, "b")
+	})
+}
+
+func TestGatewayWatch(t *testing.T) {
+	withServer(t, []server.ServerOption{server.WithHTTPPort(1235)}, func(cl pb.KeyValueStoreClient) {
+		request(t, "GET", "/v1/watch/a?prefix=true&range_end=b", "", http.StatusBadRequest)
+
+		response, err := http.Get(gatewayURL + "/v1/watch/a/?prefix=true")
+		if err != nil {
+			t.Fatalf("Watch failed: %v", err)
+		}
+		defer response.Body.Close()
+		if response.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("Expected an event stream, got '%s'", response.Header.Get("Content-Type"))
+		}
+		// The headers arrive once the watch is registered.
+		request(t, "POST", "/v1/keys/a/1", `{"value": "one"}`, http.StatusCreated)
+		request(t, "POST", "/v1/keys/b", `{"value": "two"}`, http.StatusCreated)
+		request(t, "DELETE", "/v1/keys/a/1", "", http.StatusOK)
+
+		scanner := bufio.NewScanner(response.Body)
+		var events []string
+		for len(events) < 2 && scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data: ") {
+				continue
+			}
+			var event struct {
+				Type   string `json:"type"`
+				Record struct {
+					Name string `json:"name"`
+				} `json:"record"`
+			}
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatalf("Bad event '%s': %v", line, err)
+			}
+			events = append(events, event.Type+" "+event.Record.Name)
+		}
+		if len(events) != 2 || events[0] != "PUT a/1" || events[1] != "DELETE a/1" {
+			t.Fatalf("Expected PUT and DELETE of 'a/1', got %v", events)
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package kvd
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/gnossen/kvd/kvd"
+)
+
+func checkHealth(conn *grpc.ClientConn, service string) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	response, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		return healthpb.HealthCheckResponse_UNKNOWN, err
+	}
+	return response.Status, nil
+}
+
+func expectHealth(t *testing.T, conn *grpc.ClientConn, expected healthpb.HealthCheckResponse_ServingStatus) {
+	eventually(t, func() error {
+		for _, service := range []string{"", "key_value.KeyValueStore"} {
+			servingStatus, err := checkHealth(conn, service)
+			if err != nil {
+				return err
+			}
+			if servingStatus != expected {
+				return fmt.Errorf("expected '%s' to be %v, got %v", service, expected, servingStatus)
+			}
+		}
+		return nil
+	})
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	s := startServer(t)
+	defer s.Stop()
+	conn, cl := dial(t)
+	defer conn.Close()
+	expectHealth(t, conn, healthpb.HealthCheckResponse_SERVING)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := cl.WatchRecord(ctx, &pb.WatchRecordRequest{Name: "foo"})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	if _, err := stream.Header(); err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	healthStream, err := healthpb.NewHealthClient(conn).Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("Health watch failed: %v", err)
+	}
+	if _, err := healthStream.Recv(); err != nil {
+		t.Fatalf("Health watch failed: %v", err)
+	}
+	if _, err := cl.CreateRecord(ctx, &pb.CreateRecordRequest{Record: &pb.Record{Name: "foo", Value: "oof"}}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	start := time.Now()
+	s.Shutdown(time.Minute)
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("Expected open watches not to hold up shutdown, took %v", elapsed)
+	}
+	// Queued events are delivered before the watch ends.
+	event, err := stream.Recv()
+	if err != nil || event.Record.Value != "oof" {
+		t.Fatalf("Expected the queued event, got %v, %v", event, err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Fatalf("Expected the watch to end with Unavailable, got %v", err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	s := startServer(t)
+	defer s.Stop()
+	conn, cl := dial(t)
+	defer conn.Close()
+	ctx := context.Background()
+	s.Drain()
+	expectHealth(t, conn, healthpb.HealthCheckResponse_NOT_SERVING)
+	// A draining server refuses new watches but still serves other requests.
+	if _, err := cl.CreateRecord(ctx, &pb.CreateRecordRequest{Record: &pb.Record{Name: "foo", Value: "oof"}}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	stream, err := cl.WatchRecord(ctx, &pb.WatchRecordRequest{Name: "foo"})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Fatalf("Expected Unavailable, got %v", err)
+	}
+}
+
+func TestClusterHealth(t *testing.T) {
+	c := newTestCluster(t, 3)
+	defer c.close()
+	conn, _ := c.dial(0)
+	defer conn.Close()
+	expectHealth(t, conn, healthpb.HealthCheckResponse_SERVING)
+	// A node cut off from the leader is not ready.
+	c.partition(0, true)
+	expectHealth(t, conn, healthpb.HealthCheckResponse_NOT_SERVING)
+	c.partition(0, false)
+	expectHealth(t, conn, healthpb.HealthCheckResponse_SERVING)
+}
@@ -24,12 +24,125 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
+// How up to date a read must be.
+type Consistency int32
+
+const (
+	// The read reflects every write that completed before it started.
+	Consistency_LINEARIZABLE Consistency = 0
+	// The read is served from the local replica, however far behind it is.
+	Consistency_SERIALIZABLE Consistency = 1
+	// The read is served from the local replica if it is at most
+	// max_revision_lag revisions behind the latest committed write it knows
+	// of, and is otherwise linearizable.
+	Consistency_BOUNDED_STALENESS Consistency = 2
+)
+
+var Consistency_name = map[int32]string{
+	0: "LINEARIZABLE",
+	1: "SERIALIZABLE",
+	2: "BOUNDED_STALENESS",
+}
+
+var Consistency_value = map[string]int32{
+	"LINEARIZABLE":      0,
+	"SERIALIZABLE":      1,
+	"BOUNDED_STALENESS": 2,
+}
+
+func (x Consistency) String() string {
+	return proto.EnumName(Consistency_name, int32(x))
+}
+
+func (Consistency) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{0}
+}
+
+type Compare_Result int32
+
+const (
+	Compare_EQUAL     Compare_Result = 0
+	Compare_NOT_EQUAL Compare_Result = 1
+	Compare_LESS      Compare_Result = 2
+	Compare_GREATER   Compare_Result = 3
+)
+
+var Compare_Result_name = map[int32]string{
+	0: "EQUAL",
+	1: "NOT_EQUAL",
+	2: "LESS",
+	3: "GREATER",
+}
+
+var Compare_Result_value = map[string]int32{
+	"EQUAL":     0,
+	"NOT_EQUAL": 1,
+	"LESS":      2,
+	"GREATER":   3,
+}
+
+func (x Compare_Result) String() string {
+	return proto.EnumName(Compare_Result_name, int32(x))
+}
+
+func (Compare_Result) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{7, 0}
+}
+
+type Event_EventType int32
+
+const (
+	// The record was created or updated.
+	Event_PUT Event_EventType = 0
+	// The record was deleted.
+	Event_DELETE Event_EventType = 1
+	// The watcher fell behind and some events were dropped. Re-read the
+	// watched records to catch up. Only the name from the watch request is
+	// set.
+	Event_RESYNC Event_EventType = 2
+	// Every matching event up to the mod_revision of the record has been
+	// sent. Only that and the name from the watch request are set. Sent only
+	// if the watch request asks for progress_notify.
+	Event_PROGRESS Event_EventType = 3
+)
+
+var Event_EventType_name = map[int32]string{
+	0: "PUT",
+	1: "DELETE",
+	2: "RESYNC",
+	3: "PROGRESS",
+}
+
+var Event_EventType_value = map[string]int32{
+	"PUT":      0,
+	"DELETE":   1,
+	"RESYNC":   2,
+	"PROGRESS": 3,
+}
+
+func (x Event_EventType) String() string {
+	return proto.EnumName(Event_EventType_name, int32(x))
+}
+
+func (Event_EventType) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{13, 0}
+}
+
 // A key-value pair.
 type Record struct {
 	// The key identifying the pair.
 	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
 	// The string value of the pari.
-	Value                string   `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Value string `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	// The store revision at which the record was created.
+	CreateRevision int64 `protobuf:"varint,3,opt,name=create_revision,json=createRevision,proto3" json:"create_revision,omitempty"`
+	// The store revision of the latest change to the record.
+	ModRevision int64 `protobuf:"varint,4,opt,name=mod_revision,json=modRevision,proto3" json:"mod_revision,omitempty"`
+	// The number of changes to the record since it was created, starting at 1.
+	Version int64 `protobuf:"varint,5,opt,name=version,proto3" json:"version,omitempty"`
+	// The lease the record is attached to, or 0. The record is deleted when
+	// the lease expires or is revoked.
+	Lease                int64    `protobuf:"varint,6,opt,name=lease,proto3" json:"lease,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -74,9 +187,40 @@ func (m *Record) GetValue() string {
 	return ""
 }
 
+func (m *Record) GetCreateRevision() int64 {
+	if m != nil {
+		return m.CreateRevision
+	}
+	return 0
+}
+
+func (m *Record) GetModRevision() int64 {
+	if m != nil {
+		return m.ModRevision
+	}
+	return 0
+}
+
+func (m *Record) GetVersion() int64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *Record) GetLease() int64 {
+	if m != nil {
+		return m.Lease
+	}
+	return 0
+}
+
 // A request for the value associated with a given key.
 type GetRecordRequest struct {
-	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Name        string      `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Consistency Consistency `protobuf:"varint,2,opt,name=consistency,proto3,enum=key_value.Consistency" json:"consistency,omitempty"`
+	// The number of revisions the read may lag by under BOUNDED_STALENESS.
+	MaxRevisionLag       int64    `protobuf:"varint,3,opt,name=max_revision_lag,json=maxRevisionLag,proto3" json:"max_revision_lag,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -114,9 +258,23 @@ func (m *GetRecordRequest) GetName() string {
 	return ""
 }
 
+func (m *GetRecordRequest) GetConsistency() Consistency {
+	if m != nil {
+		return m.Consistency
+	}
+	return Consistency_LINEARIZABLE
+}
+
+func (m *GetRecordRequest) GetMaxRevisionLag() int64 {
+	if m != nil {
+		return m.MaxRevisionLag
+	}
+	return 0
+}
+
 // A request to create a new record.
 type CreateRecordRequest struct {
-	// The Record to create.
+	// The Record to create. Only the name, value and lease are used.
 	Record               *Record  `protobuf:"bytes,1,opt,name=record,proto3" json:"record,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
@@ -157,8 +315,15 @@ func (m *CreateRecordRequest) GetRecord() *Record {
 
 // A request to update an existing record.
 type UpdateRecordRequest struct {
-	// The record to update.
-	Record               *Record  `protobuf:"bytes,1,opt,name=record,proto3" json:"record,omitempty"`
+	// The record to update. Only the name, value and lease are used. A lease
+	// of 0 detaches the record from its lease.
+	Record *Record `protobuf:"bytes,1,opt,name=record,proto3" json:"record,omitempty"`
+	// If nonzero, the update fails with ABORTED unless this is the current
+	// version of the record.
+	ExpectedVersion int64 `protobuf:"varint,2,opt,name=expected_version,json=expectedVersion,proto3" json:"expected_version,omitempty"`
+	// If nonzero, the update fails with ABORTED unless this is the current
+	// mod_revision of the record.
+	ExpectedModRevision  int64    `protobuf:"varint,3,opt,name=expected_mod_revision,json=expectedModRevision,proto3" json:"expected_mod_revision,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -196,296 +361,3285 @@ func (m *UpdateRecordRequest) GetRecord() *Record {
 	return nil
 }
 
-// A request to watch an existing record for updates.
-type WatchRecordRequest struct {
-	// The name of the record to watch.
-	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+func (m *UpdateRecordRequest) GetExpectedVersion() int64 {
+	if m != nil {
+		return m.ExpectedVersion
+	}
+	return 0
+}
+
+func (m *UpdateRecordRequest) GetExpectedModRevision() int64 {
+	if m != nil {
+		return m.ExpectedModRevision
+	}
+	return 0
+}
+
+// A request to delete an existing record.
+type DeleteRecordRequest struct {
+	// The name of the record to delete.
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// If nonzero, the delete fails with ABORTED unless this is the current
+	// version of the record.
+	ExpectedVersion int64 `protobuf:"varint,2,opt,name=expected_version,json=expectedVersion,proto3" json:"expected_version,omitempty"`
+	// If nonzero, the delete fails with ABORTED unless this is the current
+	// mod_revision of the record.
+	ExpectedModRevision  int64    `protobuf:"varint,3,opt,name=expected_mod_revision,json=expectedModRevision,proto3" json:"expected_mod_revision,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
 }
 
-func (m *WatchRecordRequest) Reset()         { *m = WatchRecordRequest{} }
-func (m *WatchRecordRequest) String() string { return proto.CompactTextString(m) }
-func (*WatchRecordRequest) ProtoMessage()    {}
-func (*WatchRecordRequest) Descriptor() ([]byte, []int) {
+func (m *DeleteRecordRequest) Reset()         { *m = DeleteRecordRequest{} }
+func (m *DeleteRecordRequest) String() string { return proto.CompactTextString(m) }
+func (*DeleteRecordRequest) ProtoMessage()    {}
+func (*DeleteRecordRequest) Descriptor() ([]byte, []int) {
 	return fileDescriptor_40f3a6d8264e424e, []int{4}
 }
 
-func (m *WatchRecordRequest) XXX_Unmarshal(b []byte) error {
-	return xxx_messageInfo_WatchRecordRequest.Unmarshal(m, b)
+func (m *DeleteRecordRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeleteRecordRequest.Unmarshal(m, b)
 }
-func (m *WatchRecordRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
-	return xxx_messageInfo_WatchRecordRequest.Marshal(b, m, deterministic)
+func (m *DeleteRecordRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeleteRecordRequest.Marshal(b, m, deterministic)
 }
-func (m *WatchRecordRequest) XXX_Merge(src proto.Message) {
-	xxx_messageInfo_WatchRecordRequest.Merge(m, src)
+func (m *DeleteRecordRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeleteRecordRequest.Merge(m, src)
 }
-func (m *WatchRecordRequest) XXX_Size() int {
-	return xxx_messageInfo_WatchRecordRequest.Size(m)
+func (m *DeleteRecordRequest) XXX_Size() int {
+	return xxx_messageInfo_DeleteRecordRequest.Size(m)
 }
-func (m *WatchRecordRequest) XXX_DiscardUnknown() {
-	xxx_messageInfo_WatchRecordRequest.DiscardUnknown(m)
+func (m *DeleteRecordRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeleteRecordRequest.DiscardUnknown(m)
 }
 
-var xxx_messageInfo_WatchRecordRequest proto.InternalMessageInfo
+var xxx_messageInfo_DeleteRecordRequest proto.InternalMessageInfo
 
-func (m *WatchRecordRequest) GetName() string {
+func (m *DeleteRecordRequest) GetName() string {
 	if m != nil {
 		return m.Name
 	}
 	return ""
 }
 
-func init() {
-	proto.RegisterType((*Record)(nil), "key_value.Record")
-	proto.RegisterType((*GetRecordRequest)(nil), "key_value.GetRecordRequest")
-	proto.RegisterType((*CreateRecordRequest)(nil), "key_value.CreateRecordRequest")
-	proto.RegisterType((*UpdateRecordRequest)(nil), "key_value.UpdateRecordRequest")
-	proto.RegisterType((*WatchRecordRequest)(nil), "key_value.WatchRecordRequest")
+func (m *DeleteRecordRequest) GetExpectedVersion() int64 {
+	if m != nil {
+		return m.ExpectedVersion
+	}
+	return 0
 }
 
-func init() { proto.RegisterFile("key_value.proto", fileDescriptor_40f3a6d8264e424e) }
-
-var fileDescriptor_40f3a6d8264e424e = []byte{
-	// 230 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0xcf, 0x4e, 0xad, 0x8c,
-	0x2f, 0x4b, 0xcc, 0x29, 0x4d, 0xd5, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0xe2, 0x84, 0x0b, 0x28,
-	0x19, 0x71, 0xb1, 0x05, 0xa5, 0x26, 0xe7, 0x17, 0xa5, 0x08, 0x09, 0x71, 0xb1, 0xe4, 0x25, 0xe6,
-	0xa6, 0x4a, 0x30, 0x2a, 0x30, 0x6a, 0x70, 0x06, 0x81, 0xd9, 0x42, 0x22, 0x5c, 0xac, 0x60, 0x65,
-	0x12, 0x4c, 0x60, 0x41, 0x08, 0x47, 0x49, 0x8d, 0x4b, 0xc0, 0x3d, 0xb5, 0x04, 0xa2, 0x2d, 0x28,
-	0xb5, 0xb0, 0x34, 0xb5, 0xb8, 0x04, 0x9b, 0x6e, 0x25, 0x07, 0x2e, 0x61, 0xe7, 0xa2, 0xd4, 0xc4,
-	0x92, 0x54, 0x54, 0xa5, 0x9a, 0x5c, 0x6c, 0x45, 0x60, 0x01, 0xb0, 0x62, 0x6e, 0x23, 0x41, 0x3d,
-	0x84, 0xfb, 0xa0, 0x2a, 0xa1, 0x0a, 0x40, 0x26, 0x84, 0x16, 0xa4, 0x50, 0x62, 0x82, 0x06, 0x97,
-	0x50, 0x78, 0x62, 0x49, 0x72, 0x06, 0x41, 0xd7, 0x1a, 0xcd, 0x65, 0xe2, 0xe2, 0xf5, 0x4e, 0xad,
-	0x0c, 0x03, 0x99, 0x12, 0x5c, 0x92, 0x5f, 0x94, 0x2a, 0x64, 0xcb, 0xc5, 0x09, 0xf7, 0xa7, 0x90,
-	0x34, 0x92, 0x1d, 0xe8, 0xbe, 0x97, 0xc2, 0x74, 0x80, 0x12, 0x83, 0x90, 0x33, 0x17, 0x0f, 0xb2,
-	0xf7, 0x85, 0xe4, 0x90, 0x14, 0x61, 0x09, 0x17, 0x9c, 0x86, 0x20, 0x87, 0x00, 0x8a, 0x21, 0x58,
-	0x82, 0x06, 0x97, 0x21, 0xdc, 0x48, 0x81, 0x20, 0x24, 0x8b, 0xa4, 0x06, 0x33, 0x70, 0xb0, 0x1a,
-	0x61, 0xc0, 0x98, 0xc4, 0x06, 0x4e, 0x3b, 0xc6, 0x80, 0x00, 0x00, 0x00, 0xff, 0xff, 0x0d, 0xe1,
-	0xa2, 0x37, 0x4e, 0x02, 0x00, 0x00,
+func (m *DeleteRecordRequest) GetExpectedModRevision() int64 {
+	if m != nil {
+		return m.ExpectedModRevision
+	}
+	return 0
 }
 
-// Reference imports to suppress errors if they are not otherwise used.
-var _ context.Context
-var _ grpc.ClientConnInterface
-
-// This is a compile-time assertion to ensure that this generated file
-// is compatible with the grpc package it is being compiled against.
-const _ = grpc.SupportPackageIsVersion6
-
-// KeyValueStoreClient is the client API for KeyValueStore service.
-//
-// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
-type KeyValueStoreClient interface {
-	// Look up the value associated with a given key.
-	GetRecord(ctx context.Context, in *GetRecordRequest, opts ...grpc.CallOption) (*Record, error)
-	// Associate a value with a given key.
-	CreateRecord(ctx context.Context, in *CreateRecordRequest, opts ...grpc.CallOption) (*Record, error)
-	// Update the value associated with a given key.
-	UpdateRecord(ctx context.Context, in *UpdateRecordRequest, opts ...grpc.CallOption) (*Record, error)
-	// Watch the requested record for updates.
-	WatchRecord(ctx context.Context, in *WatchRecordRequest, opts ...grpc.CallOption) (KeyValueStore_WatchRecordClient, error)
+// A request for the records in a range of keys, in lexicographic order.
+type ListRecordsRequest struct {
+	// List only records whose names begin with this prefix. May not be combined
+	// with start or end.
+	Prefix string `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	// The first name in the range, inclusive.
+	Start string `protobuf:"bytes,2,opt,name=start,proto3" json:"start,omitempty"`
+	// The end of the range, exclusive. An empty end means no upper bound.
+	End string `protobuf:"bytes,3,opt,name=end,proto3" json:"end,omitempty"`
+	// The maximum number of records to return. Zero selects a server default.
+	PageSize int32 `protobuf:"varint,4,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	// The next_page_token from a previous response, to continue that listing.
+	PageToken string `protobuf:"bytes,5,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"`
+	// Return only the names of the records.
+	KeysOnly bool `protobuf:"varint,6,opt,name=keys_only,json=keysOnly,proto3" json:"keys_only,omitempty"`
+	// Return only the number of matching records.
+	CountOnly   bool        `protobuf:"varint,7,opt,name=count_only,json=countOnly,proto3" json:"count_only,omitempty"`
+	Consistency Consistency `protobuf:"varint,8,opt,name=consistency,proto3,enum=key_value.Consistency" json:"consistency,omitempty"`
+	// The number of revisions the read may lag by under BOUNDED_STALENESS.
+	MaxRevisionLag       int64    `protobuf:"varint,9,opt,name=max_revision_lag,json=maxRevisionLag,proto3" json:"max_revision_lag,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
-type keyValueStoreClient struct {
-	cc grpc.ClientConnInterface
+func (m *ListRecordsRequest) Reset()         { *m = ListRecordsRequest{} }
+func (m *ListRecordsRequest) String() string { return proto.CompactTextString(m) }
+func (*ListRecordsRequest) ProtoMessage()    {}
+func (*ListRecordsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{5}
 }
 
-func NewKeyValueStoreClient(cc grpc.ClientConnInterface) KeyValueStoreClient {
-	return &keyValueStoreClient{cc}
+func (m *ListRecordsRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListRecordsRequest.Unmarshal(m, b)
+}
+func (m *ListRecordsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListRecordsRequest.Marshal(b, m, deterministic)
+}
+func (m *ListRecordsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListRecordsRequest.Merge(m, src)
+}
+func (m *ListRecordsRequest) XXX_Size() int {
+	return xxx_messageInfo_ListRecordsRequest.Size(m)
+}
+func (m *ListRecordsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListRecordsRequest.DiscardUnknown(m)
 }
 
-func (c *keyValueStoreClient) GetRecord(ctx context.Context, in *GetRecordRequest, opts ...grpc.CallOption) (*Record, error) {
-	out := new(Record)
-	err := c.cc.Invoke(ctx, "/key_value.KeyValueStore/GetRecord", in, out, opts...)
-	if err != nil {
-		return nil, err
+var xxx_messageInfo_ListRecordsRequest proto.InternalMessageInfo
+
+func (m *ListRecordsRequest) GetPrefix() string {
+	if m != nil {
+		return m.Prefix
 	}
-	return out, nil
+	return ""
 }
 
-func (c *keyValueStoreClient) CreateRecord(ctx context.Context, in *CreateRecordRequest, opts ...grpc.CallOption) (*Record, error) {
-	out := new(Record)
-	err := c.cc.Invoke(ctx, "/key_value.KeyValueStore/CreateRecord", in, out, opts...)
-	if err != nil {
-		return nil, err
+func (m *ListRecordsRequest) GetStart() string {
+	if m != nil {
+		return m.Start
 	}
-	return out, nil
+	return ""
 }
 
-func (c *keyValueStoreClient) UpdateRecord(ctx context.Context, in *UpdateRecordRequest, opts ...grpc.CallOption) (*Record, error) {
-	out := new(Record)
-	err := c.cc.Invoke(ctx, "/key_value.KeyValueStore/UpdateRecord", in, out, opts...)
-	if err != nil {
-		return nil, err
+func (m *ListRecordsRequest) GetEnd() string {
+	if m != nil {
+		return m.End
 	}
-	return out, nil
+	return ""
 }
 
-func (c *keyValueStoreClient) WatchRecord(ctx context.Context, in *WatchRecordRequest, opts ...grpc.CallOption) (KeyValueStore_WatchRecordClient, error) {
-	stream, err := c.cc.NewStream(ctx, &_KeyValueStore_serviceDesc.Streams[0], "/key_value.KeyValueStore/WatchRecord", opts...)
-	if err != nil {
-		return nil, err
+func (m *ListRecordsRequest) GetPageSize() int32 {
+	if m != nil {
+		return m.PageSize
 	}
-	x := &keyValueStoreWatchRecordClient{stream}
-	if err := x.ClientStream.SendMsg(in); err != nil {
-		return nil, err
+	return 0
+}
+
+func (m *ListRecordsRequest) GetPageToken() string {
+	if m != nil {
+		return m.PageToken
 	}
-	if err := x.ClientStream.CloseSend(); err != nil {
-		return nil, err
+	return ""
+}
+
+func (m *ListRecordsRequest) GetKeysOnly() bool {
+	if m != nil {
+		return m.KeysOnly
 	}
-	return x, nil
+	return false
 }
 
-type KeyValueStore_WatchRecordClient interface {
-	Recv() (*Record, error)
-	grpc.ClientStream
+func (m *ListRecordsRequest) GetCountOnly() bool {
+	if m != nil {
+		return m.CountOnly
+	}
+	return false
 }
 
-type keyValueStoreWatchRecordClient struct {
-	grpc.ClientStream
+func (m *ListRecordsRequest) GetConsistency() Consistency {
+	if m != nil {
+		return m.Consistency
+	}
+	return Consistency_LINEARIZABLE
 }
 
-func (x *keyValueStoreWatchRecordClient) Recv() (*Record, error) {
-	m := new(Record)
-	if err := x.ClientStream.RecvMsg(m); err != nil {
-		return nil, err
+func (m *ListRecordsRequest) GetMaxRevisionLag() int64 {
+	if m != nil {
+		return m.MaxRevisionLag
 	}
-	return m, nil
+	return 0
 }
 
-// KeyValueStoreServer is the server API for KeyValueStore service.
-type KeyValueStoreServer interface {
-	// Look up the value associated with a given key.
-	GetRecord(context.Context, *GetRecordRequest) (*Record, error)
-	// Associate a value with a given key.
-	CreateRecord(context.Context, *CreateRecordRequest) (*Record, error)
-	// Update the value associated with a given key.
-	UpdateRecord(context.Context, *UpdateRecordRequest) (*Record, error)
-	// Watch the requested record for updates.
-	WatchRecord(*WatchRecordRequest, KeyValueStore_WatchRecordServer) error
+// A page of records.
+type ListRecordsResponse struct {
+	// The records in this page.
+	Records []*Record `protobuf:"bytes,1,rep,name=records,proto3" json:"records,omitempty"`
+	// Pass in page_token to fetch the next page. Empty if this is the last page.
+	NextPageToken string `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"`
+	// The number of matching records, set only for count_only requests.
+	Count int64 `protobuf:"varint,3,opt,name=count,proto3" json:"count,omitempty"`
+	// The store revision the page was read at. Pages of the same listing may
+	// be read at different revisions.
+	Revision             int64    `protobuf:"varint,4,opt,name=revision,proto3" json:"revision,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
-// UnimplementedKeyValueStoreServer can be embedded to have forward compatible implementations.
-type UnimplementedKeyValueStoreServer struct {
+func (m *ListRecordsResponse) Reset()         { *m = ListRecordsResponse{} }
+func (m *ListRecordsResponse) String() string { return proto.CompactTextString(m) }
+func (*ListRecordsResponse) ProtoMessage()    {}
+func (*ListRecordsResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{6}
 }
 
-func (*UnimplementedKeyValueStoreServer) GetRecord(ctx context.Context, req *GetRecordRequest) (*Record, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method GetRecord not implemented")
+func (m *ListRecordsResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListRecordsResponse.Unmarshal(m, b)
 }
-func (*UnimplementedKeyValueStoreServer) CreateRecord(ctx context.Context, req *CreateRecordRequest) (*Record, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method CreateRecord not implemented")
+func (m *ListRecordsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListRecordsResponse.Marshal(b, m, deterministic)
 }
-func (*UnimplementedKeyValueStoreServer) UpdateRecord(ctx context.Context, req *UpdateRecordRequest) (*Record, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method UpdateRecord not implemented")
+func (m *ListRecordsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListRecordsResponse.Merge(m, src)
 }
-func (*UnimplementedKeyValueStoreServer) WatchRecord(req *WatchRecordRequest, srv KeyValueStore_WatchRecordServer) error {
-	return status.Errorf(codes.Unimplemented, "method WatchRecord not implemented")
+func (m *ListRecordsResponse) XXX_Size() int {
+	return xxx_messageInfo_ListRecordsResponse.Size(m)
 }
-
-func RegisterKeyValueStoreServer(s *grpc.Server, srv KeyValueStoreServer) {
-	s.RegisterService(&_KeyValueStore_serviceDesc, srv)
+func (m *ListRecordsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListRecordsResponse.DiscardUnknown(m)
 }
 
-func _KeyValueStore_GetRecord_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(GetRecordRequest)
-	if err := dec(in); err != nil {
-		return nil, err
-	}
-	if interceptor == nil {
-		return srv.(KeyValueStoreServer).GetRecord(ctx, in)
-	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/key_value.KeyValueStore/GetRecord",
-	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(KeyValueStoreServer).GetRecord(ctx, req.(*GetRecordRequest))
+var xxx_messageInfo_ListRecordsResponse proto.InternalMessageInfo
+
+func (m *ListRecordsResponse) GetRecords() []*Record {
+	if m != nil {
+		return m.Records
 	}
-	return interceptor(ctx, in, info, handler)
+	return nil
 }
 
-func _KeyValueStore_CreateRecord_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(CreateRecordRequest)
-	if err := dec(in); err != nil {
-		return nil, err
-	}
-	if interceptor == nil {
-		return srv.(KeyValueStoreServer).CreateRecord(ctx, in)
-	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/key_value.KeyValueStore/CreateRecord",
-	}
-	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(KeyValueStoreServer).CreateRecord(ctx, req.(*CreateRecordRequest))
+func (m *ListRecordsResponse) GetNextPageToken() string {
+	if m != nil {
+		return m.NextPageToken
 	}
-	return interceptor(ctx, in, info, handler)
+	return ""
 }
 
-func _KeyValueStore_UpdateRecord_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(UpdateRecordRequest)
-	if err := dec(in); err != nil {
-		return nil, err
+func (m *ListRecordsResponse) GetCount() int64 {
+	if m != nil {
+		return m.Count
 	}
-	if interceptor == nil {
-		return srv.(KeyValueStoreServer).UpdateRecord(ctx, in)
+	return 0
+}
+
+func (m *ListRecordsResponse) GetRevision() int64 {
+	if m != nil {
+		return m.Revision
 	}
-	info := &grpc.UnaryServerInfo{
-		Server:     srv,
-		FullMethod: "/key_value.KeyValueStore/UpdateRecord",
+	return 0
+}
+
+// A condition on a single record, evaluated by Txn.
+type Compare struct {
+	// The name of the record to examine.
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// How the record must relate to the target for the condition to hold.
+	Result Compare_Result `protobuf:"varint,2,opt,name=result,proto3,enum=key_value.Compare_Result" json:"result,omitempty"`
+	// The property of the record to compare and the value to compare it with.
+	// A missing record has version, create_revision and mod_revision 0 and
+	// never satisfies a value comparison.
+	//
+	// Types that are valid to be assigned to Target:
+	//	*Compare_Value
+	//	*Compare_Version
+	//	*Compare_CreateRevision
+	//	*Compare_ModRevision
+	//	*Compare_Exists
+	Target               isCompare_Target `protobuf_oneof:"target"`
+	XXX_NoUnkeyedLiteral struct{}         `json:"-"`
+	XXX_unrecognized     []byte           `json:"-"`
+	XXX_sizecache        int32            `json:"-"`
+}
+
+func (m *Compare) Reset()         { *m = Compare{} }
+func (m *Compare) String() string { return proto.CompactTextString(m) }
+func (*Compare) ProtoMessage()    {}
+func (*Compare) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{7}
+}
+
+func (m *Compare) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Compare.Unmarshal(m, b)
+}
+func (m *Compare) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Compare.Marshal(b, m, deterministic)
+}
+func (m *Compare) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Compare.Merge(m, src)
+}
+func (m *Compare) XXX_Size() int {
+	return xxx_messageInfo_Compare.Size(m)
+}
+func (m *Compare) XXX_DiscardUnknown() {
+	xxx_messageInfo_Compare.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Compare proto.InternalMessageInfo
+
+func (m *Compare) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Compare) GetResult() Compare_Result {
+	if m != nil {
+		return m.Result
+	}
+	return Compare_EQUAL
+}
+
+type isCompare_Target interface {
+	isCompare_Target()
+}
+
+type Compare_Value struct {
+	Value string `protobuf:"bytes,3,opt,name=value,proto3,oneof"`
+}
+
+type Compare_Version struct {
+	Version int64 `protobuf:"varint,4,opt,name=version,proto3,oneof"`
+}
+
+type Compare_CreateRevision struct {
+	CreateRevision int64 `protobuf:"varint,5,opt,name=create_revision,json=createRevision,proto3,oneof"`
+}
+
+type Compare_ModRevision struct {
+	ModRevision int64 `protobuf:"varint,6,opt,name=mod_revision,json=modRevision,proto3,oneof"`
+}
+
+type Compare_Exists struct {
+	Exists bool `protobuf:"varint,7,opt,name=exists,proto3,oneof"`
+}
+
+func (*Compare_Value) isCompare_Target() {}
+
+func (*Compare_Version) isCompare_Target() {}
+
+func (*Compare_CreateRevision) isCompare_Target() {}
+
+func (*Compare_ModRevision) isCompare_Target() {}
+
+func (*Compare_Exists) isCompare_Target() {}
+
+func (m *Compare) GetTarget() isCompare_Target {
+	if m != nil {
+		return m.Target
+	}
+	return nil
+}
+
+func (m *Compare) GetValue() string {
+	if x, ok := m.GetTarget().(*Compare_Value); ok {
+		return x.Value
+	}
+	return ""
+}
+
+func (m *Compare) GetVersion() int64 {
+	if x, ok := m.GetTarget().(*Compare_Version); ok {
+		return x.Version
+	}
+	return 0
+}
+
+func (m *Compare) GetCreateRevision() int64 {
+	if x, ok := m.GetTarget().(*Compare_CreateRevision); ok {
+		return x.CreateRevision
+	}
+	return 0
+}
+
+func (m *Compare) GetModRevision() int64 {
+	if x, ok := m.GetTarget().(*Compare_ModRevision); ok {
+		return x.ModRevision
+	}
+	return 0
+}
+
+func (m *Compare) GetExists() bool {
+	if x, ok := m.GetTarget().(*Compare_Exists); ok {
+		return x.Exists
+	}
+	return false
+}
+
+// XXX_OneofWrappers is for the internal use of the proto package.
+func (*Compare) XXX_OneofWrappers() []interface{} {
+	return []interface{}{
+		(*Compare_Value)(nil),
+		(*Compare_Version)(nil),
+		(*Compare_CreateRevision)(nil),
+		(*Compare_ModRevision)(nil),
+		(*Compare_Exists)(nil),
+	}
+}
+
+// An operation applied by Txn.
+type TxnOp struct {
+	// Types that are valid to be assigned to Op:
+	//	*TxnOp_Get
+	//	*TxnOp_Put
+	//	*TxnOp_Delete
+	Op                   isTxnOp_Op `protobuf_oneof:"op"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
+}
+
+func (m *TxnOp) Reset()         { *m = TxnOp{} }
+func (m *TxnOp) String() string { return proto.CompactTextString(m) }
+func (*TxnOp) ProtoMessage()    {}
+func (*TxnOp) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{8}
+}
+
+func (m *TxnOp) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TxnOp.Unmarshal(m, b)
+}
+func (m *TxnOp) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TxnOp.Marshal(b, m, deterministic)
+}
+func (m *TxnOp) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TxnOp.Merge(m, src)
+}
+func (m *TxnOp) XXX_Size() int {
+	return xxx_messageInfo_TxnOp.Size(m)
+}
+func (m *TxnOp) XXX_DiscardUnknown() {
+	xxx_messageInfo_TxnOp.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TxnOp proto.InternalMessageInfo
+
+type isTxnOp_Op interface {
+	isTxnOp_Op()
+}
+
+type TxnOp_Get struct {
+	Get string `protobuf:"bytes,1,opt,name=get,proto3,oneof"`
+}
+
+type TxnOp_Put struct {
+	Put *Record `protobuf:"bytes,2,opt,name=put,proto3,oneof"`
+}
+
+type TxnOp_Delete struct {
+	Delete string `protobuf:"bytes,3,opt,name=delete,proto3,oneof"`
+}
+
+func (*TxnOp_Get) isTxnOp_Op() {}
+
+func (*TxnOp_Put) isTxnOp_Op() {}
+
+func (*TxnOp_Delete) isTxnOp_Op() {}
+
+func (m *TxnOp) GetOp() isTxnOp_Op {
+	if m != nil {
+		return m.Op
+	}
+	return nil
+}
+
+func (m *TxnOp) GetGet() string {
+	if x, ok := m.GetOp().(*TxnOp_Get); ok {
+		return x.Get
+	}
+	return ""
+}
+
+func (m *TxnOp) GetPut() *Record {
+	if x, ok := m.GetOp().(*TxnOp_Put); ok {
+		return x.Put
+	}
+	return nil
+}
+
+func (m *TxnOp) GetDelete() string {
+	if x, ok := m.GetOp().(*TxnOp_Delete); ok {
+		return x.Delete
+	}
+	return ""
+}
+
+// XXX_OneofWrappers is for the internal use of the proto package.
+func (*TxnOp) XXX_OneofWrappers() []interface{} {
+	return []interface{}{
+		(*TxnOp_Get)(nil),
+		(*TxnOp_Put)(nil),
+		(*TxnOp_Delete)(nil),
+	}
+}
+
+// The outcome of a TxnOp.
+type TxnOpResult struct {
+	// The record read by a get, written by a put or removed by a delete. Unset
+	// if a get or delete found no record.
+	Record               *Record  `protobuf:"bytes,1,opt,name=record,proto3" json:"record,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *TxnOpResult) Reset()         { *m = TxnOpResult{} }
+func (m *TxnOpResult) String() string { return proto.CompactTextString(m) }
+func (*TxnOpResult) ProtoMessage()    {}
+func (*TxnOpResult) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{9}
+}
+
+func (m *TxnOpResult) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TxnOpResult.Unmarshal(m, b)
+}
+func (m *TxnOpResult) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TxnOpResult.Marshal(b, m, deterministic)
+}
+func (m *TxnOpResult) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TxnOpResult.Merge(m, src)
+}
+func (m *TxnOpResult) XXX_Size() int {
+	return xxx_messageInfo_TxnOpResult.Size(m)
+}
+func (m *TxnOpResult) XXX_DiscardUnknown() {
+	xxx_messageInfo_TxnOpResult.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TxnOpResult proto.InternalMessageInfo
+
+func (m *TxnOpResult) GetRecord() *Record {
+	if m != nil {
+		return m.Record
+	}
+	return nil
+}
+
+// A request to apply operations atomically depending on a set of conditions.
+// A transaction may not write the same record more than once.
+type TxnRequest struct {
+	// The conditions to evaluate. An empty list always holds.
+	Compare []*Compare `protobuf:"bytes,1,rep,name=compare,proto3" json:"compare,omitempty"`
+	// The operations to apply if every condition holds.
+	Success []*TxnOp `protobuf:"bytes,2,rep,name=success,proto3" json:"success,omitempty"`
+	// The operations to apply otherwise.
+	Failure              []*TxnOp `protobuf:"bytes,3,rep,name=failure,proto3" json:"failure,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *TxnRequest) Reset()         { *m = TxnRequest{} }
+func (m *TxnRequest) String() string { return proto.CompactTextString(m) }
+func (*TxnRequest) ProtoMessage()    {}
+func (*TxnRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{10}
+}
+
+func (m *TxnRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TxnRequest.Unmarshal(m, b)
+}
+func (m *TxnRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TxnRequest.Marshal(b, m, deterministic)
+}
+func (m *TxnRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TxnRequest.Merge(m, src)
+}
+func (m *TxnRequest) XXX_Size() int {
+	return xxx_messageInfo_TxnRequest.Size(m)
+}
+func (m *TxnRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_TxnRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TxnRequest proto.InternalMessageInfo
+
+func (m *TxnRequest) GetCompare() []*Compare {
+	if m != nil {
+		return m.Compare
+	}
+	return nil
+}
+
+func (m *TxnRequest) GetSuccess() []*TxnOp {
+	if m != nil {
+		return m.Success
+	}
+	return nil
+}
+
+func (m *TxnRequest) GetFailure() []*TxnOp {
+	if m != nil {
+		return m.Failure
+	}
+	return nil
+}
+
+// The outcome of a transaction.
+type TxnResponse struct {
+	// Whether every condition held and the success operations were applied.
+	Succeeded bool `protobuf:"varint,1,opt,name=succeeded,proto3" json:"succeeded,omitempty"`
+	// The store revision after the transaction. Every record written by the
+	// transaction has this mod_revision.
+	Revision int64 `protobuf:"varint,2,opt,name=revision,proto3" json:"revision,omitempty"`
+	// The result of each applied operation, in order.
+	Results              []*TxnOpResult `protobuf:"bytes,3,rep,name=results,proto3" json:"results,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *TxnResponse) Reset()         { *m = TxnResponse{} }
+func (m *TxnResponse) String() string { return proto.CompactTextString(m) }
+func (*TxnResponse) ProtoMessage()    {}
+func (*TxnResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{11}
+}
+
+func (m *TxnResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TxnResponse.Unmarshal(m, b)
+}
+func (m *TxnResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TxnResponse.Marshal(b, m, deterministic)
+}
+func (m *TxnResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TxnResponse.Merge(m, src)
+}
+func (m *TxnResponse) XXX_Size() int {
+	return xxx_messageInfo_TxnResponse.Size(m)
+}
+func (m *TxnResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_TxnResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TxnResponse proto.InternalMessageInfo
+
+func (m *TxnResponse) GetSucceeded() bool {
+	if m != nil {
+		return m.Succeeded
+	}
+	return false
+}
+
+func (m *TxnResponse) GetRevision() int64 {
+	if m != nil {
+		return m.Revision
+	}
+	return 0
+}
+
+func (m *TxnResponse) GetResults() []*TxnOpResult {
+	if m != nil {
+		return m.Results
+	}
+	return nil
+}
+
+// A request to watch an existing record, or a range of records, for updates.
+type WatchRecordRequest struct {
+	// The name of the record to watch, or the start of the range to watch.
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// Watch every record whose name begins with name, including records
+	// created after the watch starts. An empty name watches every record. May
+	// not be combined with range_end.
+	Prefix bool `protobuf:"varint,2,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	// If nonempty, watch every record with name <= n < range_end, including
+	// records created after the watch starts.
+	RangeEnd string `protobuf:"bytes,3,opt,name=range_end,json=rangeEnd,proto3" json:"range_end,omitempty"`
+	// If nonzero, first replay every retained event at or after this revision.
+	// Fails with OUT_OF_RANGE if some of those events are no longer retained,
+	// in which case the watched records must be re-read.
+	StartRevision int64 `protobuf:"varint,4,opt,name=start_revision,json=startRevision,proto3" json:"start_revision,omitempty"`
+	// Send a PROGRESS event after each batch of events.
+	ProgressNotify       bool     `protobuf:"varint,5,opt,name=progress_notify,json=progressNotify,proto3" json:"progress_notify,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *WatchRecordRequest) Reset()         { *m = WatchRecordRequest{} }
+func (m *WatchRecordRequest) String() string { return proto.CompactTextString(m) }
+func (*WatchRecordRequest) ProtoMessage()    {}
+func (*WatchRecordRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{12}
+}
+
+func (m *WatchRecordRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_WatchRecordRequest.Unmarshal(m, b)
+}
+func (m *WatchRecordRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_WatchRecordRequest.Marshal(b, m, deterministic)
+}
+func (m *WatchRecordRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WatchRecordRequest.Merge(m, src)
+}
+func (m *WatchRecordRequest) XXX_Size() int {
+	return xxx_messageInfo_WatchRecordRequest.Size(m)
+}
+func (m *WatchRecordRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_WatchRecordRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WatchRecordRequest proto.InternalMessageInfo
+
+func (m *WatchRecordRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *WatchRecordRequest) GetPrefix() bool {
+	if m != nil {
+		return m.Prefix
+	}
+	return false
+}
+
+func (m *WatchRecordRequest) GetRangeEnd() string {
+	if m != nil {
+		return m.RangeEnd
+	}
+	return ""
+}
+
+func (m *WatchRecordRequest) GetStartRevision() int64 {
+	if m != nil {
+		return m.StartRevision
+	}
+	return 0
+}
+
+func (m *WatchRecordRequest) GetProgressNotify() bool {
+	if m != nil {
+		return m.ProgressNotify
+	}
+	return false
+}
+
+// A change to a watched record.
+type Event struct {
+	// The kind of change.
+	Type Event_EventType `protobuf:"varint,1,opt,name=type,proto3,enum=key_value.Event_EventType" json:"type,omitempty"`
+	// The record after a PUT. Only the name and mod_revision, the revision of
+	// the deletion, are set for a DELETE.
+	Record               *Record  `protobuf:"bytes,2,opt,name=record,proto3" json:"record,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Event) Reset()         { *m = Event{} }
+func (m *Event) String() string { return proto.CompactTextString(m) }
+func (*Event) ProtoMessage()    {}
+func (*Event) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{13}
+}
+
+func (m *Event) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Event.Unmarshal(m, b)
+}
+func (m *Event) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Event.Marshal(b, m, deterministic)
+}
+func (m *Event) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Event.Merge(m, src)
+}
+func (m *Event) XXX_Size() int {
+	return xxx_messageInfo_Event.Size(m)
+}
+func (m *Event) XXX_DiscardUnknown() {
+	xxx_messageInfo_Event.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Event proto.InternalMessageInfo
+
+func (m *Event) GetType() Event_EventType {
+	if m != nil {
+		return m.Type
+	}
+	return Event_PUT
+}
+
+func (m *Event) GetRecord() *Record {
+	if m != nil {
+		return m.Record
+	}
+	return nil
+}
+
+// A request for a new lease.
+type GrantLeaseRequest struct {
+	// The number of seconds the lease lives without a keep-alive.
+	TtlSeconds           int64    `protobuf:"varint,1,opt,name=ttl_seconds,json=ttlSeconds,proto3" json:"ttl_seconds,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GrantLeaseRequest) Reset()         { *m = GrantLeaseRequest{} }
+func (m *GrantLeaseRequest) String() string { return proto.CompactTextString(m) }
+func (*GrantLeaseRequest) ProtoMessage()    {}
+func (*GrantLeaseRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{14}
+}
+
+func (m *GrantLeaseRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GrantLeaseRequest.Unmarshal(m, b)
+}
+func (m *GrantLeaseRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GrantLeaseRequest.Marshal(b, m, deterministic)
+}
+func (m *GrantLeaseRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GrantLeaseRequest.Merge(m, src)
+}
+func (m *GrantLeaseRequest) XXX_Size() int {
+	return xxx_messageInfo_GrantLeaseRequest.Size(m)
+}
+func (m *GrantLeaseRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_GrantLeaseRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GrantLeaseRequest proto.InternalMessageInfo
+
+func (m *GrantLeaseRequest) GetTtlSeconds() int64 {
+	if m != nil {
+		return m.TtlSeconds
+	}
+	return 0
+}
+
+// A lease and its time to live.
+type LeaseResponse struct {
+	// The ID of the lease.
+	Id int64 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	// The number of seconds the lease has left. 0 if the lease has expired or
+	// does not exist.
+	TtlSeconds           int64    `protobuf:"varint,2,opt,name=ttl_seconds,json=ttlSeconds,proto3" json:"ttl_seconds,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LeaseResponse) Reset()         { *m = LeaseResponse{} }
+func (m *LeaseResponse) String() string { return proto.CompactTextString(m) }
+func (*LeaseResponse) ProtoMessage()    {}
+func (*LeaseResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{15}
+}
+
+func (m *LeaseResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LeaseResponse.Unmarshal(m, b)
+}
+func (m *LeaseResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LeaseResponse.Marshal(b, m, deterministic)
+}
+func (m *LeaseResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LeaseResponse.Merge(m, src)
+}
+func (m *LeaseResponse) XXX_Size() int {
+	return xxx_messageInfo_LeaseResponse.Size(m)
+}
+func (m *LeaseResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_LeaseResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LeaseResponse proto.InternalMessageInfo
+
+func (m *LeaseResponse) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *LeaseResponse) GetTtlSeconds() int64 {
+	if m != nil {
+		return m.TtlSeconds
+	}
+	return 0
+}
+
+// A request to end a lease and delete its records.
+type RevokeLeaseRequest struct {
+	// The ID of the lease.
+	Id                   int64    `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RevokeLeaseRequest) Reset()         { *m = RevokeLeaseRequest{} }
+func (m *RevokeLeaseRequest) String() string { return proto.CompactTextString(m) }
+func (*RevokeLeaseRequest) ProtoMessage()    {}
+func (*RevokeLeaseRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{16}
+}
+
+func (m *RevokeLeaseRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RevokeLeaseRequest.Unmarshal(m, b)
+}
+func (m *RevokeLeaseRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RevokeLeaseRequest.Marshal(b, m, deterministic)
+}
+func (m *RevokeLeaseRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RevokeLeaseRequest.Merge(m, src)
+}
+func (m *RevokeLeaseRequest) XXX_Size() int {
+	return xxx_messageInfo_RevokeLeaseRequest.Size(m)
+}
+func (m *RevokeLeaseRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_RevokeLeaseRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RevokeLeaseRequest proto.InternalMessageInfo
+
+func (m *RevokeLeaseRequest) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+// A request to extend a lease by its full time to live.
+type KeepAliveRequest struct {
+	// The ID of the lease.
+	Id                   int64    `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *KeepAliveRequest) Reset()         { *m = KeepAliveRequest{} }
+func (m *KeepAliveRequest) String() string { return proto.CompactTextString(m) }
+func (*KeepAliveRequest) ProtoMessage()    {}
+func (*KeepAliveRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{17}
+}
+
+func (m *KeepAliveRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_KeepAliveRequest.Unmarshal(m, b)
+}
+func (m *KeepAliveRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_KeepAliveRequest.Marshal(b, m, deterministic)
+}
+func (m *KeepAliveRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_KeepAliveRequest.Merge(m, src)
+}
+func (m *KeepAliveRequest) XXX_Size() int {
+	return xxx_messageInfo_KeepAliveRequest.Size(m)
+}
+func (m *KeepAliveRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_KeepAliveRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_KeepAliveRequest proto.InternalMessageInfo
+
+func (m *KeepAliveRequest) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+// A request for the state of a lease.
+type LeaseTimeToLiveRequest struct {
+	// The ID of the lease.
+	Id int64 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	// Whether to list the names of the records attached to the lease.
+	Names                bool     `protobuf:"varint,2,opt,name=names,proto3" json:"names,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LeaseTimeToLiveRequest) Reset()         { *m = LeaseTimeToLiveRequest{} }
+func (m *LeaseTimeToLiveRequest) String() string { return proto.CompactTextString(m) }
+func (*LeaseTimeToLiveRequest) ProtoMessage()    {}
+func (*LeaseTimeToLiveRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{18}
+}
+
+func (m *LeaseTimeToLiveRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LeaseTimeToLiveRequest.Unmarshal(m, b)
+}
+func (m *LeaseTimeToLiveRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LeaseTimeToLiveRequest.Marshal(b, m, deterministic)
+}
+func (m *LeaseTimeToLiveRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LeaseTimeToLiveRequest.Merge(m, src)
+}
+func (m *LeaseTimeToLiveRequest) XXX_Size() int {
+	return xxx_messageInfo_LeaseTimeToLiveRequest.Size(m)
+}
+func (m *LeaseTimeToLiveRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_LeaseTimeToLiveRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LeaseTimeToLiveRequest proto.InternalMessageInfo
+
+func (m *LeaseTimeToLiveRequest) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *LeaseTimeToLiveRequest) GetNames() bool {
+	if m != nil {
+		return m.Names
+	}
+	return false
+}
+
+// The state of a lease.
+type LeaseTimeToLiveResponse struct {
+	// The ID of the lease.
+	Id int64 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	// The number of seconds the lease has left.
+	TtlSeconds int64 `protobuf:"varint,2,opt,name=ttl_seconds,json=ttlSeconds,proto3" json:"ttl_seconds,omitempty"`
+	// The number of seconds the lease was granted for.
+	GrantedTtlSeconds int64 `protobuf:"varint,3,opt,name=granted_ttl_seconds,json=grantedTtlSeconds,proto3" json:"granted_ttl_seconds,omitempty"`
+	// The names of the records attached to the lease, if requested.
+	Names                []string `protobuf:"bytes,4,rep,name=names,proto3" json:"names,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LeaseTimeToLiveResponse) Reset()         { *m = LeaseTimeToLiveResponse{} }
+func (m *LeaseTimeToLiveResponse) String() string { return proto.CompactTextString(m) }
+func (*LeaseTimeToLiveResponse) ProtoMessage()    {}
+func (*LeaseTimeToLiveResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{19}
+}
+
+func (m *LeaseTimeToLiveResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LeaseTimeToLiveResponse.Unmarshal(m, b)
+}
+func (m *LeaseTimeToLiveResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LeaseTimeToLiveResponse.Marshal(b, m, deterministic)
+}
+func (m *LeaseTimeToLiveResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LeaseTimeToLiveResponse.Merge(m, src)
+}
+func (m *LeaseTimeToLiveResponse) XXX_Size() int {
+	return xxx_messageInfo_LeaseTimeToLiveResponse.Size(m)
+}
+func (m *LeaseTimeToLiveResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_LeaseTimeToLiveResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LeaseTimeToLiveResponse proto.InternalMessageInfo
+
+func (m *LeaseTimeToLiveResponse) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *LeaseTimeToLiveResponse) GetTtlSeconds() int64 {
+	if m != nil {
+		return m.TtlSeconds
+	}
+	return 0
+}
+
+func (m *LeaseTimeToLiveResponse) GetGrantedTtlSeconds() int64 {
+	if m != nil {
+		return m.GrantedTtlSeconds
+	}
+	return 0
+}
+
+func (m *LeaseTimeToLiveResponse) GetNames() []string {
+	if m != nil {
+		return m.Names
+	}
+	return nil
+}
+
+// A mutation replicated through the Raft log of a cluster.
+type Command struct {
+	// The node that proposed the command.
+	NodeId uint64 `protobuf:"varint,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`
+	// Identifies the proposal among those made by node_id.
+	ProposalId uint64 `protobuf:"varint,2,opt,name=proposal_id,json=proposalId,proto3" json:"proposal_id,omitempty"`
+	// Types that are valid to be assigned to Op:
+	//	*Command_Create
+	//	*Command_Update
+	//	*Command_Delete
+	//	*Command_Txn
+	//	*Command_GrantLease
+	//	*Command_RevokeLease
+	//	*Command_KeepAlive
+	Op isCommand_Op `protobuf_oneof:"op"`
+	// The user that proposed the command, if authentication is enabled. A
+	// lease records the user that granted it.
+	User                 string   `protobuf:"bytes,10,opt,name=user,proto3" json:"user,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Command) Reset()         { *m = Command{} }
+func (m *Command) String() string { return proto.CompactTextString(m) }
+func (*Command) ProtoMessage()    {}
+func (*Command) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{20}
+}
+
+func (m *Command) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Command.Unmarshal(m, b)
+}
+func (m *Command) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Command.Marshal(b, m, deterministic)
+}
+func (m *Command) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Command.Merge(m, src)
+}
+func (m *Command) XXX_Size() int {
+	return xxx_messageInfo_Command.Size(m)
+}
+func (m *Command) XXX_DiscardUnknown() {
+	xxx_messageInfo_Command.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Command proto.InternalMessageInfo
+
+func (m *Command) GetNodeId() uint64 {
+	if m != nil {
+		return m.NodeId
+	}
+	return 0
+}
+
+func (m *Command) GetProposalId() uint64 {
+	if m != nil {
+		return m.ProposalId
+	}
+	return 0
+}
+
+type isCommand_Op interface {
+	isCommand_Op()
+}
+
+type Command_Create struct {
+	Create *CreateRecordRequest `protobuf:"bytes,3,opt,name=create,proto3,oneof"`
+}
+
+type Command_Update struct {
+	Update *UpdateRecordRequest `protobuf:"bytes,4,opt,name=update,proto3,oneof"`
+}
+
+type Command_Delete struct {
+	Delete *DeleteRecordRequest `protobuf:"bytes,5,opt,name=delete,proto3,oneof"`
+}
+
+type Command_Txn struct {
+	Txn *TxnRequest `protobuf:"bytes,6,opt,name=txn,proto3,oneof"`
+}
+
+type Command_GrantLease struct {
+	GrantLease *GrantLeaseRequest `protobuf:"bytes,7,opt,name=grant_lease,json=grantLease,proto3,oneof"`
+}
+
+type Command_RevokeLease struct {
+	RevokeLease *RevokeLeaseRequest `protobuf:"bytes,8,opt,name=revoke_lease,json=revokeLease,proto3,oneof"`
+}
+
+type Command_KeepAlive struct {
+	KeepAlive *KeepAliveRequest `protobuf:"bytes,9,opt,name=keep_alive,json=keepAlive,proto3,oneof"`
+}
+
+func (*Command_Create) isCommand_Op() {}
+
+func (*Command_Update) isCommand_Op() {}
+
+func (*Command_Delete) isCommand_Op() {}
+
+func (*Command_Txn) isCommand_Op() {}
+
+func (*Command_GrantLease) isCommand_Op() {}
+
+func (*Command_RevokeLease) isCommand_Op() {}
+
+func (*Command_KeepAlive) isCommand_Op() {}
+
+func (m *Command) GetOp() isCommand_Op {
+	if m != nil {
+		return m.Op
+	}
+	return nil
+}
+
+func (m *Command) GetCreate() *CreateRecordRequest {
+	if x, ok := m.GetOp().(*Command_Create); ok {
+		return x.Create
+	}
+	return nil
+}
+
+func (m *Command) GetUpdate() *UpdateRecordRequest {
+	if x, ok := m.GetOp().(*Command_Update); ok {
+		return x.Update
+	}
+	return nil
+}
+
+func (m *Command) GetDelete() *DeleteRecordRequest {
+	if x, ok := m.GetOp().(*Command_Delete); ok {
+		return x.Delete
+	}
+	return nil
+}
+
+func (m *Command) GetTxn() *TxnRequest {
+	if x, ok := m.GetOp().(*Command_Txn); ok {
+		return x.Txn
+	}
+	return nil
+}
+
+func (m *Command) GetGrantLease() *GrantLeaseRequest {
+	if x, ok := m.GetOp().(*Command_GrantLease); ok {
+		return x.GrantLease
+	}
+	return nil
+}
+
+func (m *Command) GetRevokeLease() *RevokeLeaseRequest {
+	if x, ok := m.GetOp().(*Command_RevokeLease); ok {
+		return x.RevokeLease
+	}
+	return nil
+}
+
+func (m *Command) GetKeepAlive() *KeepAliveRequest {
+	if x, ok := m.GetOp().(*Command_KeepAlive); ok {
+		return x.KeepAlive
+	}
+	return nil
+}
+
+func (m *Command) GetUser() string {
+	if m != nil {
+		return m.User
+	}
+	return ""
+}
+
+// XXX_OneofWrappers is for the internal use of the proto package.
+func (*Command) XXX_OneofWrappers() []interface{} {
+	return []interface{}{
+		(*Command_Create)(nil),
+		(*Command_Update)(nil),
+		(*Command_Delete)(nil),
+		(*Command_Txn)(nil),
+		(*Command_GrantLease)(nil),
+		(*Command_RevokeLease)(nil),
+		(*Command_KeepAlive)(nil),
+	}
+}
+
+// A lease as stored in its reserved record and in cluster snapshots.
+type LeaseState struct {
+	Id         int64 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	TtlSeconds int64 `protobuf:"varint,2,opt,name=ttl_seconds,json=ttlSeconds,proto3" json:"ttl_seconds,omitempty"`
+	// The user that granted the lease, if authentication was enabled.
+	Owner                string   `protobuf:"bytes,3,opt,name=owner,proto3" json:"owner,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *LeaseState) Reset()         { *m = LeaseState{} }
+func (m *LeaseState) String() string { return proto.CompactTextString(m) }
+func (*LeaseState) ProtoMessage()    {}
+func (*LeaseState) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{21}
+}
+
+func (m *LeaseState) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_LeaseState.Unmarshal(m, b)
+}
+func (m *LeaseState) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_LeaseState.Marshal(b, m, deterministic)
+}
+func (m *LeaseState) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_LeaseState.Merge(m, src)
+}
+func (m *LeaseState) XXX_Size() int {
+	return xxx_messageInfo_LeaseState.Size(m)
+}
+func (m *LeaseState) XXX_DiscardUnknown() {
+	xxx_messageInfo_LeaseState.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_LeaseState proto.InternalMessageInfo
+
+func (m *LeaseState) GetId() int64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *LeaseState) GetTtlSeconds() int64 {
+	if m != nil {
+		return m.TtlSeconds
+	}
+	return 0
+}
+
+func (m *LeaseState) GetOwner() string {
+	if m != nil {
+		return m.Owner
+	}
+	return ""
+}
+
+// The contents of a cluster snapshot.
+type StoreSnapshot struct {
+	// The store revision of the latest mutation.
+	Revision int64         `protobuf:"varint,1,opt,name=revision,proto3" json:"revision,omitempty"`
+	Records  []*Record     `protobuf:"bytes,2,rep,name=records,proto3" json:"records,omitempty"`
+	Leases   []*LeaseState `protobuf:"bytes,3,rep,name=leases,proto3" json:"leases,omitempty"`
+	// The ID of the next lease to be granted.
+	NextLeaseId          int64    `protobuf:"varint,4,opt,name=next_lease_id,json=nextLeaseId,proto3" json:"next_lease_id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *StoreSnapshot) Reset()         { *m = StoreSnapshot{} }
+func (m *StoreSnapshot) String() string { return proto.CompactTextString(m) }
+func (*StoreSnapshot) ProtoMessage()    {}
+func (*StoreSnapshot) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{22}
+}
+
+func (m *StoreSnapshot) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StoreSnapshot.Unmarshal(m, b)
+}
+func (m *StoreSnapshot) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StoreSnapshot.Marshal(b, m, deterministic)
+}
+func (m *StoreSnapshot) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StoreSnapshot.Merge(m, src)
+}
+func (m *StoreSnapshot) XXX_Size() int {
+	return xxx_messageInfo_StoreSnapshot.Size(m)
+}
+func (m *StoreSnapshot) XXX_DiscardUnknown() {
+	xxx_messageInfo_StoreSnapshot.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StoreSnapshot proto.InternalMessageInfo
+
+func (m *StoreSnapshot) GetRevision() int64 {
+	if m != nil {
+		return m.Revision
+	}
+	return 0
+}
+
+func (m *StoreSnapshot) GetRecords() []*Record {
+	if m != nil {
+		return m.Records
+	}
+	return nil
+}
+
+func (m *StoreSnapshot) GetLeases() []*LeaseState {
+	if m != nil {
+		return m.Leases
+	}
+	return nil
+}
+
+func (m *StoreSnapshot) GetNextLeaseId() int64 {
+	if m != nil {
+		return m.NextLeaseId
+	}
+	return 0
+}
+
+// A message between the Raft nodes of a cluster.
+type RaftMessage struct {
+	// A marshalled raftpb.Message.
+	Data                 []byte   `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RaftMessage) Reset()         { *m = RaftMessage{} }
+func (m *RaftMessage) String() string { return proto.CompactTextString(m) }
+func (*RaftMessage) ProtoMessage()    {}
+func (*RaftMessage) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{23}
+}
+
+func (m *RaftMessage) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RaftMessage.Unmarshal(m, b)
+}
+func (m *RaftMessage) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RaftMessage.Marshal(b, m, deterministic)
+}
+func (m *RaftMessage) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RaftMessage.Merge(m, src)
+}
+func (m *RaftMessage) XXX_Size() int {
+	return xxx_messageInfo_RaftMessage.Size(m)
+}
+func (m *RaftMessage) XXX_DiscardUnknown() {
+	xxx_messageInfo_RaftMessage.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RaftMessage proto.InternalMessageInfo
+
+func (m *RaftMessage) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+type RaftMessageAck struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RaftMessageAck) Reset()         { *m = RaftMessageAck{} }
+func (m *RaftMessageAck) String() string { return proto.CompactTextString(m) }
+func (*RaftMessageAck) ProtoMessage()    {}
+func (*RaftMessageAck) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{24}
+}
+
+func (m *RaftMessageAck) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RaftMessageAck.Unmarshal(m, b)
+}
+func (m *RaftMessageAck) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RaftMessageAck.Marshal(b, m, deterministic)
+}
+func (m *RaftMessageAck) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RaftMessageAck.Merge(m, src)
+}
+func (m *RaftMessageAck) XXX_Size() int {
+	return xxx_messageInfo_RaftMessageAck.Size(m)
+}
+func (m *RaftMessageAck) XXX_DiscardUnknown() {
+	xxx_messageInfo_RaftMessageAck.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RaftMessageAck proto.InternalMessageInfo
+
+// Access to the records whose names begin with a prefix.
+type Permission struct {
+	// The prefix of the names the permission applies to. An empty prefix
+	// applies to every record.
+	Prefix string `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	// Allows GetRecord, ListRecords, and Txn comparisons and gets.
+	Read bool `protobuf:"varint,2,opt,name=read,proto3" json:"read,omitempty"`
+	// Allows CreateRecord, UpdateRecord, DeleteRecord, and Txn puts and
+	// deletes.
+	Write bool `protobuf:"varint,3,opt,name=write,proto3" json:"write,omitempty"`
+	// Allows WatchRecord.
+	Watch                bool     `protobuf:"varint,4,opt,name=watch,proto3" json:"watch,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Permission) Reset()         { *m = Permission{} }
+func (m *Permission) String() string { return proto.CompactTextString(m) }
+func (*Permission) ProtoMessage()    {}
+func (*Permission) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{25}
+}
+
+func (m *Permission) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Permission.Unmarshal(m, b)
+}
+func (m *Permission) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Permission.Marshal(b, m, deterministic)
+}
+func (m *Permission) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Permission.Merge(m, src)
+}
+func (m *Permission) XXX_Size() int {
+	return xxx_messageInfo_Permission.Size(m)
+}
+func (m *Permission) XXX_DiscardUnknown() {
+	xxx_messageInfo_Permission.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Permission proto.InternalMessageInfo
+
+func (m *Permission) GetPrefix() string {
+	if m != nil {
+		return m.Prefix
+	}
+	return ""
+}
+
+func (m *Permission) GetRead() bool {
+	if m != nil {
+		return m.Read
+	}
+	return false
+}
+
+func (m *Permission) GetWrite() bool {
+	if m != nil {
+		return m.Write
+	}
+	return false
+}
+
+func (m *Permission) GetWatch() bool {
+	if m != nil {
+		return m.Watch
+	}
+	return false
+}
+
+// A named set of permissions granted to users. The built-in role "root"
+// grants every permission, including managing users and roles.
+type Role struct {
+	Name                 string        `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Permissions          []*Permission `protobuf:"bytes,2,rep,name=permissions,proto3" json:"permissions,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}      `json:"-"`
+	XXX_unrecognized     []byte        `json:"-"`
+	XXX_sizecache        int32         `json:"-"`
+}
+
+func (m *Role) Reset()         { *m = Role{} }
+func (m *Role) String() string { return proto.CompactTextString(m) }
+func (*Role) ProtoMessage()    {}
+func (*Role) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{26}
+}
+
+func (m *Role) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Role.Unmarshal(m, b)
+}
+func (m *Role) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Role.Marshal(b, m, deterministic)
+}
+func (m *Role) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Role.Merge(m, src)
+}
+func (m *Role) XXX_Size() int {
+	return xxx_messageInfo_Role.Size(m)
+}
+func (m *Role) XXX_DiscardUnknown() {
+	xxx_messageInfo_Role.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Role proto.InternalMessageInfo
+
+func (m *Role) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Role) GetPermissions() []*Permission {
+	if m != nil {
+		return m.Permissions
+	}
+	return nil
+}
+
+// A user who may authenticate with a password, a token or a client
+// certificate whose common name is the user's name. The built-in user
+// "root" has the root role.
+type User struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// The names of the roles granted to the user.
+	Roles                []string `protobuf:"bytes,2,rep,name=roles,proto3" json:"roles,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *User) Reset()         { *m = User{} }
+func (m *User) String() string { return proto.CompactTextString(m) }
+func (*User) ProtoMessage()    {}
+func (*User) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{27}
+}
+
+func (m *User) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_User.Unmarshal(m, b)
+}
+func (m *User) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_User.Marshal(b, m, deterministic)
+}
+func (m *User) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_User.Merge(m, src)
+}
+func (m *User) XXX_Size() int {
+	return xxx_messageInfo_User.Size(m)
+}
+func (m *User) XXX_DiscardUnknown() {
+	xxx_messageInfo_User.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_User proto.InternalMessageInfo
+
+func (m *User) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *User) GetRoles() []string {
+	if m != nil {
+		return m.Roles
+	}
+	return nil
+}
+
+// A request to create or replace a user.
+type PutUserRequest struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// The user's new password. Empty keeps the current password, if any.
+	Password string `protobuf:"bytes,2,opt,name=password,proto3" json:"password,omitempty"`
+	// The names of the roles granted to the user.
+	Roles []string `protobuf:"bytes,3,rep,name=roles,proto3" json:"roles,omitempty"`
+	// Invalidate every token issued to the user.
+	RevokeTokens         bool     `protobuf:"varint,4,opt,name=revoke_tokens,json=revokeTokens,proto3" json:"revoke_tokens,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PutUserRequest) Reset()         { *m = PutUserRequest{} }
+func (m *PutUserRequest) String() string { return proto.CompactTextString(m) }
+func (*PutUserRequest) ProtoMessage()    {}
+func (*PutUserRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{28}
+}
+
+func (m *PutUserRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PutUserRequest.Unmarshal(m, b)
+}
+func (m *PutUserRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PutUserRequest.Marshal(b, m, deterministic)
+}
+func (m *PutUserRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PutUserRequest.Merge(m, src)
+}
+func (m *PutUserRequest) XXX_Size() int {
+	return xxx_messageInfo_PutUserRequest.Size(m)
+}
+func (m *PutUserRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PutUserRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PutUserRequest proto.InternalMessageInfo
+
+func (m *PutUserRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *PutUserRequest) GetPassword() string {
+	if m != nil {
+		return m.Password
+	}
+	return ""
+}
+
+func (m *PutUserRequest) GetRoles() []string {
+	if m != nil {
+		return m.Roles
+	}
+	return nil
+}
+
+func (m *PutUserRequest) GetRevokeTokens() bool {
+	if m != nil {
+		return m.RevokeTokens
+	}
+	return false
+}
+
+// A request to delete a user and invalidate their tokens.
+type DeleteUserRequest struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DeleteUserRequest) Reset()         { *m = DeleteUserRequest{} }
+func (m *DeleteUserRequest) String() string { return proto.CompactTextString(m) }
+func (*DeleteUserRequest) ProtoMessage()    {}
+func (*DeleteUserRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{29}
+}
+
+func (m *DeleteUserRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeleteUserRequest.Unmarshal(m, b)
+}
+func (m *DeleteUserRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeleteUserRequest.Marshal(b, m, deterministic)
+}
+func (m *DeleteUserRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeleteUserRequest.Merge(m, src)
+}
+func (m *DeleteUserRequest) XXX_Size() int {
+	return xxx_messageInfo_DeleteUserRequest.Size(m)
+}
+func (m *DeleteUserRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeleteUserRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DeleteUserRequest proto.InternalMessageInfo
+
+func (m *DeleteUserRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+type ListUsersRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListUsersRequest) Reset()         { *m = ListUsersRequest{} }
+func (m *ListUsersRequest) String() string { return proto.CompactTextString(m) }
+func (*ListUsersRequest) ProtoMessage()    {}
+func (*ListUsersRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{30}
+}
+
+func (m *ListUsersRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListUsersRequest.Unmarshal(m, b)
+}
+func (m *ListUsersRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListUsersRequest.Marshal(b, m, deterministic)
+}
+func (m *ListUsersRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListUsersRequest.Merge(m, src)
+}
+func (m *ListUsersRequest) XXX_Size() int {
+	return xxx_messageInfo_ListUsersRequest.Size(m)
+}
+func (m *ListUsersRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListUsersRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListUsersRequest proto.InternalMessageInfo
+
+type ListUsersResponse struct {
+	Users                []*User  `protobuf:"bytes,1,rep,name=users,proto3" json:"users,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListUsersResponse) Reset()         { *m = ListUsersResponse{} }
+func (m *ListUsersResponse) String() string { return proto.CompactTextString(m) }
+func (*ListUsersResponse) ProtoMessage()    {}
+func (*ListUsersResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{31}
+}
+
+func (m *ListUsersResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListUsersResponse.Unmarshal(m, b)
+}
+func (m *ListUsersResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListUsersResponse.Marshal(b, m, deterministic)
+}
+func (m *ListUsersResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListUsersResponse.Merge(m, src)
+}
+func (m *ListUsersResponse) XXX_Size() int {
+	return xxx_messageInfo_ListUsersResponse.Size(m)
+}
+func (m *ListUsersResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListUsersResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListUsersResponse proto.InternalMessageInfo
+
+func (m *ListUsersResponse) GetUsers() []*User {
+	if m != nil {
+		return m.Users
+	}
+	return nil
+}
+
+// A request to create or replace a role.
+type PutRoleRequest struct {
+	Role                 *Role    `protobuf:"bytes,1,opt,name=role,proto3" json:"role,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PutRoleRequest) Reset()         { *m = PutRoleRequest{} }
+func (m *PutRoleRequest) String() string { return proto.CompactTextString(m) }
+func (*PutRoleRequest) ProtoMessage()    {}
+func (*PutRoleRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{32}
+}
+
+func (m *PutRoleRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PutRoleRequest.Unmarshal(m, b)
+}
+func (m *PutRoleRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PutRoleRequest.Marshal(b, m, deterministic)
+}
+func (m *PutRoleRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PutRoleRequest.Merge(m, src)
+}
+func (m *PutRoleRequest) XXX_Size() int {
+	return xxx_messageInfo_PutRoleRequest.Size(m)
+}
+func (m *PutRoleRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_PutRoleRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PutRoleRequest proto.InternalMessageInfo
+
+func (m *PutRoleRequest) GetRole() *Role {
+	if m != nil {
+		return m.Role
+	}
+	return nil
+}
+
+// A request to delete a role. Users granted the role lose its permissions.
+type DeleteRoleRequest struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DeleteRoleRequest) Reset()         { *m = DeleteRoleRequest{} }
+func (m *DeleteRoleRequest) String() string { return proto.CompactTextString(m) }
+func (*DeleteRoleRequest) ProtoMessage()    {}
+func (*DeleteRoleRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{33}
+}
+
+func (m *DeleteRoleRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeleteRoleRequest.Unmarshal(m, b)
+}
+func (m *DeleteRoleRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeleteRoleRequest.Marshal(b, m, deterministic)
+}
+func (m *DeleteRoleRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeleteRoleRequest.Merge(m, src)
+}
+func (m *DeleteRoleRequest) XXX_Size() int {
+	return xxx_messageInfo_DeleteRoleRequest.Size(m)
+}
+func (m *DeleteRoleRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeleteRoleRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DeleteRoleRequest proto.InternalMessageInfo
+
+func (m *DeleteRoleRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+type ListRolesRequest struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListRolesRequest) Reset()         { *m = ListRolesRequest{} }
+func (m *ListRolesRequest) String() string { return proto.CompactTextString(m) }
+func (*ListRolesRequest) ProtoMessage()    {}
+func (*ListRolesRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{34}
+}
+
+func (m *ListRolesRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListRolesRequest.Unmarshal(m, b)
+}
+func (m *ListRolesRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListRolesRequest.Marshal(b, m, deterministic)
+}
+func (m *ListRolesRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListRolesRequest.Merge(m, src)
+}
+func (m *ListRolesRequest) XXX_Size() int {
+	return xxx_messageInfo_ListRolesRequest.Size(m)
+}
+func (m *ListRolesRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListRolesRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListRolesRequest proto.InternalMessageInfo
+
+type ListRolesResponse struct {
+	Roles                []*Role  `protobuf:"bytes,1,rep,name=roles,proto3" json:"roles,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListRolesResponse) Reset()         { *m = ListRolesResponse{} }
+func (m *ListRolesResponse) String() string { return proto.CompactTextString(m) }
+func (*ListRolesResponse) ProtoMessage()    {}
+func (*ListRolesResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{35}
+}
+
+func (m *ListRolesResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListRolesResponse.Unmarshal(m, b)
+}
+func (m *ListRolesResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListRolesResponse.Marshal(b, m, deterministic)
+}
+func (m *ListRolesResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListRolesResponse.Merge(m, src)
+}
+func (m *ListRolesResponse) XXX_Size() int {
+	return xxx_messageInfo_ListRolesResponse.Size(m)
+}
+func (m *ListRolesResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListRolesResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListRolesResponse proto.InternalMessageInfo
+
+func (m *ListRolesResponse) GetRoles() []*Role {
+	if m != nil {
+		return m.Roles
+	}
+	return nil
+}
+
+// A request for a bearer token that authenticates as a user until the user
+// is deleted or their tokens are revoked.
+type CreateTokenRequest struct {
+	// The user to issue the token to. Empty means the caller. Only root may
+	// issue tokens to other users.
+	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CreateTokenRequest) Reset()         { *m = CreateTokenRequest{} }
+func (m *CreateTokenRequest) String() string { return proto.CompactTextString(m) }
+func (*CreateTokenRequest) ProtoMessage()    {}
+func (*CreateTokenRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{36}
+}
+
+func (m *CreateTokenRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CreateTokenRequest.Unmarshal(m, b)
+}
+func (m *CreateTokenRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CreateTokenRequest.Marshal(b, m, deterministic)
+}
+func (m *CreateTokenRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CreateTokenRequest.Merge(m, src)
+}
+func (m *CreateTokenRequest) XXX_Size() int {
+	return xxx_messageInfo_CreateTokenRequest.Size(m)
+}
+func (m *CreateTokenRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_CreateTokenRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CreateTokenRequest proto.InternalMessageInfo
+
+func (m *CreateTokenRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+type CreateTokenResponse struct {
+	Token                string   `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *CreateTokenResponse) Reset()         { *m = CreateTokenResponse{} }
+func (m *CreateTokenResponse) String() string { return proto.CompactTextString(m) }
+func (*CreateTokenResponse) ProtoMessage()    {}
+func (*CreateTokenResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{37}
+}
+
+func (m *CreateTokenResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_CreateTokenResponse.Unmarshal(m, b)
+}
+func (m *CreateTokenResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_CreateTokenResponse.Marshal(b, m, deterministic)
+}
+func (m *CreateTokenResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CreateTokenResponse.Merge(m, src)
+}
+func (m *CreateTokenResponse) XXX_Size() int {
+	return xxx_messageInfo_CreateTokenResponse.Size(m)
+}
+func (m *CreateTokenResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_CreateTokenResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CreateTokenResponse proto.InternalMessageInfo
+
+func (m *CreateTokenResponse) GetToken() string {
+	if m != nil {
+		return m.Token
+	}
+	return ""
+}
+
+// A user as kept in the store's reserved keyspace.
+type StoredUser struct {
+	Name  string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Roles []string `protobuf:"bytes,2,rep,name=roles,proto3" json:"roles,omitempty"`
+	// PBKDF2-SHA256 of the password. Both are empty if the user has no
+	// password.
+	PasswordSalt []byte `protobuf:"bytes,3,opt,name=password_salt,json=passwordSalt,proto3" json:"password_salt,omitempty"`
+	PasswordHash []byte `protobuf:"bytes,4,opt,name=password_hash,json=passwordHash,proto3" json:"password_hash,omitempty"`
+	// SHA-256 of each token issued to the user.
+	TokenHashes          [][]byte `protobuf:"bytes,5,rep,name=token_hashes,json=tokenHashes,proto3" json:"token_hashes,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *StoredUser) Reset()         { *m = StoredUser{} }
+func (m *StoredUser) String() string { return proto.CompactTextString(m) }
+func (*StoredUser) ProtoMessage()    {}
+func (*StoredUser) Descriptor() ([]byte, []int) {
+	return fileDescriptor_40f3a6d8264e424e, []int{38}
+}
+
+func (m *StoredUser) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_StoredUser.Unmarshal(m, b)
+}
+func (m *StoredUser) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_StoredUser.Marshal(b, m, deterministic)
+}
+func (m *StoredUser) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_StoredUser.Merge(m, src)
+}
+func (m *StoredUser) XXX_Size() int {
+	return xxx_messageInfo_StoredUser.Size(m)
+}
+func (m *StoredUser) XXX_DiscardUnknown() {
+	xxx_messageInfo_StoredUser.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_StoredUser proto.InternalMessageInfo
+
+func (m *StoredUser) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *StoredUser) GetRoles() []string {
+	if m != nil {
+		return m.Roles
+	}
+	return nil
+}
+
+func (m *StoredUser) GetPasswordSalt() []byte {
+	if m != nil {
+		return m.PasswordSalt
+	}
+	return nil
+}
+
+func (m *StoredUser) GetPasswordHash() []byte {
+	if m != nil {
+		return m.PasswordHash
+	}
+	return nil
+}
+
+func (m *StoredUser) GetTokenHashes() [][]byte {
+	if m != nil {
+		return m.TokenHashes
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterEnum("key_value.Consistency", Consistency_name, Consistency_value)
+	proto.RegisterEnum("key_value.Compare_Result", Compare_Result_name, Compare_Result_value)
+	proto.RegisterEnum("key_value.Event_EventType", Event_EventType_name, Event_EventType_value)
+	proto.RegisterType((*Record)(nil), "key_value.Record")
+	proto.RegisterType((*GetRecordRequest)(nil), "key_value.GetRecordRequest")
+	proto.RegisterType((*CreateRecordRequest)(nil), "key_value.CreateRecordRequest")
+	proto.RegisterType((*UpdateRecordRequest)(nil), "key_value.UpdateRecordRequest")
+	proto.RegisterType((*DeleteRecordRequest)(nil), "key_value.DeleteRecordRequest")
+	proto.RegisterType((*ListRecordsRequest)(nil), "key_value.ListRecordsRequest")
+	proto.RegisterType((*ListRecordsResponse)(nil), "key_value.ListRecordsResponse")
+	proto.RegisterType((*Compare)(nil), "key_value.Compare")
+	proto.RegisterType((*TxnOp)(nil), "key_value.TxnOp")
+	proto.RegisterType((*TxnOpResult)(nil), "key_value.TxnOpResult")
+	proto.RegisterType((*TxnRequest)(nil), "key_value.TxnRequest")
+	proto.RegisterType((*TxnResponse)(nil), "key_value.TxnResponse")
+	proto.RegisterType((*WatchRecordRequest)(nil), "key_value.WatchRecordRequest")
+	proto.RegisterType((*Event)(nil), "key_value.Event")
+	proto.RegisterType((*GrantLeaseRequest)(nil), "key_value.GrantLeaseRequest")
+	proto.RegisterType((*LeaseResponse)(nil), "key_value.LeaseResponse")
+	proto.RegisterType((*RevokeLeaseRequest)(nil), "key_value.RevokeLeaseRequest")
+	proto.RegisterType((*KeepAliveRequest)(nil), "key_value.KeepAliveRequest")
+	proto.RegisterType((*LeaseTimeToLiveRequest)(nil), "key_value.LeaseTimeToLiveRequest")
+	proto.RegisterType((*LeaseTimeToLiveResponse)(nil), "key_value.LeaseTimeToLiveResponse")
+	proto.RegisterType((*Command)(nil), "key_value.Command")
+	proto.RegisterType((*LeaseState)(nil), "key_value.LeaseState")
+	proto.RegisterType((*StoreSnapshot)(nil), "key_value.StoreSnapshot")
+	proto.RegisterType((*RaftMessage)(nil), "key_value.RaftMessage")
+	proto.RegisterType((*RaftMessageAck)(nil), "key_value.RaftMessageAck")
+	proto.RegisterType((*Permission)(nil), "key_value.Permission")
+	proto.RegisterType((*Role)(nil), "key_value.Role")
+	proto.RegisterType((*User)(nil), "key_value.User")
+	proto.RegisterType((*PutUserRequest)(nil), "key_value.PutUserRequest")
+	proto.RegisterType((*DeleteUserRequest)(nil), "key_value.DeleteUserRequest")
+	proto.RegisterType((*ListUsersRequest)(nil), "key_value.ListUsersRequest")
+	proto.RegisterType((*ListUsersResponse)(nil), "key_value.ListUsersResponse")
+	proto.RegisterType((*PutRoleRequest)(nil), "key_value.PutRoleRequest")
+	proto.RegisterType((*DeleteRoleRequest)(nil), "key_value.DeleteRoleRequest")
+	proto.RegisterType((*ListRolesRequest)(nil), "key_value.ListRolesRequest")
+	proto.RegisterType((*ListRolesResponse)(nil), "key_value.ListRolesResponse")
+	proto.RegisterType((*CreateTokenRequest)(nil), "key_value.CreateTokenRequest")
+	proto.RegisterType((*CreateTokenResponse)(nil), "key_value.CreateTokenResponse")
+	proto.RegisterType((*StoredUser)(nil), "key_value.StoredUser")
+}
+
+func init() { proto.RegisterFile("key_value.proto", fileDescriptor_40f3a6d8264e424e) }
+
+var fileDescriptor_40f3a6d8264e424e = []byte{
+	// 1999 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x58, 0xdd, 0x73, 0x23, 0x47,
+	0x11, 0xd7, 0xea, 0x5b, 0x2d, 0x7f, 0xc8, 0xe3, 0x3b, 0x47, 0x51, 0x2e, 0x87, 0x6f, 0x8f, 0xe3,
+	0x7c, 0x09, 0xb8, 0x0e, 0xf3, 0x91, 0x23, 0x10, 0x88, 0x3f, 0xc4, 0xc9, 0x15, 0xc5, 0x36, 0x23,
+	0x39, 0x14, 0x79, 0x51, 0x6d, 0xb4, 0x73, 0xb2, 0xca, 0xf2, 0xee, 0xb2, 0x33, 0xf2, 0x49, 0xe1,
+	0x1f, 0xa0, 0x78, 0xa2, 0x78, 0xa6, 0xa0, 0x28, 0x1e, 0x29, 0x28, 0xaa, 0xf8, 0x6f, 0x78, 0xe7,
+	0x8f, 0xe0, 0x8d, 0x9a, 0x9e, 0xd9, 0xdd, 0xd1, 0x4a, 0xb2, 0x2a, 0x09, 0x79, 0x71, 0x69, 0x7a,
+	0x7e, 0xdd, 0xd3, 0xdd, 0xd3, 0xd3, 0xbf, 0x5e, 0xc3, 0xe6, 0x35, 0x9b, 0xf6, 0x6e, 0x9d, 0xd1,
+	0x98, 0xed, 0x07, 0xa1, 0x2f, 0x7c, 0x52, 0x89, 0x05, 0xf6, 0x3f, 0x2c, 0x28, 0x52, 0xd6, 0xf7,
+	0x43, 0x97, 0x10, 0xc8, 0x7b, 0xce, 0x0d, 0xab, 0x5b, 0xbb, 0xd6, 0x5e, 0x85, 0xe2, 0x6f, 0x72,
+	0x0f, 0x0a, 0x88, 0xab, 0x67, 0x51, 0xa8, 0x16, 0xe4, 0x29, 0x6c, 0xf6, 0x43, 0xe6, 0x08, 0xd6,
+	0x0b, 0xd9, 0xed, 0x90, 0x0f, 0x7d, 0xaf, 0x9e, 0xdb, 0xb5, 0xf6, 0x72, 0x74, 0x43, 0x89, 0xa9,
+	0x96, 0x92, 0x47, 0xb0, 0x76, 0xe3, 0xbb, 0x09, 0x2a, 0x8f, 0xa8, 0xea, 0x8d, 0xef, 0xc6, 0x90,
+	0x3a, 0x94, 0x6e, 0x59, 0x88, 0xbb, 0x05, 0xdc, 0x8d, 0x96, 0xf2, 0xec, 0x11, 0x73, 0x38, 0xab,
+	0x17, 0x51, 0xae, 0x16, 0xf6, 0xef, 0x2c, 0xa8, 0xbd, 0x64, 0x42, 0xf9, 0x4c, 0xd9, 0xaf, 0xc7,
+	0x8c, 0x8b, 0x85, 0xae, 0xbf, 0x80, 0x6a, 0xdf, 0xf7, 0xf8, 0x90, 0x0b, 0xe6, 0xf5, 0xa7, 0x18,
+	0xc0, 0xc6, 0xc1, 0xce, 0x7e, 0x92, 0x8b, 0xe3, 0x64, 0x97, 0x9a, 0x50, 0xb2, 0x07, 0xb5, 0x1b,
+	0x67, 0x12, 0x7b, 0xdd, 0x1b, 0x39, 0x83, 0x28, 0xbe, 0x1b, 0x67, 0x12, 0x79, 0xde, 0x76, 0x06,
+	0xf6, 0x87, 0xb0, 0x7d, 0xac, 0x23, 0x36, 0xdd, 0x79, 0x06, 0xc5, 0x10, 0x05, 0xe8, 0x50, 0xf5,
+	0x60, 0xcb, 0x38, 0x55, 0x23, 0x35, 0xc0, 0xfe, 0xb3, 0x05, 0xdb, 0x97, 0x81, 0xfb, 0x15, 0x4c,
+	0x90, 0x67, 0x50, 0x63, 0x93, 0x80, 0xf5, 0x05, 0x73, 0x7b, 0x51, 0x2a, 0xb3, 0xe8, 0xee, 0x66,
+	0x24, 0xff, 0x44, 0xa7, 0xf4, 0x00, 0xee, 0xc7, 0xd0, 0x99, 0x8b, 0x51, 0xe1, 0x6d, 0x47, 0x9b,
+	0x1f, 0x27, 0x17, 0x64, 0xff, 0xd6, 0x82, 0xed, 0x13, 0x36, 0x62, 0x82, 0xad, 0xce, 0xf9, 0xd7,
+	0xec, 0xca, 0x3f, 0xb3, 0x40, 0xda, 0x43, 0xae, 0x2f, 0x9f, 0x47, 0x9e, 0xec, 0x40, 0x31, 0x08,
+	0xd9, 0xab, 0xe1, 0x44, 0xfb, 0xa2, 0x57, 0xb2, 0x80, 0xb8, 0x70, 0x42, 0x11, 0x15, 0x2f, 0x2e,
+	0x48, 0x0d, 0x72, 0xcc, 0x73, 0xf1, 0x98, 0x0a, 0x95, 0x3f, 0xc9, 0x5b, 0x50, 0x09, 0x9c, 0x01,
+	0xeb, 0xf1, 0xe1, 0xe7, 0x0c, 0x4b, 0xb4, 0x40, 0xcb, 0x52, 0xd0, 0x19, 0x7e, 0xce, 0xc8, 0xdb,
+	0x00, 0xb8, 0x29, 0xfc, 0x6b, 0xa6, 0x4a, 0xb4, 0x42, 0x11, 0xde, 0x95, 0x02, 0xa9, 0x7b, 0xcd,
+	0xa6, 0xbc, 0xe7, 0x7b, 0xa3, 0x29, 0x16, 0x6a, 0x99, 0x96, 0xa5, 0xe0, 0xdc, 0x1b, 0x4d, 0xa5,
+	0x6e, 0xdf, 0x1f, 0x7b, 0x42, 0xed, 0x96, 0x70, 0xb7, 0x82, 0x12, 0xdc, 0x4e, 0x55, 0x68, 0xf9,
+	0xab, 0x55, 0x68, 0x65, 0x61, 0x85, 0xfe, 0xd1, 0x82, 0xed, 0x99, 0x94, 0xf1, 0xc0, 0xf7, 0x38,
+	0x23, 0xef, 0x42, 0x49, 0x95, 0x0f, 0xaf, 0x5b, 0xbb, 0xb9, 0xc5, 0x05, 0x16, 0x21, 0xc8, 0xb7,
+	0x60, 0xd3, 0x63, 0x13, 0xd1, 0x33, 0x12, 0xa1, 0x52, 0xba, 0x2e, 0xc5, 0x17, 0x71, 0x32, 0xee,
+	0x41, 0x01, 0xa3, 0xd3, 0x77, 0xa8, 0x16, 0xa4, 0x01, 0xe5, 0x54, 0x03, 0x88, 0xd7, 0xf6, 0xbf,
+	0xb2, 0x50, 0x3a, 0xf6, 0x6f, 0x02, 0x27, 0x64, 0x0b, 0x0b, 0xea, 0xbb, 0xf2, 0x19, 0xf0, 0xf1,
+	0x48, 0xe8, 0xf7, 0xfb, 0xe6, 0x4c, 0x76, 0x50, 0x6f, 0x9f, 0x22, 0x80, 0x6a, 0x20, 0xd9, 0x89,
+	0x5a, 0x16, 0xde, 0x70, 0x2b, 0x13, 0x35, 0xad, 0x46, 0xd2, 0x68, 0xd0, 0x8b, 0x56, 0x26, 0x69,
+	0x35, 0xcf, 0xe6, 0x1b, 0x5a, 0x41, 0x63, 0xd2, 0x2d, 0xed, 0x71, 0xaa, 0xa5, 0x15, 0x35, 0x2e,
+	0xd5, 0xd4, 0x8a, 0x6c, 0x32, 0xe4, 0x82, 0xab, 0x4b, 0x6f, 0x65, 0xa8, 0x5e, 0xdb, 0x3f, 0x92,
+	0xed, 0x16, 0xfd, 0xac, 0x40, 0xa1, 0xf9, 0x8b, 0xcb, 0xc3, 0x76, 0x2d, 0x43, 0xd6, 0xa1, 0x72,
+	0x76, 0xde, 0xed, 0xa9, 0xa5, 0x45, 0xca, 0x90, 0x6f, 0x37, 0x3b, 0x9d, 0x5a, 0x96, 0x54, 0xa1,
+	0xf4, 0x92, 0x36, 0x0f, 0xbb, 0x4d, 0x5a, 0xcb, 0x1d, 0x95, 0xa1, 0x28, 0x9c, 0x70, 0xc0, 0x84,
+	0xfd, 0x19, 0x14, 0xba, 0x13, 0xef, 0x3c, 0x20, 0x04, 0x72, 0x03, 0x26, 0x54, 0xc6, 0x5a, 0x19,
+	0x2a, 0x17, 0xe4, 0x09, 0xe4, 0x82, 0xb1, 0xca, 0xd7, 0xa2, 0x5b, 0x95, 0xb0, 0x60, 0x2c, 0xa4,
+	0x8b, 0x2e, 0xbe, 0xea, 0x38, 0x4f, 0x7a, 0x7d, 0x94, 0x87, 0xac, 0x1f, 0xd8, 0x2f, 0xa0, 0x8a,
+	0x67, 0x68, 0x6f, 0xbf, 0x40, 0x4b, 0xfb, 0x83, 0x05, 0xd0, 0x9d, 0x78, 0xd1, 0xeb, 0xfc, 0x36,
+	0x94, 0xfa, 0xea, 0xa6, 0x74, 0xa5, 0x91, 0xf9, 0x3b, 0xa4, 0x11, 0x84, 0xbc, 0x03, 0x25, 0x3e,
+	0xee, 0xf7, 0x19, 0xe7, 0xf5, 0x2c, 0xa2, 0x6b, 0x06, 0x5a, 0x39, 0x14, 0x01, 0x24, 0xf6, 0x95,
+	0x33, 0x1c, 0x8d, 0x43, 0x19, 0xc3, 0x12, 0xac, 0x06, 0xd8, 0x53, 0x0c, 0x27, 0x2e, 0xff, 0x07,
+	0x50, 0x41, 0x2b, 0xcc, 0x65, 0x2a, 0xa2, 0x32, 0x4d, 0x04, 0x33, 0x15, 0x9b, 0x9d, 0xad, 0x58,
+	0xf2, 0x5c, 0x3e, 0x1c, 0x99, 0x12, 0xae, 0x0f, 0xdd, 0x99, 0x3b, 0x14, 0xb7, 0x69, 0x04, 0xb3,
+	0xff, 0x6e, 0x01, 0xf9, 0xa5, 0x23, 0xfa, 0x57, 0xab, 0xfb, 0x67, 0xd2, 0xc9, 0xb2, 0xe8, 0x93,
+	0x5e, 0xc9, 0x2e, 0x13, 0x3a, 0xde, 0x80, 0xf5, 0x92, 0xce, 0x55, 0x46, 0x41, 0xd3, 0x73, 0xc9,
+	0x13, 0xd8, 0xc0, 0xce, 0x96, 0xa6, 0xd9, 0x75, 0x94, 0xc6, 0x35, 0xf9, 0x14, 0x36, 0x83, 0xd0,
+	0x1f, 0x84, 0x8c, 0xf3, 0x9e, 0xe7, 0x8b, 0xe1, 0xab, 0x29, 0xd6, 0x78, 0x99, 0x6e, 0x44, 0xe2,
+	0x33, 0x94, 0xda, 0x7f, 0xb2, 0xa0, 0xd0, 0xbc, 0x65, 0x9e, 0x20, 0xfb, 0x90, 0x17, 0xd3, 0x40,
+	0xb9, 0xb8, 0x71, 0xd0, 0x30, 0x02, 0xc5, 0x7d, 0xf5, 0xb7, 0x3b, 0x0d, 0x18, 0x45, 0x9c, 0x51,
+	0x24, 0xd9, 0x55, 0x45, 0xf2, 0x3e, 0x54, 0x62, 0x6d, 0x52, 0x82, 0xdc, 0xc5, 0x65, 0xb7, 0x96,
+	0x21, 0x00, 0xc5, 0x93, 0x66, 0xbb, 0xd9, 0x6d, 0xd6, 0x2c, 0xf9, 0x9b, 0x36, 0x3b, 0xbf, 0x3a,
+	0x3b, 0xae, 0x65, 0xc9, 0x1a, 0x94, 0x2f, 0xe8, 0xf9, 0x4b, 0x2a, 0x5f, 0x45, 0xce, 0xfe, 0x3e,
+	0x6c, 0xbd, 0x0c, 0x1d, 0x4f, 0xb4, 0x99, 0xc3, 0x59, 0x94, 0xce, 0x6f, 0x40, 0x55, 0x88, 0x51,
+	0x8f, 0xb3, 0xbe, 0xef, 0x61, 0x53, 0x93, 0x29, 0x00, 0x21, 0x46, 0x1d, 0x25, 0xb1, 0x3f, 0x84,
+	0x75, 0xad, 0xa0, 0x6b, 0x60, 0x03, 0xb2, 0x43, 0x57, 0x03, 0xb3, 0x43, 0x37, 0x6d, 0x21, 0x3b,
+	0x67, 0xe1, 0x9b, 0x40, 0x28, 0xbb, 0xf5, 0xaf, 0xd9, 0xcc, 0xc1, 0x29, 0x33, 0xb6, 0x0d, 0xb5,
+	0x8f, 0x18, 0x0b, 0x0e, 0x47, 0xc3, 0xdb, 0xa5, 0x98, 0x9f, 0xc2, 0x0e, 0xda, 0xe8, 0x0e, 0x6f,
+	0x58, 0xd7, 0x6f, 0x2f, 0x47, 0xca, 0x96, 0x2a, 0x2b, 0x83, 0xeb, 0x82, 0x50, 0x0b, 0xfb, 0xf7,
+	0x16, 0xbc, 0x31, 0x67, 0xe0, 0x4b, 0x86, 0x45, 0xf6, 0x61, 0x7b, 0x20, 0xd3, 0xc9, 0xdc, 0x9e,
+	0x09, 0x54, 0x3d, 0x7c, 0x4b, 0x6f, 0x75, 0x13, 0x7c, 0xec, 0x52, 0x7e, 0x37, 0x27, 0x69, 0x55,
+	0xb9, 0xf4, 0xdf, 0x1c, 0x76, 0xf2, 0x1b, 0xc7, 0x73, 0xc9, 0x1b, 0x50, 0xf2, 0x7c, 0x97, 0xf5,
+	0xb4, 0x1f, 0x79, 0x5a, 0x94, 0xcb, 0x53, 0xf4, 0x25, 0x08, 0xfd, 0xc0, 0xe7, 0xce, 0x48, 0x6e,
+	0x66, 0x71, 0x13, 0x22, 0xd1, 0xa9, 0x4b, 0x5e, 0x40, 0x51, 0xf5, 0x5b, 0x3c, 0xbe, 0x7a, 0xf0,
+	0xd0, 0xec, 0x15, 0xf3, 0x93, 0x96, 0xec, 0x5a, 0x0a, 0x2f, 0x35, 0xc7, 0x38, 0x47, 0xd5, 0xf3,
+	0x73, 0x9a, 0x0b, 0x06, 0x2c, 0xa9, 0xa9, 0xf0, 0x52, 0x53, 0x77, 0xc2, 0xc2, 0x9c, 0xe6, 0x82,
+	0xc1, 0x27, 0xe9, 0x94, 0xe4, 0x19, 0xe4, 0xc4, 0x44, 0x51, 0x40, 0xf5, 0xe0, 0xfe, 0x6c, 0x1f,
+	0x48, 0xd0, 0x12, 0x43, 0x7e, 0x06, 0x55, 0xcc, 0x64, 0x4f, 0x8d, 0xb4, 0x25, 0x54, 0x79, 0x60,
+	0xa8, 0xcc, 0x55, 0x74, 0x2b, 0x43, 0x61, 0x10, 0x0b, 0xc9, 0x11, 0xac, 0x85, 0x58, 0x7c, 0xda,
+	0x42, 0x19, 0x2d, 0xbc, 0x3d, 0xf3, 0xc2, 0xd2, 0xb5, 0x29, 0x69, 0x29, 0x4c, 0xa4, 0xe4, 0x27,
+	0x00, 0xd7, 0x8c, 0x05, 0x3d, 0x47, 0xd6, 0x26, 0x0e, 0x0c, 0xd5, 0x83, 0xb7, 0x0c, 0x0b, 0xe9,
+	0xba, 0x6d, 0x65, 0x68, 0xe5, 0x3a, 0x92, 0xc9, 0x86, 0x35, 0xe6, 0x2c, 0xac, 0x83, 0x6a, 0x58,
+	0xf2, 0xb7, 0xe6, 0x8a, 0x0e, 0x00, 0x1e, 0xd0, 0x11, 0x8e, 0xf8, 0x12, 0x05, 0x78, 0x0f, 0x0a,
+	0xfe, 0x6b, 0x8f, 0x85, 0xba, 0xb3, 0xa9, 0x85, 0xfd, 0x37, 0x0b, 0xd6, 0x3b, 0xc2, 0x0f, 0x59,
+	0xc7, 0x73, 0x02, 0x7e, 0xe5, 0xcf, 0x0e, 0x12, 0x56, 0xaa, 0x2d, 0x1b, 0xf3, 0x4c, 0x76, 0xe5,
+	0x3c, 0xf3, 0x1d, 0x28, 0x62, 0x12, 0xa3, 0x16, 0x6e, 0x5e, 0x5d, 0x12, 0x08, 0xd5, 0x20, 0x62,
+	0x03, 0xce, 0x39, 0x2a, 0xf1, 0xb2, 0x6e, 0xf5, 0x67, 0x8c, 0x14, 0x22, 0xfc, 0xd4, 0xb5, 0x1f,
+	0x41, 0x95, 0x3a, 0xaf, 0xc4, 0xc7, 0x8c, 0x73, 0x67, 0x80, 0xb9, 0x72, 0x1d, 0xe1, 0xa0, 0x9b,
+	0x6b, 0x14, 0x7f, 0xdb, 0x35, 0xd8, 0x30, 0x20, 0x87, 0xfd, 0x6b, 0xdb, 0x05, 0xb8, 0x60, 0xe1,
+	0xcd, 0x90, 0x63, 0x08, 0xcb, 0xc6, 0x58, 0x02, 0xf9, 0x90, 0x39, 0xae, 0xee, 0x00, 0xf8, 0x5b,
+	0xa6, 0xec, 0x75, 0x38, 0xd4, 0xcf, 0xa4, 0x4c, 0xd5, 0x02, 0xa5, 0x92, 0x68, 0xea, 0x79, 0x2d,
+	0x95, 0x0b, 0xbb, 0x03, 0x79, 0xea, 0x8f, 0x16, 0xcf, 0x57, 0xef, 0x41, 0x35, 0x88, 0x3d, 0x88,
+	0x52, 0x67, 0xa6, 0x23, 0xf1, 0x8f, 0x9a, 0x48, 0xfb, 0x39, 0xe4, 0x2f, 0x39, 0x0b, 0x97, 0x7d,
+	0x34, 0x86, 0xfe, 0x88, 0x29, 0x73, 0x15, 0xaa, 0x16, 0xf6, 0x6f, 0x60, 0xe3, 0x62, 0x2c, 0xa4,
+	0xd2, 0x5d, 0x0c, 0xd8, 0x80, 0x72, 0xe0, 0x70, 0xfe, 0x3a, 0x22, 0x91, 0x0a, 0x8d, 0xd7, 0x89,
+	0xdd, 0x9c, 0x61, 0x97, 0x3c, 0x86, 0x75, 0xfd, 0x30, 0x70, 0x32, 0xe5, 0x3a, 0x78, 0xfd, 0x5a,
+	0x70, 0x30, 0xe5, 0xf6, 0x53, 0xd8, 0x52, 0x4f, 0x79, 0xc5, 0xf9, 0x36, 0x81, 0x9a, 0x1c, 0x97,
+	0x25, 0x2c, 0xfa, 0xbe, 0xb0, 0xdf, 0x87, 0x2d, 0x43, 0xa6, 0xdb, 0xec, 0x13, 0x28, 0xc8, 0x17,
+	0x10, 0x8d, 0xcf, 0x9b, 0x66, 0xbb, 0x91, 0x67, 0xa8, 0x5d, 0xfb, 0x07, 0x18, 0xb5, 0xcc, 0x7f,
+	0x74, 0xea, 0x63, 0xc8, 0x4b, 0xc7, 0xf5, 0x1c, 0x65, 0xea, 0x21, 0x0a, 0x37, 0x13, 0x7f, 0x4d,
+	0xcd, 0x3b, 0xfc, 0x95, 0xb0, 0xb4, 0xbf, 0x5a, 0x96, 0xf8, 0xab, 0x92, 0x37, 0xef, 0x2f, 0x9e,
+	0xa1, 0x6f, 0x69, 0x0f, 0x88, 0xea, 0xb3, 0x98, 0xb8, 0xbb, 0x4e, 0x7e, 0x17, 0xb6, 0x67, 0x90,
+	0xfa, 0x9c, 0x7b, 0x50, 0x50, 0x5f, 0x08, 0x0a, 0xab, 0x16, 0xf6, 0x5f, 0x2d, 0x00, 0x7c, 0xcc,
+	0xee, 0x17, 0xab, 0x1a, 0x79, 0xbb, 0xd1, 0xfd, 0xf7, 0xb8, 0x33, 0x52, 0x9f, 0x16, 0x6b, 0x74,
+	0x2d, 0x12, 0x76, 0x9c, 0x91, 0x98, 0x01, 0x5d, 0x39, 0x5c, 0xd5, 0xbf, 0x01, 0x6a, 0x39, 0xfc,
+	0x4a, 0xfe, 0x2f, 0x02, 0x7d, 0x41, 0x04, 0xe3, 0xf5, 0xc2, 0x6e, 0x6e, 0x6f, 0x8d, 0x56, 0x51,
+	0xd6, 0x42, 0xd1, 0x3b, 0x2d, 0xa8, 0x1a, 0x9f, 0x5c, 0xa4, 0x06, 0x6b, 0xed, 0xd3, 0xb3, 0xe6,
+	0x21, 0x3d, 0xfd, 0xf4, 0xf0, 0xa8, 0xdd, 0xac, 0x65, 0xa4, 0xa4, 0xd3, 0xa4, 0xa7, 0x87, 0x6d,
+	0x2d, 0xb1, 0xc8, 0x7d, 0xd8, 0x3a, 0x3a, 0xbf, 0x3c, 0x3b, 0x69, 0x9e, 0xf4, 0x3a, 0xdd, 0xc3,
+	0x76, 0xf3, 0x0c, 0x07, 0xf7, 0x83, 0xbf, 0x14, 0x61, 0xfd, 0x23, 0x36, 0xfd, 0x44, 0xe6, 0x17,
+	0xe3, 0x26, 0x1f, 0x40, 0x25, 0xfe, 0xb7, 0x05, 0x31, 0x9b, 0x6e, 0xfa, 0x9f, 0x19, 0x8d, 0xf9,
+	0xce, 0x65, 0x67, 0xc8, 0x31, 0xac, 0x99, 0xfc, 0x47, 0x56, 0x10, 0xe3, 0x52, 0x23, 0x26, 0x15,
+	0x92, 0x15, 0x1c, 0xb9, 0xd4, 0x88, 0xc9, 0x8a, 0x64, 0x05, 0x5d, 0x2e, 0x36, 0x72, 0x06, 0x55,
+	0xe3, 0xab, 0x94, 0x98, 0x34, 0x36, 0xff, 0x81, 0xdf, 0x78, 0xb8, 0x6c, 0x5b, 0xd5, 0x9c, 0x9d,
+	0x21, 0x3f, 0x84, 0x5c, 0x77, 0xe2, 0x91, 0xc5, 0x1c, 0xdc, 0xd8, 0x49, 0x8b, 0x63, 0xbd, 0x23,
+	0xa8, 0x1a, 0xa3, 0xf9, 0x8c, 0x1f, 0xf3, 0x23, 0x7b, 0xa3, 0x96, 0x9e, 0x80, 0xed, 0xcc, 0x73,
+	0x8b, 0xfc, 0x1c, 0x20, 0x21, 0x6f, 0x72, 0x27, 0xa7, 0x37, 0xea, 0x69, 0xa6, 0x31, 0x7c, 0x69,
+	0x41, 0xd5, 0xa0, 0x70, 0x72, 0x37, 0xb5, 0xaf, 0xb0, 0x54, 0x89, 0xa9, 0x9c, 0xdc, 0x45, 0xf0,
+	0x77, 0x59, 0xd9, 0xb3, 0x9e, 0x5b, 0xe4, 0x53, 0xd8, 0x4c, 0xcd, 0x99, 0xe4, 0x51, 0x5a, 0x65,
+	0x6e, 0x88, 0x6d, 0xd8, 0x77, 0x41, 0x22, 0xfb, 0x07, 0xff, 0xb1, 0x20, 0x2f, 0x09, 0x91, 0xfc,
+	0x18, 0xf2, 0x1d, 0xc1, 0x02, 0x62, 0x5e, 0x93, 0xc1, 0x94, 0x8d, 0x37, 0x17, 0xcb, 0x25, 0x83,
+	0x66, 0xc8, 0xc9, 0xff, 0x23, 0xd6, 0xaf, 0x35, 0xce, 0x7f, 0xe7, 0x20, 0x7f, 0x38, 0x16, 0x57,
+	0xe4, 0x3d, 0x28, 0x69, 0x06, 0x24, 0x66, 0x48, 0xb3, 0xac, 0xd8, 0x48, 0x33, 0x89, 0x9d, 0x21,
+	0x1f, 0x00, 0x24, 0xec, 0x35, 0x53, 0x61, 0x73, 0xa4, 0xb6, 0x48, 0xbd, 0x05, 0x95, 0x98, 0xbf,
+	0x66, 0x52, 0x94, 0x66, 0xba, 0xc6, 0x83, 0xc5, 0x9b, 0x71, 0x9a, 0x54, 0x04, 0x38, 0x4d, 0xa4,
+	0x22, 0x30, 0x78, 0xaa, 0x91, 0xe6, 0x16, 0x33, 0x02, 0xd4, 0x9d, 0x8f, 0x60, 0x85, 0xba, 0x8e,
+	0x80, 0x22, 0x25, 0xa4, 0x23, 0x30, 0xb9, 0xaf, 0xf1, 0x60, 0xf1, 0x66, 0x1c, 0xc1, 0x19, 0x54,
+	0x0d, 0xd6, 0x9a, 0x79, 0x64, 0xf3, 0xbc, 0xd7, 0x78, 0xb8, 0x6c, 0x3b, 0xb2, 0xf7, 0x59, 0x11,
+	0xff, 0xa3, 0xfe, 0xbd, 0xff, 0x0d, 0x00, 0x49, 0xd7, 0x54, 0x0b, 0x64, 0x17, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConnInterface
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion6
+
+// KeyValueStoreClient is the client API for KeyValueStore service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type KeyValueStoreClient interface {
+	// Look up the value associated with a given key. The server sends the
+	// store revision the read was served at in a "read-revision" header.
+	GetRecord(ctx context.Context, in *GetRecordRequest, opts ...grpc.CallOption) (*Record, error)
+	// Associate a value with a given key.
+	CreateRecord(ctx context.Context, in *CreateRecordRequest, opts ...grpc.CallOption) (*Record, error)
+	// Update the value associated with a given key.
+	UpdateRecord(ctx context.Context, in *UpdateRecordRequest, opts ...grpc.CallOption) (*Record, error)
+	// Remove the value associated with a given key.
+	DeleteRecord(ctx context.Context, in *DeleteRecordRequest, opts ...grpc.CallOption) (*Record, error)
+	// List the records in a range of keys.
+	ListRecords(ctx context.Context, in *ListRecordsRequest, opts ...grpc.CallOption) (*ListRecordsResponse, error)
+	// Atomically apply one of two lists of operations depending on whether a
+	// set of conditions holds.
+	Txn(ctx context.Context, in *TxnRequest, opts ...grpc.CallOption) (*TxnResponse, error)
+	// Watch the requested record, or range of records, for updates. Once the
+	// watch is registered, the server sends a "watch-revision" header with a
+	// revision after which every matching event will be delivered.
+	WatchRecord(ctx context.Context, in *WatchRecordRequest, opts ...grpc.CallOption) (KeyValueStore_WatchRecordClient, error)
+	// Create a lease that expires unless kept alive.
+	GrantLease(ctx context.Context, in *GrantLeaseRequest, opts ...grpc.CallOption) (*LeaseResponse, error)
+	// End a lease early, deleting every record attached to it.
+	RevokeLease(ctx context.Context, in *RevokeLeaseRequest, opts ...grpc.CallOption) (*LeaseResponse, error)
+	// Keep leases alive. Each request extends a lease by its full time to live
+	// and is answered with the lease's new time to live.
+	KeepAlive(ctx context.Context, opts ...grpc.CallOption) (KeyValueStore_KeepAliveClient, error)
+	// Look up the state of a lease.
+	LeaseTimeToLive(ctx context.Context, in *LeaseTimeToLiveRequest, opts ...grpc.CallOption) (*LeaseTimeToLiveResponse, error)
+}
+
+type keyValueStoreClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewKeyValueStoreClient(cc grpc.ClientConnInterface) KeyValueStoreClient {
+	return &keyValueStoreClient{cc}
+}
+
+func (c *keyValueStoreClient) GetRecord(ctx context.Context, in *GetRecordRequest, opts ...grpc.CallOption) (*Record, error) {
+	out := new(Record)
+	err := c.cc.Invoke(ctx, "/key_value.KeyValueStore/GetRecord", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueStoreClient) CreateRecord(ctx context.Context, in *CreateRecordRequest, opts ...grpc.CallOption) (*Record, error) {
+	out := new(Record)
+	err := c.cc.Invoke(ctx, "/key_value.KeyValueStore/CreateRecord", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueStoreClient) UpdateRecord(ctx context.Context, in *UpdateRecordRequest, opts ...grpc.CallOption) (*Record, error) {
+	out := new(Record)
+	err := c.cc.Invoke(ctx, "/key_value.KeyValueStore/UpdateRecord", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueStoreClient) DeleteRecord(ctx context.Context, in *DeleteRecordRequest, opts ...grpc.CallOption) (*Record, error) {
+	out := new(Record)
+	err := c.cc.Invoke(ctx, "/key_value.KeyValueStore/DeleteRecord", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueStoreClient) ListRecords(ctx context.Context, in *ListRecordsRequest, opts ...grpc.CallOption) (*ListRecordsResponse, error) {
+	out := new(ListRecordsResponse)
+	err := c.cc.Invoke(ctx, "/key_value.KeyValueStore/ListRecords", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueStoreClient) Txn(ctx context.Context, in *TxnRequest, opts ...grpc.CallOption) (*TxnResponse, error) {
+	out := new(TxnResponse)
+	err := c.cc.Invoke(ctx, "/key_value.KeyValueStore/Txn", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueStoreClient) WatchRecord(ctx context.Context, in *WatchRecordRequest, opts ...grpc.CallOption) (KeyValueStore_WatchRecordClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_KeyValueStore_serviceDesc.Streams[0], "/key_value.KeyValueStore/WatchRecord", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &keyValueStoreWatchRecordClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type KeyValueStore_WatchRecordClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type keyValueStoreWatchRecordClient struct {
+	grpc.ClientStream
+}
+
+func (x *keyValueStoreWatchRecordClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *keyValueStoreClient) GrantLease(ctx context.Context, in *GrantLeaseRequest, opts ...grpc.CallOption) (*LeaseResponse, error) {
+	out := new(LeaseResponse)
+	err := c.cc.Invoke(ctx, "/key_value.KeyValueStore/GrantLease", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueStoreClient) RevokeLease(ctx context.Context, in *RevokeLeaseRequest, opts ...grpc.CallOption) (*LeaseResponse, error) {
+	out := new(LeaseResponse)
+	err := c.cc.Invoke(ctx, "/key_value.KeyValueStore/RevokeLease", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueStoreClient) KeepAlive(ctx context.Context, opts ...grpc.CallOption) (KeyValueStore_KeepAliveClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_KeyValueStore_serviceDesc.Streams[1], "/key_value.KeyValueStore/KeepAlive", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &keyValueStoreKeepAliveClient{stream}
+	return x, nil
+}
+
+type KeyValueStore_KeepAliveClient interface {
+	Send(*KeepAliveRequest) error
+	Recv() (*LeaseResponse, error)
+	grpc.ClientStream
+}
+
+type keyValueStoreKeepAliveClient struct {
+	grpc.ClientStream
+}
+
+func (x *keyValueStoreKeepAliveClient) Send(m *KeepAliveRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *keyValueStoreKeepAliveClient) Recv() (*LeaseResponse, error) {
+	m := new(LeaseResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *keyValueStoreClient) LeaseTimeToLive(ctx context.Context, in *LeaseTimeToLiveRequest, opts ...grpc.CallOption) (*LeaseTimeToLiveResponse, error) {
+	out := new(LeaseTimeToLiveResponse)
+	err := c.cc.Invoke(ctx, "/key_value.KeyValueStore/LeaseTimeToLive", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// KeyValueStoreServer is the server API for KeyValueStore service.
+type KeyValueStoreServer interface {
+	// Look up the value associated with a given key. The server sends the
+	// store revision the read was served at in a "read-revision" header.
+	GetRecord(context.Context, *GetRecordRequest) (*Record, error)
+	// Associate a value with a given key.
+	CreateRecord(context.Context, *CreateRecordRequest) (*Record, error)
+	// Update the value associated with a given key.
+	UpdateRecord(context.Context, *UpdateRecordRequest) (*Record, error)
+	// Remove the value associated with a given key.
+	DeleteRecord(context.Context, *DeleteRecordRequest) (*Record, error)
+	// List the records in a range of keys.
+	ListRecords(context.Context, *ListRecordsRequest) (*ListRecordsResponse, error)
+	// Atomically apply one of two lists of operations depending on whether a
+	// set of conditions holds.
+	Txn(context.Context, *TxnRequest) (*TxnResponse, error)
+	// Watch the requested record, or range of records, for updates. Once the
+	// watch is registered, the server sends a "watch-revision" header with a
+	// revision after which every matching event will be delivered.
+	WatchRecord(*WatchRecordRequest, KeyValueStore_WatchRecordServer) error
+	// Create a lease that expires unless kept alive.
+	GrantLease(context.Context, *GrantLeaseRequest) (*LeaseResponse, error)
+	// End a lease early, deleting every record attached to it.
+	RevokeLease(context.Context, *RevokeLeaseRequest) (*LeaseResponse, error)
+	// Keep leases alive. Each request extends a lease by its full time to live
+	// and is answered with the lease's new time to live.
+	KeepAlive(KeyValueStore_KeepAliveServer) error
+	// Look up the state of a lease.
+	LeaseTimeToLive(context.Context, *LeaseTimeToLiveRequest) (*LeaseTimeToLiveResponse, error)
+}
+
+// UnimplementedKeyValueStoreServer can be embedded to have forward compatible implementations.
+type UnimplementedKeyValueStoreServer struct {
+}
+
+func (*UnimplementedKeyValueStoreServer) GetRecord(ctx context.Context, req *GetRecordRequest) (*Record, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRecord not implemented")
+}
+func (*UnimplementedKeyValueStoreServer) CreateRecord(ctx context.Context, req *CreateRecordRequest) (*Record, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateRecord not implemented")
+}
+func (*UnimplementedKeyValueStoreServer) UpdateRecord(ctx context.Context, req *UpdateRecordRequest) (*Record, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateRecord not implemented")
+}
+func (*UnimplementedKeyValueStoreServer) DeleteRecord(ctx context.Context, req *DeleteRecordRequest) (*Record, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteRecord not implemented")
+}
+func (*UnimplementedKeyValueStoreServer) ListRecords(ctx context.Context, req *ListRecordsRequest) (*ListRecordsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListRecords not implemented")
+}
+func (*UnimplementedKeyValueStoreServer) Txn(ctx context.Context, req *TxnRequest) (*TxnResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Txn not implemented")
+}
+func (*UnimplementedKeyValueStoreServer) WatchRecord(req *WatchRecordRequest, srv KeyValueStore_WatchRecordServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchRecord not implemented")
+}
+func (*UnimplementedKeyValueStoreServer) GrantLease(ctx context.Context, req *GrantLeaseRequest) (*LeaseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GrantLease not implemented")
+}
+func (*UnimplementedKeyValueStoreServer) RevokeLease(ctx context.Context, req *RevokeLeaseRequest) (*LeaseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeLease not implemented")
+}
+func (*UnimplementedKeyValueStoreServer) KeepAlive(srv KeyValueStore_KeepAliveServer) error {
+	return status.Errorf(codes.Unimplemented, "method KeepAlive not implemented")
+}
+func (*UnimplementedKeyValueStoreServer) LeaseTimeToLive(ctx context.Context, req *LeaseTimeToLiveRequest) (*LeaseTimeToLiveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LeaseTimeToLive not implemented")
+}
+
+func RegisterKeyValueStoreServer(s *grpc.Server, srv KeyValueStoreServer) {
+	s.RegisterService(&_KeyValueStore_serviceDesc, srv)
+}
+
+func _KeyValueStore_GetRecord_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRecordRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueStoreServer).GetRecord(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/key_value.KeyValueStore/GetRecord",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueStoreServer).GetRecord(ctx, req.(*GetRecordRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueStore_CreateRecord_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateRecordRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueStoreServer).CreateRecord(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/key_value.KeyValueStore/CreateRecord",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueStoreServer).CreateRecord(ctx, req.(*CreateRecordRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueStore_UpdateRecord_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateRecordRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueStoreServer).UpdateRecord(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/key_value.KeyValueStore/UpdateRecord",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueStoreServer).UpdateRecord(ctx, req.(*UpdateRecordRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueStore_DeleteRecord_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRecordRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueStoreServer).DeleteRecord(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/key_value.KeyValueStore/DeleteRecord",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueStoreServer).DeleteRecord(ctx, req.(*DeleteRecordRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueStore_ListRecords_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRecordsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueStoreServer).ListRecords(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/key_value.KeyValueStore/ListRecords",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueStoreServer).ListRecords(ctx, req.(*ListRecordsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueStore_Txn_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TxnRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueStoreServer).Txn(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/key_value.KeyValueStore/Txn",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueStoreServer).Txn(ctx, req.(*TxnRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueStore_WatchRecord_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRecordRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(KeyValueStoreServer).WatchRecord(m, &keyValueStoreWatchRecordServer{stream})
+}
+
+type KeyValueStore_WatchRecordServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type keyValueStoreWatchRecordServer struct {
+	grpc.ServerStream
+}
+
+func (x *keyValueStoreWatchRecordServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _KeyValueStore_GrantLease_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GrantLeaseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueStoreServer).GrantLease(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/key_value.KeyValueStore/GrantLease",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueStoreServer).GrantLease(ctx, req.(*GrantLeaseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueStore_RevokeLease_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeLeaseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueStoreServer).RevokeLease(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/key_value.KeyValueStore/RevokeLease",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueStoreServer).RevokeLease(ctx, req.(*RevokeLeaseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueStore_KeepAlive_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(KeyValueStoreServer).KeepAlive(&keyValueStoreKeepAliveServer{stream})
+}
+
+type KeyValueStore_KeepAliveServer interface {
+	Send(*LeaseResponse) error
+	Recv() (*KeepAliveRequest, error)
+	grpc.ServerStream
+}
+
+type keyValueStoreKeepAliveServer struct {
+	grpc.ServerStream
+}
+
+func (x *keyValueStoreKeepAliveServer) Send(m *LeaseResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *keyValueStoreKeepAliveServer) Recv() (*KeepAliveRequest, error) {
+	m := new(KeepAliveRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _KeyValueStore_LeaseTimeToLive_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LeaseTimeToLiveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueStoreServer).LeaseTimeToLive(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/key_value.KeyValueStore/LeaseTimeToLive",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueStoreServer).LeaseTimeToLive(ctx, req.(*LeaseTimeToLiveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _KeyValueStore_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "key_value.KeyValueStore",
+	HandlerType: (*KeyValueStoreServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetRecord",
+			Handler:    _KeyValueStore_GetRecord_Handler,
+		},
+		{
+			MethodName: "CreateRecord",
+			Handler:    _KeyValueStore_CreateRecord_Handler,
+		},
+		{
+			MethodName: "UpdateRecord",
+			Handler:    _KeyValueStore_UpdateRecord_Handler,
+		},
+		{
+			MethodName: "DeleteRecord",
+			Handler:    _KeyValueStore_DeleteRecord_Handler,
+		},
+		{
+			MethodName: "ListRecords",
+			Handler:    _KeyValueStore_ListRecords_Handler,
+		},
+		{
+			MethodName: "Txn",
+			Handler:    _KeyValueStore_Txn_Handler,
+		},
+		{
+			MethodName: "GrantLease",
+			Handler:    _KeyValueStore_GrantLease_Handler,
+		},
+		{
+			MethodName: "RevokeLease",
+			Handler:    _KeyValueStore_RevokeLease_Handler,
+		},
+		{
+			MethodName: "LeaseTimeToLive",
+			Handler:    _KeyValueStore_LeaseTimeToLive_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchRecord",
+			Handler:       _KeyValueStore_WatchRecord_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "KeepAlive",
+			Handler:       _KeyValueStore_KeepAlive_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "key_value.proto",
+}
+
+// RaftClient is the client API for Raft service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type RaftClient interface {
+	// Deliver a message to the receiving node.
+	Step(ctx context.Context, in *RaftMessage, opts ...grpc.CallOption) (*RaftMessageAck, error)
+	// Extend a lease on the leader, which alone tracks when leases expire.
+	KeepAlive(ctx context.Context, in *KeepAliveRequest, opts ...grpc.CallOption) (*LeaseResponse, error)
+	// Look up the state of a lease on the leader.
+	LeaseTimeToLive(ctx context.Context, in *LeaseTimeToLiveRequest, opts ...grpc.CallOption) (*LeaseTimeToLiveResponse, error)
+}
+
+type raftClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRaftClient(cc grpc.ClientConnInterface) RaftClient {
+	return &raftClient{cc}
+}
+
+func (c *raftClient) Step(ctx context.Context, in *RaftMessage, opts ...grpc.CallOption) (*RaftMessageAck, error) {
+	out := new(RaftMessageAck)
+	err := c.cc.Invoke(ctx, "/key_value.Raft/Step", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *raftClient) KeepAlive(ctx context.Context, in *KeepAliveRequest, opts ...grpc.CallOption) (*LeaseResponse, error) {
+	out := new(LeaseResponse)
+	err := c.cc.Invoke(ctx, "/key_value.Raft/KeepAlive", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *raftClient) LeaseTimeToLive(ctx context.Context, in *LeaseTimeToLiveRequest, opts ...grpc.CallOption) (*LeaseTimeToLiveResponse, error) {
+	out := new(LeaseTimeToLiveResponse)
+	err := c.cc.Invoke(ctx, "/key_value.Raft/LeaseTimeToLive", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RaftServer is the server API for Raft service.
+type RaftServer interface {
+	// Deliver a message to the receiving node.
+	Step(context.Context, *RaftMessage) (*RaftMessageAck, error)
+	// Extend a lease on the leader, which alone tracks when leases expire.
+	KeepAlive(context.Context, *KeepAliveRequest) (*LeaseResponse, error)
+	// Look up the state of a lease on the leader.
+	LeaseTimeToLive(context.Context, *LeaseTimeToLiveRequest) (*LeaseTimeToLiveResponse, error)
+}
+
+// UnimplementedRaftServer can be embedded to have forward compatible implementations.
+type UnimplementedRaftServer struct {
+}
+
+func (*UnimplementedRaftServer) Step(ctx context.Context, req *RaftMessage) (*RaftMessageAck, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Step not implemented")
+}
+func (*UnimplementedRaftServer) KeepAlive(ctx context.Context, req *KeepAliveRequest) (*LeaseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method KeepAlive not implemented")
+}
+func (*UnimplementedRaftServer) LeaseTimeToLive(ctx context.Context, req *LeaseTimeToLiveRequest) (*LeaseTimeToLiveResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LeaseTimeToLive not implemented")
+}
+
+func RegisterRaftServer(s *grpc.Server, srv RaftServer) {
+	s.RegisterService(&_Raft_serviceDesc, srv)
+}
+
+func _Raft_Step_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RaftMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftServer).Step(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/key_value.Raft/Step",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(KeyValueStoreServer).UpdateRecord(ctx, req.(*UpdateRecordRequest))
+		return srv.(RaftServer).Step(ctx, req.(*RaftMessage))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _KeyValueStore_WatchRecord_Handler(srv interface{}, stream grpc.ServerStream) error {
-	m := new(WatchRecordRequest)
-	if err := stream.RecvMsg(m); err != nil {
-		return err
+func _Raft_KeepAlive_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(KeepAliveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
 	}
-	return srv.(KeyValueStoreServer).WatchRecord(m, &keyValueStoreWatchRecordServer{stream})
+	if interceptor == nil {
+		return srv.(RaftServer).KeepAlive(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/key_value.Raft/KeepAlive",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftServer).KeepAlive(ctx, req.(*KeepAliveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
 }
 
-type KeyValueStore_WatchRecordServer interface {
-	Send(*Record) error
-	grpc.ServerStream
+func _Raft_LeaseTimeToLive_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LeaseTimeToLiveRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftServer).LeaseTimeToLive(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/key_value.Raft/LeaseTimeToLive",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftServer).LeaseTimeToLive(ctx, req.(*LeaseTimeToLiveRequest))
+	}
+	return interceptor(ctx, in, info, handler)
 }
 
-type keyValueStoreWatchRecordServer struct {
-	grpc.ServerStream
+var _Raft_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "key_value.Raft",
+	HandlerType: (*RaftServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Step",
+			Handler:    _Raft_Step_Handler,
+		},
+		{
+			MethodName: "KeepAlive",
+			Handler:    _Raft_KeepAlive_Handler,
+		},
+		{
+			MethodName: "LeaseTimeToLive",
+			Handler:    _Raft_LeaseTimeToLive_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "key_value.proto",
 }
 
-func (x *keyValueStoreWatchRecordServer) Send(m *Record) error {
-	return x.ServerStream.SendMsg(m)
+// AuthClient is the client API for Auth service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type AuthClient interface {
+	// Create or replace a user.
+	PutUser(ctx context.Context, in *PutUserRequest, opts ...grpc.CallOption) (*User, error)
+	// Delete a user.
+	DeleteUser(ctx context.Context, in *DeleteUserRequest, opts ...grpc.CallOption) (*User, error)
+	// List the users, other than root.
+	ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*ListUsersResponse, error)
+	// Create or replace a role.
+	PutRole(ctx context.Context, in *PutRoleRequest, opts ...grpc.CallOption) (*Role, error)
+	// Delete a role.
+	DeleteRole(ctx context.Context, in *DeleteRoleRequest, opts ...grpc.CallOption) (*Role, error)
+	// List the roles, other than root.
+	ListRoles(ctx context.Context, in *ListRolesRequest, opts ...grpc.CallOption) (*ListRolesResponse, error)
+	// Issue a bearer token, to be sent as "authorization: Bearer <token>"
+	// metadata.
+	CreateToken(ctx context.Context, in *CreateTokenRequest, opts ...grpc.CallOption) (*CreateTokenResponse, error)
 }
 
-var _KeyValueStore_serviceDesc = grpc.ServiceDesc{
-	ServiceName: "key_value.KeyValueStore",
-	HandlerType: (*KeyValueStoreServer)(nil),
+type authClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAuthClient(cc grpc.ClientConnInterface) AuthClient {
+	return &authClient{cc}
+}
+
+func (c *authClient) PutUser(ctx context.Context, in *PutUserRequest, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/key_value.Auth/PutUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authClient) DeleteUser(ctx context.Context, in *DeleteUserRequest, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/key_value.Auth/DeleteUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authClient) ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*ListUsersResponse, error) {
+	out := new(ListUsersResponse)
+	err := c.cc.Invoke(ctx, "/key_value.Auth/ListUsers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authClient) PutRole(ctx context.Context, in *PutRoleRequest, opts ...grpc.CallOption) (*Role, error) {
+	out := new(Role)
+	err := c.cc.Invoke(ctx, "/key_value.Auth/PutRole", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authClient) DeleteRole(ctx context.Context, in *DeleteRoleRequest, opts ...grpc.CallOption) (*Role, error) {
+	out := new(Role)
+	err := c.cc.Invoke(ctx, "/key_value.Auth/DeleteRole", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authClient) ListRoles(ctx context.Context, in *ListRolesRequest, opts ...grpc.CallOption) (*ListRolesResponse, error) {
+	out := new(ListRolesResponse)
+	err := c.cc.Invoke(ctx, "/key_value.Auth/ListRoles", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authClient) CreateToken(ctx context.Context, in *CreateTokenRequest, opts ...grpc.CallOption) (*CreateTokenResponse, error) {
+	out := new(CreateTokenResponse)
+	err := c.cc.Invoke(ctx, "/key_value.Auth/CreateToken", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AuthServer is the server API for Auth service.
+type AuthServer interface {
+	// Create or replace a user.
+	PutUser(context.Context, *PutUserRequest) (*User, error)
+	// Delete a user.
+	DeleteUser(context.Context, *DeleteUserRequest) (*User, error)
+	// List the users, other than root.
+	ListUsers(context.Context, *ListUsersRequest) (*ListUsersResponse, error)
+	// Create or replace a role.
+	PutRole(context.Context, *PutRoleRequest) (*Role, error)
+	// Delete a role.
+	DeleteRole(context.Context, *DeleteRoleRequest) (*Role, error)
+	// List the roles, other than root.
+	ListRoles(context.Context, *ListRolesRequest) (*ListRolesResponse, error)
+	// Issue a bearer token, to be sent as "authorization: Bearer <token>"
+	// metadata.
+	CreateToken(context.Context, *CreateTokenRequest) (*CreateTokenResponse, error)
+}
+
+// UnimplementedAuthServer can be embedded to have forward compatible implementations.
+type UnimplementedAuthServer struct {
+}
+
+func (*UnimplementedAuthServer) PutUser(ctx context.Context, req *PutUserRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PutUser not implemented")
+}
+func (*UnimplementedAuthServer) DeleteUser(ctx context.Context, req *DeleteUserRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteUser not implemented")
+}
+func (*UnimplementedAuthServer) ListUsers(ctx context.Context, req *ListUsersRequest) (*ListUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListUsers not implemented")
+}
+func (*UnimplementedAuthServer) PutRole(ctx context.Context, req *PutRoleRequest) (*Role, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PutRole not implemented")
+}
+func (*UnimplementedAuthServer) DeleteRole(ctx context.Context, req *DeleteRoleRequest) (*Role, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteRole not implemented")
+}
+func (*UnimplementedAuthServer) ListRoles(ctx context.Context, req *ListRolesRequest) (*ListRolesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListRoles not implemented")
+}
+func (*UnimplementedAuthServer) CreateToken(ctx context.Context, req *CreateTokenRequest) (*CreateTokenResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateToken not implemented")
+}
+
+func RegisterAuthServer(s *grpc.Server, srv AuthServer) {
+	s.RegisterService(&_Auth_serviceDesc, srv)
+}
+
+func _Auth_PutUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServer).PutUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/key_value.Auth/PutUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServer).PutUser(ctx, req.(*PutUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Auth_DeleteUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServer).DeleteUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/key_value.Auth/DeleteUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServer).DeleteUser(ctx, req.(*DeleteUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Auth_ListUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServer).ListUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/key_value.Auth/ListUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServer).ListUsers(ctx, req.(*ListUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Auth_PutRole_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutRoleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServer).PutRole(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/key_value.Auth/PutRole",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServer).PutRole(ctx, req.(*PutRoleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Auth_DeleteRole_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRoleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServer).DeleteRole(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/key_value.Auth/DeleteRole",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServer).DeleteRole(ctx, req.(*DeleteRoleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Auth_ListRoles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRolesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServer).ListRoles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/key_value.Auth/ListRoles",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServer).ListRoles(ctx, req.(*ListRolesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Auth_CreateToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServer).CreateToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/key_value.Auth/CreateToken",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServer).CreateToken(ctx, req.(*CreateTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Auth_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "key_value.Auth",
+	HandlerType: (*AuthServer)(nil),
 	Methods: []grpc.MethodDesc{
 		{
-			MethodName: "GetRecord",
-			Handler:    _KeyValueStore_GetRecord_Handler,
+			MethodName: "PutUser",
+			Handler:    _Auth_PutUser_Handler,
 		},
 		{
-			MethodName: "CreateRecord",
-			Handler:    _KeyValueStore_CreateRecord_Handler,
+			MethodName: "DeleteUser",
+			Handler:    _Auth_DeleteUser_Handler,
 		},
 		{
-			MethodName: "UpdateRecord",
-			Handler:    _KeyValueStore_UpdateRecord_Handler,
+			MethodName: "ListUsers",
+			Handler:    _Auth_ListUsers_Handler,
 		},
-	},
-	Streams: []grpc.StreamDesc{
 		{
-			StreamName:    "WatchRecord",
-			Handler:       _KeyValueStore_WatchRecord_Handler,
-			ServerStreams: true,
+			MethodName: "PutRole",
+			Handler:    _Auth_PutRole_Handler,
+		},
+		{
+			MethodName: "DeleteRole",
+			Handler:    _Auth_DeleteRole_Handler,
+		},
+		{
+			MethodName: "ListRoles",
+			Handler:    _Auth_ListRoles_Handler,
+		},
+		{
+			MethodName: "CreateToken",
+			Handler:    _Auth_CreateToken_Handler,
 		},
 	},
+	Streams:  []grpc.StreamDesc{},
 	Metadata: "key_value.proto",
 }
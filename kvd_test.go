@@ -1,8 +1,17 @@
 package kvd
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"github.com/gnossen/kvd/client"
 	"github.com/gnossen/kvd/server"
 	pb "github.com/gnossen/kvd/kvd"
@@ -10,6 +19,59 @@ import (
 	"github.com/golang/protobuf/proto"
 )
 
+func dial(t *testing.T) (*grpc.ClientConn, pb.KeyValueStoreClient) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, "localhost:1234", grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		t.Fatalf("fail to dial: %v", err)
+	}
+	return conn, pb.NewKeyValueStoreClient(conn)
+}
+
+// startServer starts a server on the port dial connects to.
+func startServer(t *testing.T, opts ...server.ServerOption) *server.Server {
+	s, err := server.New(append([]server.ServerOption{server.WithAddress("tcp", ":1234")}, opts...)...)
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	go s.Serve()
+	return s
+}
+
+func withServer(t *testing.T, opts []server.ServerOption, f func(pb.KeyValueStoreClient)) {
+	s := startServer(t, opts...)
+	defer s.Stop()
+	conn, cl := dial(t)
+	defer conn.Close()
+	f(cl)
+}
+
+func expectValue(t *testing.T, cl pb.KeyValueStoreClient, name string, value string) {
+	response, err := cl.GetRecord(context.Background(), &pb.GetRecordRequest{Name: name})
+	if err != nil {
+		t.Fatalf("Get '%s' failed: %v", name, err)
+	}
+	if response.Value != value {
+		t.Fatalf("Expected '%s' at '%s', got '%s'", value, name, response.Value)
+	}
+}
+
+func expectMissing(t *testing.T, cl pb.KeyValueStoreClient, name string) {
+	_, err := cl.GetRecord(context.Background(), &pb.GetRecordRequest{Name: name})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("Expected '%s' to be missing, got '%v'", name, err)
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "kvd")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	return dir
+}
+
 func TestUnary(t *testing.T) {
 	server, lis := server.NewServer(1234)
 	go server.Serve(lis)
@@ -22,7 +84,7 @@ func TestUnary(t *testing.T) {
 	defer conn.Close()
 	cl := pb.NewKeyValueStoreClient(conn)
 	record := client.Create(cl, "foo", "oof")
-	expected := pb.Record{Name: "foo", Value: "oof"}
+	expected := pb.Record{Name: "foo", Value: "oof", CreateRevision: 1, ModRevision: 1, Version: 1}
 	if !proto.Equal(record, &expected) {
 		t.Fatalf("Expected '%v', got '%v'", expected, *record)
 	}
@@ -31,7 +93,7 @@ func TestUnary(t *testing.T) {
 		t.Fatalf("Expected '%v', got '%v'", expected, *record)
 	}
 	record = client.Update(cl, "foo", "bigoof")
-	expected = pb.Record{Name: "foo", Value: "bigoof"}
+	expected = pb.Record{Name: "foo", Value: "bigoof", CreateRevision: 1, ModRevision: 2, Version: 2}
 	if !proto.Equal(record, &expected) {
 		t.Fatalf("Expected '%v', got '%v'", expected, *record)
 	}
@@ -58,20 +120,396 @@ func TestWatch(t *testing.T) {
 		client.Update(cl, "foo", "6")
 		client.Update(cl, "foo", "7")
 	}()
-	expected := pb.Record{Name: "foo", Value: "5"}
-	record := <-c
-	if !proto.Equal(record, &expected) {
-		t.Fatalf("Expected '%v', got '%v'", expected, *record)
+	expected := pb.Event{Type: pb.Event_PUT, Record: &pb.Record{Name: "foo", Value: "5", CreateRevision: 1, ModRevision: 1, Version: 1}}
+	event := <-c
+	if !proto.Equal(event, &expected) {
+		t.Fatalf("Expected '%v', got '%v'", expected, *event)
 	}
-	expected.Value = "6"
-	record = <-c
-	if !proto.Equal(record, &expected) {
-		t.Fatalf("Expected '%v', got '%v'", expected, *record)
+	expected.Record = &pb.Record{Name: "foo", Value: "6", CreateRevision: 1, ModRevision: 2, Version: 2}
+	event = <-c
+	if !proto.Equal(event, &expected) {
+		t.Fatalf("Expected '%v', got '%v'", expected, *event)
+	}
+	expected.Record = &pb.Record{Name: "foo", Value: "7", CreateRevision: 1, ModRevision: 3, Version: 3}
+	event = <-c
+	if !proto.Equal(event, &expected) {
+		t.Fatalf("Expected '%v', got '%v'", expected, *event)
 	}
-	expected.Value = "7"
-	record = <-c
+}
+
+func TestDelete(t *testing.T) {
+	server, lis := server.NewServer(1234)
+	go server.Serve(lis)
+	defer server.Stop()
+	defer lis.Close()
+	conn, err := grpc.Dial("localhost:1234", []grpc.DialOption{grpc.WithInsecure()}...)
+	if err != nil {
+		t.Fatalf("fail to dial: %v", err)
+	}
+	defer conn.Close()
+	cl := pb.NewKeyValueStoreClient(conn)
+	c := client.Watch(cl, "foo", 2)
+	client.Create(cl, "foo", "oof")
+	record := client.Delete(cl, "foo")
+	expected := pb.Record{Name: "foo", Value: "oof", CreateRevision: 1, ModRevision: 1, Version: 1}
 	if !proto.Equal(record, &expected) {
 		t.Fatalf("Expected '%v', got '%v'", expected, *record)
 	}
+	_, err = cl.GetRecord(context.Background(), &pb.GetRecordRequest{Name: "foo"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("Expected NotFound, got '%v'", err)
+	}
+	_, err = cl.DeleteRecord(context.Background(), &pb.DeleteRecordRequest{Name: "foo"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("Expected NotFound, got '%v'", err)
+	}
+	expectedEvent := pb.Event{Type: pb.Event_PUT, Record: &expected}
+	event := <-c
+	if !proto.Equal(event, &expectedEvent) {
+		t.Fatalf("Expected '%v', got '%v'", expectedEvent, *event)
+	}
+	expectedEvent = pb.Event{Type: pb.Event_DELETE, Record: &pb.Record{Name: "foo", ModRevision: 2}}
+	event = <-c
+	if !proto.Equal(event, &expectedEvent) {
+		t.Fatalf("Expected '%v', got '%v'", expectedEvent, *event)
+	}
+}
+
+func TestList(t *testing.T) {
+	withServer(t, nil, func(cl pb.KeyValueStoreClient) {
+		for _, name := range []string{"b/2", "a", "b/1", "c", "b/3", "b0"} {
+			client.Create(cl, name, name+"!")
+		}
+		names := func(records []*pb.Record) []string {
+			var names []string
+			for _, record := range records {
+				names = append(names, record.Name)
+			}
+			return names
+		}
+		records := client.List(cl, &pb.ListRecordsRequest{Prefix: "b/", PageSize: 2})
+		if got := names(records); !reflect.DeepEqual(got, []string{"b/1", "b/2", "b/3"}) {
+			t.Fatalf("Expected prefix 'b/' to list b/1, b/2, b/3, got '%v'", got)
+		}
+		if records[0].Value != "b/1!" {
+			t.Fatalf("Expected value 'b/1!', got '%s'", records[0].Value)
+		}
+		records = client.List(cl, &pb.ListRecordsRequest{Start: "b/2", End: "c", KeysOnly: true})
+		if got := names(records); !reflect.DeepEqual(got, []string{"b/2", "b/3", "b0"}) {
+			t.Fatalf("Expected range ['b/2', 'c') to list b/2, b/3, b0, got '%v'", got)
+		}
+		if records[0].Value != "" {
+			t.Fatalf("Expected no value for keys_only, got '%s'", records[0].Value)
+		}
+		response, err := cl.ListRecords(context.Background(), &pb.ListRecordsRequest{PageSize: 4})
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		if len(response.Records) != 4 || response.NextPageToken == "" {
+			t.Fatalf("Expected a full page and a token, got '%v'", response)
+		}
+		if count := client.Count(cl, &pb.ListRecordsRequest{Prefix: "b"}); count != 4 {
+			t.Fatalf("Expected 4 records with prefix 'b', got %d", count)
+		}
+		_, err = cl.ListRecords(context.Background(), &pb.ListRecordsRequest{Prefix: "b", Start: "a"})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("Expected InvalidArgument, got '%v'", err)
+		}
+	})
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	withServer(t, nil, func(cl pb.KeyValueStoreClient) {
+		client.Create(cl, "other", "0")
+		record := client.Create(cl, "foo", "1")
+		if record.CreateRevision != 2 || record.ModRevision != 2 || record.Version != 1 {
+			t.Fatalf("Unexpected revisions for new record: '%v'", record)
+		}
+		updated, ok := client.CompareAndSwap(cl, "foo", "2", record.ModRevision)
+		if !ok {
+			t.Fatalf("Expected swap at mod_revision %d to succeed", record.ModRevision)
+		}
+		if updated.CreateRevision != 2 || updated.ModRevision != 3 || updated.Version != 2 {
+			t.Fatalf("Unexpected revisions for updated record: '%v'", updated)
+		}
+		if _, ok := client.CompareAndSwap(cl, "foo", "3", record.ModRevision); ok {
+			t.Fatalf("Expected swap at stale mod_revision %d to fail", record.ModRevision)
+		}
+		expectValue(t, cl, "foo", "2")
+		request := pb.UpdateRecordRequest{Record: &pb.Record{Name: "foo", Value: "3"}, ExpectedVersion: 1}
+		if _, err := cl.UpdateRecord(context.Background(), &request); status.Code(err) != codes.Aborted {
+			t.Fatalf("Expected Aborted, got '%v'", err)
+		}
+		if _, ok := client.CompareAndDelete(cl, "foo", record.ModRevision); ok {
+			t.Fatalf("Expected delete at stale mod_revision %d to fail", record.ModRevision)
+		}
+		if _, ok := client.CompareAndDelete(cl, "foo", updated.ModRevision); !ok {
+			t.Fatalf("Expected delete at mod_revision %d to succeed", updated.ModRevision)
+		}
+		expectMissing(t, cl, "foo")
+		record = client.Create(cl, "foo", "4")
+		if record.CreateRevision != 5 || record.Version != 1 {
+			t.Fatalf("Expected a recreated record to start over at revision 5, got '%v'", record)
+		}
+	})
+}
+
+func TestTxn(t *testing.T) {
+	withServer(t, nil, func(cl pb.KeyValueStoreClient) {
+		client.Create(cl, "flag", "off")
+		client.Create(cl, "percent", "0")
+		flagEvents := client.Watch(cl, "flag", 1)
+		percentEvents := client.Watch(cl, "percent", 1)
+		response := client.Txn(cl, &pb.TxnRequest{
+			Compare: []*pb.Compare{
+				{Name: "flag", Result: pb.Compare_EQUAL, Target: &pb.Compare_Value{Value: "off"}},
+				{Name: "missing", Result: pb.Compare_EQUAL, Target: &pb.Compare_Exists{Exists: false}},
+			},
+			Success: []*pb.TxnOp{
+				{Op: &pb.TxnOp_Put{Put: &pb.Record{Name: "flag", Value: "on"}}},
+				{Op: &pb.TxnOp_Put{Put: &pb.Record{Name: "percent", Value: "10"}}},
+				{Op: &pb.TxnOp_Get{Get: "flag"}},
+			},
+			Failure: []*pb.TxnOp{
+				{Op: &pb.TxnOp_Get{Get: "flag"}},
+			},
+		})
+		if !response.Succeeded || response.Revision != 3 || len(response.Results) != 3 {
+			t.Fatalf("Unexpected response '%v'", response)
+		}
+		if response.Results[2].Record.Value != "on" {
+			t.Fatalf("Expected a get to see an earlier put, got '%v'", response.Results[2].Record)
+		}
+		flagEvent, percentEvent := <-flagEvents, <-percentEvents
+		if flagEvent.Record.ModRevision != 3 || percentEvent.Record.ModRevision != 3 {
+			t.Fatalf("Expected both events at revision 3, got '%v' and '%v'", flagEvent, percentEvent)
+		}
+		expectValue(t, cl, "percent", "10")
+
+		response = client.Txn(cl, &pb.TxnRequest{
+			Compare: []*pb.Compare{
+				{Name: "flag", Result: pb.Compare_LESS, Target: &pb.Compare_Version{Version: 2}},
+			},
+			Success: []*pb.TxnOp{
+				{Op: &pb.TxnOp_Delete{Delete: "flag"}},
+			},
+			Failure: []*pb.TxnOp{
+				{Op: &pb.TxnOp_Delete{Delete: "percent"}},
+				{Op: &pb.TxnOp_Delete{Delete: "missing"}},
+			},
+		})
+		if response.Succeeded || response.Revision != 4 {
+			t.Fatalf("Unexpected response '%v'", response)
+		}
+		if response.Results[0].Record.Value != "10" || response.Results[1].Record != nil {
+			t.Fatalf("Unexpected delete results '%v'", response.Results)
+		}
+		expectValue(t, cl, "flag", "on")
+		expectMissing(t, cl, "percent")
+
+		_, err := cl.Txn(context.Background(), &pb.TxnRequest{
+			Success: []*pb.TxnOp{
+				{Op: &pb.TxnOp_Put{Put: &pb.Record{Name: "flag", Value: "a"}}},
+				{Op: &pb.TxnOp_Delete{Delete: "flag"}},
+			},
+		})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("Expected InvalidArgument, got '%v'", err)
+		}
+		expectValue(t, cl, "flag", "on")
+	})
+}
+
+func TestSlowWatcherDoesNotBlockWriters(t *testing.T) {
+	for _, policy := range []server.SlowConsumerPolicy{server.CoalesceSlowConsumers, server.DisconnectSlowConsumers} {
+		t.Run(policy.String(), func(t *testing.T) {
+			testSlowWatcherDoesNotBlockWriters(t, policy)
+		})
+	}
+}
+
+// testSlowWatcherDoesNotBlockWriters runs thousands of watchers, one of which
+// never reads, and checks that writes go on and the others see them all.
+// Watchers disconnected by the policy resume after the last event they saw.
+func testSlowWatcherDoesNotBlockWriters(t *testing.T, policy server.SlowConsumerPolicy) {
+	const watcherCount = 2000
+	const updateCount = 100
+	const deadline = 2 * time.Minute
+	padding := strings.Repeat("x", 1024)
+	final := fmt.Sprintf("%d%s", updateCount, padding)
+	opts := []server.ServerOption{
+		server.WithWatchQueueSize(8),
+		server.WithSlowConsumerPolicy(policy),
+	}
+	withServer(t, opts, func(cl pb.KeyValueStoreClient) {
+		client.Create(cl, "hot", "0")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		watch := func(cl pb.KeyValueStoreClient, start int64) (pb.KeyValueStore_WatchRecordClient, error) {
+			stream, err := cl.WatchRecord(ctx, &pb.WatchRecordRequest{Name: "hot", StartRevision: start})
+			if err == nil {
+				_, err = stream.Header()
+			}
+			return stream, err
+		}
+		// A watcher on its own connection that never reads.
+		stuckConn, stuck := dial(t)
+		defer stuckConn.Close()
+		if _, err := watch(stuck, 0); err != nil {
+			t.Fatalf("Failed to watch: %v", err)
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < watcherCount; i++ {
+			stream, err := watch(cl, 0)
+			if err != nil {
+				t.Fatalf("Failed to watch: %v", err)
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var last int64
+				for {
+					event, err := stream.Recv()
+					if status.Code(err) == codes.ResourceExhausted && last != 0 {
+						stream, err = watch(cl, last+1)
+						if err == nil {
+							continue
+						}
+					}
+					if err != nil {
+						if ctx.Err() == nil {
+							t.Errorf("Watch failed: %v", err)
+						}
+						return
+					}
+					last = event.Record.ModRevision
+					if event.Type == pb.Event_PUT && event.Record.Value == final {
+						return
+					}
+				}
+			}()
+		}
+		// Every goroutine must finish before the test returns, so give up by
+		// cancelling ctx and waiting for them.
+		defer wg.Wait()
+		writer := client.NewClient(cl)
+		written := make(chan struct{})
+		go func() {
+			defer close(written)
+			for i := 1; i <= updateCount; i++ {
+				if _, err := writer.Update(ctx, "hot", fmt.Sprintf("%d%s", i, padding)); err != nil {
+					if ctx.Err() == nil {
+						t.Errorf("Update failed: %v", err)
+					}
+					return
+				}
+			}
+		}()
+		defer func() { <-written }()
+		select {
+		case <-written:
+		case <-time.After(deadline):
+			cancel()
+			t.Fatalf("Writers were blocked by a slow watcher")
+		}
+		delivered := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(delivered)
+		}()
+		select {
+		case <-delivered:
+		case <-time.After(deadline):
+			cancel()
+			t.Fatalf("Watchers did not all see the final value")
+		}
+	})
+}
+
+func TestWatchPrefix(t *testing.T) {
+	withServer(t, nil, func(cl pb.KeyValueStoreClient) {
+		client.Create(cl, "services/payments/a", "1")
+		prefixEvents := client.WatchPrefix(cl, "services/payments/", 3)
+		rangeEvents := client.WatchRange(cl, "services/payments/b", "services/payments/c", 1)
+		client.Update(cl, "services/payments/a", "2")
+		client.Create(cl, "services/billing/a", "1")
+		client.Create(cl, "services/payments/b", "1")
+		client.Delete(cl, "services/payments/a")
+		expected := []pb.Event{
+			{Type: pb.Event_PUT, Record: &pb.Record{Name: "services/payments/a", Value: "2"}},
+			{Type: pb.Event_PUT, Record: &pb.Record{Name: "services/payments/b", Value: "1"}},
+			{Type: pb.Event_DELETE, Record: &pb.Record{Name: "services/payments/a"}},
+		}
+		for _, e := range expected {
+			event := <-prefixEvents
+			if event.Type != e.Type || event.Record.Name != e.Record.Name || event.Record.Value != e.Record.Value {
+				t.Fatalf("Expected '%v', got '%v'", e, *event)
+			}
+		}
+		if event := <-rangeEvents; event.Record.Name != "services/payments/b" {
+			t.Fatalf("Expected an event for 'services/payments/b', got '%v'", *event)
+		}
+		for _, request := range []*pb.WatchRecordRequest{
+			{Name: "a", Prefix: true, RangeEnd: "b"},
+			{Name: "b", RangeEnd: "a"},
+			{Name: "a", RangeEnd: "a"},
+		} {
+			stream, err := cl.WatchRecord(context.Background(), request)
+			if err == nil {
+				_, err = stream.Recv()
+			}
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("Expected InvalidArgument for '%v', got '%v'", request, err)
+			}
+		}
+	})
+}
+
+func TestWatchFromRevision(t *testing.T) {
+	withServer(t, []server.ServerOption{server.WithHistory(3, 0)}, func(cl pb.KeyValueStoreClient) {
+		client.Create(cl, "foo", "1")
+		client.Update(cl, "foo", "2")
+		client.Create(cl, "bar", "1")
+		client.Update(cl, "foo", "3")
+		events := client.WatchWithRequest(cl, &pb.WatchRecordRequest{Name: "foo", StartRevision: 2}, 3)
+		client.Update(cl, "foo", "4")
+		for _, value := range []string{"2", "3", "4"} {
+			if event := <-events; event.Record.Value != value {
+				t.Fatalf("Expected '%s', got '%v'", value, *event)
+			}
+		}
+		stream, err := cl.WatchRecord(context.Background(), &pb.WatchRecordRequest{Name: "foo", StartRevision: 1})
+		if err == nil {
+			_, err = stream.Recv()
+		}
+		if !client.IsCompacted(err) {
+			t.Fatalf("Expected a compaction error, got '%v'", err)
+		}
+	})
 }
 
+func TestReadConsistency(t *testing.T) {
+	withServer(t, nil, func(cl pb.KeyValueStoreClient) {
+		client.Create(cl, "foo", "oof")
+		client.Create(cl, "bar", "rab")
+		for _, consistency := range []pb.Consistency{pb.Consistency_LINEARIZABLE, pb.Consistency_SERIALIZABLE, pb.Consistency_BOUNDED_STALENESS} {
+			record, revision := client.GetWithRequest(cl, &pb.GetRecordRequest{Name: "foo", Consistency: consistency})
+			if record.Value != "oof" || revision != 2 {
+				t.Fatalf("Expected 'oof' at revision 2, got %v at %d", record, revision)
+			}
+		}
+		list, err := cl.ListRecords(context.Background(), &pb.ListRecordsRequest{Consistency: pb.Consistency_SERIALIZABLE})
+		if err != nil || list.Revision != 2 {
+			t.Fatalf("Expected a listing at revision 2, got %v, %v", list, err)
+		}
+		_, err = cl.GetRecord(context.Background(), &pb.GetRecordRequest{
+			Name:           "foo",
+			Consistency:    pb.Consistency_BOUNDED_STALENESS,
+			MaxRevisionLag: -1,
+		})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("Expected a negative lag to be rejected, got %v", err)
+		}
+	})
+}
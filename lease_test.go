@@ -0,0 +1,204 @@
+package kvd
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/gnossen/kvd/client"
+	pb "github.com/gnossen/kvd/kvd"
+	"github.com/gnossen/kvd/server"
+)
+
+type fakeClockWaiter struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+// fakeClock only moves when Advance is called.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeClockWaiter
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	waiter := fakeClockWaiter{deadline: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.waiters = append(c.waiters, waiter)
+	return waiter.c
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiting := c.waiters[:0]
+	for _, waiter := range c.waiters {
+		if waiter.deadline.After(c.now) {
+			waiting = append(waiting, waiter)
+		} else {
+			waiter.c <- c.now
+		}
+	}
+	c.waiters = waiting
+}
+
+// awaitEvent nudges clock forward until the server's expiry loop delivers an
+// event on events.
+func awaitEvent(t *testing.T, clock *fakeClock, events chan *pb.Event) *pb.Event {
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(10 * time.Millisecond):
+			clock.Advance(100 * time.Millisecond)
+		case <-deadline:
+			t.Fatalf("Timed out waiting for an event")
+		}
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	clock := newFakeClock()
+	withServer(t, []server.ServerOption{server.WithClock(clock)}, func(cl pb.KeyValueStoreClient) {
+		lease := client.GrantLease(cl, 5)
+		client.CreateWithLease(cl, "foo", "oof", lease)
+		client.CreateWithLease(cl, "bar", "rab", lease)
+		client.Create(cl, "baz", "zab")
+		events := client.Watch(cl, "foo", 1)
+		clock.Advance(4 * time.Second)
+		ttl := client.LeaseTimeToLive(cl, lease)
+		if ttl.TtlSeconds != 1 || ttl.GrantedTtlSeconds != 5 || !reflect.DeepEqual(ttl.Names, []string{"bar", "foo"}) {
+			t.Fatalf("Unexpected time to live %v", ttl)
+		}
+		expectValue(t, cl, "foo", "oof")
+		clock.Advance(time.Second)
+		event := awaitEvent(t, clock, events)
+		if event.Type != pb.Event_DELETE || event.Record.Name != "foo" {
+			t.Fatalf("Expected foo to be deleted, got %v", event)
+		}
+		expectMissing(t, cl, "foo")
+		expectMissing(t, cl, "bar")
+		expectValue(t, cl, "baz", "zab")
+		_, err := cl.LeaseTimeToLive(context.Background(), &pb.LeaseTimeToLiveRequest{Id: lease})
+		if status.Code(err) != codes.NotFound {
+			t.Fatalf("Expected the lease to be gone, got %v", err)
+		}
+	})
+}
+
+func TestLeaseRevoke(t *testing.T) {
+	withServer(t, nil, func(cl pb.KeyValueStoreClient) {
+		lease := client.GrantLease(cl, 60)
+		client.CreateWithLease(cl, "foo", "oof", lease)
+		client.CreateWithLease(cl, "bar", "rab", lease)
+		// Updating without a lease detaches the record.
+		client.Update(cl, "bar", "bar")
+		client.RevokeLease(cl, lease)
+		expectMissing(t, cl, "foo")
+		expectValue(t, cl, "bar", "bar")
+		_, err := cl.CreateRecord(context.Background(), &pb.CreateRecordRequest{
+			Record: &pb.Record{Name: "foo", Value: "oof", Lease: lease},
+		})
+		if status.Code(err) != codes.NotFound {
+			t.Fatalf("Expected creation with a revoked lease to fail, got %v", err)
+		}
+		_, err = cl.GrantLease(context.Background(), &pb.GrantLeaseRequest{})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("Expected a zero TTL to be rejected, got %v", err)
+		}
+	})
+}
+
+func TestLeaseKeepAlive(t *testing.T) {
+	clock := newFakeClock()
+	withServer(t, []server.ServerOption{server.WithClock(clock)}, func(cl pb.KeyValueStoreClient) {
+		lease := client.GrantLease(cl, 5)
+		client.CreateWithLease(cl, "foo", "oof", lease)
+		stream, err := cl.KeepAlive(context.Background())
+		if err != nil {
+			t.Fatalf("KeepAlive failed: %v", err)
+		}
+		keepAlive := func(id int64) int64 {
+			if err := stream.Send(&pb.KeepAliveRequest{Id: id}); err != nil {
+				t.Fatalf("Send failed: %v", err)
+			}
+			response, err := stream.Recv()
+			if err != nil {
+				t.Fatalf("Recv failed: %v", err)
+			}
+			return response.TtlSeconds
+		}
+		clock.Advance(4 * time.Second)
+		if ttl := keepAlive(lease); ttl != 5 {
+			t.Fatalf("Expected the lease to be renewed for 5s, got %d", ttl)
+		}
+		clock.Advance(4 * time.Second)
+		expectValue(t, cl, "foo", "oof")
+		if ttl := client.LeaseTimeToLive(cl, lease).TtlSeconds; ttl != 1 {
+			t.Fatalf("Expected 1s remaining, got %d", ttl)
+		}
+		if ttl := keepAlive(lease + 1); ttl != 0 {
+			t.Fatalf("Expected an unknown lease to have no time to live, got %d", ttl)
+		}
+		stream.CloseSend()
+	})
+}
+
+func TestLeaseRecovery(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	opts := []server.ServerOption{server.WithDataDir(dir)}
+	var unused, used int64
+	withServer(t, opts, func(cl pb.KeyValueStoreClient) {
+		unused = client.GrantLease(cl, 30)
+		used = client.GrantLease(cl, 5)
+		client.CreateWithLease(cl, "foo", "oof", used)
+	})
+	withServer(t, opts, func(cl pb.KeyValueStoreClient) {
+		// A lease with no records must survive, and its ID not be reused.
+		if ttl := client.LeaseTimeToLive(cl, unused); ttl.GrantedTtlSeconds != 30 {
+			t.Fatalf("Expected lease %d to keep its 30s time to live, got %v", unused, ttl)
+		}
+		ttl := client.LeaseTimeToLive(cl, used)
+		if ttl.GrantedTtlSeconds != 5 || !reflect.DeepEqual(ttl.Names, []string{"foo"}) {
+			t.Fatalf("Expected lease %d to keep its 5s time to live and foo, got %v", used, ttl)
+		}
+		if lease := client.GrantLease(cl, 5); lease == unused || lease == used {
+			t.Fatalf("Expected a new lease ID, got %d again", lease)
+		}
+		client.RevokeLease(cl, used)
+		expectMissing(t, cl, "foo")
+		if _, err := cl.GetRecord(context.Background(), &pb.GetRecordRequest{Name: "\x00lease/next"}); status.Code(err) != codes.PermissionDenied {
+			t.Fatalf("Expected leases to be reserved, got %v", err)
+		}
+	})
+	withServer(t, opts, func(cl pb.KeyValueStoreClient) {
+		_, err := cl.LeaseTimeToLive(context.Background(), &pb.LeaseTimeToLiveRequest{Id: used})
+		if status.Code(err) != codes.NotFound {
+			t.Fatalf("Expected the revoked lease to stay gone, got %v", err)
+		}
+		if lease := client.GrantLease(cl, 5); lease <= used {
+			t.Fatalf("Expected a lease ID after %d, got %d", used, lease)
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package kvd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/gnossen/kvd/kvd"
+	"github.com/gnossen/kvd/server"
+)
+
+// logBuffer collects a server's log entries.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) entries(t *testing.T) []map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Log line %q is not JSON: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// find returns the entries with all of fields.
+func find(entries []map[string]interface{}, fields map[string]interface{}) []map[string]interface{} {
+	var found []map[string]interface{}
+	for _, entry := range entries {
+		matches := true
+		for name, value := range fields {
+			if entry[name] != value {
+				matches = false
+			}
+		}
+		if matches {
+			found = append(found, entry)
+		}
+	}
+	return found
+}
+
+func TestLogging(t *testing.T) {
+	logs := &logBuffer{}
+	opts := []server.ServerOption{
+		server.WithLogOutput(logs),
+		server.WithLogLevel(server.LevelDebug),
+		server.WithValueRedaction(server.RedactPrefixes, "secret/"),
+		server.WithLogSampling(2),
+	}
+	withServer(t, opts, func(cl pb.KeyValueStoreClient) {
+		ctx := context.Background()
+		for name, value := range map[string]string{"secret/password": "hunter2", "greeting": "hello"} {
+			if _, err := cl.CreateRecord(ctx, &pb.CreateRecordRequest{Record: &pb.Record{Name: name, Value: value}}); err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+		}
+		var header metadata.MD
+		requestCtx := metadata.AppendToOutgoingContext(ctx, "x-request-id", "abc123")
+		if _, err := cl.GetRecord(requestCtx, &pb.GetRecordRequest{Name: "greeting"}, grpc.Header(&header)); err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if ids := header.Get("x-request-id"); len(ids) != 1 || ids[0] != "abc123" {
+			t.Fatalf("Expected the request ID to be returned, got %v", ids)
+		}
+		for i := 0; i < 3; i++ {
+			expectValue(t, cl, "greeting", "hello")
+		}
+		expectMissing(t, cl, "missing")
+	})
+
+	entries := logs.entries(t)
+	if strings.Contains(logs.buf.String(), "hunter2") {
+		t.Fatalf("Expected secret values to be redacted, got:\n%s", logs.buf.String())
+	}
+	for name, value := range map[string]string{"secret/password": "[REDACTED]", "greeting": "hello"} {
+		if len(find(entries, map[string]interface{}{"msg": "Create", "key": name, "value": value})) != 1 {
+			t.Fatalf("Expected the creation of '%s' to be logged with value '%s', got:\n%s", name, value, logs.buf.String())
+		}
+	}
+	// Handler entries carry the request's ID.
+	for _, msg := range []string{"Get", "Request"} {
+		if len(find(entries, map[string]interface{}{"msg": msg, "request_id": "abc123"})) != 1 {
+			t.Fatalf("Expected a %s entry for request abc123, got:\n%s", msg, logs.buf.String())
+		}
+	}
+	gets := map[string]interface{}{"msg": "Request", "method": "/key_value.KeyValueStore/GetRecord"}
+	gets["code"] = "OK"
+	if found := find(entries, gets); len(found) != 2 || found[0]["sampling"] != 2.0 || found[0]["level"] != "info" {
+		t.Fatalf("Expected half the successful gets to be logged, got %v", found)
+	}
+	gets["code"] = "NotFound"
+	if found := find(entries, gets); len(found) != 1 || found[0]["duration_ms"] == nil {
+		t.Fatalf("Expected failed gets to be logged, got %v", found)
+	}
+}
@@ -0,0 +1,138 @@
+package kvd
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gnossen/kvd/client"
+	pb "github.com/gnossen/kvd/kvd"
+	"github.com/gnossen/kvd/server"
+)
+
+// scrape returns the metrics served on port 1237.
+func scrape(t *testing.T) string {
+	response, err := http.Get("http://localhost:1237/metrics")
+	if err != nil {
+		t.Fatalf("Scrape failed: %v", err)
+	}
+	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("Scrape failed: %v", err)
+	}
+	return string(data)
+}
+
+func TestMetrics(t *testing.T) {
+	withServer(t, []server.ServerOption{server.WithMetricsPort(1237)}, func(cl pb.KeyValueStoreClient) {
+		c := client.NewClient(cl)
+		ctx := context.Background()
+		c.Get(ctx, "foo")
+		w, err := c.Watch(ctx, &pb.WatchRecordRequest{Name: "foo"})
+		if err != nil {
+			t.Fatalf("Watch failed: %v", err)
+		}
+		c.Create(ctx, "foo", "oof")
+		c.Create(ctx, "bar", "rab")
+		expectEvent(t, w, pb.Event_PUT, "foo", "oof")
+
+		metrics := scrape(t)
+		for _, line := range []string{
+			`kvd_requests_total{code="NotFound",method="/key_value.KeyValueStore/GetRecord"} 1`,
+			`kvd_requests_total{code="OK",method="/key_value.KeyValueStore/CreateRecord"} 2`,
+			`kvd_request_duration_seconds_count{code="OK",method="/key_value.KeyValueStore/CreateRecord"} 2`,
+			`kvd_keys 2`,
+			`kvd_bytes 12`,
+			`kvd_watchers{kind="key"} 1`,
+			`kvd_watch_events_sent_total 1`,
+			`kvd_watch_events_dropped_total 0`,
+		} {
+			if !strings.Contains(metrics, line+"\n") {
+				t.Fatalf("Expected metrics to include %s, got:\n%s", line, metrics)
+			}
+		}
+		if !strings.Contains(metrics, `kvd_lock_wait_seconds_count{mode="write"}`) {
+			t.Fatalf("Expected lock wait times, got:\n%s", metrics)
+		}
+
+		// Finished streams are counted once they end.
+		w.Close()
+		deadline := time.Now().Add(5 * time.Second)
+		for !strings.Contains(metrics, `kvd_requests_total{code="Canceled",method="/key_value.KeyValueStore/WatchRecord"} 1`) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected a canceled watch to be counted, got:\n%s", metrics)
+			}
+			time.Sleep(10 * time.Millisecond)
+			metrics = scrape(t)
+		}
+		if strings.Contains(metrics, "kvd_key_watchers") {
+			t.Fatalf("Expected watchers not to be counted by key unless asked, got:\n%s", metrics)
+		}
+		// Writes keep the record counts up to date.
+		c.Update(ctx, "foo", "oofoof")
+		c.Delete(ctx, "bar")
+		metrics = scrape(t)
+		for _, line := range []string{
+			`kvd_keys 1`,
+			`kvd_bytes 9`,
+			`kvd_watchers{kind="key"} 0`,
+		} {
+			if !strings.Contains(metrics, line+"\n") {
+				t.Fatalf("Expected metrics to include %s, got:\n%s", line, metrics)
+			}
+		}
+	})
+}
+
+func TestKeyWatcherMetrics(t *testing.T) {
+	opts := []server.ServerOption{server.WithMetricsPort(1237), server.WithKeyWatcherMetrics()}
+	withServer(t, opts, func(cl pb.KeyValueStoreClient) {
+		c := client.NewClient(cl)
+		ctx := context.Background()
+		var watches []*client.Watcher
+		for _, request := range []*pb.WatchRecordRequest{
+			{Name: "foo"},
+			{Name: "foo"},
+			{Name: "bar"},
+			{Name: "foo", Prefix: true},
+		} {
+			w, err := c.Watch(ctx, request)
+			if err != nil {
+				t.Fatalf("Watch failed: %v", err)
+			}
+			watches = append(watches, w)
+		}
+		metrics := scrape(t)
+		for _, line := range []string{
+			`kvd_key_watchers{key="foo"} 2`,
+			`kvd_key_watchers{key="bar"} 1`,
+			`kvd_watchers{kind="key"} 3`,
+			`kvd_watchers{kind="prefix"} 1`,
+		} {
+			if !strings.Contains(metrics, line+"\n") {
+				t.Fatalf("Expected metrics to include %s, got:\n%s", line, metrics)
+			}
+		}
+
+		// Keys drop out once nobody watches them.
+		watches[2].Close()
+		deadline := time.Now().Add(5 * time.Second)
+		for strings.Contains(metrics, `kvd_key_watchers{key="bar"}`) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected bar to have no watchers, got:\n%s", metrics)
+			}
+			time.Sleep(10 * time.Millisecond)
+			metrics = scrape(t)
+		}
+		if !strings.Contains(metrics, `kvd_key_watchers{key="foo"} 2`+"\n") {
+			t.Fatalf("Expected foo to keep its watchers, got:\n%s", metrics)
+		}
+		for _, w := range watches {
+			w.Close()
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package kvd
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/gnossen/kvd/client"
+	pb "github.com/gnossen/kvd/kvd"
+	"github.com/gnossen/kvd/server"
+)
+
+func nextEvent(t *testing.T, w *client.Watcher) *pb.Event {
+	select {
+	case event, ok := <-w.Events():
+		if !ok {
+			t.Fatalf("Watch ended: %v", w.Err())
+		}
+		return event
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Timed out waiting for an event")
+	}
+	return nil
+}
+
+func expectEvent(t *testing.T, w *client.Watcher, eventType pb.Event_EventType, name string, value string) {
+	event := nextEvent(t, w)
+	if event.Type != eventType || event.Record.Name != name || event.Record.Value != value {
+		t.Fatalf("Expected %v '%s': '%s', got %v", eventType, name, value, event)
+	}
+}
+
+func TestReconnectingWatch(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	start := func(dir string) func() {
+		return startServer(t, server.WithDataDir(dir)).Stop
+	}
+	stop := start(dir)
+	conn, err := grpc.Dial("localhost:1234", grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("fail to dial: %v", err)
+	}
+	defer conn.Close()
+	c := client.NewClient(pb.NewKeyValueStoreClient(conn))
+	ctx := context.Background()
+	config := backoff.Config{BaseDelay: 200 * time.Millisecond, Multiplier: 1.6, Jitter: 0.2, MaxDelay: time.Second}
+
+	if _, err := c.ReconnectingWatch(ctx, &pb.WatchRecordRequest{Prefix: true, RangeEnd: "z"}, config); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument, got %v", err)
+	}
+	c.Create(ctx, "foo", "1")
+	w, err := c.ReconnectingWatch(ctx, &pb.WatchRecordRequest{Prefix: true}, config)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer w.Close()
+	c.Update(ctx, "foo", "2")
+	expectEvent(t, w, pb.Event_PUT, "foo", "2")
+
+	// Changes made while the watch reconnects are replayed, each once.
+	stop()
+	stop = start(dir)
+	for {
+		if _, err := c.Txn(ctx, &pb.TxnRequest{Success: []*pb.TxnOp{
+			{Op: &pb.TxnOp_Put{Put: &pb.Record{Name: "foo", Value: "3"}}},
+			{Op: &pb.TxnOp_Put{Put: &pb.Record{Name: "bar", Value: "1"}}},
+		}}); status.Code(err) != codes.Unavailable {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	expectEvent(t, w, pb.Event_PUT, "foo", "3")
+	expectEvent(t, w, pb.Event_PUT, "bar", "1")
+	c.Update(ctx, "foo", "4")
+	expectEvent(t, w, pb.Event_PUT, "foo", "4")
+
+	// A server that has lost the delivered events calls for a resync.
+	stop()
+	empty := tempDir(t)
+	defer os.RemoveAll(empty)
+	stop = start(empty)
+	defer func() { stop() }()
+	if event := nextEvent(t, w); event.Type != pb.Event_RESYNC {
+		t.Fatalf("Expected a resync, got %v", event)
+	}
+	c.Create(ctx, "baz", "1")
+	expectEvent(t, w, pb.Event_PUT, "baz", "1")
+}
+
+func TestWatchProgress(t *testing.T) {
+	withServer(t, nil, func(cl pb.KeyValueStoreClient) {
+		c := client.NewClient(cl)
+		ctx := context.Background()
+		w, err := c.Watch(ctx, &pb.WatchRecordRequest{Name: "foo", ProgressNotify: true})
+		if err != nil {
+			t.Fatalf("Watch failed: %v", err)
+		}
+		defer w.Close()
+		c.Create(ctx, "bar", "rab")
+		c.Create(ctx, "foo", "oof")
+		expectEvent(t, w, pb.Event_PUT, "foo", "oof")
+		if event := nextEvent(t, w); event.Type != pb.Event_PROGRESS || event.Record.ModRevision != 2 {
+			t.Fatalf("Expected progress up to revision 2, got %v", event)
+		}
+	})
+}
+
+func TestReconnectingWatchGivesUp(t *testing.T) {
+	var calls int32
+	// The first watch is registered and then fails, as does every later one.
+	failWatches := func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if info.FullMethod != "/key_value.KeyValueStore/WatchRecord" {
+			return handler(srv, stream)
+		}
+		if atomic.AddInt32(&calls, 1) == 1 {
+			stream.SendHeader(metadata.Pairs("watch-revision", "0"))
+		}
+		return status.Errorf(codes.Internal, "Broken.")
+	}
+	withServer(t, []server.ServerOption{server.WithStreamInterceptors(failWatches)}, func(cl pb.KeyValueStoreClient) {
+		config := backoff.Config{BaseDelay: 10 * time.Millisecond, Multiplier: 1.6, Jitter: 0.2, MaxDelay: 50 * time.Millisecond}
+		w, err := client.NewClient(cl).ReconnectingWatch(context.Background(), &pb.WatchRecordRequest{Name: "foo"}, config)
+		if err != nil {
+			t.Fatalf("Watch failed: %v", err)
+		}
+		defer w.Close()
+		select {
+		case _, ok := <-w.Events():
+			if ok {
+				t.Fatalf("Expected no events")
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Expected the watch to give up on repeated internal errors")
+		}
+		if status.Code(w.Err()) != codes.Internal {
+			t.Fatalf("Expected the watch to end with Internal, got %v", w.Err())
+		}
+		if n := atomic.LoadInt32(&calls); n != 6 {
+			t.Fatalf("Expected 5 retries after the first watch, got %d", n-1)
+		}
+	})
+}
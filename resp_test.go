@@ -0,0 +1,238 @@
+package kvd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/gnossen/kvd/kvd"
+	"github.com/gnossen/kvd/server"
+)
+
+// respClient speaks just enough of the Redis protocol for tests.
+type respClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialRESP(t *testing.T) *respClient {
+	conn, err := net.Dial("tcp", "localhost:1236")
+	if err != nil {
+		t.Fatalf("fail to dial: %v", err)
+	}
+	return &respClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *respClient) send(args ...string) {
+	command := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		command += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.conn, command); err != nil {
+		c.t.Fatalf("Failed to send %v: %v", args, err)
+	}
+}
+
+// read returns the next reply, with simple and bulk strings as strings, null
+// as nil, integers as int64 and errors as errors.
+func (c *respClient) read() interface{} {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatalf("Failed to read reply: %v", err)
+	}
+	line = line[:len(line)-2]
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return fmt.Errorf("%s", line[1:])
+	case ':':
+		n, _ := strconv.ParseInt(line[1:], 10, 64)
+		return n
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			c.t.Fatalf("Failed to read reply: %v", err)
+		}
+		return string(data[:n])
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		values := make([]interface{}, n)
+		for i := range values {
+			values[i] = c.read()
+		}
+		return values
+	}
+	c.t.Fatalf("Unexpected reply '%s'", line)
+	return nil
+}
+
+func (c *respClient) expect(expected interface{}, args ...string) {
+	c.send(args...)
+	if reply := c.read(); !reflect.DeepEqual(reply, expected) {
+		c.t.Fatalf("Expected %v to reply %#v, got %#v", args, expected, reply)
+	}
+}
+
+func TestRESP(t *testing.T) {
+	withServer(t, []server.ServerOption{server.WithRESPPort(1236)}, func(cl pb.KeyValueStoreClient) {
+		c := dialRESP(t)
+		defer c.conn.Close()
+		c.expect("PONG", "PING")
+		c.expect(nil, "GET", "foo")
+		c.expect("OK", "SET", "foo", "oof")
+		c.expect("oof", "GET", "foo")
+		expectValue(t, cl, "foo", "oof")
+		c.expect(nil, "SET", "foo", "bar", "NX")
+		c.expect(nil, "SET", "baz", "zab", "XX")
+		c.expect(int64(1), "SETNX", "baz", "zab")
+		c.expect(int64(0), "SETNX", "baz", "zab")
+		c.expect(int64(2), "EXISTS", "foo", "baz", "missing")
+		c.expect("OK", "SET", "food", "doof")
+		c.expect([]interface{}{"foo", "food"}, "KEYS", "fo*")
+		c.expect([]interface{}{"baz", "foo", "food"}, "KEYS", "*")
+		c.expect([]interface{}{"baz"}, "KEYS", "?a[xyz]")
+
+		var scanned []interface{}
+		cursor := "0"
+		for {
+			c.send("SCAN", cursor, "COUNT", "2")
+			reply := c.read().([]interface{})
+			scanned = append(scanned, reply[1].([]interface{})...)
+			if cursor = reply[0].(string); cursor == "0" {
+				break
+			}
+		}
+		if !reflect.DeepEqual(scanned, []interface{}{"baz", "foo", "food"}) {
+			t.Fatalf("Expected to scan every key, got %v", scanned)
+		}
+
+		c.expect(int64(2), "DEL", "foo", "food", "missing")
+		c.expect(int64(0), "EXISTS", "foo")
+		expectMissing(t, cl, "foo")
+		c.send("SET", "foo")
+		if _, ok := c.read().(error); !ok {
+			t.Fatalf("Expected an error for a SET without a value")
+		}
+	})
+}
+
+func TestRESPExpiry(t *testing.T) {
+	clock := newFakeClock()
+	withServer(t, []server.ServerOption{server.WithClock(clock), server.WithRESPPort(1236)}, func(cl pb.KeyValueStoreClient) {
+		c := dialRESP(t)
+		defer c.conn.Close()
+		subscriber := dialRESP(t)
+		defer subscriber.conn.Close()
+		subscriber.expect([]interface{}{"psubscribe", "__keyspace@0__:*", int64(1)}, "PSUBSCRIBE", "__keyspace@0__:*")
+		subscriber.expect([]interface{}{"subscribe", "__keyevent@0__:del", int64(2)}, "SUBSCRIBE", "__keyevent@0__:del")
+
+		c.expect("OK", "SET", "foo", "oof", "EX", "10")
+		c.expect("OK", "SET", "bar", "rab")
+		c.expect(int64(-1), "TTL", "bar")
+		c.expect(int64(1), "EXPIRE", "bar", "5")
+		c.expect(int64(0), "EXPIRE", "missing", "5")
+		c.expect(int64(10), "TTL", "foo")
+		c.expect(int64(5), "TTL", "bar")
+		c.expect(int64(-2), "TTL", "missing")
+		for _, name := range []string{"foo", "bar", "bar"} {
+			reply := subscriber.read()
+			expected := []interface{}{"pmessage", "__keyspace@0__:*", "__keyspace@0__:" + name, "set"}
+			if !reflect.DeepEqual(reply, expected) {
+				t.Fatalf("Expected %v, got %v", expected, reply)
+			}
+		}
+
+		expiry := make(chan interface{})
+		go func() {
+			expiry <- subscriber.read()
+		}()
+		clock.Advance(5 * time.Second)
+		var reply interface{}
+		deadline := time.After(5 * time.Second)
+		for reply == nil {
+			select {
+			case reply = <-expiry:
+			case <-time.After(10 * time.Millisecond):
+				clock.Advance(100 * time.Millisecond)
+			case <-deadline:
+				t.Fatalf("Timed out waiting for bar to expire")
+			}
+		}
+		expected := []interface{}{"pmessage", "__keyspace@0__:*", "__keyspace@0__:bar", "del"}
+		if !reflect.DeepEqual(reply, expected) {
+			t.Fatalf("Expected %v, got %v", expected, reply)
+		}
+		expected = []interface{}{"message", "__keyevent@0__:del", "bar"}
+		if reply := subscriber.read(); !reflect.DeepEqual(reply, expected) {
+			t.Fatalf("Expected %v, got %v", expected, reply)
+		}
+		c.expect(nil, "GET", "bar")
+		c.expect("oof", "GET", "foo")
+	})
+}
+
+func TestRESPLeaseCleanup(t *testing.T) {
+	withServer(t, []server.ServerOption{server.WithRESPPort(1236)}, func(cl pb.KeyValueStoreClient) {
+		c := dialRESP(t)
+		defer c.conn.Close()
+		expectRevoked := func(id int64) {
+			_, err := cl.LeaseTimeToLive(context.Background(), &pb.LeaseTimeToLiveRequest{Id: id})
+			if status.Code(err) != codes.NotFound {
+				t.Fatalf("Expected lease %d to be revoked, got %v", id, err)
+			}
+		}
+		// Leases are numbered from 1 in the order they are granted.
+		c.expect("OK", "SET", "foo", "oof", "EX", "10")
+		c.expect(nil, "SET", "foo", "bar", "NX", "EX", "10")
+		expectRevoked(2)
+		c.expect(int64(1), "EXPIRE", "foo", "20")
+		expectRevoked(1)
+		c.expect(int64(20), "TTL", "foo")
+		c.expect("OK", "SET", "foo", "bar")
+		expectRevoked(3)
+		c.expect(int64(-1), "TTL", "foo")
+		c.expect("bar", "GET", "foo")
+	})
+}
+
+func TestRESPProtocolErrors(t *testing.T) {
+	withServer(t, []server.ServerOption{server.WithRESPPort(1236)}, func(cl pb.KeyValueStoreClient) {
+		for _, command := range []string{"*-1\r\n", "*-2\r\n", "*1048577\r\n", "*1\r\n$-1\r\n"} {
+			c := dialRESP(t)
+			if _, err := io.WriteString(c.conn, command); err != nil {
+				t.Fatalf("Failed to send %q: %v", command, err)
+			}
+			if _, ok := c.read().(error); !ok {
+				t.Fatalf("Expected an error for %q", command)
+			}
+			c.conn.Close()
+		}
+		// The server survives them all.
+		c := dialRESP(t)
+		defer c.conn.Close()
+		c.expect("PONG", "PING")
+
+		// Patterns with many stars must not backtrack exponentially.
+		name := strings.Repeat("a", 64)
+		c.expect("OK", "SET", name, "x")
+		c.expect([]interface{}{}, "KEYS", strings.Repeat("a*", 16)+"b")
+		c.expect([]interface{}{name}, "KEYS", strings.Repeat("a*", 16)+"a")
+		c.expect([]interface{}{name}, "KEYS", "*[a-c]?\\a")
+	})
+}
@@ -0,0 +1,888 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/gnossen/kvd/kvd"
+)
+
+// Users, roles and tokens are kept as records in a reserved keyspace, so that
+// they are persisted and replicated like any other record. No client may
+// access a reserved record, and reserved records are left out of listings
+// and watches.
+const (
+	reservedPrefix = "\x00auth/"
+	userPrefix     = reservedPrefix + "user/"
+	rolePrefix     = reservedPrefix + "role/"
+	tokenPrefix    = reservedPrefix + "token/"
+
+	rootName = "root"
+
+	passwordIterations = 10000
+)
+
+// isReserved reports whether name is in the keyspace of users, roles and
+// tokens, or in that of leases.
+func isReserved(name string) bool {
+	return strings.HasPrefix(name, reservedPrefix) || strings.HasPrefix(name, leasePrefix)
+}
+
+// permission is a kind of access granted by a pb.Permission.
+type permission int
+
+const (
+	readPermission permission = iota
+	writePermission
+	watchPermission
+)
+
+func (p permission) String() string {
+	return [...]string{"read", "write", "watch"}[p]
+}
+
+func (p permission) grantedBy(grant *pb.Permission) bool {
+	switch p {
+	case readPermission:
+		return grant.Read
+	case writePermission:
+		return grant.Write
+	}
+	return grant.Watch
+}
+
+// principal is an authenticated user.
+type principal struct {
+	name  string
+	roles []string
+}
+
+type principalKey struct{}
+
+func withPrincipal(ctx context.Context, p *principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+func principalFrom(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalKey{}).(*principal)
+	return p
+}
+
+// hashPassword derives a key from password with PBKDF2-HMAC-SHA256.
+func hashPassword(password string, salt []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(password))
+	mac.Write(salt)
+	binary.Write(mac, binary.BigEndian, uint32(1))
+	u := mac.Sum(nil)
+	key := append([]byte(nil), u...)
+	for i := 1; i < passwordIterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+func hashToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// randomBytes returns n bytes for salts and tokens, failing with
+// codes.Internal if the system cannot supply them.
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to read random bytes: %v", err)
+	}
+	return b, nil
+}
+
+// encodeReserved and decodeReserved convert the messages kept in reserved
+// records to and from record values, which must be valid UTF-8.
+func encodeReserved(message proto.Message) (string, error) {
+	data, err := proto.Marshal(message)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "Failed to encode %T: %v", message, err)
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+func decodeReserved(value string, message proto.Message) error {
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err == nil {
+		err = proto.Unmarshal(data, message)
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "Failed to decode %T: %v", message, err)
+	}
+	return nil
+}
+
+// authorizer authenticates callers and checks their requests against the
+// permissions of their roles. When authentication is disabled, it only
+// keeps clients away from the reserved keyspace.
+type authorizer struct {
+	store        *Store
+	enabled      bool
+	rootPassword string
+}
+
+// getReserved returns the reserved record at name, or nil.
+func (a *authorizer) getReserved(name string) (*pb.Record, error) {
+	a.store.mu.RLock()
+	defer a.store.mu.RUnlock()
+	record, exists, err := a.store.storage.Get(name)
+	if err != nil {
+		return nil, storageError(name, err)
+	}
+	if !exists {
+		return nil, nil
+	}
+	return record, nil
+}
+
+// loadUser returns the stored user and the mod_revision of its record, or
+// nil and 0 if there is no such user.
+func (a *authorizer) loadUser(name string) (*pb.StoredUser, int64, error) {
+	record, err := a.getReserved(userPrefix + name)
+	if err != nil || record == nil {
+		return nil, 0, err
+	}
+	var user pb.StoredUser
+	if err := decodeReserved(record.Value, &user); err != nil {
+		return nil, 0, err
+	}
+	return &user, record.ModRevision, nil
+}
+
+func (a *authorizer) loadRole(name string) (*pb.Role, error) {
+	record, err := a.getReserved(rolePrefix + name)
+	if err != nil || record == nil {
+		return nil, err
+	}
+	var role pb.Role
+	if err := decodeReserved(record.Value, &role); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+var errBadCredentials = status.Errorf(codes.Unauthenticated, "Invalid user name, password or token.")
+
+// lookup returns the principal for the user called name, without checking
+// any credentials.
+func (a *authorizer) lookup(name string) (*principal, error) {
+	if name == rootName {
+		return &principal{name: rootName, roles: []string{rootName}}, nil
+	}
+	user, _, err := a.loadUser(name)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errBadCredentials
+	}
+	return &principal{name: user.Name, roles: user.Roles}, nil
+}
+
+// login checks a user's password.
+func (a *authorizer) login(name string, password string) (*principal, error) {
+	if name == rootName {
+		if a.rootPassword == "" || subtle.ConstantTimeCompare([]byte(password), []byte(a.rootPassword)) != 1 {
+			return nil, errBadCredentials
+		}
+		return a.lookup(rootName)
+	}
+	user, _, err := a.loadUser(name)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || len(user.PasswordHash) == 0 ||
+		!hmac.Equal(hashPassword(password, user.PasswordSalt), user.PasswordHash) {
+		return nil, errBadCredentials
+	}
+	return &principal{name: user.Name, roles: user.Roles}, nil
+}
+
+func (a *authorizer) loginWithToken(token string) (*principal, error) {
+	record, err := a.getReserved(tokenPrefix + hex.EncodeToString(hashToken(token)))
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, errBadCredentials
+	}
+	return a.lookup(record.Value)
+}
+
+// authenticate identifies the caller of ctx from, in order of preference, a
+// principal already attached to ctx, "authorization" metadata holding Basic
+// or Bearer credentials, or a verified client certificate. It returns nil if
+// authentication is disabled.
+func (a *authorizer) authenticate(ctx context.Context) (*principal, error) {
+	if p := principalFrom(ctx); p != nil || !a.enabled {
+		return p, nil
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("authorization")) > 0 {
+		authorization := md.Get("authorization")[0]
+		if strings.HasPrefix(authorization, "Bearer ") {
+			return a.loginWithToken(strings.TrimPrefix(authorization, "Bearer "))
+		}
+		if strings.HasPrefix(authorization, "Basic ") {
+			credentials, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(authorization, "Basic "))
+			if i := strings.IndexByte(string(credentials), ':'); err == nil && i >= 0 {
+				return a.login(string(credentials[:i]), string(credentials[i+1:]))
+			}
+		}
+		return nil, status.Errorf(codes.Unauthenticated, "Unsupported authorization metadata.")
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.VerifiedChains) > 0 {
+			return a.lookup(info.State.VerifiedChains[0][0].Subject.CommonName)
+		}
+	}
+	return nil, status.Errorf(codes.Unauthenticated, "Credentials are required.")
+}
+
+// permissions returns the permissions granted by p's roles, or nil if p has
+// the root role.
+func (a *authorizer) permissions(p *principal) ([]*pb.Permission, bool, error) {
+	var permissions []*pb.Permission
+	for _, name := range p.roles {
+		if name == rootName {
+			return nil, true, nil
+		}
+		role, err := a.loadRole(name)
+		if err != nil {
+			return nil, false, err
+		}
+		if role != nil {
+			permissions = append(permissions, role.Permissions...)
+		}
+	}
+	return permissions, false, nil
+}
+
+// checkRange fails unless p may access every name in [start, end). An empty
+// end means no upper bound.
+func (a *authorizer) checkRange(p *principal, perm permission, start string, end string) error {
+	if p == nil {
+		return nil
+	}
+	permissions, root, err := a.permissions(p)
+	if err != nil || root {
+		return err
+	}
+	for _, grant := range permissions {
+		if !perm.grantedBy(grant) || !strings.HasPrefix(start, grant.Prefix) {
+			continue
+		}
+		if grantEnd := prefixEnd(grant.Prefix); grantEnd == "" || (end != "" && end <= grantEnd) {
+			return nil
+		}
+	}
+	if end == start+"\x00" {
+		return status.Errorf(codes.PermissionDenied,
+			"User '%s' may not %s key '%s'.", p.name, perm, start)
+	}
+	return status.Errorf(codes.PermissionDenied,
+		"User '%s' may not %s range ['%s', '%s').", p.name, perm, start, end)
+}
+
+func (a *authorizer) checkKey(p *principal, perm permission, name string) error {
+	if isReserved(name) {
+		return status.Errorf(codes.PermissionDenied, "Key '%s' is reserved.", name)
+	}
+	return a.checkRange(p, perm, name, name+"\x00")
+}
+
+// checkLeaseOwner fails unless p granted lease id or has the root role, and
+// returns the names of the records attached to the lease. A lease's owner
+// never changes and its ID is never reused, so the check holds for as long
+// as the lease exists.
+func (a *authorizer) checkLeaseOwner(p *principal, id int64) ([]string, error) {
+	if p == nil {
+		return nil, nil
+	}
+	_, root, err := a.permissions(p)
+	if err != nil {
+		return nil, err
+	}
+	a.store.mu.RLock()
+	defer a.store.mu.RUnlock()
+	l, exists := a.store.leases[id]
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "Lease %d not found.", id)
+	}
+	if !root && l.owner != p.name {
+		return nil, status.Errorf(codes.PermissionDenied, "User '%s' did not grant lease %d.", p.name, id)
+	}
+	var names []string
+	for name := range l.names {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// checkLease fails unless p owns lease id and may access every key attached
+// to it, since revoking a lease deletes its keys and looking it up may list
+// them. Only the owner attaches keys to a lease, so p may usually access
+// them already.
+func (a *authorizer) checkLease(p *principal, perm permission, id int64) error {
+	names, err := a.checkLeaseOwner(p, id)
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := a.checkKey(p, perm, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkWrite fails unless p may write record, attaching it to its lease.
+func (a *authorizer) checkWrite(p *principal, record *pb.Record) error {
+	if err := a.checkKey(p, writePermission, record.GetName()); err != nil {
+		return err
+	}
+	if record.GetLease() != 0 {
+		_, err := a.checkLeaseOwner(p, record.Lease)
+		return err
+	}
+	return nil
+}
+
+func (a *authorizer) checkRoot(p *principal) error {
+	if !a.enabled {
+		return status.Errorf(codes.FailedPrecondition, "Authentication is not enabled.")
+	}
+	_, root, err := a.permissions(p)
+	if err != nil {
+		return err
+	}
+	if !root {
+		return status.Errorf(codes.PermissionDenied,
+			"User '%s' does not have the root role.", p.name)
+	}
+	return nil
+}
+
+// authorize checks that p may make request.
+func (a *authorizer) authorize(p *principal, request interface{}) error {
+	switch r := request.(type) {
+	case *pb.GetRecordRequest:
+		return a.checkKey(p, readPermission, r.Name)
+	case *pb.CreateRecordRequest:
+		return a.checkWrite(p, r.GetRecord())
+	case *pb.UpdateRecordRequest:
+		return a.checkWrite(p, r.GetRecord())
+	case *pb.DeleteRecordRequest:
+		return a.checkKey(p, writePermission, r.Name)
+	case *pb.ListRecordsRequest:
+		if r.Prefix != "" {
+			return a.checkRange(p, readPermission, r.Prefix, prefixEnd(r.Prefix))
+		}
+		return a.checkRange(p, readPermission, r.Start, r.End)
+	case *pb.TxnRequest:
+		for _, compare := range r.Compare {
+			if err := a.checkKey(p, readPermission, compare.Name); err != nil {
+				return err
+			}
+		}
+		for _, op := range append(append([]*pb.TxnOp(nil), r.Success...), r.Failure...) {
+			var err error
+			switch op := op.Op.(type) {
+			case *pb.TxnOp_Get:
+				err = a.checkKey(p, readPermission, op.Get)
+			case *pb.TxnOp_Put:
+				err = a.checkWrite(p, op.Put)
+			case *pb.TxnOp_Delete:
+				err = a.checkKey(p, writePermission, op.Delete)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case *pb.WatchRecordRequest:
+		if r.Prefix {
+			return a.checkRange(p, watchPermission, r.Name, prefixEnd(r.Name))
+		} else if r.RangeEnd != "" {
+			return a.checkRange(p, watchPermission, r.Name, r.RangeEnd)
+		}
+		return a.checkKey(p, watchPermission, r.Name)
+	case *pb.GrantLeaseRequest, *pb.KeepAliveRequest:
+		// Any user may grant leases and keep them alive.
+		return nil
+	case *pb.RevokeLeaseRequest:
+		return a.checkLease(p, writePermission, r.Id)
+	case *pb.LeaseTimeToLiveRequest:
+		if r.Names {
+			return a.checkLease(p, readPermission, r.Id)
+		}
+		_, err := a.checkLeaseOwner(p, r.Id)
+		return err
+	case *pb.CreateTokenRequest:
+		if p != nil && (r.Name == "" || r.Name == p.name) {
+			return nil
+		}
+		return a.checkRoot(p)
+	case *pb.PutUserRequest, *pb.DeleteUserRequest, *pb.ListUsersRequest,
+		*pb.PutRoleRequest, *pb.DeleteRoleRequest, *pb.ListRolesRequest:
+		return a.checkRoot(p)
+	}
+	return status.Errorf(codes.PermissionDenied, "Unknown request %T.", request)
+}
+
+// check authenticates the caller of ctx and authorizes request, returning
+// ctx with the caller attached.
+func (a *authorizer) check(ctx context.Context, request interface{}) (context.Context, error) {
+	p, err := a.authenticate(ctx)
+	if err != nil {
+		a.store.log.warn(ctx, "Authentication failed", "error", err)
+		return ctx, err
+	}
+	if err := a.authorize(p, request); err != nil {
+		a.store.log.warn(ctx, "Authorization failed", "error", err)
+		return ctx, err
+	}
+	if p != nil {
+		ctx = withPrincipal(ctx, p)
+	}
+	return ctx, nil
+}
+
+// guarded reports whether the gRPC method is subject to authorization. Health
+// checks and reflection are open to every client. The Raft transport is served
+// on its own listener, where peers authenticate with client certificates.
+func guarded(method string) bool {
+	return strings.HasPrefix(method, "/key_value.KeyValueStore/") || strings.HasPrefix(method, "/key_value.Auth/")
+}
+
+func (a *authorizer) unaryInterceptor(ctx context.Context, request interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !guarded(info.FullMethod) {
+		return handler(ctx, request)
+	}
+	ctx, err := a.check(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, request)
+}
+
+func (a *authorizer) streamInterceptor(srv interface{}, stream grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !guarded(info.FullMethod) {
+		return handler(srv, stream)
+	}
+	p, err := a.authenticate(stream.Context())
+	if err != nil {
+		a.store.log.warn(stream.Context(), "Authentication failed", "error", err)
+		return err
+	}
+	ctx := stream.Context()
+	if p != nil {
+		ctx = withPrincipal(ctx, p)
+	}
+	return handler(srv, &authorizedStream{ServerStream: stream, ctx: ctx, auth: a})
+}
+
+// authorizedStream authorizes each message received on a stream.
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	auth *authorizer
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *authorizedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	_, err := s.auth.check(s.ctx, m)
+	return err
+}
+
+// guardedStore authorizes requests before passing them to the store, for the
+// front-ends that call the store directly rather than through gRPC.
+type guardedStore struct {
+	store *Store
+	auth  *authorizer
+}
+
+// get reads a record, and returns it with the revision it was read at.
+func (g *guardedStore) get(ctx context.Context, request *pb.GetRecordRequest) (*pb.Record, int64, error) {
+	ctx, err := g.auth.check(ctx, request)
+	if err != nil {
+		return nil, 0, err
+	}
+	return g.store.get(ctx, request)
+}
+
+func (g *guardedStore) CreateRecord(ctx context.Context, request *pb.CreateRecordRequest) (*pb.Record, error) {
+	ctx, err := g.auth.check(ctx, request)
+	if err != nil {
+		return &pb.Record{}, err
+	}
+	return g.store.createRecord(ctx, request)
+}
+
+func (g *guardedStore) UpdateRecord(ctx context.Context, request *pb.UpdateRecordRequest) (*pb.Record, error) {
+	ctx, err := g.auth.check(ctx, request)
+	if err != nil {
+		return &pb.Record{}, err
+	}
+	return g.store.updateRecord(ctx, request)
+}
+
+func (g *guardedStore) DeleteRecord(ctx context.Context, request *pb.DeleteRecordRequest) (*pb.Record, error) {
+	ctx, err := g.auth.check(ctx, request)
+	if err != nil {
+		return &pb.Record{}, err
+	}
+	return g.store.deleteRecord(ctx, request)
+}
+
+func (g *guardedStore) ListRecords(ctx context.Context, request *pb.ListRecordsRequest) (*pb.ListRecordsResponse, error) {
+	ctx, err := g.auth.check(ctx, request)
+	if err != nil {
+		return &pb.ListRecordsResponse{}, err
+	}
+	return g.store.listRecords(ctx, request)
+}
+
+func (g *guardedStore) Txn(ctx context.Context, request *pb.TxnRequest) (*pb.TxnResponse, error) {
+	ctx, err := g.auth.check(ctx, request)
+	if err != nil {
+		return &pb.TxnResponse{}, err
+	}
+	return g.store.txn(ctx, request)
+}
+
+func (g *guardedStore) GrantLease(ctx context.Context, request *pb.GrantLeaseRequest) (*pb.LeaseResponse, error) {
+	ctx, err := g.auth.check(ctx, request)
+	if err != nil {
+		return &pb.LeaseResponse{}, err
+	}
+	return g.store.grantLease(ctx, request)
+}
+
+func (g *guardedStore) RevokeLease(ctx context.Context, request *pb.RevokeLeaseRequest) (*pb.LeaseResponse, error) {
+	ctx, err := g.auth.check(ctx, request)
+	if err != nil {
+		return &pb.LeaseResponse{}, err
+	}
+	return g.store.revokeLease(ctx, request)
+}
+
+func (g *guardedStore) LeaseTimeToLive(ctx context.Context, request *pb.LeaseTimeToLiveRequest) (*pb.LeaseTimeToLiveResponse, error) {
+	ctx, err := g.auth.check(ctx, request)
+	if err != nil {
+		return &pb.LeaseTimeToLiveResponse{}, err
+	}
+	return g.store.leaseTimeToLive(ctx, request)
+}
+
+func (g *guardedStore) WatchRecord(request *pb.WatchRecordRequest, stream pb.KeyValueStore_WatchRecordServer) error {
+	if _, err := g.auth.check(stream.Context(), request); err != nil {
+		return err
+	}
+	return g.store.watchRecord(request, stream)
+}
+
+func (g *guardedStore) addWatcher(ctx context.Context, request *pb.WatchRecordRequest) (*watcher, func(), error) {
+	if _, err := g.auth.check(ctx, request); err != nil {
+		return nil, nil, err
+	}
+	return g.store.addWatcher(request)
+}
+
+// authServer implements the Auth service by writing reserved records with
+// transactions, which are replicated like any other.
+type authServer struct {
+	auth *authorizer
+}
+
+func checkName(kind string, name string) error {
+	if name == "" {
+		return status.Errorf(codes.InvalidArgument, "A %s must have a name.", kind)
+	}
+	if name == rootName {
+		return status.Errorf(codes.InvalidArgument, "The %s '%s' is built in.", kind, name)
+	}
+	return nil
+}
+
+// commit applies ops if the record at name is still at modRevision, which is
+// 0 if there was no record. It reports whether the ops were applied.
+func (s *authServer) commit(ctx context.Context, name string, modRevision int64, ops []*pb.TxnOp) (bool, error) {
+	response, err := s.auth.store.propose(ctx, &pb.Command{Op: &pb.Command_Txn{Txn: &pb.TxnRequest{
+		Compare: []*pb.Compare{{
+			Name:   name,
+			Result: pb.Compare_EQUAL,
+			Target: &pb.Compare_ModRevision{ModRevision: modRevision},
+		}},
+		Success: ops,
+	}}})
+	if err != nil {
+		return false, err
+	}
+	return response.(*pb.TxnResponse).Succeeded, nil
+}
+
+func putOp(name string, message proto.Message) (*pb.TxnOp, error) {
+	value, err := encodeReserved(message)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.TxnOp{Op: &pb.TxnOp_Put{Put: &pb.Record{Name: name, Value: value}}}, nil
+}
+
+func deleteOp(name string) *pb.TxnOp {
+	return &pb.TxnOp{Op: &pb.TxnOp_Delete{Delete: name}}
+}
+
+func tokenDeleteOps(user *pb.StoredUser) []*pb.TxnOp {
+	var ops []*pb.TxnOp
+	for _, hash := range user.TokenHashes {
+		ops = append(ops, deleteOp(tokenPrefix+hex.EncodeToString(hash)))
+	}
+	return ops
+}
+
+func (s *authServer) PutUser(ctx context.Context, request *pb.PutUserRequest) (*pb.User, error) {
+	s.auth.store.log.debug(ctx, "Put user", "user", request.Name)
+	if err := checkName("user", request.Name); err != nil {
+		return &pb.User{}, err
+	}
+	var salt, hash []byte
+	if request.Password != "" {
+		var err error
+		if salt, err = randomBytes(16); err != nil {
+			return &pb.User{}, err
+		}
+		hash = hashPassword(request.Password, salt)
+	}
+	for {
+		current, modRevision, err := s.auth.loadUser(request.Name)
+		if err != nil {
+			return &pb.User{}, err
+		}
+		user := &pb.StoredUser{Name: request.Name, Roles: request.Roles, PasswordSalt: salt, PasswordHash: hash}
+		var ops []*pb.TxnOp
+		if current != nil {
+			if hash == nil {
+				user.PasswordSalt, user.PasswordHash = current.PasswordSalt, current.PasswordHash
+			}
+			if request.RevokeTokens {
+				ops = tokenDeleteOps(current)
+			} else {
+				user.TokenHashes = current.TokenHashes
+			}
+		}
+		op, err := putOp(userPrefix+user.Name, user)
+		if err != nil {
+			return &pb.User{}, err
+		}
+		succeeded, err := s.commit(ctx, userPrefix+user.Name, modRevision, append(ops, op))
+		if err != nil {
+			return &pb.User{}, err
+		}
+		if succeeded {
+			return &pb.User{Name: user.Name, Roles: user.Roles}, nil
+		}
+	}
+}
+
+func (s *authServer) DeleteUser(ctx context.Context, request *pb.DeleteUserRequest) (*pb.User, error) {
+	s.auth.store.log.debug(ctx, "Delete user", "user", request.Name)
+	if err := checkName("user", request.Name); err != nil {
+		return &pb.User{}, err
+	}
+	for {
+		current, modRevision, err := s.auth.loadUser(request.Name)
+		if err != nil {
+			return &pb.User{}, err
+		}
+		if current == nil {
+			return &pb.User{}, status.Errorf(codes.NotFound,
+				"User '%s' not found.", request.Name)
+		}
+		ops := append(tokenDeleteOps(current), deleteOp(userPrefix+current.Name))
+		succeeded, err := s.commit(ctx, userPrefix+current.Name, modRevision, ops)
+		if err != nil {
+			return &pb.User{}, err
+		}
+		if succeeded {
+			return &pb.User{Name: current.Name, Roles: current.Roles}, nil
+		}
+	}
+}
+
+// rangeReserved calls f with the value of each reserved record whose name
+// begins with prefix.
+func (s *authServer) rangeReserved(prefix string, f func(value string) error) error {
+	var values []string
+	s.auth.store.mu.RLock()
+	err := s.auth.store.storage.Range(prefix, prefixEnd(prefix), func(record *pb.Record) bool {
+		values = append(values, record.Value)
+		return true
+	})
+	s.auth.store.mu.RUnlock()
+	if err != nil {
+		return storageError(prefix, err)
+	}
+	for _, value := range values {
+		if err := f(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *authServer) ListUsers(ctx context.Context, request *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	s.auth.store.log.debug(ctx, "List users")
+	response := &pb.ListUsersResponse{}
+	err := s.rangeReserved(userPrefix, func(value string) error {
+		var user pb.StoredUser
+		if err := decodeReserved(value, &user); err != nil {
+			return err
+		}
+		response.Users = append(response.Users, &pb.User{Name: user.Name, Roles: user.Roles})
+		return nil
+	})
+	if err != nil {
+		return &pb.ListUsersResponse{}, err
+	}
+	return response, nil
+}
+
+func (s *authServer) PutRole(ctx context.Context, request *pb.PutRoleRequest) (*pb.Role, error) {
+	role := request.Role
+	if role == nil {
+		role = &pb.Role{}
+	}
+	s.auth.store.log.debug(ctx, "Put role", "role", role.Name)
+	if err := checkName("role", role.Name); err != nil {
+		return &pb.Role{}, err
+	}
+	op, err := putOp(rolePrefix+role.Name, role)
+	if err != nil {
+		return &pb.Role{}, err
+	}
+	if _, err := s.auth.store.propose(ctx, &pb.Command{Op: &pb.Command_Txn{Txn: &pb.TxnRequest{
+		Success: []*pb.TxnOp{op},
+	}}}); err != nil {
+		return &pb.Role{}, err
+	}
+	return role, nil
+}
+
+func (s *authServer) DeleteRole(ctx context.Context, request *pb.DeleteRoleRequest) (*pb.Role, error) {
+	s.auth.store.log.debug(ctx, "Delete role", "role", request.Name)
+	if err := checkName("role", request.Name); err != nil {
+		return &pb.Role{}, err
+	}
+	response, err := s.auth.store.propose(ctx, &pb.Command{Op: &pb.Command_Txn{Txn: &pb.TxnRequest{
+		Success: []*pb.TxnOp{deleteOp(rolePrefix + request.Name)},
+	}}})
+	if err != nil {
+		return &pb.Role{}, err
+	}
+	deleted := response.(*pb.TxnResponse).Results[0].Record
+	if deleted == nil {
+		return &pb.Role{}, status.Errorf(codes.NotFound,
+			"Role '%s' not found.", request.Name)
+	}
+	var role pb.Role
+	if err := decodeReserved(deleted.Value, &role); err != nil {
+		return &pb.Role{}, err
+	}
+	return &role, nil
+}
+
+func (s *authServer) ListRoles(ctx context.Context, request *pb.ListRolesRequest) (*pb.ListRolesResponse, error) {
+	s.auth.store.log.debug(ctx, "List roles")
+	response := &pb.ListRolesResponse{}
+	err := s.rangeReserved(rolePrefix, func(value string) error {
+		var role pb.Role
+		if err := decodeReserved(value, &role); err != nil {
+			return err
+		}
+		response.Roles = append(response.Roles, &role)
+		return nil
+	})
+	if err != nil {
+		return &pb.ListRolesResponse{}, err
+	}
+	return response, nil
+}
+
+func (s *authServer) CreateToken(ctx context.Context, request *pb.CreateTokenRequest) (*pb.CreateTokenResponse, error) {
+	name := request.Name
+	if name == "" {
+		name = principalFrom(ctx).name
+	}
+	s.auth.store.log.debug(ctx, "Create token", "user", name)
+	if err := checkName("user", name); err != nil {
+		return &pb.CreateTokenResponse{}, err
+	}
+	secret, err := randomBytes(32)
+	if err != nil {
+		return &pb.CreateTokenResponse{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	hash := hashToken(token)
+	for {
+		user, modRevision, err := s.auth.loadUser(name)
+		if err != nil {
+			return &pb.CreateTokenResponse{}, err
+		}
+		if user == nil {
+			return &pb.CreateTokenResponse{}, status.Errorf(codes.NotFound,
+				"User '%s' not found.", name)
+		}
+		user.TokenHashes = append(user.TokenHashes, hash)
+		op, err := putOp(userPrefix+name, user)
+		if err != nil {
+			return &pb.CreateTokenResponse{}, err
+		}
+		tokenOp := &pb.TxnOp{Op: &pb.TxnOp_Put{Put: &pb.Record{
+			Name:  tokenPrefix + hex.EncodeToString(hash),
+			Value: name,
+		}}}
+		succeeded, err := s.commit(ctx, userPrefix+name, modRevision, []*pb.TxnOp{op, tokenOp})
+		if err != nil {
+			return &pb.CreateTokenResponse{}, err
+		}
+		if succeeded {
+			return &pb.CreateTokenResponse{Token: token}, nil
+		}
+	}
+}
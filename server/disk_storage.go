@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	pb "github.com/gnossen/kvd/kvd"
+)
+
+// diskStorage serves reads from memory and makes every mutation durable in
+// a write-ahead log before applying it.
+type diskStorage struct {
+	mem *memoryStorage
+	wal *writeAheadLog
+
+	// A store serving the storage replaces these with its own.
+	log              *logger
+	snapshotFailures prometheus.Counter // nil if not counted
+}
+
+// OpenDiskStorage returns a Storage persisted in dir, recovering any records
+// already there. A snapshot is taken every snapshotInterval log entries; zero
+// disables snapshots.
+func OpenDiskStorage(dir string, snapshotInterval int) (Storage, error) {
+	mem := newMemoryStorage()
+	wal, err := openWriteAheadLog(dir, snapshotInterval, mem)
+	if err != nil {
+		return nil, err
+	}
+	return &diskStorage{mem: mem, wal: wal, log: newLogger()}, nil
+}
+
+func (s *diskStorage) commit(events ...*pb.Event) error {
+	if err := s.wal.append(events...); err != nil {
+		return err
+	}
+	for _, event := range events {
+		applyEvent(s.mem, event)
+	}
+	// The events are already durable in the log, so a failed snapshot does
+	// not fail the commit. The log keeps growing until a snapshot succeeds.
+	if s.wal.needsSnapshot() {
+		if err := s.wal.snapshot(s.mem); err != nil {
+			s.log.error(context.Background(), "Failed to write snapshot", "error", err)
+			if s.snapshotFailures != nil {
+				s.snapshotFailures.Inc()
+			}
+		}
+	}
+	return nil
+}
+
+func (s *diskStorage) Get(name string) (*pb.Record, bool, error) {
+	return s.mem.Get(name)
+}
+
+func (s *diskStorage) PutIfAbsent(record *pb.Record) (bool, error) {
+	if _, exists := s.mem.m[record.Name]; exists {
+		return false, nil
+	}
+	err := s.commit(&pb.Event{Type: pb.Event_PUT, Record: record})
+	return err == nil, err
+}
+
+func (s *diskStorage) PutIfPresent(record *pb.Record) (bool, error) {
+	if _, exists := s.mem.m[record.Name]; !exists {
+		return false, nil
+	}
+	err := s.commit(&pb.Event{Type: pb.Event_PUT, Record: record})
+	return err == nil, err
+}
+
+func (s *diskStorage) Delete(name string, revision int64) (*pb.Record, bool, error) {
+	record, exists := s.mem.m[name]
+	if !exists {
+		return nil, false, nil
+	}
+	event := &pb.Event{Type: pb.Event_DELETE, Record: &pb.Record{Name: name, ModRevision: revision}}
+	if err := s.commit(event); err != nil {
+		return nil, false, err
+	}
+	return record, true, nil
+}
+
+func (s *diskStorage) Commit(events []*pb.Event) error {
+	return s.commit(events...)
+}
+
+func (s *diskStorage) Range(start string, end string, f func(record *pb.Record) bool) error {
+	return s.mem.Range(start, end, f)
+}
+
+func (s *diskStorage) Snapshot(f func(record *pb.Record) bool) error {
+	return s.mem.Snapshot(f)
+}
+
+func (s *diskStorage) Revision() int64 {
+	return s.mem.Revision()
+}
+
+func (s *diskStorage) Close() error {
+	return s.wal.close()
+}
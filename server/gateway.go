@@ -0,0 +1,406 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/gnossen/kvd/kvd"
+)
+
+// The gateway serves the key-value store as JSON over HTTP:
+//
+//	GET    /v1/keys/{name}   Get a record, read at the revision in its Read-Revision header.
+//	POST   /v1/keys/{name}   Create a record from a JSON body like {"value": "v", "lease": 1}.
+//	PUT    /v1/keys/{name}   Update a record from a body like POST's.
+//	DELETE /v1/keys/{name}   Delete a record.
+//	GET    /v1/keys          List records.
+//	GET    /v1/watch/{name}  Watch records as Server-Sent Events.
+//
+// Other request fields, such as expected_mod_revision or prefix, are passed as
+// query parameters named as in the protocol buffer definitions. If the server
+// requires authentication, requests carry Basic or Bearer credentials in
+// their Authorization header, or a client certificate.
+type gateway struct {
+	store *guardedStore
+}
+
+var jsonMarshaler = jsonpb.Marshaler{OrigName: true, EmitDefaults: true}
+
+func newGateway(store *guardedStore) http.Handler {
+	g := &gateway{store: store}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/keys", g.serveList)
+	mux.HandleFunc("/v1/keys/", g.serveKey)
+	mux.HandleFunc("/v1/watch/", g.serveWatch)
+	return g.logged(mux)
+}
+
+// statusRecorder records the status of an HTTP response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// logged gives each request an ID, taken from its X-Request-Id header if it
+// has one, and logs it once handled.
+func (g *gateway) logged(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(requestIDHeader)
+		if id == "" {
+			id = newRequestID()
+		}
+		r = r.WithContext(withRequestID(r.Context(), id))
+		w.Header().Set(requestIDHeader, id)
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(recorder, r)
+		level := LevelInfo
+		if recorder.status == http.StatusServiceUnavailable || recorder.status == http.StatusGatewayTimeout ||
+			recorder.status == http.StatusTooManyRequests {
+			level = LevelWarn
+		} else if recorder.status >= 500 {
+			level = LevelError
+		}
+		successfulRead := r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v1/keys") && recorder.status == http.StatusOK
+		g.store.store.log.request(withPeer(r), level, successfulRead,
+			"method", r.Method, "path", r.URL.Path, "status", recorder.status, "duration_ms", time.Since(start))
+	})
+}
+
+// httpStatus returns the HTTP status corresponding to a gRPC status code.
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+type gatewayError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	w.Header().Set("Content-Type", "application/json")
+	if st.Code() == codes.Unauthenticated {
+		w.Header().Set("WWW-Authenticate", `Basic realm="kvd"`)
+	}
+	w.WriteHeader(httpStatus(st.Code()))
+	json.NewEncoder(w).Encode(gatewayError{Code: st.Code().String(), Message: st.Message()})
+}
+
+func (g *gateway) writeMessage(w http.ResponseWriter, code int, message proto.Message) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := jsonMarshaler.Marshal(w, message); err != nil {
+		g.store.store.log.warn(context.Background(), "Failed to write HTTP response", "error", err)
+	}
+}
+
+// withPeer attributes the request to the HTTP client in the server's logs,
+// and passes on its credentials as a gRPC call would.
+func withPeer(r *http.Request) context.Context {
+	ctx := r.Context()
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", authorization))
+	}
+	addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		return ctx
+	}
+	p := &peer.Peer{Addr: addr}
+	if r.TLS != nil {
+		p.AuthInfo = credentials.TLSInfo{State: *r.TLS}
+	}
+	return peer.NewContext(ctx, p)
+}
+
+// query parses the query parameters of a request.
+type query struct {
+	values map[string][]string
+	err    error
+}
+
+func (q *query) string(name string) string {
+	if values := q.values[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (q *query) int64(name string) int64 {
+	value := q.string(name)
+	if value == "" || q.err != nil {
+		return 0
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		q.err = status.Errorf(codes.InvalidArgument,
+			"Query parameter %s must be an integer, not '%s'.", name, value)
+	}
+	return n
+}
+
+func (q *query) bool(name string) bool {
+	value := q.string(name)
+	if value == "" || q.err != nil {
+		return false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		q.err = status.Errorf(codes.InvalidArgument,
+			"Query parameter %s must be true or false, not '%s'.", name, value)
+	}
+	return b
+}
+
+func (q *query) consistency() pb.Consistency {
+	value := q.string("consistency")
+	if value == "" || q.err != nil {
+		return pb.Consistency_LINEARIZABLE
+	}
+	consistency, ok := pb.Consistency_value[strings.ToUpper(value)]
+	if !ok {
+		q.err = status.Errorf(codes.InvalidArgument,
+			"Unknown consistency '%s'.", value)
+	}
+	return pb.Consistency(consistency)
+}
+
+// readRecord parses the JSON record in the body of r.
+func readRecord(r *http.Request, name string) (*pb.Record, error) {
+	var record pb.Record
+	if err := jsonpb.Unmarshal(r.Body, &record); err != nil && err != io.EOF {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"Request body is not a JSON record: %v", err)
+	}
+	record.Name = name
+	return &record, nil
+}
+
+func (g *gateway) serveList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, status.Errorf(codes.Unimplemented, "Method %s is not supported.", r.Method))
+		return
+	}
+	q := &query{values: r.URL.Query()}
+	request := &pb.ListRecordsRequest{
+		Prefix:         q.string("prefix"),
+		Start:          q.string("start"),
+		End:            q.string("end"),
+		PageSize:       int32(q.int64("page_size")),
+		PageToken:      q.string("page_token"),
+		KeysOnly:       q.bool("keys_only"),
+		CountOnly:      q.bool("count_only"),
+		Consistency:    q.consistency(),
+		MaxRevisionLag: q.int64("max_revision_lag"),
+	}
+	if q.err != nil {
+		writeError(w, q.err)
+		return
+	}
+	response, err := g.store.ListRecords(withPeer(r), request)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	g.writeMessage(w, http.StatusOK, response)
+}
+
+func (g *gateway) serveKey(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/v1/keys/")
+	if name == "" {
+		g.serveList(w, r)
+		return
+	}
+	ctx := withPeer(r)
+	q := &query{values: r.URL.Query()}
+	var response proto.Message
+	var err error
+	code := http.StatusOK
+	switch r.Method {
+	case http.MethodGet:
+		request := &pb.GetRecordRequest{
+			Name:           name,
+			Consistency:    q.consistency(),
+			MaxRevisionLag: q.int64("max_revision_lag"),
+		}
+		if err = q.err; err == nil {
+			var revision int64
+			if response, revision, err = g.store.get(ctx, request); err == nil {
+				w.Header().Set("Read-Revision", strconv.FormatInt(revision, 10))
+			}
+		}
+	case http.MethodPost:
+		var record *pb.Record
+		if record, err = readRecord(r, name); err == nil {
+			response, err = g.store.CreateRecord(ctx, &pb.CreateRecordRequest{Record: record})
+			code = http.StatusCreated
+		}
+	case http.MethodPut:
+		var record *pb.Record
+		if record, err = readRecord(r, name); err == nil {
+			request := &pb.UpdateRecordRequest{
+				Record:              record,
+				ExpectedVersion:     q.int64("expected_version"),
+				ExpectedModRevision: q.int64("expected_mod_revision"),
+			}
+			if err = q.err; err == nil {
+				response, err = g.store.UpdateRecord(ctx, request)
+			}
+		}
+	case http.MethodDelete:
+		request := &pb.DeleteRecordRequest{
+			Name:                name,
+			ExpectedVersion:     q.int64("expected_version"),
+			ExpectedModRevision: q.int64("expected_mod_revision"),
+		}
+		if err = q.err; err == nil {
+			response, err = g.store.DeleteRecord(ctx, request)
+		}
+	default:
+		err = status.Errorf(codes.Unimplemented, "Method %s is not supported.", r.Method)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	g.writeMessage(w, code, response)
+}
+
+// sseStream adapts an HTTP response to a WatchRecord stream, sending each
+// event as a Server-Sent Event.
+type sseStream struct {
+	ctx     context.Context
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func (s *sseStream) SetHeader(metadata.MD) error {
+	return nil
+}
+
+func (s *sseStream) SendHeader(md metadata.MD) error {
+	for key, values := range md {
+		for _, value := range values {
+			s.w.Header().Add(key, value)
+		}
+	}
+	s.w.Header().Set("Content-Type", "text/event-stream")
+	s.w.Header().Set("Cache-Control", "no-cache")
+	s.w.WriteHeader(http.StatusOK)
+	s.flusher.Flush()
+	return nil
+}
+
+func (s *sseStream) SetTrailer(metadata.MD) {}
+
+func (s *sseStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *sseStream) Send(event *pb.Event) error {
+	data, err := jsonMarshaler.MarshalToString(event)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "data: %s\n\n", data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+func (s *sseStream) SendMsg(m interface{}) error {
+	return s.Send(m.(*pb.Event))
+}
+
+func (s *sseStream) RecvMsg(m interface{}) error {
+	return io.EOF
+}
+
+func (g *gateway) serveWatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, status.Errorf(codes.Unimplemented, "Method %s is not supported.", r.Method))
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, status.Errorf(codes.Internal, "Streaming is not supported."))
+		return
+	}
+	q := &query{values: r.URL.Query()}
+	request := &pb.WatchRecordRequest{
+		Name:           strings.TrimPrefix(r.URL.Path, "/v1/watch/"),
+		Prefix:         q.bool("prefix"),
+		RangeEnd:       q.string("range_end"),
+		StartRevision:  q.int64("start_revision"),
+		ProgressNotify: q.bool("progress_notify"),
+	}
+	if q.err != nil {
+		writeError(w, q.err)
+		return
+	}
+	stream := &sseStream{ctx: withPeer(r), w: w, flusher: flusher}
+	err := g.store.WatchRecord(request, stream)
+	if err == nil || r.Context().Err() != nil {
+		return
+	}
+	if w.Header().Get("Content-Type") != "text/event-stream" {
+		writeError(w, err)
+		return
+	}
+	// The stream has started, so the error can only be sent as an event.
+	st := status.Convert(err)
+	data, _ := json.Marshal(gatewayError{Code: st.Code().String(), Message: st.Message()})
+	fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+	flusher.Flush()
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	healthCheckInterval = 100 * time.Millisecond
+
+	// maxReadyLag is how many revisions a cluster member may be behind the
+	// latest commit it knows of while still reporting that it is serving.
+	maxReadyLag = 100
+)
+
+// healthServices are the services whose status the health service reports,
+// including the server as a whole ("").
+var healthServices = []string{"", "key_value.KeyValueStore", "key_value.Auth"}
+
+// ready reports whether the store can serve requests. A cluster member is
+// ready once it knows of a leader and has nearly caught up with it, until
+// its Raft node fails.
+func (s *Store) ready() bool {
+	if s.raft == nil {
+		return true
+	}
+	if s.raft.failure() != nil {
+		return false
+	}
+	lag, ok := s.raft.lag()
+	return ok && lag <= maxReadyLag
+}
+
+func (s *Store) setServing(serving bool) {
+	servingStatus := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		servingStatus = healthpb.HealthCheckResponse_SERVING
+	}
+	for _, service := range healthServices {
+		s.health.SetServingStatus(service, servingStatus)
+	}
+}
+
+// reportHealth sets the health service's statuses from the store's
+// readiness, and keeps them up to date until the store drains.
+func (s *Store) reportHealth() {
+	serving := s.ready()
+	s.setServing(serving)
+	go func() {
+		ticker := time.NewTicker(healthCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.draining:
+				return
+			case <-ticker.C:
+			}
+			if ready := s.ready(); ready != serving {
+				s.log.info(context.Background(), "Readiness changed", "ready", ready)
+				serving = ready
+				s.setServing(serving)
+			}
+		}
+	}()
+}
+
+// drain prepares the store to shut down. The health service reports
+// NOT_SERVING from then on, and watches end with an Unavailable status once
+// they have sent the events already queued for them, so that clients can
+// move to another server.
+func (s *Store) drain() {
+	s.drainOnce.Do(func() {
+		close(s.draining)
+		s.health.Shutdown()
+		err := status.Errorf(codes.Unavailable, "Server is shutting down.")
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, watchers := range s.watchers {
+			for elem := watchers.Front(); elem != nil; elem = elem.Next() {
+				elem.Value.(*watcher).fail(err)
+			}
+		}
+		for elem := s.rangeWatchers.Front(); elem != nil; elem = elem.Next() {
+			elem.Value.(*watcher).fail(err)
+		}
+	})
+}
+
+// drainInterceptor ends health watches when the store drains. Unlike
+// WatchRecord streams they would otherwise stay open, holding up a graceful
+// stop until its deadline.
+func (s *Store) drainInterceptor(srv interface{}, stream grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if info.FullMethod != "/grpc.health.v1.Health/Watch" {
+		return handler(srv, stream)
+	}
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.draining:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+}
+
+// gracefulStop stops server gracefully, or forcibly after timeout.
+func gracefulStop(server *grpc.Server, timeout time.Duration, serverLog *logger) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		serverLog.warn(context.Background(), "RPCs still in flight, stopping", "timeout", timeout.String())
+		server.Stop()
+		<-stopped
+	}
+}
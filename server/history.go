@@ -0,0 +1,87 @@
+package server
+
+import (
+	"sort"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/gnossen/kvd/kvd"
+)
+
+type historyEntry struct {
+	event *pb.Event
+	added time.Time
+}
+
+// history retains recent events so that watchers can resume from a past
+// revision. Events are dropped once there are more than maxEvents of them or
+// they are older than maxAge.
+type history struct {
+	entries   []historyEntry // Oldest first.
+	maxEvents int
+	maxAge    time.Duration
+	// The latest revision whose events may have been dropped.
+	compacted int64
+	now       func() time.Time
+}
+
+func newHistory(maxEvents int, maxAge time.Duration, compacted int64) *history {
+	return &history{
+		maxEvents: maxEvents,
+		maxAge:    maxAge,
+		compacted: compacted,
+		now:       time.Now,
+	}
+}
+
+func (h *history) add(event *pb.Event) {
+	h.entries = append(h.entries, historyEntry{event: event, added: h.now()})
+	h.trim()
+}
+
+func (h *history) trim() {
+	drop := 0
+	if len(h.entries) > h.maxEvents {
+		drop = len(h.entries) - h.maxEvents
+	}
+	if h.maxAge > 0 {
+		cutoff := h.now().Add(-h.maxAge)
+		for drop < len(h.entries) && h.entries[drop].added.Before(cutoff) {
+			drop++
+		}
+	}
+	if drop == 0 {
+		return
+	}
+	h.compacted = h.entries[drop-1].event.Record.ModRevision
+	h.entries = h.entries[drop:]
+}
+
+// reset drops every event, treating revisions up to compacted as compacted.
+func (h *history) reset(compacted int64) {
+	h.entries = nil
+	h.compacted = compacted
+}
+
+// since returns the retained events at or after revision that w would have
+// received. It fails with codes.OutOfRange if some of them were dropped.
+func (h *history) since(revision int64, w *watcher) ([]*pb.Event, error) {
+	h.trim()
+	if revision <= h.compacted {
+		return nil, status.Errorf(codes.OutOfRange,
+			"Revision %d has been compacted. The oldest available revision is %d.",
+			revision, h.compacted+1)
+	}
+	first := sort.Search(len(h.entries), func(i int) bool {
+		return h.entries[i].event.Record.ModRevision >= revision
+	})
+	var events []*pb.Event
+	for _, entry := range h.entries[first:] {
+		if w.contains(entry.event.Record.Name) {
+			events = append(events, entry.event)
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/gnossen/kvd/kvd"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func putAt(name string, revision int64) *pb.Event {
+	return &pb.Event{Type: pb.Event_PUT, Record: &pb.Record{Name: name, ModRevision: revision}}
+}
+
+func revisions(events []*pb.Event) []int64 {
+	var revisions []int64
+	for _, event := range events {
+		revisions = append(revisions, event.Record.ModRevision)
+	}
+	return revisions
+}
+
+func TestHistoryCountLimit(t *testing.T) {
+	h := newHistory(3, 0, 0)
+	for revision := int64(1); revision <= 5; revision++ {
+		h.add(putAt("foo", revision))
+	}
+	all := newWatcher("foo", 1, DisconnectSlowConsumers)
+	events, err := h.since(3, all)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := revisions(events); len(got) != 3 || got[0] != 3 || got[2] != 5 {
+		t.Fatalf("Expected revisions 3 through 5, got %v", got)
+	}
+	if _, err := h.since(2, all); status.Code(err) != codes.OutOfRange {
+		t.Fatalf("Expected OutOfRange, got %v", err)
+	}
+	if events, _ := h.since(6, all); len(events) != 0 {
+		t.Fatalf("Expected no events from the future, got %v", revisions(events))
+	}
+}
+
+func TestHistoryAgeLimit(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	h := newHistory(100, time.Minute, 0)
+	h.now = clock.Now
+	h.add(putAt("foo", 1))
+	clock.now = clock.now.Add(30 * time.Second)
+	h.add(putAt("bar", 2))
+	h.add(putAt("foo", 3))
+	foo := newWatcher("foo", 1, DisconnectSlowConsumers)
+	if got, _ := h.since(1, foo); len(got) != 2 {
+		t.Fatalf("Expected 2 events for 'foo', got %v", revisions(got))
+	}
+	clock.now = clock.now.Add(45 * time.Second)
+	if _, err := h.since(1, foo); status.Code(err) != codes.OutOfRange {
+		t.Fatalf("Expected OutOfRange once revision 1 expired, got %v", err)
+	}
+	if got, _ := h.since(2, foo); len(got) != 1 || got[0].Record.ModRevision != 3 {
+		t.Fatalf("Expected only revision 3, got %v", revisions(got))
+	}
+}
+
+func TestHistoryStartsCompacted(t *testing.T) {
+	h := newHistory(100, 0, 7)
+	w := newWatcher("foo", 1, DisconnectSlowConsumers)
+	if _, err := h.since(7, w); status.Code(err) != codes.OutOfRange {
+		t.Fatalf("Expected revisions before startup to be unavailable, got %v", err)
+	}
+	if _, err := h.since(8, w); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,347 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/gnossen/kvd/kvd"
+)
+
+// Clock abstracts time so that lease expiry can be tested.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+const (
+	leaseCheckInterval = 100 * time.Millisecond
+
+	// Each lease is kept in a reserved record holding its pb.LeaseState,
+	// and the ID of the next lease in another, so that leases and their IDs
+	// survive a restart.
+	leasePrefix     = "\x00lease/"
+	nextLeaseIDName = leasePrefix + "next"
+
+	// A record attached to a lease with no reserved record of its own was
+	// written before leases were persisted. Its lease is recreated with
+	// this time to live, giving its owner a chance to keep it alive.
+	recoveredLeaseTTL = time.Minute
+)
+
+type lease struct {
+	id     int64
+	ttl    time.Duration
+	expiry time.Time
+	names  map[string]bool
+	owner  string // The user that granted the lease, if any.
+}
+
+func leaseName(id int64) string {
+	return leasePrefix + strconv.FormatInt(id, 10)
+}
+
+// remaining returns the whole number of seconds, rounded up, before l
+// expires.
+func (l *lease) remaining(now time.Time) int64 {
+	if !now.Before(l.expiry) {
+		return 0
+	}
+	return int64((l.expiry.Sub(now) + time.Second - 1) / time.Second)
+}
+
+// recoverLeases recreates the stored leases, each with its full time to
+// live, and attaches the stored records to them.
+func (s *Store) recoverLeases() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	var err error
+	rangeErr := s.storage.Range(leasePrefix, prefixEnd(leasePrefix), func(record *pb.Record) bool {
+		if record.Name == nextLeaseIDName {
+			var next int64
+			if next, err = strconv.ParseInt(record.Value, 10, 64); err != nil {
+				return false
+			}
+			if next > s.nextLeaseID {
+				s.nextLeaseID = next
+			}
+			return true
+		}
+		var state pb.LeaseState
+		if err = decodeReserved(record.Value, &state); err != nil {
+			return false
+		}
+		ttl := time.Duration(state.TtlSeconds) * time.Second
+		s.leases[state.Id] = &lease{
+			id:     state.Id,
+			ttl:    ttl,
+			expiry: now.Add(ttl),
+			names:  make(map[string]bool),
+			owner:  state.Owner,
+		}
+		if state.Id >= s.nextLeaseID {
+			s.nextLeaseID = state.Id + 1
+		}
+		return true
+	})
+	if rangeErr != nil {
+		return rangeErr
+	}
+	if err != nil {
+		return err
+	}
+	return s.storage.Snapshot(func(record *pb.Record) bool {
+		if record.Lease == 0 {
+			return true
+		}
+		if _, exists := s.leases[record.Lease]; !exists {
+			s.leases[record.Lease] = &lease{
+				id:     record.Lease,
+				ttl:    recoveredLeaseTTL,
+				expiry: now.Add(recoveredLeaseTTL),
+				names:  make(map[string]bool),
+			}
+		}
+		s.leases[record.Lease].names[record.Name] = true
+		if record.Lease >= s.nextLeaseID {
+			s.nextLeaseID = record.Lease + 1
+		}
+		return true
+	})
+}
+
+func (s *Store) checkLeaseLocked(id int64) error {
+	if id == 0 {
+		return nil
+	}
+	if _, exists := s.leases[id]; !exists {
+		return status.Errorf(codes.NotFound, "Lease %d not found.", id)
+	}
+	return nil
+}
+
+// attachLocked moves name from lease from to lease to. Either may be 0.
+func (s *Store) attachLocked(name string, from int64, to int64) {
+	if l, exists := s.leases[from]; exists {
+		delete(l.names, name)
+	}
+	if l, exists := s.leases[to]; exists {
+		l.names[name] = true
+	}
+}
+
+// revokeLocked ends l and deletes its records at a single revision.
+func (s *Store) revokeLocked(ctx context.Context, l *lease) error {
+	revision := s.storage.Revision() + 1
+	var events []*pb.Event
+	var deleted []*pb.Record
+	for name := range l.names {
+		record, exists, err := s.storage.Get(name)
+		if err != nil {
+			return storageError(name, err)
+		}
+		if exists {
+			deleted = append(deleted, record)
+		}
+		events = append(events, &pb.Event{
+			Type:   pb.Event_DELETE,
+			Record: &pb.Record{Name: name, ModRevision: revision},
+		})
+	}
+	if _, exists, err := s.storage.Get(leaseName(l.id)); err != nil {
+		return storageError(leaseName(l.id), err)
+	} else if exists {
+		events = append(events, &pb.Event{
+			Type:   pb.Event_DELETE,
+			Record: &pb.Record{Name: leaseName(l.id), ModRevision: revision},
+		})
+	}
+	if len(events) > 0 {
+		if err := s.storage.Commit(events); err != nil {
+			return storageError(events[0].Record.Name, err)
+		}
+		for _, record := range deleted {
+			s.metrics.recordChanged(record, nil)
+		}
+		for _, event := range events {
+			s.notifyLocked(ctx, event)
+		}
+	}
+	delete(s.leases, l.id)
+	return nil
+}
+
+// expireLeases revokes leases as they expire until the store is closed. In a
+// cluster, only the leader revokes leases.
+func (s *Store) expireLeases() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.clock.After(leaseCheckInterval):
+		}
+		if s.raft != nil && !s.raft.isLeader() {
+			continue
+		}
+		var expired []int64
+		s.mu.RLock()
+		now := s.clock.Now()
+		for _, l := range s.leases {
+			if !now.Before(l.expiry) {
+				expired = append(expired, l.id)
+			}
+		}
+		s.mu.RUnlock()
+		for _, id := range expired {
+			s.log.info(context.Background(), "Lease expired", "lease", id)
+			command := &pb.Command{Op: &pb.Command_RevokeLease{RevokeLease: &pb.RevokeLeaseRequest{Id: id}}}
+			if _, err := s.propose(context.Background(), command); err != nil && status.Code(err) != codes.NotFound {
+				s.log.error(context.Background(), "Failed to expire lease", "lease", id, "error", err)
+			}
+		}
+	}
+}
+
+func (s *Store) grantLease(ctx context.Context, request *pb.GrantLeaseRequest) (*pb.LeaseResponse, error) {
+	s.log.debug(ctx, "Grant lease", "ttl_seconds", request.TtlSeconds)
+	if request.TtlSeconds <= 0 {
+		return &pb.LeaseResponse{},
+			status.Errorf(codes.InvalidArgument, "A lease must live for at least a second.")
+	}
+	command := &pb.Command{Op: &pb.Command_GrantLease{GrantLease: request}}
+	if p := principalFrom(ctx); p != nil {
+		command.User = p.name
+	}
+	response, err := s.propose(ctx, command)
+	if err != nil {
+		return &pb.LeaseResponse{}, err
+	}
+	return response.(*pb.LeaseResponse), nil
+}
+
+// grantLeaseLocked grants a lease owned by user.
+func (s *Store) grantLeaseLocked(ctx context.Context, request *pb.GrantLeaseRequest, user string) (*pb.LeaseResponse, error) {
+	ttl := time.Duration(request.TtlSeconds) * time.Second
+	l := &lease{
+		id:     s.nextLeaseID,
+		ttl:    ttl,
+		expiry: s.clock.Now().Add(ttl),
+		names:  make(map[string]bool),
+		owner:  user,
+	}
+	state, err := encodeReserved(&pb.LeaseState{Id: l.id, TtlSeconds: request.TtlSeconds, Owner: user})
+	if err != nil {
+		return &pb.LeaseResponse{}, err
+	}
+	// The lease and the ID after it are stored at a single revision.
+	if _, err := s.txnLocked(ctx, &pb.TxnRequest{Success: []*pb.TxnOp{
+		{Op: &pb.TxnOp_Put{Put: &pb.Record{Name: leaseName(l.id), Value: state}}},
+		{Op: &pb.TxnOp_Put{Put: &pb.Record{Name: nextLeaseIDName, Value: strconv.FormatInt(l.id+1, 10)}}},
+	}}); err != nil {
+		return &pb.LeaseResponse{}, err
+	}
+	s.leases[l.id] = l
+	s.nextLeaseID++
+	return &pb.LeaseResponse{Id: l.id, TtlSeconds: request.TtlSeconds}, nil
+}
+
+func (s *Store) revokeLease(ctx context.Context, request *pb.RevokeLeaseRequest) (*pb.LeaseResponse, error) {
+	s.log.debug(ctx, "Revoke lease", "lease", request.Id)
+	response, err := s.propose(ctx, &pb.Command{Op: &pb.Command_RevokeLease{RevokeLease: request}})
+	if err != nil {
+		return &pb.LeaseResponse{}, err
+	}
+	return response.(*pb.LeaseResponse), nil
+}
+
+func (s *Store) revokeLeaseLocked(ctx context.Context, request *pb.RevokeLeaseRequest) (*pb.LeaseResponse, error) {
+	if err := s.checkLeaseLocked(request.Id); err != nil {
+		return &pb.LeaseResponse{}, err
+	}
+	if err := s.revokeLocked(ctx, s.leases[request.Id]); err != nil {
+		return &pb.LeaseResponse{}, err
+	}
+	return &pb.LeaseResponse{Id: request.Id}, nil
+}
+
+// refreshLeases gives every lease its full time to live from now.
+func (s *Store) refreshLeases() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	for _, l := range s.leases {
+		l.expiry = now.Add(l.ttl)
+	}
+}
+
+// keepAliveLocked extends a lease and returns its new time to live, or 0 if
+// it no longer exists.
+func (s *Store) keepAliveLocked(request *pb.KeepAliveRequest) (*pb.LeaseResponse, error) {
+	l, exists := s.leases[request.Id]
+	now := s.clock.Now()
+	if !exists || !now.Before(l.expiry) {
+		return &pb.LeaseResponse{Id: request.Id}, nil
+	}
+	l.expiry = now.Add(l.ttl)
+	return &pb.LeaseResponse{Id: request.Id, TtlSeconds: l.remaining(now)}, nil
+}
+
+// keepAlive extends a lease. Keep-alives are not replicated, since only the
+// leader of a cluster tracks when leases expire; other nodes forward them.
+func (s *Store) keepAlive(ctx context.Context, request *pb.KeepAliveRequest) (*pb.LeaseResponse, error) {
+	if s.raft != nil && !s.raft.isLeader() {
+		return s.raft.forwardKeepAlive(ctx, request)
+	}
+	return s.keepAliveLocal(ctx, request)
+}
+
+func (s *Store) keepAliveLocal(ctx context.Context, request *pb.KeepAliveRequest) (*pb.LeaseResponse, error) {
+	s.lock(ctx)
+	defer s.mu.Unlock()
+	return s.keepAliveLocked(request)
+}
+
+// leaseTimeToLive looks up a lease, on the leader if the store belongs to a
+// cluster.
+func (s *Store) leaseTimeToLive(ctx context.Context, request *pb.LeaseTimeToLiveRequest) (*pb.LeaseTimeToLiveResponse, error) {
+	if s.raft != nil && !s.raft.isLeader() {
+		return s.raft.forwardLeaseTimeToLive(ctx, request)
+	}
+	return s.leaseTimeToLiveLocal(ctx, request)
+}
+
+func (s *Store) leaseTimeToLiveLocal(ctx context.Context, request *pb.LeaseTimeToLiveRequest) (*pb.LeaseTimeToLiveResponse, error) {
+	s.rlock(ctx)
+	defer s.mu.RUnlock()
+	if err := s.checkLeaseLocked(request.Id); err != nil {
+		return &pb.LeaseTimeToLiveResponse{}, err
+	}
+	l := s.leases[request.Id]
+	response := &pb.LeaseTimeToLiveResponse{
+		Id:                l.id,
+		TtlSeconds:        l.remaining(s.clock.Now()),
+		GrantedTtlSeconds: int64(l.ttl / time.Second),
+	}
+	if request.Names {
+		for name := range l.names {
+			response.Names = append(response.Names, name)
+		}
+		sort.Strings(response.Names)
+	}
+	return response, nil
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// ParseLevel returns the level named "debug", "info", "warn" or "error".
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if name == levelName {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level '%s'", name)
+}
+
+// Redaction decides which values are left out of the logs.
+type Redaction int
+
+const (
+	// RedactNever logs every value.
+	RedactNever Redaction = iota
+
+	// RedactPrefixes leaves out the values of records whose names begin
+	// with one of the prefixes given to WithValueRedaction.
+	RedactPrefixes
+
+	// RedactAlways leaves out every value.
+	RedactAlways
+)
+
+var redactionNames = map[Redaction]string{
+	RedactNever:    "never",
+	RedactPrefixes: "prefix",
+	RedactAlways:   "always",
+}
+
+func (r Redaction) String() string {
+	if name, ok := redactionNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("Redaction(%d)", int(r))
+}
+
+// ParseRedaction returns the redaction named "never", "prefix" or "always".
+func ParseRedaction(name string) (Redaction, error) {
+	for redaction, redactionName := range redactionNames {
+		if name == redactionName {
+			return redaction, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown value redaction '%s'", name)
+}
+
+const redacted = "[REDACTED]"
+
+// requestIDHeader carries a request's ID in gRPC metadata and HTTP headers.
+// Requests without one are given one.
+const requestIDHeader = "x-request-id"
+
+type requestIDKey struct{}
+
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// incomingRequestID returns the request ID in ctx's metadata, or a new one.
+func incomingRequestID(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(requestIDHeader); len(ids) > 0 && ids[0] != "" {
+			return ids[0]
+		}
+	}
+	return newRequestID()
+}
+
+func newRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// logger writes log entries as lines of JSON. Each entry has the time,
+// level and message, the peer, request ID and trace ID from its context if
+// there are any, and then its fields.
+type logger struct {
+	level     Level
+	redaction Redaction
+	prefixes  []string
+	sampling  int64
+	sampled   int64 // Accessed atomically.
+	mu        sync.Mutex
+	out       io.Writer
+}
+
+func newLogger() *logger {
+	return &logger{level: LevelInfo, sampling: 1, out: os.Stderr}
+}
+
+// log writes an entry if level is enabled. Fields alternate between names
+// and values.
+func (l *logger) log(ctx context.Context, level Level, msg string, fields ...interface{}) {
+	if level < l.level {
+		return
+	}
+	var b bytes.Buffer
+	b.WriteString("{")
+	writeField(&b, "time", time.Now().UTC().Format(time.RFC3339Nano))
+	b.WriteString(",")
+	writeField(&b, "level", level.String())
+	b.WriteString(",")
+	writeField(&b, "msg", msg)
+	if p, ok := peer.FromContext(ctx); ok {
+		b.WriteString(",")
+		writeField(&b, "peer", p.Addr.String())
+	}
+	if id := requestID(ctx); id != "" {
+		b.WriteString(",")
+		writeField(&b, "request_id", id)
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		b.WriteString(",")
+		writeField(&b, "trace_id", spanContext.TraceID().String())
+	}
+	for i := 0; i+1 < len(fields); i += 2 {
+		b.WriteString(",")
+		writeField(&b, fmt.Sprint(fields[i]), fields[i+1])
+	}
+	b.WriteString("}\n")
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(b.Bytes())
+}
+
+func writeField(b *bytes.Buffer, name string, value interface{}) {
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case time.Duration:
+		value = float64(v) / float64(time.Millisecond)
+	case fmt.Stringer:
+		value = v.String()
+	}
+	key, _ := json.Marshal(name)
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(value))
+	}
+	b.Write(key)
+	b.WriteString(":")
+	b.Write(data)
+}
+
+func (l *logger) debug(ctx context.Context, msg string, fields ...interface{}) {
+	l.log(ctx, LevelDebug, msg, fields...)
+}
+
+func (l *logger) info(ctx context.Context, msg string, fields ...interface{}) {
+	l.log(ctx, LevelInfo, msg, fields...)
+}
+
+func (l *logger) warn(ctx context.Context, msg string, fields ...interface{}) {
+	l.log(ctx, LevelWarn, msg, fields...)
+}
+
+func (l *logger) error(ctx context.Context, msg string, fields ...interface{}) {
+	l.log(ctx, LevelError, msg, fields...)
+}
+
+// value returns what to log of the value of the record name.
+func (l *logger) value(name string, value string) string {
+	switch l.redaction {
+	case RedactAlways:
+		return redacted
+	case RedactPrefixes:
+		for _, prefix := range l.prefixes {
+			if strings.HasPrefix(name, prefix) {
+				return redacted
+			}
+		}
+	}
+	return value
+}
+
+// sample reports whether to log a successful read, of which only one in
+// every l.sampling are logged.
+func (l *logger) sample() bool {
+	return l.sampling <= 1 || atomic.AddInt64(&l.sampled, 1)%l.sampling == 1
+}
+
+// codeLevel returns the level at which to log a request that ended with
+// code: errors for the server's faults, warnings for its limits, and
+// information otherwise.
+func codeLevel(code codes.Code) Level {
+	switch code {
+	case codes.Unknown, codes.Internal, codes.DataLoss, codes.Unimplemented:
+		return LevelError
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return LevelWarn
+	}
+	return LevelInfo
+}
+
+// request logs a finished request. If it was a successful read, it is one
+// of those sampled.
+func (l *logger) request(ctx context.Context, level Level, successfulRead bool, fields ...interface{}) {
+	if successfulRead {
+		if !l.sample() {
+			return
+		}
+		if l.sampling > 1 {
+			fields = append(fields, "sampling", l.sampling)
+		}
+	}
+	l.log(ctx, level, "Request", fields...)
+}
+
+// grpcRequest logs a finished gRPC request.
+func (l *logger) grpcRequest(ctx context.Context, method string, start time.Time, err error) {
+	code := errorCode(err)
+	fields := []interface{}{"method", method, "code", code, "duration_ms", time.Since(start)}
+	if err != nil {
+		fields = append(fields, "error", status.Convert(err).Message())
+	}
+	l.request(ctx, codeLevel(code), code == codes.OK && sampledMethods[method], fields...)
+}
+
+// sampledMethods are the read methods whose successful requests are
+// sampled.
+var sampledMethods = map[string]bool{
+	"/key_value.KeyValueStore/GetRecord":   true,
+	"/key_value.KeyValueStore/ListRecords": true,
+}
+
+func (l *logger) unaryInterceptor(ctx context.Context, request interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	id := incomingRequestID(ctx)
+	ctx = withRequestID(ctx, id)
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, id))
+	response, err := handler(ctx, request)
+	l.grpcRequest(ctx, info.FullMethod, start, err)
+	return response, err
+}
+
+func (l *logger) streamInterceptor(srv interface{}, stream grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	id := incomingRequestID(stream.Context())
+	ctx := withRequestID(stream.Context(), id)
+	stream.SetHeader(metadata.Pairs(requestIDHeader, id))
+	err := handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+	l.grpcRequest(ctx, info.FullMethod, start, err)
+	return err
+}
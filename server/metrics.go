@@ -0,0 +1,239 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/gnossen/kvd/kvd"
+)
+
+// metrics are the Prometheus metrics of one server. Each server has its own
+// registry, so that several can run in one process.
+type metrics struct {
+	registry         *prometheus.Registry
+	requests         *prometheus.CounterVec
+	latency          *prometheus.HistogramVec
+	eventsSent       prometheus.Counter
+	eventsDropped    prometheus.Counter
+	snapshotFailures prometheus.Counter
+	lockWait         *prometheus.HistogramVec
+	keys             prometheus.Gauge
+	bytes            prometheus.Gauge
+	watchers         *prometheus.GaugeVec
+	keyWatchers      *prometheus.GaugeVec // nil unless WithKeyWatcherMetrics
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "kvd_requests_total",
+			Help: "gRPC requests handled, by method and status code.",
+		}, []string{"method", "code"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "kvd_request_duration_seconds",
+			Help:    "Time taken to handle gRPC requests, or for streams their lifetime, by method and status code.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"method", "code"}),
+		eventsSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "kvd_watch_events_sent_total",
+			Help: "Events sent to watchers.",
+		}),
+		eventsDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "kvd_watch_events_dropped_total",
+			Help: "Events dropped from the queues of slow watchers.",
+		}),
+		snapshotFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "kvd_snapshot_failures_total",
+			Help: "Snapshots of the on-disk storage that failed to be written.",
+		}),
+		lockWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "kvd_lock_wait_seconds",
+			Help:    "Time spent waiting for the store lock, by mode.",
+			Buckets: prometheus.ExponentialBuckets(0.000001, 4, 12),
+		}, []string{"mode"}),
+		keys: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "kvd_keys",
+			Help: "Records in the store.",
+		}),
+		bytes: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "kvd_bytes",
+			Help: "Total size of the names and values of the records in the store.",
+		}),
+		// Watches are counted by kind here. Counting them by key is left to
+		// WithKeyWatcherMetrics, since keys are unbounded and may not be
+		// shown to whoever scrapes the metrics.
+		watchers: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "kvd_watchers",
+			Help: "Active watchers, by whether they watch a key, a prefix or a range.",
+		}, []string{"kind"}),
+	}
+	m.registry.MustRegister(
+		m.requests,
+		m.latency,
+		m.eventsSent,
+		m.eventsDropped,
+		m.snapshotFailures,
+		m.lockWait,
+		m.keys,
+		m.bytes,
+		m.watchers,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// enableKeyWatchers adds kvd_key_watchers, which counts the watchers of each
+// key.
+func (m *metrics) enableKeyWatchers() {
+	m.keyWatchers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kvd_key_watchers",
+		Help: "Active watchers of each key, not counting prefix and range watchers.",
+	}, []string{"key"})
+	m.registry.MustRegister(m.keyWatchers)
+}
+
+// keyWatchersChanged sets kvd_key_watchers for name to count, dropping the
+// key once it has no watchers so that the metric only grows with the keys
+// being watched.
+func (m *metrics) keyWatchersChanged(name string, count int) {
+	if m.keyWatchers == nil {
+		return
+	}
+	if count == 0 {
+		m.keyWatchers.DeleteLabelValues(name)
+		return
+	}
+	m.keyWatchers.WithLabelValues(name).Set(float64(count))
+}
+
+// errorCode returns the status code that grpc reports to the client for an
+// error returned by a handler.
+func errorCode(err error) codes.Code {
+	code := status.Code(err)
+	if code == codes.Unknown {
+		// Handlers return the context's error when their caller goes away,
+		// which grpc reports as Canceled or DeadlineExceeded.
+		code = status.FromContextError(err).Code()
+	}
+	return code
+}
+
+func (m *metrics) observe(method string, start time.Time, err error) {
+	code := errorCode(err)
+	m.requests.WithLabelValues(method, code.String()).Inc()
+	m.latency.WithLabelValues(method, code.String()).Observe(time.Since(start).Seconds())
+}
+
+func (m *metrics) unaryInterceptor(ctx context.Context, request interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	response, err := handler(ctx, request)
+	m.observe(info.FullMethod, start, err)
+	return response, err
+}
+
+func (m *metrics) streamInterceptor(srv interface{}, stream grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	err := handler(srv, stream)
+	m.observe(info.FullMethod, start, err)
+	return err
+}
+
+// chainUnaryInterceptors runs interceptors in order, the first outermost.
+func chainUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], handler
+			handler = func(ctx context.Context, request interface{}) (interface{}, error) {
+				return interceptor(ctx, request, info, next)
+			}
+		}
+		return handler(ctx, request)
+	}
+}
+
+// chainStreamInterceptors runs interceptors in order, the first outermost.
+func chainStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], handler
+			handler = func(srv interface{}, stream grpc.ServerStream) error {
+				return interceptor(srv, stream, info, next)
+			}
+		}
+		return handler(srv, stream)
+	}
+}
+
+// contextStream replaces the context of a stream, for interceptors that add
+// to it.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// recordChanged updates kvd_keys and kvd_bytes for a record replaced by
+// another with the same name. Before is nil if the record was created, and
+// after is nil if it was deleted.
+func (m *metrics) recordChanged(before *pb.Record, after *pb.Record) {
+	if before != nil && !isReserved(before.Name) {
+		m.keys.Dec()
+		m.bytes.Sub(float64(len(before.Name) + len(before.Value)))
+	}
+	if after != nil && !isReserved(after.Name) {
+		m.keys.Inc()
+		m.bytes.Add(float64(len(after.Name) + len(after.Value)))
+	}
+}
+
+// countRecords sets kvd_keys and kvd_bytes from the records in storage, for
+// when the store is opened or replaced by a snapshot. Writes keep them up to
+// date from then on.
+func (m *metrics) countRecords(storage Storage) error {
+	var keys, bytes int
+	err := storage.Snapshot(func(record *pb.Record) bool {
+		if !isReserved(record.Name) {
+			keys++
+			bytes += len(record.Name) + len(record.Value)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	m.keys.Set(float64(keys))
+	m.bytes.Set(float64(bytes))
+	return nil
+}
+
+// timedRWMutex is a sync.RWMutex that records how long callers wait for it.
+type timedRWMutex struct {
+	sync.RWMutex
+	readWait  prometheus.Observer
+	writeWait prometheus.Observer
+}
+
+func (m *timedRWMutex) Lock() {
+	start := time.Now()
+	m.RWMutex.Lock()
+	m.writeWait.Observe(time.Since(start).Seconds())
+}
+
+func (m *timedRWMutex) RLock() {
+	start := time.Now()
+	m.RWMutex.RLock()
+	m.readWait.Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,609 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"go.etcd.io/etcd/raft/v3"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/gnossen/kvd/kvd"
+)
+
+const (
+	raftTickInterval = 100 * time.Millisecond
+	raftElectionTick = 10
+
+	// How long a proposal may wait to be committed, unless the caller's
+	// deadline is sooner.
+	proposalTimeout = 10 * time.Second
+)
+
+// ParsePeers parses a comma-separated list of id=host:port pairs naming every
+// node of a cluster, including this one.
+func ParsePeers(spec string) (map[uint64]string, error) {
+	peers := make(map[uint64]string)
+	for _, peer := range strings.Split(spec, ",") {
+		parts := strings.SplitN(peer, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("peer '%s' is not of the form id=host:port", peer)
+		}
+		id, err := strconv.ParseUint(parts[0], 10, 64)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("peer '%s' does not have a positive integer ID", peer)
+		}
+		if _, exists := peers[id]; exists {
+			return nil, fmt.Errorf("peer ID %d appears more than once", id)
+		}
+		peers[id] = parts[1]
+	}
+	return peers, nil
+}
+
+type applyResult struct {
+	response proto.Message
+	err      error
+}
+
+// proposal awaits the result of a command proposed by this node. Its
+// context is the proposer's, for tracing the command's application.
+type proposal struct {
+	ctx    context.Context
+	result chan applyResult
+}
+
+// raftNode replicates the commands of a Store through a Raft log. Every
+// node applies committed commands to its own store in log order. Proposals
+// made on a follower are forwarded to the leader by Raft itself. Only the
+// leader tracks when leases expire, so followers send it keep-alives and
+// time to live queries instead.
+type raftNode struct {
+	id               uint64
+	store            *Store
+	node             raft.Node
+	storage          *raft.MemoryStorage
+	log              *raftLog // nil if the node is not persisted
+	transport        *raftTransport
+	snapshotInterval uint64
+
+	// Owned by run.
+	confState     raftpb.ConfState
+	appliedIndex  uint64
+	snapshotIndex uint64
+
+	lead uint64 // Accessed atomically.
+
+	mu           sync.Mutex
+	proposals    map[uint64]*proposal
+	nextProposal uint64
+	reads        map[string]chan uint64 // Keyed by read request context.
+	nextRead     uint64
+	applied      uint64        // appliedIndex, for readers.
+	appliedCh    chan struct{} // Closed when applied advances.
+
+	done    chan struct{}
+	stopped chan struct{}
+	failed  chan struct{} // Closed by run if it fails.
+	err     error         // Why run failed. Set before failed is closed.
+}
+
+// startRaftNode joins store to the cluster of peers as node id, dialing them
+// with creds. If dir is not empty, the node's state is persisted there and
+// any state already there is recovered.
+func startRaftNode(id uint64, peers map[uint64]string, dir string, snapshotInterval int, creds grpc.DialOption, store *Store) (*raftNode, error) {
+	n := &raftNode{
+		id:               id,
+		store:            store,
+		storage:          raft.NewMemoryStorage(),
+		snapshotInterval: uint64(snapshotInterval),
+		proposals:        make(map[uint64]*proposal),
+		reads:            make(map[string]chan uint64),
+		appliedCh:        make(chan struct{}),
+		// Proposal IDs must not repeat across restarts, since commands
+		// proposed before a restart may still be committed after it.
+		nextProposal: uint64(time.Now().UnixNano()),
+		done:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+		failed:       make(chan struct{}),
+	}
+	if dir != "" {
+		var err error
+		if n.log, err = openRaftLog(dir, n.storage); err != nil {
+			return nil, err
+		}
+	}
+	snapshot, err := n.storage.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	if !raft.IsEmptySnap(snapshot) {
+		if err := store.restore(snapshot.Data); err != nil {
+			return nil, err
+		}
+		n.confState = snapshot.Metadata.ConfState
+		n.appliedIndex = snapshot.Metadata.Index
+		n.snapshotIndex = snapshot.Metadata.Index
+		n.applied = snapshot.Metadata.Index
+	}
+	config := &raft.Config{
+		ID:              id,
+		ElectionTick:    raftElectionTick,
+		HeartbeatTick:   1,
+		Storage:         n.storage,
+		Applied:         n.appliedIndex,
+		MaxSizePerMsg:   1 << 20,
+		MaxInflightMsgs: 256,
+		CheckQuorum:     true,
+		PreVote:         true,
+	}
+	if n.transport, err = newRaftTransport(id, peers, creds, n); err != nil {
+		return nil, err
+	}
+	state, _, err := n.storage.InitialState()
+	if err != nil {
+		return nil, err
+	}
+	if raft.IsEmptyHardState(state) && raft.IsEmptySnap(snapshot) {
+		var raftPeers []raft.Peer
+		for peer := range peers {
+			raftPeers = append(raftPeers, raft.Peer{ID: peer})
+		}
+		n.node = raft.StartNode(config, raftPeers)
+	} else {
+		n.node = raft.RestartNode(config)
+	}
+	go n.run()
+	return n, nil
+}
+
+// failure returns why the node stopped taking part in the cluster, or nil if
+// it has not failed.
+func (n *raftNode) failure() error {
+	select {
+	case <-n.failed:
+		return fmt.Errorf("raft node %d failed: %v", n.id, n.err)
+	default:
+		return nil
+	}
+}
+
+// failedError is the status requests fail with once the node has failed.
+func (n *raftNode) failedError() error {
+	return status.Errorf(codes.Unavailable, "Node %d failed: %v", n.id, n.err)
+}
+
+func (n *raftNode) isLeader() bool {
+	return atomic.LoadUint64(&n.lead) == n.id
+}
+
+// propose replicates command and returns its result once this node has
+// applied it.
+func (n *raftNode) propose(ctx context.Context, command *pb.Command) (proto.Message, error) {
+	ctx, span := n.store.startSpan(ctx, "raft.propose")
+	defer span.End()
+	if n.failure() != nil {
+		return nil, n.failedError()
+	}
+	n.mu.Lock()
+	n.nextProposal++
+	id := n.nextProposal
+	result := make(chan applyResult, 1)
+	n.proposals[id] = &proposal{ctx: ctx, result: result}
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		delete(n.proposals, id)
+		n.mu.Unlock()
+	}()
+	command = proto.Clone(command).(*pb.Command)
+	command.NodeId = n.id
+	command.ProposalId = id
+	data, err := proto.Marshal(command)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "Failed to marshal command: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, proposalTimeout)
+	defer cancel()
+	if err := n.node.Propose(ctx, data); err != nil {
+		return nil, proposalError(ctx, err)
+	}
+	select {
+	case r := <-result:
+		return r.response, r.err
+	case <-ctx.Done():
+		return nil, proposalError(ctx, ctx.Err())
+	case <-n.failed:
+		return nil, n.failedError()
+	case <-n.done:
+		return nil, status.Errorf(codes.Unavailable, "Node is shutting down.")
+	}
+}
+
+// linearizableRead waits until this node has applied every command
+// committed before the call, so that a local read reflects them all.
+func (n *raftNode) linearizableRead(ctx context.Context) error {
+	ctx, span := n.store.startSpan(ctx, "raft.linearizableRead")
+	defer span.End()
+	if n.failure() != nil {
+		return n.failedError()
+	}
+	n.mu.Lock()
+	n.nextRead++
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, n.nextRead)
+	result := make(chan uint64, 1)
+	n.reads[string(key)] = result
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		delete(n.reads, string(key))
+		n.mu.Unlock()
+	}()
+	ctx, cancel := context.WithTimeout(ctx, proposalTimeout)
+	defer cancel()
+	if err := n.node.ReadIndex(ctx, key); err != nil {
+		return readError(ctx, err)
+	}
+	var index uint64
+	select {
+	case index = <-result:
+	case <-ctx.Done():
+		return readError(ctx, ctx.Err())
+	case <-n.failed:
+		return n.failedError()
+	case <-n.done:
+		return status.Errorf(codes.Unavailable, "Node is shutting down.")
+	}
+	for {
+		n.mu.Lock()
+		applied, appliedCh := n.applied, n.appliedCh
+		n.mu.Unlock()
+		if applied >= index {
+			return nil
+		}
+		select {
+		case <-appliedCh:
+		case <-ctx.Done():
+			return readError(ctx, ctx.Err())
+		case <-n.failed:
+			return n.failedError()
+		case <-n.done:
+			return status.Errorf(codes.Unavailable, "Node is shutting down.")
+		}
+	}
+}
+
+func readError(ctx context.Context, err error) error {
+	if ctx.Err() == context.Canceled {
+		return status.Errorf(codes.Canceled, "Read canceled.")
+	}
+	return status.Errorf(codes.Unavailable,
+		"Cluster did not confirm the read: %v", err)
+}
+
+// lag returns an upper bound on the number of revisions this node is behind
+// the latest commit it knows of, or false if it has lost touch with the
+// leader. Each command advances the revision at most once.
+func (n *raftNode) lag() (int64, bool) {
+	st := n.node.Status()
+	if st.Lead == raft.None {
+		return 0, false
+	}
+	n.mu.Lock()
+	applied := n.applied
+	n.mu.Unlock()
+	if st.Commit <= applied {
+		return 0, true
+	}
+	return int64(st.Commit - applied), true
+}
+
+func proposalError(ctx context.Context, err error) error {
+	if ctx.Err() == context.Canceled {
+		return status.Errorf(codes.Canceled, "Proposal canceled.")
+	}
+	return status.Errorf(codes.Unavailable,
+		"Cluster did not commit the proposal: %v", err)
+}
+
+func (n *raftNode) run() {
+	defer close(n.stopped)
+	ticker := time.NewTicker(raftTickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+			n.node.Tick()
+		case rd := <-n.node.Ready():
+			if err := n.handleReady(rd); err != nil {
+				// The node can no longer keep its promises to its peers,
+				// so it stops taking part in the cluster.
+				n.store.log.error(context.Background(), "Raft node failed", "node", n.id, "error", err)
+				n.err = err
+				close(n.failed)
+				return
+			}
+			n.node.Advance()
+		}
+	}
+}
+
+func (n *raftNode) handleReady(rd raft.Ready) error {
+	if rd.SoftState != nil {
+		wasLeader := n.isLeader()
+		atomic.StoreUint64(&n.lead, rd.SoftState.Lead)
+		if rd.SoftState.Lead == n.id && !wasLeader {
+			// Keep-alives went to the previous leader, so every lease
+			// starts over with its full time to live, as on a restart.
+			n.store.refreshLeases()
+		}
+	}
+	if n.log != nil {
+		if err := n.log.save(rd.HardState, rd.Entries, rd.Snapshot); err != nil {
+			return err
+		}
+	}
+	if !raft.IsEmptySnap(rd.Snapshot) {
+		if err := n.storage.ApplySnapshot(rd.Snapshot); err != nil {
+			return err
+		}
+		n.store.log.info(context.Background(), "Installing Raft snapshot", "node", n.id, "index", rd.Snapshot.Metadata.Index)
+		if err := n.store.restore(rd.Snapshot.Data); err != nil {
+			return err
+		}
+		n.confState = rd.Snapshot.Metadata.ConfState
+		n.appliedIndex = rd.Snapshot.Metadata.Index
+		n.snapshotIndex = rd.Snapshot.Metadata.Index
+	}
+	if !raft.IsEmptyHardState(rd.HardState) {
+		if err := n.storage.SetHardState(rd.HardState); err != nil {
+			return err
+		}
+	}
+	if err := n.storage.Append(rd.Entries); err != nil {
+		return err
+	}
+	n.transport.send(rd.Messages)
+	for _, entry := range rd.CommittedEntries {
+		if entry.Index <= n.appliedIndex {
+			continue
+		}
+		if err := n.apply(entry); err != nil {
+			return err
+		}
+		n.appliedIndex = entry.Index
+	}
+	n.mu.Lock()
+	for _, state := range rd.ReadStates {
+		if result, exists := n.reads[string(state.RequestCtx)]; exists {
+			result <- state.Index
+		}
+	}
+	if n.applied != n.appliedIndex {
+		n.applied = n.appliedIndex
+		close(n.appliedCh)
+		n.appliedCh = make(chan struct{})
+	}
+	n.mu.Unlock()
+	return n.maybeSnapshot()
+}
+
+// apply applies a committed entry to the store. It fails only if the entry
+// cannot be decoded, after which the node may not go on: every node must
+// apply every entry for the replicas to agree.
+func (n *raftNode) apply(entry raftpb.Entry) error {
+	switch entry.Type {
+	case raftpb.EntryNormal:
+		if len(entry.Data) == 0 {
+			// Leaders append an empty entry when elected.
+			return nil
+		}
+		var command pb.Command
+		if err := proto.Unmarshal(entry.Data, &command); err != nil {
+			return fmt.Errorf("corrupt entry at index %d: %v", entry.Index, err)
+		}
+		var p *proposal
+		if command.NodeId == n.id {
+			n.mu.Lock()
+			p = n.proposals[command.ProposalId]
+			n.mu.Unlock()
+		}
+		ctx := context.Background()
+		if p != nil {
+			ctx = p.ctx
+		}
+		n.store.mu.Lock()
+		response, err := n.store.applyLocked(ctx, &command)
+		n.store.mu.Unlock()
+		if p != nil {
+			p.result <- applyResult{response, err}
+		}
+	case raftpb.EntryConfChange:
+		var change raftpb.ConfChange
+		if err := change.Unmarshal(entry.Data); err != nil {
+			return fmt.Errorf("corrupt configuration change at index %d: %v", entry.Index, err)
+		}
+		n.confState = *n.node.ApplyConfChange(change)
+	}
+	return nil
+}
+
+// maybeSnapshot compacts the log once snapshotInterval entries have been
+// applied since the last snapshot. Entries from the last interval are kept
+// so that slightly lagging peers can catch up without a snapshot.
+func (n *raftNode) maybeSnapshot() error {
+	if n.snapshotInterval == 0 || n.appliedIndex-n.snapshotIndex < n.snapshotInterval {
+		return nil
+	}
+	data, err := n.store.snapshot()
+	if err != nil {
+		return err
+	}
+	if _, err := n.storage.CreateSnapshot(n.appliedIndex, &n.confState, data); err != nil {
+		return err
+	}
+	if n.snapshotIndex > 0 {
+		if err := n.storage.Compact(n.snapshotIndex); err != nil && err != raft.ErrCompacted {
+			return err
+		}
+	}
+	n.snapshotIndex = n.appliedIndex
+	if n.log != nil {
+		return n.log.compact(n.storage)
+	}
+	return nil
+}
+
+// Step delivers a message from a peer.
+func (n *raftNode) Step(ctx context.Context, request *pb.RaftMessage) (*pb.RaftMessageAck, error) {
+	var message raftpb.Message
+	if err := message.Unmarshal(request.Data); err != nil {
+		return &pb.RaftMessageAck{}, status.Errorf(codes.InvalidArgument, "Corrupt message: %v", err)
+	}
+	if err := n.node.Step(ctx, message); err != nil {
+		return &pb.RaftMessageAck{}, status.Errorf(codes.Unavailable, "Failed to step: %v", err)
+	}
+	return &pb.RaftMessageAck{}, nil
+}
+
+// KeepAlive extends a lease for a follower's client.
+func (n *raftNode) KeepAlive(ctx context.Context, request *pb.KeepAliveRequest) (*pb.LeaseResponse, error) {
+	if !n.isLeader() {
+		return &pb.LeaseResponse{}, status.Errorf(codes.Unavailable, "Node %d is not the leader.", n.id)
+	}
+	return n.store.keepAliveLocal(ctx, request)
+}
+
+// LeaseTimeToLive looks up a lease for a follower's client.
+func (n *raftNode) LeaseTimeToLive(ctx context.Context, request *pb.LeaseTimeToLiveRequest) (*pb.LeaseTimeToLiveResponse, error) {
+	if !n.isLeader() {
+		return &pb.LeaseTimeToLiveResponse{}, status.Errorf(codes.Unavailable, "Node %d is not the leader.", n.id)
+	}
+	return n.store.leaseTimeToLiveLocal(ctx, request)
+}
+
+// leader returns the Raft client of the leader, which is another node.
+func (n *raftNode) leader() (pb.RaftClient, error) {
+	peer, exists := n.transport.peers[atomic.LoadUint64(&n.lead)]
+	if !exists {
+		return nil, status.Errorf(codes.Unavailable, "Cluster has no leader.")
+	}
+	return peer.client, nil
+}
+
+// forwardKeepAlive sends a keep-alive to the leader.
+func (n *raftNode) forwardKeepAlive(ctx context.Context, request *pb.KeepAliveRequest) (*pb.LeaseResponse, error) {
+	leader, err := n.leader()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, proposalTimeout)
+	defer cancel()
+	return leader.KeepAlive(ctx, request)
+}
+
+// forwardLeaseTimeToLive asks the leader for the state of a lease.
+func (n *raftNode) forwardLeaseTimeToLive(ctx context.Context, request *pb.LeaseTimeToLiveRequest) (*pb.LeaseTimeToLiveResponse, error) {
+	leader, err := n.leader()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, proposalTimeout)
+	defer cancel()
+	return leader.LeaseTimeToLive(ctx, request)
+}
+
+func (n *raftNode) stop() {
+	close(n.done)
+	<-n.stopped
+	n.node.Stop()
+	n.transport.close()
+	if n.log != nil {
+		if err := n.log.close(); err != nil {
+			n.store.log.error(context.Background(), "Failed to close Raft log", "node", n.id, "error", err)
+		}
+	}
+}
+
+// snapshot encodes the state of the store for a Raft snapshot.
+func (s *Store) snapshot() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snapshot := &pb.StoreSnapshot{
+		Revision:    s.storage.Revision(),
+		NextLeaseId: s.nextLeaseID,
+	}
+	err := s.storage.Snapshot(func(record *pb.Record) bool {
+		snapshot.Records = append(snapshot.Records, record)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range s.leases {
+		snapshot.Leases = append(snapshot.Leases, &pb.LeaseState{Id: l.id, TtlSeconds: int64(l.ttl / time.Second), Owner: l.owner})
+	}
+	return proto.Marshal(snapshot)
+}
+
+// restore replaces the state of the store with a Raft snapshot, closing the
+// storage it held. Watchers are told to resync, since the events in between
+// are lost.
+func (s *Store) restore(data []byte) error {
+	var snapshot pb.StoreSnapshot
+	if err := proto.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	mem := newMemoryStorage()
+	mem.observe(snapshot.Revision)
+	for _, record := range snapshot.Records {
+		mem.put(record)
+	}
+	old := s.storage
+	s.storage = mem
+	if err := old.Close(); err != nil {
+		return err
+	}
+	s.leases = make(map[int64]*lease)
+	for _, state := range snapshot.Leases {
+		ttl := time.Duration(state.TtlSeconds) * time.Second
+		s.leases[state.Id] = &lease{
+			id:     state.Id,
+			ttl:    ttl,
+			expiry: s.clock.Now().Add(ttl),
+			names:  make(map[string]bool),
+			owner:  state.Owner,
+		}
+	}
+	for _, record := range snapshot.Records {
+		s.attachLocked(record.Name, 0, record.Lease)
+	}
+	s.nextLeaseID = snapshot.NextLeaseId
+	if err := s.metrics.countRecords(mem); err != nil {
+		return err
+	}
+	s.history.reset(snapshot.Revision)
+	for _, watchers := range s.watchers {
+		for elem := watchers.Front(); elem != nil; elem = elem.Next() {
+			elem.Value.(*watcher).resync()
+		}
+	}
+	for elem := s.rangeWatchers.Front(); elem != nil; elem = elem.Next() {
+		elem.Value.(*watcher).resync()
+	}
+	return nil
+}
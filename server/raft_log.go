@@ -0,0 +1,210 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go.etcd.io/etcd/raft/v3"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+)
+
+const raftLogFileName = "raft"
+
+// The first byte of each raft log frame says what the rest holds.
+const (
+	raftLogSnapshot byte = iota + 1
+	raftLogHardState
+	raftLogEntry
+)
+
+// raftLog persists the state of a Raft node: its hard state, its log entries
+// and its latest snapshot. New state is appended to a single file, which is
+// rewritten from scratch each time a snapshot compacts the log.
+type raftLog struct {
+	dir  string
+	file *os.File
+}
+
+// openRaftLog loads the state persisted in dir into storage. A torn write at
+// the end of the file is discarded; damage anywhere else fails it.
+func openRaftLog(dir string, storage *raft.MemoryStorage) (*raftLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, raftLogFileName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l := &raftLog{dir: dir, file: file}
+	if err := l.replay(storage); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *raftLog) replay(storage *raft.MemoryStorage) error {
+	reader := bufio.NewReader(l.file)
+	var offset int64
+	for {
+		payload, err := readFrame(reader)
+		if err == io.EOF {
+			break
+		}
+		if err == errCorruptFrame {
+			// Entries and hard states after it were acknowledged, and
+			// dropping them could break Raft's guarantees.
+			return fmt.Errorf("corrupt raft log entry at offset %d: %v", offset, err)
+		}
+		if err == io.ErrUnexpectedEOF {
+			// The last write never completed. Drop it.
+			if err := l.file.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := loadRaftFrame(payload, storage); err != nil {
+			return fmt.Errorf("corrupt raft log entry at offset %d: %v", offset, err)
+		}
+		offset += int64(frameHeaderSize + len(payload))
+	}
+	_, err := l.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+func loadRaftFrame(payload []byte, storage *raft.MemoryStorage) error {
+	if len(payload) == 0 {
+		return errCorruptFrame
+	}
+	switch payload[0] {
+	case raftLogSnapshot:
+		var snapshot raftpb.Snapshot
+		if err := snapshot.Unmarshal(payload[1:]); err != nil {
+			return err
+		}
+		return storage.ApplySnapshot(snapshot)
+	case raftLogHardState:
+		var state raftpb.HardState
+		if err := state.Unmarshal(payload[1:]); err != nil {
+			return err
+		}
+		return storage.SetHardState(state)
+	case raftLogEntry:
+		var entry raftpb.Entry
+		if err := entry.Unmarshal(payload[1:]); err != nil {
+			return err
+		}
+		return storage.Append([]raftpb.Entry{entry})
+	}
+	return errCorruptFrame
+}
+
+type raftMarshaler interface {
+	Marshal() ([]byte, error)
+}
+
+func raftFrame(kind byte, m raftMarshaler) ([]byte, error) {
+	data, err := m.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return frame(append([]byte{kind}, data...)), nil
+}
+
+// save durably appends the state from a raft.Ready.
+func (l *raftLog) save(state raftpb.HardState, entries []raftpb.Entry, snapshot raftpb.Snapshot) error {
+	var buf []byte
+	if !raft.IsEmptySnap(snapshot) {
+		f, err := raftFrame(raftLogSnapshot, &snapshot)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, f...)
+	}
+	for i := range entries {
+		f, err := raftFrame(raftLogEntry, &entries[i])
+		if err != nil {
+			return err
+		}
+		buf = append(buf, f...)
+	}
+	// The hard state goes last so that its commit index never refers to
+	// entries that did not make it to disk.
+	if !raft.IsEmptyHardState(state) {
+		f, err := raftFrame(raftLogHardState, &state)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, f...)
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	offset, err := l.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if err := writeAndSync(l.file, buf); err != nil {
+		rollBack(l.file, offset)
+		return err
+	}
+	return nil
+}
+
+// compact replaces the file with the contents of storage, which has just
+// been compacted.
+func (l *raftLog) compact(storage *raft.MemoryStorage) error {
+	snapshot, err := storage.Snapshot()
+	if err != nil {
+		return err
+	}
+	state, _, err := storage.InitialState()
+	if err != nil {
+		return err
+	}
+	first, err := storage.FirstIndex()
+	if err != nil {
+		return err
+	}
+	last, err := storage.LastIndex()
+	if err != nil {
+		return err
+	}
+	var entries []raftpb.Entry
+	if last >= first {
+		if entries, err = storage.Entries(first, last+1, ^uint64(0)); err != nil {
+			return err
+		}
+	}
+	path := filepath.Join(l.dir, raftLogFileName)
+	tmp, err := os.OpenFile(path+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	next := &raftLog{dir: l.dir, file: tmp}
+	if err := next.save(state, entries, snapshot); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := syncDir(l.dir); err != nil {
+		tmp.Close()
+		return err
+	}
+	l.file.Close()
+	l.file = tmp
+	return nil
+}
+
+func (l *raftLog) close() error {
+	return l.file.Close()
+}
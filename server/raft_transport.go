@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.etcd.io/etcd/raft/v3"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+
+	pb "github.com/gnossen/kvd/kvd"
+)
+
+const (
+	raftQueueSize       = 4096
+	raftMessageTimeout  = time.Second
+	raftSnapshotTimeout = time.Minute
+)
+
+// raftTransport delivers messages to the other nodes of a cluster through
+// their Raft services. Each peer has its own queue and sender so that a slow
+// or unreachable peer does not hold up the rest.
+type raftTransport struct {
+	peers map[uint64]*raftPeer
+}
+
+type raftPeer struct {
+	id     uint64
+	conn   *grpc.ClientConn
+	client pb.RaftClient
+	queue  chan raftpb.Message
+	done   chan struct{}
+}
+
+func newRaftTransport(id uint64, peers map[uint64]string, creds grpc.DialOption, n *raftNode) (*raftTransport, error) {
+	t := &raftTransport{peers: make(map[uint64]*raftPeer)}
+	for peerID, addr := range peers {
+		if peerID == id {
+			continue
+		}
+		// Dialing does not block, so this only fails on bad options.
+		conn, err := grpc.Dial(addr, creds, grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff:           backoff.Config{BaseDelay: 100 * time.Millisecond, Multiplier: 1.6, Jitter: 0.2, MaxDelay: time.Second},
+			MinConnectTimeout: time.Second,
+		}))
+		if err != nil {
+			t.close()
+			return nil, fmt.Errorf("failed to dial peer %d at '%s': %v", peerID, addr, err)
+		}
+		peer := &raftPeer{
+			id:     peerID,
+			conn:   conn,
+			client: pb.NewRaftClient(conn),
+			queue:  make(chan raftpb.Message, raftQueueSize),
+			done:   make(chan struct{}),
+		}
+		t.peers[peerID] = peer
+		go peer.run(n)
+	}
+	return t, nil
+}
+
+func (t *raftTransport) send(messages []raftpb.Message) {
+	for _, message := range messages {
+		peer, exists := t.peers[message.To]
+		if !exists {
+			continue
+		}
+		select {
+		case peer.queue <- message:
+		default:
+			// Raft recovers from lost messages.
+		}
+	}
+}
+
+func (t *raftTransport) close() {
+	for _, peer := range t.peers {
+		close(peer.done)
+		peer.conn.Close()
+	}
+}
+
+func (p *raftPeer) run(n *raftNode) {
+	for {
+		var message raftpb.Message
+		select {
+		case <-p.done:
+			return
+		case message = <-p.queue:
+		}
+		err := p.deliver(message)
+		if err != nil {
+			n.node.ReportUnreachable(p.id)
+		}
+		if message.Type == raftpb.MsgSnap {
+			if err != nil {
+				n.node.ReportSnapshot(p.id, raft.SnapshotFailure)
+			} else {
+				n.node.ReportSnapshot(p.id, raft.SnapshotFinish)
+			}
+		}
+	}
+}
+
+func (p *raftPeer) deliver(message raftpb.Message) error {
+	data, err := message.Marshal()
+	if err != nil {
+		return err
+	}
+	timeout := raftMessageTimeout
+	if message.Type == raftpb.MsgSnap {
+		timeout = raftSnapshotTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, err = p.client.Step(ctx, &pb.RaftMessage{Data: data})
+	return err
+}
@@ -0,0 +1,937 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/gnossen/kvd/kvd"
+)
+
+// The RESP front-end serves the store to Redis clients. It supports GET, SET,
+// SETNX, DEL, EXISTS, KEYS, SCAN, EXPIRE and TTL, with expiry implemented by
+// leases. Keyspace notifications are published to subscribers on the
+// channels __keyspace@0__:<name>, with the message "set" or "del", and
+// __keyevent@0__:set and __keyevent@0__:del, with the record's name as the
+// message. If the server requires authentication, clients send AUTH with a
+// user name and password, or just root's password, or present a client
+// certificate. Subscribing requires permission to watch every record.
+
+const (
+	// maxBulkLength is the largest argument accepted, as in Redis.
+	maxBulkLength = 512 << 20
+
+	keyspaceChannel = "__keyspace@0__:"
+	keyeventChannel = "__keyevent@0__:"
+
+	// respWriteTimeout is how long a client has to read what is written to
+	// it before its connection fails.
+	respWriteTimeout = 10 * time.Second
+)
+
+var errQuit = errors.New("quit")
+
+// respServer accepts RESP connections until it is closed.
+type respServer struct {
+	store *guardedStore
+	lis   net.Listener
+
+	mu     sync.Mutex
+	conns  map[*respConn]bool
+	closed bool
+}
+
+func newRESPServer(store *guardedStore, lis net.Listener) *respServer {
+	return &respServer{store: store, lis: lis, conns: make(map[*respConn]bool)}
+}
+
+func (s *respServer) serve() {
+	for {
+		conn, err := s.lis.Accept()
+		if err != nil {
+			return
+		}
+		c := newRESPConn(s.store, conn)
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[c] = true
+		s.mu.Unlock()
+		go func() {
+			c.serve()
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+func (s *respServer) close() {
+	s.lis.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for c := range s.conns {
+		c.conn.Close()
+	}
+}
+
+// respConn serves the commands of a single client. Replies and published
+// messages are written under mu. Where both are held, mu is locked before
+// subscriptions, and subscriptions is never held while writing to the
+// network.
+type respConn struct {
+	store  *guardedStore
+	conn   net.Conn
+	ctx    context.Context
+	cancel context.CancelFunc
+	r      *bufio.Reader
+
+	mu sync.Mutex
+	w  *bufio.Writer
+
+	// The client's subscriptions, all fed by a single watcher on every key.
+	// Only the serving goroutine changes them.
+	channels      map[string]bool
+	patterns      map[string]bool
+	subscriptions sync.Mutex
+	stopWatch     func()
+}
+
+func newRESPConn(store *guardedStore, conn net.Conn) *respConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &respConn{
+		store:    store,
+		conn:     conn,
+		ctx:      peer.NewContext(ctx, &peer.Peer{Addr: conn.RemoteAddr()}),
+		cancel:   cancel,
+		r:        bufio.NewReader(conn),
+		w:        bufio.NewWriter(conn),
+		channels: make(map[string]bool),
+		patterns: make(map[string]bool),
+	}
+}
+
+func (c *respConn) serve() {
+	// Closing the connection first ends any write the watch is blocked on,
+	// so that unwatch does not wait for it.
+	defer c.unwatch()
+	defer c.cancel()
+	defer c.conn.Close()
+	if conn, ok := c.conn.(*tls.Conn); ok {
+		if err := conn.Handshake(); err != nil {
+			c.store.store.log.warn(c.ctx, "TLS handshake failed", "error", err)
+			return
+		}
+		c.ctx = peer.NewContext(c.ctx, &peer.Peer{
+			Addr:     conn.RemoteAddr(),
+			AuthInfo: credentials.TLSInfo{State: conn.ConnectionState()},
+		})
+	}
+	for {
+		args, err := c.readCommand()
+		if err != nil {
+			if err != io.EOF {
+				c.reply(err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		command := strings.ToUpper(args[0])
+		start := time.Now()
+		reply, err := c.execute(command, args[1:])
+		c.logCommand(command, start, err)
+		if err == errQuit {
+			c.reply("OK")
+			return
+		}
+		if err != nil {
+			reply = err
+		}
+		if !c.reply(reply) {
+			return
+		}
+	}
+}
+
+// logCommand logs a command once executed, with a request ID of its own.
+// Errors without a status code are the client's.
+func (c *respConn) logCommand(command string, start time.Time, err error) {
+	level := LevelInfo
+	fields := []interface{}{"command", command, "duration_ms", time.Since(start)}
+	if err != nil && err != errQuit {
+		if st, ok := status.FromError(err); ok {
+			level = codeLevel(st.Code())
+			fields = append(fields, "error", st.Message())
+		} else {
+			fields = append(fields, "error", err)
+		}
+	}
+	successfulRead := err == nil && (command == "GET" || command == "EXISTS")
+	c.store.store.log.request(withRequestID(c.ctx, newRequestID()), level, successfulRead, fields...)
+}
+
+// readCommand reads a command sent either as an array of bulk strings or
+// inline, as space-separated words on one line.
+func (c *respConn) readCommand() ([]string, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > 1024*1024 {
+		return nil, fmt.Errorf("Protocol error: invalid multibulk length")
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("Protocol error: expected '$', got '%s'", line)
+		}
+		length, err := strconv.Atoi(line[1:])
+		if err != nil || length < 0 || length > maxBulkLength {
+			return nil, fmt.Errorf("Protocol error: invalid bulk length")
+		}
+		data := make([]byte, length+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		args = append(args, string(data[:length]))
+	}
+	return args, nil
+}
+
+func (c *respConn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// reply writes value and flushes it to the client, reporting whether that
+// succeeded.
+func (c *respConn) reply(value interface{}) bool {
+	c.lockWriter()
+	defer c.mu.Unlock()
+	writeRESP(c.w, value)
+	return c.w.Flush() == nil
+}
+
+// lockWriter locks mu to write to the client, which then has until
+// respWriteTimeout to read what is written.
+func (c *respConn) lockWriter() {
+	c.mu.Lock()
+	c.conn.SetWriteDeadline(time.Now().Add(respWriteTimeout))
+}
+
+// writeRESP encodes value, where a Go string is a simple string, a *string
+// is a bulk string or null, an int or int64 is an integer, []interface{} is
+// an array and an error is an error. Nil encodes as nothing.
+func writeRESP(w *bufio.Writer, value interface{}) {
+	switch value := value.(type) {
+	case string:
+		fmt.Fprintf(w, "+%s\r\n", value)
+	case *string:
+		if value == nil {
+			w.WriteString("$-1\r\n")
+		} else {
+			fmt.Fprintf(w, "$%d\r\n%s\r\n", len(*value), *value)
+		}
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", value)
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", value)
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(value))
+		for _, element := range value {
+			writeRESP(w, element)
+		}
+	case error:
+		message := value.Error()
+		if st, ok := status.FromError(value); ok {
+			message = st.Message()
+		}
+		if !strings.HasPrefix(message, "ERR ") && !strings.HasPrefix(message, "WRONGTYPE ") {
+			message = "ERR " + message
+		}
+		fmt.Fprintf(w, "-%s\r\n", strings.Replace(message, "\r\n", " ", -1))
+	}
+}
+
+func bulk(s string) *string {
+	return &s
+}
+
+func bulks(strs []string) []interface{} {
+	values := make([]interface{}, len(strs))
+	for i, s := range strs {
+		values[i] = bulk(s)
+	}
+	return values
+}
+
+func wrongArgs(command string) error {
+	return fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(command))
+}
+
+func (c *respConn) execute(command string, args []string) (interface{}, error) {
+	c.subscriptions.Lock()
+	subscribed := len(c.channels)+len(c.patterns) > 0
+	c.subscriptions.Unlock()
+	if subscribed {
+		switch command {
+		case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE", "PING", "QUIT":
+		default:
+			return nil, fmt.Errorf("Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context",
+				strings.ToLower(command))
+		}
+	}
+	switch command {
+	case "PING":
+		if len(args) > 1 {
+			return nil, wrongArgs(command)
+		}
+		if len(args) == 1 {
+			return bulk(args[0]), nil
+		}
+		return "PONG", nil
+	case "ECHO":
+		if len(args) != 1 {
+			return nil, wrongArgs(command)
+		}
+		return bulk(args[0]), nil
+	case "QUIT":
+		return nil, errQuit
+	case "AUTH":
+		if len(args) != 1 && len(args) != 2 {
+			return nil, wrongArgs(command)
+		}
+		return c.auth(args)
+	case "SELECT":
+		if len(args) != 1 {
+			return nil, wrongArgs(command)
+		}
+		if args[0] != "0" {
+			return nil, fmt.Errorf("DB index is out of range")
+		}
+		return "OK", nil
+	case "COMMAND":
+		return []interface{}{}, nil
+	case "GET":
+		if len(args) != 1 {
+			return nil, wrongArgs(command)
+		}
+		return c.get(args[0])
+	case "SET":
+		if len(args) < 2 {
+			return nil, wrongArgs(command)
+		}
+		return c.set(args[0], args[1], args[2:])
+	case "SETNX":
+		if len(args) != 2 {
+			return nil, wrongArgs(command)
+		}
+		reply, err := c.set(args[0], args[1], []string{"NX"})
+		if err != nil {
+			return nil, err
+		}
+		if reply == "OK" {
+			return 1, nil
+		}
+		return 0, nil
+	case "DEL":
+		if len(args) == 0 {
+			return nil, wrongArgs(command)
+		}
+		return c.del(args)
+	case "EXISTS":
+		if len(args) == 0 {
+			return nil, wrongArgs(command)
+		}
+		return c.exists(args)
+	case "KEYS":
+		if len(args) != 1 {
+			return nil, wrongArgs(command)
+		}
+		return c.keys(args[0])
+	case "SCAN":
+		if len(args) == 0 {
+			return nil, wrongArgs(command)
+		}
+		return c.scan(args[0], args[1:])
+	case "EXPIRE":
+		if len(args) != 2 {
+			return nil, wrongArgs(command)
+		}
+		seconds, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("value is not an integer or out of range")
+		}
+		return c.expire(args[0], seconds)
+	case "TTL":
+		if len(args) != 1 {
+			return nil, wrongArgs(command)
+		}
+		return c.ttl(args[0])
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		if len(args) == 0 {
+			return nil, wrongArgs(command)
+		}
+		return c.subscribe(command == "PSUBSCRIBE", args)
+	case "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		return c.unsubscribe(command == "PUNSUBSCRIBE", args)
+	}
+	return nil, fmt.Errorf("unknown command '%s'", strings.ToLower(command))
+}
+
+// auth authenticates the client as the user named by args[0] with password
+// args[1], or as root with password args[0].
+func (c *respConn) auth(args []string) (interface{}, error) {
+	if !c.store.auth.enabled {
+		return nil, fmt.Errorf("AUTH called without authentication enabled")
+	}
+	name, password := rootName, args[0]
+	if len(args) == 2 {
+		name, password = args[0], args[1]
+	}
+	p, err := c.store.auth.login(name, password)
+	if err != nil {
+		return nil, err
+	}
+	c.ctx = withPrincipal(c.ctx, p)
+	return "OK", nil
+}
+
+func (c *respConn) get(name string) (interface{}, error) {
+	record, _, err := c.store.get(c.ctx, &pb.GetRecordRequest{Name: name})
+	if status.Code(err) == codes.NotFound {
+		return (*string)(nil), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bulk(record.Value), nil
+}
+
+// set implements SET name value [NX|XX] [EX seconds|PX milliseconds]. Like
+// Redis, it clears any expiry the record had unless a new one is given.
+func (c *respConn) set(name string, value string, options []string) (interface{}, error) {
+	var compare []*pb.Compare
+	var ttl time.Duration
+	for i := 0; i < len(options); i++ {
+		switch option := strings.ToUpper(options[i]); option {
+		case "NX", "XX":
+			if compare != nil {
+				return nil, fmt.Errorf("syntax error")
+			}
+			compare = []*pb.Compare{{Name: name, Target: &pb.Compare_Exists{Exists: option == "XX"}}}
+		case "EX", "PX":
+			if ttl != 0 || i+1 == len(options) {
+				return nil, fmt.Errorf("syntax error")
+			}
+			i++
+			n, err := strconv.ParseInt(options[i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("value is not an integer or out of range")
+			}
+			if n <= 0 {
+				return nil, fmt.Errorf("invalid expire time in 'set' command")
+			}
+			ttl = time.Duration(n) * time.Second
+			if option == "PX" {
+				ttl = time.Duration(n) * time.Millisecond
+			}
+		default:
+			return nil, fmt.Errorf("syntax error")
+		}
+	}
+	record := &pb.Record{Name: name, Value: value}
+	if ttl != 0 {
+		// Leases live for whole seconds.
+		lease, err := c.store.GrantLease(c.ctx, &pb.GrantLeaseRequest{
+			TtlSeconds: int64((ttl + time.Second - 1) / time.Second),
+		})
+		if err != nil {
+			return nil, err
+		}
+		record.Lease = lease.Id
+	}
+	// The record as it was tells which lease, if any, it no longer needs.
+	response, err := c.store.Txn(c.ctx, &pb.TxnRequest{
+		Compare: compare,
+		Success: []*pb.TxnOp{
+			{Op: &pb.TxnOp_Get{Get: name}},
+			{Op: &pb.TxnOp_Put{Put: record}},
+		},
+	})
+	if err != nil || !response.Succeeded {
+		// The new lease is left unused, unless a failed write was in fact
+		// applied.
+		if record.Lease != 0 {
+			c.revokeUnused(record.Lease)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return (*string)(nil), nil
+	}
+	if previous := response.Results[0].Record; previous != nil && previous.Lease != 0 {
+		c.revokeUnused(previous.Lease)
+	}
+	return "OK", nil
+}
+
+// revokeUnused revokes lease id if no record is attached to it any more, as
+// after its record is overwritten or moved to another lease. It fails only
+// to log, since the command it follows has already been applied.
+func (c *respConn) revokeUnused(id int64) {
+	response, err := c.store.LeaseTimeToLive(c.ctx, &pb.LeaseTimeToLiveRequest{Id: id, Names: true})
+	if err == nil && len(response.Names) == 0 {
+		_, err = c.store.RevokeLease(c.ctx, &pb.RevokeLeaseRequest{Id: id})
+	}
+	if err != nil && status.Code(err) != codes.NotFound {
+		c.store.store.log.warn(c.ctx, "Failed to revoke unused lease", "lease", id, "error", err)
+	}
+}
+
+func (c *respConn) del(names []string) (interface{}, error) {
+	var ops []*pb.TxnOp
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			ops = append(ops, &pb.TxnOp{Op: &pb.TxnOp_Delete{Delete: name}})
+		}
+	}
+	response, err := c.store.Txn(c.ctx, &pb.TxnRequest{Success: ops})
+	if err != nil {
+		return nil, err
+	}
+	deleted := 0
+	for _, result := range response.Results {
+		if result.Record != nil {
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (c *respConn) exists(names []string) (interface{}, error) {
+	count := 0
+	for _, name := range names {
+		_, _, err := c.store.get(c.ctx, &pb.GetRecordRequest{Name: name})
+		if err == nil {
+			count++
+		} else if status.Code(err) != codes.NotFound {
+			return nil, err
+		}
+	}
+	return count, nil
+}
+
+// listMatching lists a page of the names matching pattern, starting from
+// pageToken.
+func (c *respConn) listMatching(pattern string, pageToken string, pageSize int32) ([]string, string, error) {
+	response, err := c.store.ListRecords(c.ctx, &pb.ListRecordsRequest{
+		Prefix:    globPrefix(pattern),
+		PageToken: pageToken,
+		PageSize:  pageSize,
+		KeysOnly:  true,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	var names []string
+	for _, record := range response.Records {
+		if globMatch(pattern, record.Name) {
+			names = append(names, record.Name)
+		}
+	}
+	return names, response.NextPageToken, nil
+}
+
+func (c *respConn) keys(pattern string) (interface{}, error) {
+	var names []string
+	pageToken := ""
+	for {
+		page, next, err := c.listMatching(pattern, pageToken, maxPageSize)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, page...)
+		if next == "" {
+			return bulks(names), nil
+		}
+		pageToken = next
+	}
+}
+
+// scan implements SCAN cursor [MATCH pattern] [COUNT count]. Cursors are
+// ListRecords page tokens, except for "0", which starts and ends a scan.
+func (c *respConn) scan(cursor string, options []string) (interface{}, error) {
+	pattern := "*"
+	count := int64(10)
+	for i := 0; i < len(options); i++ {
+		if i+1 == len(options) {
+			return nil, fmt.Errorf("syntax error")
+		}
+		switch strings.ToUpper(options[i]) {
+		case "MATCH":
+			pattern = options[i+1]
+		case "COUNT":
+			var err error
+			if count, err = strconv.ParseInt(options[i+1], 10, 32); err != nil || count < 1 {
+				return nil, fmt.Errorf("syntax error")
+			}
+		default:
+			return nil, fmt.Errorf("syntax error")
+		}
+		i++
+	}
+	if cursor == "0" {
+		cursor = ""
+	}
+	names, next, err := c.listMatching(pattern, cursor, int32(count))
+	if status.Code(err) == codes.InvalidArgument {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if next == "" {
+		next = "0"
+	}
+	return []interface{}{bulk(next), bulks(names)}, nil
+}
+
+// expire attaches the record to a new lease living for seconds. As in Redis,
+// a time that is not positive deletes the record.
+func (c *respConn) expire(name string, seconds int64) (interface{}, error) {
+	if seconds <= 0 {
+		return c.del([]string{name})
+	}
+	for {
+		current, _, err := c.store.get(c.ctx, &pb.GetRecordRequest{Name: name})
+		if status.Code(err) == codes.NotFound {
+			return 0, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		lease, err := c.store.GrantLease(c.ctx, &pb.GrantLeaseRequest{TtlSeconds: seconds})
+		if err != nil {
+			return nil, err
+		}
+		// Only replace the version of the record that was read, so that
+		// concurrent writes are not undone.
+		response, err := c.store.Txn(c.ctx, &pb.TxnRequest{
+			Compare: []*pb.Compare{{
+				Name:   name,
+				Target: &pb.Compare_ModRevision{ModRevision: current.ModRevision},
+			}},
+			Success: []*pb.TxnOp{{Op: &pb.TxnOp_Put{Put: &pb.Record{
+				Name:  name,
+				Value: current.Value,
+				Lease: lease.Id,
+			}}}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if response.Succeeded {
+			if current.Lease != 0 {
+				c.revokeUnused(current.Lease)
+			}
+			return 1, nil
+		}
+		if _, err := c.store.RevokeLease(c.ctx, &pb.RevokeLeaseRequest{Id: lease.Id}); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ttl returns the seconds before the record expires, -1 if it never does or
+// -2 if it does not exist.
+func (c *respConn) ttl(name string) (interface{}, error) {
+	current, _, err := c.store.get(c.ctx, &pb.GetRecordRequest{Name: name})
+	if status.Code(err) == codes.NotFound {
+		return -2, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if current.Lease == 0 {
+		return -1, nil
+	}
+	response, err := c.store.LeaseTimeToLive(c.ctx, &pb.LeaseTimeToLiveRequest{Id: current.Lease})
+	if status.Code(err) == codes.NotFound {
+		return -2, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return response.TtlSeconds, nil
+}
+
+// subscribe adds channels or patterns and replies to each itself, as Redis
+// does, returning no reply of its own.
+func (c *respConn) subscribe(isPattern bool, names []string) (interface{}, error) {
+	if err := c.watch(); err != nil {
+		return nil, err
+	}
+	kind := "subscribe"
+	if isPattern {
+		kind = "psubscribe"
+	}
+	// Holding mu until the replies are written keeps events for the new
+	// subscriptions from being published before them.
+	c.lockWriter()
+	defer c.mu.Unlock()
+	for _, name := range names {
+		c.subscriptions.Lock()
+		if isPattern {
+			c.patterns[name] = true
+		} else {
+			c.channels[name] = true
+		}
+		count := len(c.channels) + len(c.patterns)
+		c.subscriptions.Unlock()
+		writeRESP(c.w, []interface{}{bulk(kind), bulk(name), count})
+	}
+	return nil, nil
+}
+
+func (c *respConn) unsubscribe(isPattern bool, names []string) (interface{}, error) {
+	kind := "unsubscribe"
+	subscribed := c.channels
+	if isPattern {
+		kind = "punsubscribe"
+		subscribed = c.patterns
+	}
+	c.lockWriter()
+	c.subscriptions.Lock()
+	if len(names) == 0 {
+		for name := range subscribed {
+			names = append(names, name)
+		}
+	}
+	replies := make([][]interface{}, 0, len(names))
+	if len(names) == 0 {
+		replies = append(replies, []interface{}{bulk(kind), (*string)(nil), len(c.channels) + len(c.patterns)})
+	}
+	for _, name := range names {
+		delete(subscribed, name)
+		replies = append(replies, []interface{}{bulk(kind), bulk(name), len(c.channels) + len(c.patterns)})
+	}
+	remaining := len(c.channels) + len(c.patterns)
+	c.subscriptions.Unlock()
+	for _, reply := range replies {
+		writeRESP(c.w, reply)
+	}
+	c.mu.Unlock()
+	if remaining == 0 {
+		c.unwatch()
+	}
+	return nil, nil
+}
+
+// watch starts publishing events to the client's subscriptions, unless it
+// has already started.
+func (c *respConn) watch() error {
+	if c.stopWatch != nil {
+		return nil
+	}
+	w, remove, err := c.store.addWatcher(c.ctx, &pb.WatchRecordRequest{Prefix: true})
+	if err != nil {
+		return err
+	}
+	ctx := c.ctx
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	c.stopWatch = func() {
+		close(done)
+		<-stopped
+		remove()
+	}
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			case <-w.ready:
+				events, err := w.drain()
+				for _, event := range events {
+					if !c.publish(event) {
+						c.conn.Close()
+						return
+					}
+					c.store.store.metrics.eventsSent.Inc()
+				}
+				if err != nil {
+					// Redis disconnects subscribers that fall behind.
+					c.store.store.log.warn(ctx, "Disconnecting RESP subscriber", "error", err)
+					c.conn.Close()
+					return
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *respConn) unwatch() {
+	if c.stopWatch != nil {
+		c.stopWatch()
+		c.stopWatch = nil
+	}
+}
+
+// publish sends the keyspace notifications for event to the client,
+// reporting whether that succeeded.
+func (c *respConn) publish(event *pb.Event) bool {
+	var action string
+	switch event.Type {
+	case pb.Event_PUT:
+		action = "set"
+	case pb.Event_DELETE:
+		action = "del"
+	default:
+		return true
+	}
+	messages := [][2]string{
+		{keyspaceChannel + event.Record.Name, action},
+		{keyeventChannel + action, event.Record.Name},
+	}
+	var replies [][]interface{}
+	c.subscriptions.Lock()
+	for _, message := range messages {
+		channel, payload := message[0], message[1]
+		if c.channels[channel] {
+			replies = append(replies, []interface{}{bulk("message"), bulk(channel), bulk(payload)})
+		}
+		for pattern := range c.patterns {
+			if globMatch(pattern, channel) {
+				replies = append(replies, []interface{}{bulk("pmessage"), bulk(pattern), bulk(channel), bulk(payload)})
+			}
+		}
+	}
+	c.subscriptions.Unlock()
+	if len(replies) == 0 {
+		return true
+	}
+	c.lockWriter()
+	defer c.mu.Unlock()
+	for _, reply := range replies {
+		writeRESP(c.w, reply)
+	}
+	return c.w.Flush() == nil
+}
+
+// globPrefix returns the literal text at the start of a glob pattern.
+func globPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, "*?[\\"); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// globMatch reports whether name matches a Redis glob pattern, in which *
+// matches any text, ? any character, [abc], [^abc] and [a-c] a character in
+// or out of a set and \ escapes the next character. Every other part of a
+// pattern matches a single character, so on a mismatch only the latest * needs
+// to take in one more character, and matching takes at most the product of
+// the lengths of pattern and name.
+func globMatch(pattern string, name string) bool {
+	p, n := 0, 0
+	// Where matching resumes after the latest *, if any.
+	star, starName := -1, 0
+	for n < len(name) {
+		if p < len(pattern) {
+			if pattern[p] == '*' {
+				p++
+				star, starName = p, n
+				continue
+			}
+			if width, ok := globMatchOne(pattern[p:], name[n]); ok {
+				p += width
+				n++
+				continue
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		starName++
+		p, n = star, starName
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// globMatchOne reports whether b matches the part of a glob pattern at its
+// start, which must not be *, and how many bytes of pattern that part spans.
+func globMatchOne(pattern string, b byte) (int, bool) {
+	switch pattern[0] {
+	case '?':
+		return 1, true
+	case '[':
+		end := strings.IndexByte(pattern[1:], ']')
+		if end < 0 {
+			// An unterminated set matches a literal '['.
+			return 1, b == '['
+		}
+		set := pattern[1 : end+1]
+		negate := strings.HasPrefix(set, "^")
+		if negate {
+			set = set[1:]
+		}
+		return end + 2, inSet(set, b) != negate
+	case '\\':
+		if len(pattern) > 1 {
+			return 2, pattern[1] == b
+		}
+	}
+	return 1, pattern[0] == b
+}
+
+func inSet(set string, b byte) bool {
+	for i := 0; i < len(set); i++ {
+		if i+2 < len(set) && set[i+1] == '-' {
+			if set[i] <= b && b <= set[i+2] {
+				return true
+			}
+			i += 2
+		} else if set[i] == b {
+			return true
+		}
+	}
+	return false
+}
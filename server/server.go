@@ -5,138 +5,1426 @@ package server
 import (
 	list "container/list"
 	"context"
+	"crypto/tls"
+	"encoding/base64"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
 
 	pb "github.com/gnossen/kvd/kvd"
 )
 
-type kvStore struct {
-	m        map[string]string
-	mu       sync.RWMutex
-	watchers map[string]*list.List // List[*chan string]
-	ctx      context.Context
+// notifyLocked records event in the history and queues it for the watchers
+// it concerns. Ctx is that of the command being applied, and is used only for
+// tracing.
+func (s *Store) notifyLocked(ctx context.Context, event *pb.Event) {
+	_, span := s.startSpan(ctx, "Store.notify")
+	defer span.End()
+	s.history.add(event)
+	notified := 0
+	if watchers, exists := s.watchers[event.Record.Name]; exists {
+		for elem := watchers.Front(); elem != nil; elem = elem.Next() {
+			elem.Value.(*watcher).push(event)
+			notified++
+		}
+	}
+	for elem := s.rangeWatchers.Front(); elem != nil; elem = elem.Next() {
+		w := elem.Value.(*watcher)
+		if w.contains(event.Record.Name) {
+			w.push(event)
+			notified++
+		}
+	}
+	span.SetAttributes(attribute.Int("kvd.watchers", notified))
 }
 
-func newKeyValueStore() *kvStore {
-	var store kvStore
-	store.m = make(map[string]string)
-	store.watchers = make(map[string]*list.List)
-	return &store
+// propose applies command, through the Raft log if the store belongs to a
+// cluster, and returns its result.
+func (s *Store) propose(ctx context.Context, command *pb.Command) (proto.Message, error) {
+	if s.raft != nil {
+		return s.raft.propose(ctx, command)
+	}
+	s.lock(ctx)
+	defer s.mu.Unlock()
+	return s.applyLocked(ctx, command)
 }
 
-func (s *kvStore) upsertLocked(key string, value string) {
-	s.m[key] = value
-	if watchers, exists := s.watchers[key]; exists {
-		for elem := watchers.Front(); elem != nil; elem = elem.Next() {
-			watcher := elem.Value.(*chan string)
-			*watcher <- value
-		}
+// applyLocked applies command. Its context is that of the request that
+// proposed it, if any, and is used only for tracing.
+func (s *Store) applyLocked(ctx context.Context, command *pb.Command) (proto.Message, error) {
+	ctx, span := s.startSpan(ctx, "Store.apply")
+	defer span.End()
+	switch op := command.Op.(type) {
+	case *pb.Command_Create:
+		return s.createLocked(ctx, op.Create)
+	case *pb.Command_Update:
+		return s.updateLocked(ctx, op.Update)
+	case *pb.Command_Delete:
+		return s.deleteLocked(ctx, op.Delete)
+	case *pb.Command_Txn:
+		return s.txnLocked(ctx, op.Txn)
+	case *pb.Command_GrantLease:
+		return s.grantLeaseLocked(ctx, op.GrantLease, command.User)
+	case *pb.Command_RevokeLease:
+		return s.revokeLeaseLocked(ctx, op.RevokeLease)
+	case *pb.Command_KeepAlive:
+		// Keep-alives are no longer proposed, but a log may hold some.
+		return s.keepAliveLocked(op.KeepAlive)
 	}
+	return nil, status.Errorf(codes.InvalidArgument, "Command is empty.")
 }
 
-func peerString(ctx context.Context) string {
-	if p, ok := peer.FromContext(ctx); ok {
-		return p.Addr.String()
-	} else {
-		return ""
+func storageError(name string, err error) error {
+	return status.Errorf(codes.Internal,
+		"Storage failure at key '%s': %v", name, err)
+}
+
+// awaitConsistency waits until a read may be served from this server's
+// copy of the store with the requested consistency.
+func (s *Store) awaitConsistency(ctx context.Context, consistency pb.Consistency, maxRevisionLag int64) error {
+	switch consistency {
+	case pb.Consistency_LINEARIZABLE, pb.Consistency_SERIALIZABLE:
+	case pb.Consistency_BOUNDED_STALENESS:
+		if maxRevisionLag < 0 {
+			return status.Errorf(codes.InvalidArgument, "max_revision_lag may not be negative.")
+		}
+	default:
+		return status.Errorf(codes.InvalidArgument,
+			"Unknown consistency %v.", consistency)
+	}
+	if s.raft == nil || consistency == pb.Consistency_SERIALIZABLE {
+		return nil
+	}
+	if consistency == pb.Consistency_BOUNDED_STALENESS {
+		if lag, ok := s.raft.lag(); ok && lag <= maxRevisionLag {
+			return nil
+		}
 	}
+	return s.raft.linearizableRead(ctx)
 }
 
-func (s *kvStore) GetRecord(ctx context.Context, request *pb.GetRecordRequest) (*pb.Record, error) {
-	log.Printf("%s: Get '%s'\n", peerString(ctx), request.Name)
-	s.mu.RLock()
+// get reads a record with the consistency request asks for, and returns it
+// with the store revision the read was served at.
+func (s *Store) get(ctx context.Context, request *pb.GetRecordRequest) (*pb.Record, int64, error) {
+	s.log.debug(ctx, "Get", "key", request.Name)
+	if err := checkKeyName(request.Name); err != nil {
+		return nil, 0, err
+	}
+	if err := s.awaitConsistency(ctx, request.Consistency, request.MaxRevisionLag); err != nil {
+		return nil, 0, err
+	}
+	s.rlock(ctx)
 	defer s.mu.RUnlock()
-	var value string
-	var exists bool
-	if value, exists = s.m[request.Name]; !exists {
-		return &pb.Record{},
+	_, span := s.startSpan(ctx, "storage.Get")
+	record, exists, err := s.storage.Get(request.Name)
+	span.End()
+	if err != nil {
+		return nil, 0, storageError(request.Name, err)
+	}
+	if !exists {
+		return nil, 0,
 			status.Errorf(codes.NotFound,
-				fmt.Sprintf("Record at key '%s' not found.",
-					request.Name))
+				"Record at key '%s' not found.",
+				request.Name)
 	}
-	return &pb.Record{Name: request.Name, Value: value}, nil
+	return record, s.storage.Revision(), nil
 }
 
-func (s *kvStore) CreateRecord(ctx context.Context, request *pb.CreateRecordRequest) (*pb.Record, error) {
-	log.Printf("%s: Create '%s': '%s'\n", peerString(ctx), request.Record.Name, request.Record.Value)
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if _, exists := s.m[request.Record.Name]; exists {
+// checkKeyName fails with codes.InvalidArgument if name belongs to the store's
+// own records, such as users and leases.
+func checkKeyName(name string) error {
+	if isReserved(name) {
+		return status.Errorf(codes.InvalidArgument, "Key '%s' is reserved.", name)
+	}
+	return nil
+}
+
+// checkRecordSize fails with codes.InvalidArgument if a record named name
+// with value would be larger than the server allows.
+func (s *Store) checkRecordSize(name string, value string) error {
+	if s.maxRecordSize > 0 && len(name)+len(value) > s.maxRecordSize {
+		return status.Errorf(codes.InvalidArgument,
+			"Record at key '%s' is %d bytes, over the limit of %d.",
+			name, len(name)+len(value), s.maxRecordSize)
+	}
+	return nil
+}
+
+func (s *Store) createRecord(ctx context.Context, request *pb.CreateRecordRequest) (*pb.Record, error) {
+	s.log.debug(ctx, "Create", "key", request.Record.Name, "value", s.log.value(request.Record.Name, request.Record.Value))
+	if err := checkKeyName(request.Record.Name); err != nil {
+		return &pb.Record{}, err
+	}
+	if err := s.checkRecordSize(request.Record.Name, request.Record.Value); err != nil {
+		return &pb.Record{}, err
+	}
+	response, err := s.propose(ctx, &pb.Command{Op: &pb.Command_Create{Create: request}})
+	if err != nil {
+		return &pb.Record{}, err
+	}
+	return response.(*pb.Record), nil
+}
+
+func (s *Store) createLocked(ctx context.Context, request *pb.CreateRecordRequest) (*pb.Record, error) {
+	if err := s.checkLeaseLocked(request.Record.Lease); err != nil {
+		return &pb.Record{}, err
+	}
+	revision := s.storage.Revision() + 1
+	record := &pb.Record{
+		Name:           request.Record.Name,
+		Value:          request.Record.Value,
+		CreateRevision: revision,
+		ModRevision:    revision,
+		Version:        1,
+		Lease:          request.Record.Lease,
+	}
+	created, err := s.storage.PutIfAbsent(record)
+	if err != nil {
+		return &pb.Record{}, storageError(record.Name, err)
+	}
+	if !created {
 		return &pb.Record{},
-			status.Errorf(codes.InvalidArgument,
-				fmt.Sprintf("Record at key '%s already exists.",
-					request.Record.Name))
+			status.Errorf(codes.AlreadyExists,
+				"Record at key '%s' already exists.",
+				record.Name)
 	}
-	s.upsertLocked(request.Record.Name, request.Record.Value)
-	return &pb.Record{Name: request.Record.Name, Value: request.Record.Value}, nil
+	s.attachLocked(record.Name, 0, record.Lease)
+	s.metrics.recordChanged(nil, record)
+	s.notifyLocked(ctx, &pb.Event{Type: pb.Event_PUT, Record: record})
+	return record, nil
 }
 
-func (s *kvStore) UpdateRecord(ctx context.Context, request *pb.UpdateRecordRequest) (*pb.Record, error) {
-	log.Printf("%s: Update '%s': '%s'\n", peerString(ctx), request.Record.Name, request.Record.Value)
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	if _, exists := s.m[request.Record.Name]; !exists {
+// checkExpected fails with codes.Aborted if current does not match the
+// nonzero expectations.
+func checkExpected(current *pb.Record, expectedVersion int64, expectedModRevision int64) error {
+	if expectedVersion != 0 && expectedVersion != current.Version {
+		return status.Errorf(codes.Aborted,
+			"Record at key '%s' is at version %d, not %d.",
+			current.Name, current.Version, expectedVersion)
+	}
+	if expectedModRevision != 0 && expectedModRevision != current.ModRevision {
+		return status.Errorf(codes.Aborted,
+			"Record at key '%s' is at mod_revision %d, not %d.",
+			current.Name, current.ModRevision, expectedModRevision)
+	}
+	return nil
+}
+
+func (s *Store) updateRecord(ctx context.Context, request *pb.UpdateRecordRequest) (*pb.Record, error) {
+	s.log.debug(ctx, "Update", "key", request.Record.Name, "value", s.log.value(request.Record.Name, request.Record.Value))
+	if err := checkKeyName(request.Record.Name); err != nil {
+		return &pb.Record{}, err
+	}
+	if err := s.checkRecordSize(request.Record.Name, request.Record.Value); err != nil {
+		return &pb.Record{}, err
+	}
+	response, err := s.propose(ctx, &pb.Command{Op: &pb.Command_Update{Update: request}})
+	if err != nil {
+		return &pb.Record{}, err
+	}
+	return response.(*pb.Record), nil
+}
+
+func (s *Store) updateLocked(ctx context.Context, request *pb.UpdateRecordRequest) (*pb.Record, error) {
+	current, exists, err := s.storage.Get(request.Record.Name)
+	if err != nil {
+		return &pb.Record{}, storageError(request.Record.Name, err)
+	}
+	if !exists {
 		return &pb.Record{},
 			status.Errorf(codes.NotFound,
-				fmt.Sprintf("Record at key '%s' not found.",
-					request.Record.Name))
+				"Record at key '%s' not found.",
+				request.Record.Name)
+	}
+	if err := checkExpected(current, request.ExpectedVersion, request.ExpectedModRevision); err != nil {
+		return &pb.Record{}, err
 	}
-	s.upsertLocked(request.Record.Name, request.Record.Value)
-	return &pb.Record{Name: request.Record.Name, Value: request.Record.Value}, nil
+	if err := s.checkLeaseLocked(request.Record.Lease); err != nil {
+		return &pb.Record{}, err
+	}
+	record := &pb.Record{
+		Name:           current.Name,
+		Value:          request.Record.Value,
+		CreateRevision: current.CreateRevision,
+		ModRevision:    s.storage.Revision() + 1,
+		Version:        current.Version + 1,
+		Lease:          request.Record.Lease,
+	}
+	if _, err := s.storage.PutIfPresent(record); err != nil {
+		return &pb.Record{}, storageError(record.Name, err)
+	}
+	s.attachLocked(record.Name, current.Lease, record.Lease)
+	s.metrics.recordChanged(current, record)
+	s.notifyLocked(ctx, &pb.Event{Type: pb.Event_PUT, Record: record})
+	return record, nil
 }
 
-func (s *kvStore) addWatcher(key string) (*chan string, *list.Element) {
-	c := make(chan string)
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	var exists bool
-	if _, exists = s.watchers[key]; !exists {
-		s.watchers[key] = list.New()
+func (s *Store) deleteRecord(ctx context.Context, request *pb.DeleteRecordRequest) (*pb.Record, error) {
+	s.log.debug(ctx, "Delete", "key", request.Name)
+	if err := checkKeyName(request.Name); err != nil {
+		return &pb.Record{}, err
 	}
-	elem := s.watchers[key].PushBack(&c)
-	return &c, elem
+	response, err := s.propose(ctx, &pb.Command{Op: &pb.Command_Delete{Delete: request}})
+	if err != nil {
+		return &pb.Record{}, err
+	}
+	return response.(*pb.Record), nil
+}
+
+func (s *Store) deleteLocked(ctx context.Context, request *pb.DeleteRecordRequest) (*pb.Record, error) {
+	current, exists, err := s.storage.Get(request.Name)
+	if err != nil {
+		return &pb.Record{}, storageError(request.Name, err)
+	}
+	if !exists {
+		return &pb.Record{},
+			status.Errorf(codes.NotFound,
+				"Record at key '%s' not found.",
+				request.Name)
+	}
+	if err := checkExpected(current, request.ExpectedVersion, request.ExpectedModRevision); err != nil {
+		return &pb.Record{}, err
+	}
+	revision := s.storage.Revision() + 1
+	if _, _, err := s.storage.Delete(request.Name, revision); err != nil {
+		return &pb.Record{}, storageError(request.Name, err)
+	}
+	s.attachLocked(request.Name, current.Lease, 0)
+	s.metrics.recordChanged(current, nil)
+	s.notifyLocked(ctx, &pb.Event{
+		Type:   pb.Event_DELETE,
+		Record: &pb.Record{Name: request.Name, ModRevision: revision},
+	})
+	return current, nil
 }
 
-func (s *kvStore) removeWatcher(key string, elem *list.Element) {
+func compareInts(a int64, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// compareLocked reports whether the condition in compare holds.
+func (s *Store) compareLocked(compare *pb.Compare) (bool, error) {
+	record, exists, err := s.storage.Get(compare.Name)
+	if err != nil {
+		return false, storageError(compare.Name, err)
+	}
+	if !exists {
+		record = &pb.Record{Name: compare.Name}
+	}
+	var cmp int
+	switch target := compare.Target.(type) {
+	case *pb.Compare_Value:
+		if !exists {
+			return false, nil
+		}
+		cmp = strings.Compare(record.Value, target.Value)
+	case *pb.Compare_Version:
+		cmp = compareInts(record.Version, target.Version)
+	case *pb.Compare_CreateRevision:
+		cmp = compareInts(record.CreateRevision, target.CreateRevision)
+	case *pb.Compare_ModRevision:
+		cmp = compareInts(record.ModRevision, target.ModRevision)
+	case *pb.Compare_Exists:
+		if compare.Result != pb.Compare_EQUAL && compare.Result != pb.Compare_NOT_EQUAL {
+			return false, status.Errorf(codes.InvalidArgument,
+				"Existence of key '%s' may only be compared for equality.",
+				compare.Name)
+		}
+		if exists != target.Exists {
+			cmp = 1
+		}
+	default:
+		return false, status.Errorf(codes.InvalidArgument,
+			"Comparison on key '%s' has no target.", compare.Name)
+	}
+	switch compare.Result {
+	case pb.Compare_EQUAL:
+		return cmp == 0, nil
+	case pb.Compare_NOT_EQUAL:
+		return cmp != 0, nil
+	case pb.Compare_LESS:
+		return cmp < 0, nil
+	case pb.Compare_GREATER:
+		return cmp > 0, nil
+	}
+	return false, status.Errorf(codes.InvalidArgument,
+		"Unknown comparison result %v.", compare.Result)
+}
+
+func (s *Store) txn(ctx context.Context, request *pb.TxnRequest) (*pb.TxnResponse, error) {
+	s.log.debug(ctx, "Txn", "comparisons", len(request.Compare))
+	for _, compare := range request.Compare {
+		if err := checkKeyName(compare.Name); err != nil {
+			return &pb.TxnResponse{}, err
+		}
+	}
+	for _, ops := range [][]*pb.TxnOp{request.Success, request.Failure} {
+		for _, op := range ops {
+			var err error
+			switch op := op.Op.(type) {
+			case *pb.TxnOp_Get:
+				err = checkKeyName(op.Get)
+			case *pb.TxnOp_Delete:
+				err = checkKeyName(op.Delete)
+			case *pb.TxnOp_Put:
+				if err = checkKeyName(op.Put.Name); err == nil {
+					err = s.checkRecordSize(op.Put.Name, op.Put.Value)
+				}
+			}
+			if err != nil {
+				return &pb.TxnResponse{}, err
+			}
+		}
+	}
+	response, err := s.propose(ctx, &pb.Command{Op: &pb.Command_Txn{Txn: request}})
+	if err != nil {
+		return &pb.TxnResponse{}, err
+	}
+	return response.(*pb.TxnResponse), nil
+}
+
+func (s *Store) txnLocked(ctx context.Context, request *pb.TxnRequest) (*pb.TxnResponse, error) {
+	succeeded := true
+	for _, compare := range request.Compare {
+		holds, err := s.compareLocked(compare)
+		if err != nil {
+			return &pb.TxnResponse{}, err
+		}
+		if !holds {
+			succeeded = false
+			break
+		}
+	}
+	ops := request.Success
+	if !succeeded {
+		ops = request.Failure
+	}
+	revision := s.storage.Revision() + 1
+	// Records written earlier in this transaction. Deleted records map to nil.
+	written := make(map[string]*pb.Record)
+	read := func(name string) (*pb.Record, error) {
+		if record, exists := written[name]; exists {
+			return record, nil
+		}
+		record, _, err := s.storage.Get(name)
+		if err != nil {
+			return nil, storageError(name, err)
+		}
+		return record, nil
+	}
+	write := func(name string, record *pb.Record) error {
+		if _, exists := written[name]; exists {
+			return status.Errorf(codes.InvalidArgument,
+				"Transaction writes key '%s' more than once.", name)
+		}
+		written[name] = record
+		return nil
+	}
+	var events []*pb.Event
+	// Lease and metric changes to make once the events are committed.
+	type attachment struct {
+		name          string
+		from, to      int64
+		before, after *pb.Record
+	}
+	var attachments []attachment
+	response := &pb.TxnResponse{Succeeded: succeeded}
+	for _, op := range ops {
+		var result *pb.Record
+		var err error
+		switch op := op.Op.(type) {
+		case *pb.TxnOp_Get:
+			result, err = read(op.Get)
+		case *pb.TxnOp_Put:
+			var current *pb.Record
+			if current, err = read(op.Put.Name); err != nil {
+				break
+			}
+			if err = s.checkLeaseLocked(op.Put.Lease); err != nil {
+				break
+			}
+			result = &pb.Record{
+				Name:           op.Put.Name,
+				Value:          op.Put.Value,
+				CreateRevision: revision,
+				ModRevision:    revision,
+				Version:        1,
+				Lease:          op.Put.Lease,
+			}
+			from := int64(0)
+			if current != nil {
+				result.CreateRevision = current.CreateRevision
+				result.Version = current.Version + 1
+				from = current.Lease
+			}
+			if err = write(op.Put.Name, result); err == nil {
+				events = append(events, &pb.Event{Type: pb.Event_PUT, Record: result})
+				attachments = append(attachments, attachment{op.Put.Name, from, result.Lease, current, result})
+			}
+		case *pb.TxnOp_Delete:
+			if result, err = read(op.Delete); err != nil || result == nil {
+				break
+			}
+			if err = write(op.Delete, nil); err == nil {
+				events = append(events, &pb.Event{
+					Type:   pb.Event_DELETE,
+					Record: &pb.Record{Name: op.Delete, ModRevision: revision},
+				})
+				attachments = append(attachments, attachment{op.Delete, result.Lease, 0, result, nil})
+			}
+		default:
+			err = status.Errorf(codes.InvalidArgument, "Transaction operation is empty.")
+		}
+		if err != nil {
+			return &pb.TxnResponse{}, err
+		}
+		response.Results = append(response.Results, &pb.TxnOpResult{Record: result})
+	}
+	if len(events) > 0 {
+		if err := s.storage.Commit(events); err != nil {
+			return &pb.TxnResponse{}, storageError(events[0].Record.Name, err)
+		}
+		for _, a := range attachments {
+			s.attachLocked(a.name, a.from, a.to)
+			s.metrics.recordChanged(a.before, a.after)
+		}
+		for _, event := range events {
+			s.notifyLocked(ctx, event)
+		}
+	}
+	response.Revision = s.storage.Revision()
+	return response, nil
+}
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+func (s *Store) listRecords(ctx context.Context, request *pb.ListRecordsRequest) (*pb.ListRecordsResponse, error) {
+	s.log.debug(ctx, "List", "prefix", request.Prefix, "start", request.Start, "end", request.End)
+	start, end := request.Start, request.End
+	if request.Prefix != "" {
+		if start != "" || end != "" {
+			return &pb.ListRecordsResponse{},
+				status.Errorf(codes.InvalidArgument, "A prefix may not be combined with a range.")
+		}
+		start, end = request.Prefix, prefixEnd(request.Prefix)
+	}
+	if err := checkKeyName(start); err != nil {
+		return &pb.ListRecordsResponse{}, err
+	}
+	if request.PageToken != "" {
+		token, err := base64.URLEncoding.DecodeString(request.PageToken)
+		if err != nil || string(token) < start || (end != "" && string(token) >= end) {
+			return &pb.ListRecordsResponse{},
+				status.Errorf(codes.InvalidArgument,
+					"Invalid page token '%s'.", request.PageToken)
+		}
+		start = string(token)
+	}
+	pageSize := int(request.PageSize)
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	} else if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	if err := s.awaitConsistency(ctx, request.Consistency, request.MaxRevisionLag); err != nil {
+		return &pb.ListRecordsResponse{}, err
+	}
+	s.rlock(ctx)
+	defer s.mu.RUnlock()
+	_, span := s.startSpan(ctx, "storage.Range")
+	defer span.End()
+	response := &pb.ListRecordsResponse{Revision: s.storage.Revision()}
+	var err error
+	if request.CountOnly {
+		err = s.storage.Range(start, end, func(record *pb.Record) bool {
+			if !isReserved(record.Name) {
+				response.Count++
+			}
+			return true
+		})
+	} else {
+		err = s.storage.Range(start, end, func(record *pb.Record) bool {
+			if isReserved(record.Name) {
+				return true
+			}
+			if len(response.Records) == pageSize {
+				response.NextPageToken = base64.URLEncoding.EncodeToString([]byte(record.Name))
+				return false
+			}
+			if request.KeysOnly {
+				record = &pb.Record{Name: record.Name}
+			}
+			response.Records = append(response.Records, record)
+			return true
+		})
+	}
+	if err != nil {
+		return &pb.ListRecordsResponse{}, storageError(start, err)
+	}
+	return response, nil
+}
+
+// addWatcher registers a watcher for request and returns it along with a
+// function that unregisters it. If the request has a start revision, the
+// watcher's queue starts out with the events since then.
+func (s *Store) addWatcher(request *pb.WatchRecordRequest) (*watcher, func(), error) {
+	w := newWatcher(request.Name, s.watchQueueSize, s.slowConsumerPolicy)
+	w.dropped = s.metrics.eventsDropped
+	if request.Prefix || request.RangeEnd != "" {
+		w.isRange = true
+		w.end = request.RangeEnd
+		if request.Prefix {
+			w.end = prefixEnd(request.Name)
+		}
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.watchers[key].Remove(elem)
+	select {
+	case <-s.draining:
+		return nil, nil, status.Errorf(codes.Unavailable, "Server is shutting down.")
+	default:
+	}
+	if s.maxWatchers > 0 && s.watcherCount >= s.maxWatchers {
+		return nil, nil, status.Errorf(codes.ResourceExhausted,
+			"The server is at its limit of %d watchers.", s.maxWatchers)
+	}
+	if request.StartRevision != 0 {
+		events, err := s.history.since(request.StartRevision, w)
+		if err != nil {
+			return nil, nil, err
+		}
+		// The replay does not count against the queue limit.
+		w.limit += len(events)
+		for _, event := range events {
+			w.push(event)
+		}
+	}
+	s.watcherCount++
+	active := s.metrics.watchers.WithLabelValues(watchKind(request))
+	active.Inc()
+	if w.isRange {
+		elem := s.rangeWatchers.PushBack(w)
+		return w, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.watcherCount--
+			active.Dec()
+			s.rangeWatchers.Remove(elem)
+		}, nil
+	}
+	if _, exists := s.watchers[request.Name]; !exists {
+		s.watchers[request.Name] = list.New()
+	}
+	elem := s.watchers[request.Name].PushBack(w)
+	s.metrics.keyWatchersChanged(request.Name, s.watchers[request.Name].Len())
+	return w, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.watcherCount--
+		active.Dec()
+		s.watchers[request.Name].Remove(elem)
+		s.metrics.keyWatchersChanged(request.Name, s.watchers[request.Name].Len())
+		if s.watchers[request.Name].Len() == 0 {
+			delete(s.watchers, request.Name)
+		}
+	}, nil
 }
 
-func (s *kvStore) WatchRecord(request *pb.WatchRecordRequest,
-	stream pb.KeyValueStore_WatchRecordServer) error {
-	log.Printf("%s: Start Watch '%s'\n", peerString(stream.Context()), request.Name)
-	defer log.Printf("%s: End Watch '%s'\n", peerString(stream.Context()), request.Name)
-	c, elem := s.addWatcher(request.Name)
-	defer s.removeWatcher(request.Name, elem)
+// watchKind returns whether request watches a key, a prefix or a range.
+func watchKind(request *pb.WatchRecordRequest) string {
+	if request.Prefix {
+		return "prefix"
+	} else if request.RangeEnd != "" {
+		return "range"
+	}
+	return "key"
+}
+
+func describeWatch(request *pb.WatchRecordRequest) string {
+	if request.Prefix {
+		return fmt.Sprintf("prefix '%s'", request.Name)
+	} else if request.RangeEnd != "" {
+		return fmt.Sprintf("range ['%s', '%s')", request.Name, request.RangeEnd)
+	}
+	return fmt.Sprintf("'%s'", request.Name)
+}
+
+// startWatch registers a watch for request. It returns the watcher, the
+// revision after which it receives every matching event, and a function that
+// unregisters it.
+func (s *Store) startWatch(ctx context.Context, request *pb.WatchRecordRequest) (*watcher, int64, func(), error) {
+	if request.Prefix && request.RangeEnd != "" {
+		return nil, 0, nil, status.Errorf(codes.InvalidArgument, "A prefix watch may not have a range_end.")
+	}
+	if request.RangeEnd != "" && request.RangeEnd <= request.Name {
+		return nil, 0, nil, status.Errorf(codes.InvalidArgument,
+			"Watch range_end '%s' is not after its start '%s'.", request.RangeEnd, request.Name)
+	}
+	if err := checkKeyName(request.Name); err != nil {
+		return nil, 0, nil, err
+	}
+	s.log.debug(ctx, "Start watch", "watch", describeWatch(request))
+	w, remove, err := s.addWatcher(request)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	s.mu.RLock()
+	revision := s.storage.Revision()
+	s.mu.RUnlock()
+	return w, revision, func() {
+		remove()
+		s.log.debug(ctx, "End watch", "watch", describeWatch(request))
+	}, nil
+}
+
+// watch registers a watch for request, delivering its events on a channel
+// until ctx ends or the watch fails.
+func (s *Store) watch(ctx context.Context, request *pb.WatchRecordRequest) (*Watch, error) {
+	w, revision, remove, err := s.startWatch(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	watch := &Watch{
+		events:   make(chan *pb.Event),
+		revision: revision,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go func() {
+		defer close(watch.done)
+		defer close(watch.events)
+		defer remove()
+		watch.err = s.deliver(ctx, request, w, func(event *pb.Event) error {
+			select {
+			case watch.events <- event:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	return watch, nil
+}
+
+// deliver passes the events queued for w to send until ctx ends, w fails or
+// send fails.
+func (s *Store) deliver(ctx context.Context, request *pb.WatchRecordRequest, w *watcher, send func(*pb.Event) error) error {
 	for {
 		select {
-		case <-stream.Context().Done():
-			return stream.Context().Err()
-		case value := <-*c:
-			stream.Send(&pb.Record{Name: request.Name, Value: value})
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.ready:
+			// Writers notify watchers under the store lock, so holding it
+			// here means the batch ends with a complete revision.
+			spanCtx, span := s.startSpan(ctx, "Store.deliver")
+			s.rlock(spanCtx)
+			batch, err := w.drain()
+			revision := s.storage.Revision()
+			s.mu.RUnlock()
+			span.SetAttributes(attribute.Int("kvd.events", len(batch)))
+			for _, event := range batch {
+				if err := send(event); err != nil {
+					span.End()
+					return err
+				}
+				s.metrics.eventsSent.Inc()
+			}
+			span.End()
+			if err != nil {
+				return err
+			}
+			if request.ProgressNotify {
+				progress := &pb.Event{
+					Type:   pb.Event_PROGRESS,
+					Record: &pb.Record{Name: request.Name, ModRevision: revision},
+				}
+				if err := send(progress); err != nil {
+					return err
+				}
+			}
 		}
 	}
-	return nil
 }
 
-func NewServer(port int) (*grpc.Server, net.Listener) {
-	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+// watchRecord sends the events of a watch to stream as they leave the
+// watcher's queue.
+func (s *Store) watchRecord(request *pb.WatchRecordRequest, stream pb.KeyValueStore_WatchRecordServer) error {
+	w, revision, remove, err := s.startWatch(stream.Context(), request)
 	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
+		return err
+	}
+	defer remove()
+	// Let the client know the watch is registered before any events arrive.
+	if err := stream.SendHeader(metadata.Pairs("watch-revision", strconv.FormatInt(revision, 10))); err != nil {
+		return err
+	}
+	return s.deliver(stream.Context(), request, w, stream.Send)
+}
+
+const (
+	defaultWatchQueueSize = 1024
+	defaultHistorySize    = 10000
+)
+
+type serverOptions struct {
+	storage            Storage
+	dataDir            string
+	snapshotInterval   int
+	watchQueueSize     int
+	slowConsumerPolicy SlowConsumerPolicy
+	historySize        int
+	historyRetention   time.Duration
+	clock              Clock
+	nodeID             uint64
+	peers              map[uint64]string
+	httpPort           int
+	respPort           int
+	certFile           string
+	keyFile            string
+	caFile             string
+	allowedClients     []string
+	metricsPort        int
+	authEnabled        bool
+	rootPassword       string
+	logOutput          io.Writer
+	logLevel           Level
+	redaction          Redaction
+	redactedPrefixes   []string
+	logSampling        int
+	tracerProvider     trace.TracerProvider
+	listener           net.Listener
+	network            string
+	address            string
+	grpcOptions        []grpc.ServerOption
+	unaryInterceptors  []grpc.UnaryServerInterceptor
+	streamInterceptors []grpc.StreamServerInterceptor
+	maxRecordSize      int
+	maxWatchers        int
+	keyWatcherMetrics  bool
+}
+
+type ServerOption func(*serverOptions)
+
+// WithStorage serves records from storage. It takes precedence over
+// WithDataDir, and may not be combined with WithCluster.
+func WithStorage(storage Storage) ServerOption {
+	return func(o *serverOptions) {
+		o.storage = storage
+	}
+}
+
+// WithDataDir persists the store in dir using the on-disk storage engine,
+// recovering any existing contents on startup. Without it the store is kept
+// only in memory. In a cluster, dir holds the node's Raft log instead.
+func WithDataDir(dir string) ServerOption {
+	return func(o *serverOptions) {
+		o.dataDir = dir
+	}
+}
+
+// WithSnapshotInterval sets the number of log entries written between
+// snapshots. Zero disables snapshots.
+func WithSnapshotInterval(entries int) ServerOption {
+	return func(o *serverOptions) {
+		o.snapshotInterval = entries
+	}
+}
+
+// WithWatchQueueSize sets the number of undelivered events buffered for each
+// watcher before its slow consumer policy applies. It must be positive.
+func WithWatchQueueSize(events int) ServerOption {
+	return func(o *serverOptions) {
+		o.watchQueueSize = events
+	}
+}
+
+// WithSlowConsumerPolicy sets how watchers whose queues fill up are handled.
+// The default is DisconnectSlowConsumers.
+func WithSlowConsumerPolicy(policy SlowConsumerPolicy) ServerOption {
+	return func(o *serverOptions) {
+		o.slowConsumerPolicy = policy
+	}
+}
+
+// WithHistory bounds the events retained for watches that resume from a past
+// revision. Events beyond the latest size of them, or older than retention,
+// are dropped. A zero retention keeps events regardless of age.
+func WithHistory(size int, retention time.Duration) ServerOption {
+	return func(o *serverOptions) {
+		o.historySize = size
+		o.historyRetention = retention
+	}
+}
+
+// WithClock measures lease lifetimes with clock instead of the system clock.
+func WithClock(clock Clock) ServerOption {
+	return func(o *serverOptions) {
+		o.clock = clock
+	}
+}
+
+// WithCluster makes the server node nodeID of a Raft cluster. Peers maps the
+// ID of every node, including this one, to the address its Raft transport
+// listens on, which must differ from the address clients connect to.
+// Records are kept in memory and the Raft log in the data directory, if one
+// is set.
+func WithCluster(nodeID uint64, peers map[uint64]string) ServerOption {
+	return func(o *serverOptions) {
+		o.nodeID = nodeID
+		o.peers = peers
+	}
+}
+
+// WithHTTPPort also serves the store as JSON over HTTP on port. See gateway
+// for the routes.
+func WithHTTPPort(port int) ServerOption {
+	return func(o *serverOptions) {
+		o.httpPort = port
+	}
+}
+
+// WithRESPPort also serves the store to Redis clients on port. See respServer
+// for the commands supported.
+func WithRESPPort(port int) ServerOption {
+	return func(o *serverOptions) {
+		o.respPort = port
+	}
+}
+
+// WithMetricsPort serves Prometheus metrics at /metrics on port.
+func WithMetricsPort(port int) ServerOption {
+	return func(o *serverOptions) {
+		o.metricsPort = port
+	}
+}
+
+// WithTLS serves every protocol over TLS with the certificate and key in
+// certFile and keyFile. If caFile is not empty, clients must present a
+// certificate signed by one of the CAs in it, which must also have signed
+// the certificates of the other nodes of a cluster. The files are reloaded
+// when they change.
+func WithTLS(certFile string, keyFile string, caFile string) ServerOption {
+	return func(o *serverOptions) {
+		o.certFile = certFile
+		o.keyFile = keyFile
+		o.caFile = caFile
+	}
+}
+
+// WithAllowedClients accepts only client certificates whose subject has one
+// of subjects as its common name or as its whole distinguished name, such as
+// "CN=alice,O=Example". In a cluster, the subjects of the other nodes must
+// be included. It requires WithTLS with a CA file.
+func WithAllowedClients(subjects ...string) ServerOption {
+	return func(o *serverOptions) {
+		o.allowedClients = subjects
+	}
+}
+
+// WithAuth requires clients to authenticate, and checks their requests
+// against the permissions of their roles. The built-in user root has every
+// permission and authenticates with rootPassword or a client certificate
+// whose common name is "root". Manage other users and roles with the Auth
+// service. In a cluster, it requires WithAllowedClients, so that only the
+// other nodes may send Raft messages.
+func WithAuth(rootPassword string) ServerOption {
+	return func(o *serverOptions) {
+		o.authEnabled = true
+		o.rootPassword = rootPassword
+	}
+}
+
+// WithLogOutput writes the server's logs to out instead of standard error.
+func WithLogOutput(out io.Writer) ServerOption {
+	return func(o *serverOptions) {
+		o.logOutput = out
+	}
+}
+
+// WithLogLevel logs only entries of at least level. The default is
+// LevelInfo, which logs every request; LevelDebug adds the keys and values
+// each request touches.
+func WithLogLevel(level Level) ServerOption {
+	return func(o *serverOptions) {
+		o.logLevel = level
+	}
+}
+
+// WithValueRedaction leaves values out of the logs as redaction decides,
+// with prefixes naming the records whose values RedactPrefixes leaves out.
+func WithValueRedaction(redaction Redaction, prefixes ...string) ServerOption {
+	return func(o *serverOptions) {
+		o.redaction = redaction
+		o.redactedPrefixes = prefixes
+	}
+}
+
+// WithLogSampling logs only one in every n successful reads, which are
+// usually most of the traffic. Errors and writes are always logged.
+func WithLogSampling(n int) ServerOption {
+	return func(o *serverOptions) {
+		o.logSampling = n
+	}
+}
+
+// WithTracerProvider traces requests with provider's tracer, instead of
+// the global provider's. Spans within a request cover waiting for the store
+// lock, reading storage, applying writes, notifying watchers and delivering
+// their events.
+func WithTracerProvider(provider trace.TracerProvider) ServerOption {
+	return func(o *serverOptions) {
+		o.tracerProvider = provider
+	}
+}
+
+// WithListener serves gRPC on lis, which the server closes when it stops.
+// It takes precedence over WithAddress.
+func WithListener(lis net.Listener) ServerOption {
+	return func(o *serverOptions) {
+		o.listener = lis
+	}
+}
+
+// WithAddress serves gRPC on address in network, which is "tcp" or "unix".
+// Port 0 picks a free port; Server.Addr reports which.
+func WithAddress(network string, address string) ServerOption {
+	return func(o *serverOptions) {
+		o.network = network
+		o.address = address
+	}
+}
+
+// WithGRPCOptions creates the gRPC server with opts, such as message size
+// or concurrent stream limits. Use WithUnaryInterceptors and
+// WithStreamInterceptors rather than grpc.UnaryInterceptor and
+// grpc.StreamInterceptor, which the server sets itself.
+func WithGRPCOptions(opts ...grpc.ServerOption) ServerOption {
+	return func(o *serverOptions) {
+		o.grpcOptions = append(o.grpcOptions, opts...)
+	}
+}
+
+// WithUnaryInterceptors runs interceptors, in order, on each unary request
+// after the server's own, so that they see the request's ID, span and
+// authenticated user.
+func WithUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) ServerOption {
+	return func(o *serverOptions) {
+		o.unaryInterceptors = append(o.unaryInterceptors, interceptors...)
+	}
+}
+
+// WithStreamInterceptors runs interceptors, in order, on each streaming
+// request after the server's own.
+func WithStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) ServerOption {
+	return func(o *serverOptions) {
+		o.streamInterceptors = append(o.streamInterceptors, interceptors...)
+	}
+}
+
+// WithMaxRecordSize rejects writes of records whose name and value together
+// are longer than bytes. Zero means no limit.
+func WithMaxRecordSize(bytes int) ServerOption {
+	return func(o *serverOptions) {
+		o.maxRecordSize = bytes
+	}
+}
+
+// WithMaxWatchers rejects watches beyond n at a time with
+// codes.ResourceExhausted. Zero means no limit.
+func WithMaxWatchers(n int) ServerOption {
+	return func(o *serverOptions) {
+		o.maxWatchers = n
+	}
+}
+
+// WithKeyWatcherMetrics adds the metric kvd_key_watchers, the number of
+// watchers of each key, labelled by the key. It is off by default, since the
+// metric grows with the number of keys being watched and shows their names to
+// whoever scrapes the metrics.
+func WithKeyWatcherMetrics() ServerOption {
+	return func(o *serverOptions) {
+		o.keyWatcherMetrics = true
+	}
+}
+
+func defaultServerOptions() serverOptions {
+	return serverOptions{
+		snapshotInterval: 10000,
+		watchQueueSize:   defaultWatchQueueSize,
+		historySize:      defaultHistorySize,
+		clock:            realClock{},
+		logOutput:        os.Stderr,
+		logLevel:         LevelInfo,
+		logSampling:      1,
+		network:          "tcp",
+		address:          ":0",
+	}
+}
+
+func newLoggerWithOptions(options *serverOptions) *logger {
+	return &logger{
+		level:     options.logLevel,
+		redaction: options.redaction,
+		prefixes:  options.redactedPrefixes,
+		sampling:  int64(options.logSampling),
+		out:       options.logOutput,
+	}
+}
+
+// openStore opens the store that options describe, without starting its
+// background work. If it fails, it closes the storage it opened, but not
+// storage given with WithStorage.
+func openStore(options *serverOptions, serverLog *logger) (*Store, error) {
+	if options.watchQueueSize <= 0 {
+		return nil, fmt.Errorf("watch queue size must be positive, not %d", options.watchQueueSize)
+	}
+	storage := options.storage
+	if options.peers != nil {
+		if storage != nil {
+			return nil, fmt.Errorf("a cluster keeps its records in memory, not in the given storage")
+		}
+		storage = NewMemoryStorage()
+	}
+	if storage == nil && options.dataDir != "" {
+		var err error
+		if storage, err = OpenDiskStorage(options.dataDir, options.snapshotInterval); err != nil {
+			return nil, fmt.Errorf("failed to recover from '%s': %v", options.dataDir, err)
+		}
+	}
+	if storage == nil {
+		storage = NewMemoryStorage()
+	}
+	store := newStore(storage)
+	store.log = serverLog
+	if disk, ok := storage.(*diskStorage); ok {
+		disk.log = serverLog
+		disk.snapshotFailures = store.metrics.snapshotFailures
+	}
+	if options.tracerProvider != nil {
+		store.tracer = options.tracerProvider.Tracer(tracerName)
+	}
+	store.watchQueueSize = options.watchQueueSize
+	store.slowConsumerPolicy = options.slowConsumerPolicy
+	store.history = newHistory(options.historySize, options.historyRetention, storage.Revision())
+	store.clock = options.clock
+	store.maxRecordSize = options.maxRecordSize
+	store.maxWatchers = options.maxWatchers
+	if options.keyWatcherMetrics {
+		store.metrics.enableKeyWatchers()
+	}
+	if err := store.recoverLeases(); err != nil {
+		if storage != options.storage {
+			storage.Close()
+		}
+		return nil, fmt.Errorf("failed to recover leases: %v", err)
+	}
+	if err := store.metrics.countRecords(storage); err != nil {
+		if storage != options.storage {
+			storage.Close()
+		}
+		return nil, fmt.Errorf("failed to count records: %v", err)
+	}
+	return store, nil
+}
+
+// Server is a key-value store server.
+type Server struct {
+	grpcServer *grpc.Server
+	lis        net.Listener
+	raftLis    net.Listener // nil unless the server belongs to a cluster
+	store      *Store
+	closers    []func() // The Raft transport and the HTTP, metrics and RESP front-ends.
+	closeOnce  sync.Once
+	closeErr   error
+}
+
+// NewServer returns a gRPC server for the key-value store and a listener on
+// port for it to serve. It exits if the server cannot start.
+//
+// Deprecated: Use New. Stopping the gRPC server NewServer returns leaves the
+// store open, along with its data directory and the HTTP and RESP front-ends,
+// while Server.Stop closes them all.
+func NewServer(port int, opts ...ServerOption) (*grpc.Server, net.Listener) {
+	opts = append([]ServerOption{WithAddress("tcp", fmt.Sprintf(":%d", port))}, opts...)
+	s, err := New(opts...)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	return s.grpcServer, s.lis
+}
+
+// New returns a server for the key-value store, listening but not yet
+// serving. Without WithListener or WithAddress it listens on a free TCP
+// port. The server owns the storage given with WithStorage, closing it when
+// it stops. If New fails, it releases what it started, but not the listener
+// or storage it was given.
+func New(opts ...ServerOption) (s *Server, err error) {
+	options := defaultServerOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	// What has been started, to stop in reverse if a later step fails.
+	var cleanup []func()
+	defer func() {
+		if err != nil {
+			for i := len(cleanup) - 1; i >= 0; i-- {
+				cleanup[i]()
+			}
+		}
+	}()
+	serverLog := newLoggerWithOptions(&options)
+	lis := options.listener
+	if lis == nil {
+		if lis, err = net.Listen(options.network, options.address); err != nil {
+			return nil, fmt.Errorf("failed to listen: %v", err)
+		}
+		cleanup = append(cleanup, func() { lis.Close() })
+	}
+	store, err := openStore(&options, serverLog)
+	if err != nil {
+		return nil, err
+	}
+	cleanup = append(cleanup, func() {
+		close(store.done)
+		if store.raft != nil {
+			store.raft.stop()
+		}
+		if store.storage != options.storage {
+			store.storage.Close()
+		}
+	})
+	tracing := &tracing{tracer: store.tracer}
+	var certs *certReloader
+	if options.certFile != "" {
+		if certs, err = newCertReloader(options.certFile, options.keyFile, options.caFile, options.allowedClients, serverLog); err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificates: %v", err)
+		}
+	}
+	if len(options.allowedClients) > 0 && (certs == nil || options.caFile == "") {
+		return nil, fmt.Errorf("allowed clients require TLS with a CA file")
+	}
+	var raftLis net.Listener
+	if options.peers != nil {
+		addr, exists := options.peers[options.nodeID]
+		if !exists {
+			return nil, fmt.Errorf("node ID %d is not among the peers", options.nodeID)
+		}
+		// Raft messages change the store, users and roles included, so with
+		// authentication only allowed peers may send them.
+		if options.authEnabled && len(options.allowedClients) == 0 {
+			return nil, fmt.Errorf("authentication in a cluster requires allowed clients, to restrict the Raft transport to peers")
+		}
+		if raftLis, err = net.Listen("tcp", addr); err != nil {
+			return nil, fmt.Errorf("failed to listen for Raft: %v", err)
+		}
+		cleanup = append(cleanup, func() { raftLis.Close() })
+	}
+	auth := &authorizer{store: store, enabled: options.authEnabled, rootPassword: options.rootPassword}
+	guarded := &guardedStore{store: store, auth: auth}
+	unaryInterceptors := append([]grpc.UnaryServerInterceptor{
+		store.metrics.unaryInterceptor, tracing.unaryInterceptor, serverLog.unaryInterceptor, auth.unaryInterceptor,
+	}, options.unaryInterceptors...)
+	streamInterceptors := append([]grpc.StreamServerInterceptor{
+		store.metrics.streamInterceptor, tracing.streamInterceptor, serverLog.streamInterceptor, store.drainInterceptor, auth.streamInterceptor,
+	}, options.streamInterceptors...)
+	serverOpts := append([]grpc.ServerOption{
+		grpc.UnaryInterceptor(chainUnaryInterceptors(unaryInterceptors...)),
+		grpc.StreamInterceptor(chainStreamInterceptors(streamInterceptors...)),
+	}, options.grpcOptions...)
+	var raftOpts []grpc.ServerOption
+	peerCreds := grpc.WithInsecure()
+	if certs != nil {
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(certs.serverConfig("h2"))))
+		raftOpts = append(raftOpts, grpc.Creds(credentials.NewTLS(certs.serverConfig("h2"))))
+		peerCreds = grpc.WithTransportCredentials(credentials.NewTLS(certs.clientConfig()))
+	}
+	grpcServer := grpc.NewServer(serverOpts...)
+	pb.RegisterKeyValueStoreServer(grpcServer, &storeServer{store: store})
+	pb.RegisterAuthServer(grpcServer, &authServer{auth: auth})
+	healthpb.RegisterHealthServer(grpcServer, store.health)
+	if options.peers != nil {
+		if store.raft, err = startRaftNode(options.nodeID, options.peers, options.dataDir, options.snapshotInterval, peerCreds, store); err != nil {
+			return nil, fmt.Errorf("failed to start raft node %d: %v", options.nodeID, err)
+		}
 	}
-	grpcServer := grpc.NewServer()
-	store := newKeyValueStore()
-	pb.RegisterKeyValueStoreServer(grpcServer, store)
 	reflection.Register(grpcServer)
-	return grpcServer, lis
+	var closers []func()
+	if store.raft != nil {
+		raftServer := grpc.NewServer(raftOpts...)
+		pb.RegisterRaftServer(raftServer, store.raft)
+		go raftServer.Serve(raftLis)
+		closers = append(closers, raftServer.Stop)
+		cleanup = append(cleanup, raftServer.Stop)
+	}
+	if options.httpPort != 0 {
+		httpLis, err := net.Listen("tcp", fmt.Sprintf(":%d", options.httpPort))
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen for HTTP: %v", err)
+		}
+		if certs != nil {
+			httpLis = tls.NewListener(httpLis, certs.serverConfig("http/1.1"))
+		}
+		httpServer := &http.Server{Handler: newGateway(guarded)}
+		go httpServer.Serve(httpLis)
+		closers = append(closers, func() { httpServer.Close() })
+		cleanup = append(cleanup, func() { httpServer.Close() })
+	}
+	if options.metricsPort != 0 {
+		metricsLis, err := net.Listen("tcp", fmt.Sprintf(":%d", options.metricsPort))
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen for metrics: %v", err)
+		}
+		if certs != nil {
+			metricsLis = tls.NewListener(metricsLis, certs.serverConfig("http/1.1"))
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(store.metrics.registry, promhttp.HandlerOpts{}))
+		metricsServer := &http.Server{Handler: mux}
+		go metricsServer.Serve(metricsLis)
+		closers = append(closers, func() { metricsServer.Close() })
+		cleanup = append(cleanup, func() { metricsServer.Close() })
+	}
+	if options.respPort != 0 {
+		respLis, err := net.Listen("tcp", fmt.Sprintf(":%d", options.respPort))
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen for RESP: %v", err)
+		}
+		if certs != nil {
+			respLis = tls.NewListener(respLis, certs.serverConfig())
+		}
+		respServer := newRESPServer(guarded, respLis)
+		go respServer.serve()
+		closers = append(closers, respServer.close)
+	}
+	go store.expireLeases()
+	store.reportHealth()
+	return &Server{
+		grpcServer: grpcServer,
+		lis:        lis,
+		raftLis:    raftLis,
+		store:      store,
+		closers:    closers,
+	}, nil
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() net.Addr {
+	return s.lis.Addr()
+}
+
+// RaftAddr returns the address the server listens on for the other nodes of
+// its cluster, or nil if it does not belong to one.
+func (s *Server) RaftAddr() net.Addr {
+	if s.raftLis == nil {
+		return nil
+	}
+	return s.raftLis.Addr()
+}
+
+// Store returns the store the server serves, for use within the process.
+// Stopping the server closes it.
+func (s *Server) Store() *Store {
+	return s.store
+}
+
+// GRPCServer returns the underlying gRPC server, with which to register
+// further services before serving.
+func (s *Server) GRPCServer() *grpc.Server {
+	return s.grpcServer
+}
+
+// Serve serves gRPC requests until the server stops. If the server belongs
+// to a cluster and its Raft node fails, the server drains and stops, and
+// Serve returns the node's error. The server must still be closed.
+func (s *Server) Serve() error {
+	if s.store.raft != nil {
+		go func() {
+			select {
+			case <-s.store.raft.failed:
+				s.Drain()
+				s.grpcServer.Stop()
+			case <-s.store.done:
+			}
+		}()
+	}
+	err := s.grpcServer.Serve(s.lis)
+	if s.store.raft != nil {
+		if failure := s.store.raft.failure(); failure != nil {
+			return failure
+		}
+	}
+	return err
+}
+
+// Stop stops the server at once, ending every RPC, and closes it.
+func (s *Server) Stop() {
+	s.grpcServer.Stop()
+	s.lis.Close()
+	s.Close()
+}
+
+// Drain prepares the server to shut down while it goes on serving. The health
+// service reports NOT_SERVING from then on, and watches end with an
+// Unavailable status once they have sent the events already queued for them,
+// so that clients can move to another server. New watches are refused.
+func (s *Server) Drain() {
+	s.store.drain()
+}
+
+// Shutdown stops the server gracefully and closes it. The server drains
+// first, and then RPCs still in flight have until timeout to finish before
+// the server stops forcibly.
+func (s *Server) Shutdown(timeout time.Duration) {
+	s.Drain()
+	gracefulStop(s.grpcServer, timeout, s.store.log)
+	s.lis.Close()
+	s.Close()
+}
+
+// Close shuts down the Raft transport, the HTTP, RESP and metrics front-ends
+// and then the store, ending every watch and closing its storage. Stop and
+// Shutdown close the server once gRPC requests have stopped; call Close
+// directly only if the server never served. Closing more than once does
+// nothing more.
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		for _, closer := range s.closers {
+			closer()
+		}
+		if s.closeErr = s.store.close(); s.closeErr != nil {
+			s.store.log.error(context.Background(), "Failed to close storage", "error", s.closeErr)
+		}
+		if s.closeErr == nil && s.store.raft != nil {
+			s.closeErr = s.store.raft.failure()
+		}
+	})
+	return s.closeErr
 }
@@ -1,19 +1,166 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"github.com/gnossen/kvd/server"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
 var (
-	port = flag.Int("port", 50051, "The server port")
+	port             = flag.Int("port", 50051, "The server port")
+	unixSocket       = flag.String("unix_socket", "", "If set, serve gRPC on this Unix socket instead of -port.")
+	dataDir          = flag.String("data_dir", "", "The directory in which to persist records. Records are kept only in memory if empty.")
+	snapshotInterval = flag.Int("snapshot_interval", 10000, "The number of log entries between snapshots.")
+	watchQueueSize   = flag.Int("watch_queue_size", 1024, "The number of undelivered events buffered for each watcher.")
+	slowConsumers    = flag.String("slow_consumer_policy", "disconnect", "What to do when a watcher's queue is full: disconnect, resync or coalesce.")
+	historySize      = flag.Int("history_size", 10000, "The number of past events retained for resuming watches.")
+	historyRetention = flag.Duration("history_retention", 0, "How long past events are retained for resuming watches. Zero means no limit.")
+	httpPort         = flag.Int("http_port", 0, "The port on which to serve records as JSON over HTTP. Disabled if zero.")
+	respPort         = flag.Int("resp_port", 0, "The port on which to serve records to Redis clients. Disabled if zero.")
+	metricsPort      = flag.Int("metrics_port", 0, "The port on which to serve Prometheus metrics at /metrics. Disabled if zero.")
+	tlsCertFile      = flag.String("tls_cert_file", "", "The server's TLS certificate. Connections are unencrypted if empty.")
+	tlsKeyFile       = flag.String("tls_key_file", "", "The private key of -tls_cert_file.")
+	tlsCAFile        = flag.String("tls_ca_file", "", "If set, clients must present a certificate signed by one of these CAs.")
+	allowedClients   = flag.String("tls_allowed_subjects", "", "If set, accept only client certificates with these comma-separated subjects or common names.")
+	rootPasswordFile = flag.String("root_password_file", "", "If set, require clients to authenticate, with the password of the root user read from this file.")
+	nodeID           = flag.Uint64("node_id", 0, "This server's ID among -peers.")
+	peers            = flag.String("peers", "", "Run as part of a Raft cluster whose nodes, including this one, exchange Raft messages at these comma-separated id=host:port addresses, apart from -port.")
+	logLevel         = flag.String("log_level", "info", "The least severe log entries written: debug, info, warn or error.")
+	redactValues     = flag.String("log_redact_values", "never", "Which values to leave out of the logs: never, prefix or always.")
+	redactedPrefixes = flag.String("log_redacted_prefixes", "", "With -log_redact_values=prefix, the comma-separated prefixes of the records whose values are left out.")
+	logSampling      = flag.Int("log_sampling", 1, "Log only one in this many successful reads.")
+	otlpEndpoint     = flag.String("otlp_endpoint", "", "If set, export traces over OTLP/gRPC to this host:port.")
+	otlpInsecure     = flag.Bool("otlp_insecure", false, "Export traces to -otlp_endpoint without TLS.")
+	traceFile        = flag.String("trace_file", "", "If set, write traces to this file as JSON.")
+	drainTimeout     = flag.Duration("drain_timeout", 10*time.Second, "How long in-flight requests have to finish after SIGINT or SIGTERM before the server stops.")
 )
 
+// newTracerProvider returns a tracer provider exporting to -otlp_endpoint
+// and -trace_file.
+func newTracerProvider() (*sdktrace.TracerProvider, error) {
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String("kvd"))),
+	}
+	if *otlpEndpoint != "" {
+		exporterOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(*otlpEndpoint)}
+		if *otlpInsecure {
+			exporterOpts = append(exporterOpts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err := otlptracegrpc.New(context.Background(), exporterOpts...)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+	if *traceFile != "" {
+		file, err := os.Create(*traceFile)
+		if err != nil {
+			return nil, err
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+	return sdktrace.NewTracerProvider(opts...), nil
+}
 
 func main() {
 	flag.Parse()
-	server, lis := server.NewServer(*port)
-	defer server.Stop()
-	defer lis.Close()
-	server.Serve(lis)
+	policy, err := server.ParseSlowConsumerPolicy(*slowConsumers)
+	if err != nil {
+		log.Fatalf("invalid -slow_consumer_policy: %v", err)
+	}
+	level, err := server.ParseLevel(*logLevel)
+	if err != nil {
+		log.Fatalf("invalid -log_level: %v", err)
+	}
+	redaction, err := server.ParseRedaction(*redactValues)
+	if err != nil {
+		log.Fatalf("invalid -log_redact_values: %v", err)
+	}
+	opts := []server.ServerOption{
+		server.WithDataDir(*dataDir),
+		server.WithSnapshotInterval(*snapshotInterval),
+		server.WithWatchQueueSize(*watchQueueSize),
+		server.WithSlowConsumerPolicy(policy),
+		server.WithHistory(*historySize, *historyRetention),
+		server.WithHTTPPort(*httpPort),
+		server.WithRESPPort(*respPort),
+		server.WithMetricsPort(*metricsPort),
+		server.WithLogLevel(level),
+		server.WithLogSampling(*logSampling),
+	}
+	if redaction == server.RedactPrefixes {
+		opts = append(opts, server.WithValueRedaction(redaction, strings.Split(*redactedPrefixes, ",")...))
+	} else {
+		opts = append(opts, server.WithValueRedaction(redaction))
+	}
+	if *otlpEndpoint != "" || *traceFile != "" {
+		provider, err := newTracerProvider()
+		if err != nil {
+			log.Fatalf("failed to set up tracing: %v", err)
+		}
+		// Flush the spans still buffered on shutdown.
+		defer provider.Shutdown(context.Background())
+		opts = append(opts, server.WithTracerProvider(provider))
+	}
+	if *tlsCertFile != "" {
+		opts = append(opts, server.WithTLS(*tlsCertFile, *tlsKeyFile, *tlsCAFile))
+	}
+	if *allowedClients != "" {
+		opts = append(opts, server.WithAllowedClients(strings.Split(*allowedClients, ",")...))
+	}
+	if *rootPasswordFile != "" {
+		password, err := ioutil.ReadFile(*rootPasswordFile)
+		if err != nil {
+			log.Fatalf("invalid -root_password_file: %v", err)
+		}
+		opts = append(opts, server.WithAuth(strings.TrimSpace(string(password))))
+	}
+	if *peers != "" {
+		cluster, err := server.ParsePeers(*peers)
+		if err != nil {
+			log.Fatalf("invalid -peers: %v", err)
+		}
+		opts = append(opts, server.WithCluster(*nodeID, cluster))
+	}
+	if *unixSocket != "" {
+		opts = append(opts, server.WithAddress("unix", *unixSocket))
+	} else {
+		opts = append(opts, server.WithAddress("tcp", fmt.Sprintf(":%d", *port)))
+	}
+	kvServer, err := server.New(opts...)
+	if err != nil {
+		log.Fatalf("failed to start server: %v", err)
+	}
+	log.Printf("Serving on %v\n", kvServer.Addr())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		sig := <-signals
+		log.Printf("Received %v, shutting down\n", sig)
+		kvServer.Shutdown(*drainTimeout)
+		close(stopped)
+	}()
+	if err := kvServer.Serve(); err != nil {
+		kvServer.Close()
+		log.Fatalf("failed to serve: %v", err)
+	}
+	<-stopped
 }
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"sort"
+
+	pb "github.com/gnossen/kvd/kvd"
+)
+
+// Storage is the engine underneath the key-value service. The service
+// serializes mutations, so implementations need only tolerate concurrent
+// reads. Records are never modified once stored.
+type Storage interface {
+	// Get returns the record at name and whether it exists.
+	Get(name string) (*pb.Record, bool, error)
+
+	// PutIfAbsent stores record unless its name already exists. It reports
+	// whether the record was stored.
+	PutIfAbsent(record *pb.Record) (bool, error)
+
+	// PutIfPresent replaces the record with the same name if it exists. It
+	// reports whether the record was stored.
+	PutIfPresent(record *pb.Record) (bool, error)
+
+	// Delete removes name as of the store revision revision, returning its
+	// last record and whether it existed.
+	Delete(name string, revision int64) (*pb.Record, bool, error)
+
+	// Commit atomically applies a batch of events. A PUT stores its record
+	// and a DELETE removes the record named by its own, as of its
+	// mod_revision.
+	Commit(events []*pb.Event) error
+
+	// Range calls f in lexicographic order for each record with
+	// start <= name < end. An empty end means no upper bound. Iteration
+	// stops early if f returns false.
+	Range(start string, end string, f func(record *pb.Record) bool) error
+
+	// Snapshot calls f for every record, in no particular order, until f
+	// returns false.
+	Snapshot(f func(record *pb.Record) bool) error
+
+	// Revision returns the store revision of the latest mutation.
+	Revision() int64
+
+	Close() error
+}
+
+// prefixEnd returns the smallest name greater than every name beginning with
+// prefix, or "" if there is none.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
+type memoryStorage struct {
+	m        map[string]*pb.Record
+	names    []string // Sorted keys of m.
+	revision int64
+}
+
+// NewMemoryStorage returns a Storage that keeps records only in memory.
+func NewMemoryStorage() Storage {
+	return newMemoryStorage()
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{m: make(map[string]*pb.Record)}
+}
+
+func (s *memoryStorage) observe(revision int64) {
+	if revision > s.revision {
+		s.revision = revision
+	}
+}
+
+func (s *memoryStorage) put(record *pb.Record) {
+	if _, exists := s.m[record.Name]; !exists {
+		i := sort.SearchStrings(s.names, record.Name)
+		s.names = append(s.names, "")
+		copy(s.names[i+1:], s.names[i:])
+		s.names[i] = record.Name
+	}
+	s.m[record.Name] = record
+	s.observe(record.ModRevision)
+}
+
+func (s *memoryStorage) remove(name string, revision int64) {
+	s.observe(revision)
+	if _, exists := s.m[name]; !exists {
+		return
+	}
+	i := sort.SearchStrings(s.names, name)
+	s.names = append(s.names[:i], s.names[i+1:]...)
+	delete(s.m, name)
+}
+
+func applyEvent(mem *memoryStorage, event *pb.Event) {
+	switch event.Type {
+	case pb.Event_PUT:
+		mem.put(event.Record)
+	case pb.Event_DELETE:
+		mem.remove(event.Record.Name, event.Record.ModRevision)
+	}
+}
+
+func (s *memoryStorage) Get(name string) (*pb.Record, bool, error) {
+	record, exists := s.m[name]
+	return record, exists, nil
+}
+
+func (s *memoryStorage) PutIfAbsent(record *pb.Record) (bool, error) {
+	if _, exists := s.m[record.Name]; exists {
+		return false, nil
+	}
+	s.put(record)
+	return true, nil
+}
+
+func (s *memoryStorage) PutIfPresent(record *pb.Record) (bool, error) {
+	if _, exists := s.m[record.Name]; !exists {
+		return false, nil
+	}
+	s.put(record)
+	return true, nil
+}
+
+func (s *memoryStorage) Delete(name string, revision int64) (*pb.Record, bool, error) {
+	record, exists := s.m[name]
+	if exists {
+		s.remove(name, revision)
+	}
+	return record, exists, nil
+}
+
+func (s *memoryStorage) Commit(events []*pb.Event) error {
+	for _, event := range events {
+		applyEvent(s, event)
+	}
+	return nil
+}
+
+func (s *memoryStorage) Range(start string, end string, f func(record *pb.Record) bool) error {
+	for i := sort.SearchStrings(s.names, start); i < len(s.names); i++ {
+		name := s.names[i]
+		if end != "" && name >= end {
+			break
+		}
+		if !f(s.m[name]) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *memoryStorage) Snapshot(f func(record *pb.Record) bool) error {
+	for _, record := range s.m {
+		if !f(record) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *memoryStorage) Revision() int64 {
+	return s.revision
+}
+
+func (s *memoryStorage) Close() error {
+	return nil
+}
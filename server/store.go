@@ -0,0 +1,287 @@
+package server
+
+import (
+	list "container/list"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/gnossen/kvd/kvd"
+)
+
+// Store is the key-value store, for use within a process without gRPC. It
+// has the semantics of the KeyValueStore service, which serves a Store's
+// records in the same way, and fails with the same status errors; compare
+// them with status.Code. Requests are not checked against WithAuth's
+// permissions.
+type Store struct {
+	storage            Storage
+	mu                 timedRWMutex
+	watchers           map[string]*list.List // List[*watcher]
+	rangeWatchers      *list.List            // List[*watcher]
+	watchQueueSize     int
+	slowConsumerPolicy SlowConsumerPolicy
+	history            *history
+	raft               *raftNode // nil unless the store belongs to a cluster
+	clock              Clock
+	leases             map[int64]*lease
+	nextLeaseID        int64
+	done               chan struct{}
+	metrics            *metrics
+	log                *logger
+	tracer             trace.Tracer
+	health             *health.Server
+	draining           chan struct{} // Closed by drain.
+	drainOnce          sync.Once
+	maxRecordSize      int // Zero means no limit.
+	maxWatchers        int // Zero means no limit.
+	watcherCount       int
+}
+
+func newStore(storage Storage) *Store {
+	var store Store
+	store.storage = storage
+	store.watchers = make(map[string]*list.List)
+	store.rangeWatchers = list.New()
+	store.watchQueueSize = defaultWatchQueueSize
+	store.history = newHistory(defaultHistorySize, 0, storage.Revision())
+	store.clock = realClock{}
+	store.leases = make(map[int64]*lease)
+	store.nextLeaseID = 1
+	store.done = make(chan struct{})
+	store.log = newLogger()
+	store.tracer = otel.GetTracerProvider().Tracer(tracerName)
+	store.health = health.NewServer()
+	store.draining = make(chan struct{})
+	store.metrics = newMetrics()
+	store.mu.readWait = store.metrics.lockWait.WithLabelValues("read")
+	store.mu.writeWait = store.metrics.lockWait.WithLabelValues("write")
+	return &store
+}
+
+// close stops the store's background work and closes its storage.
+func (s *Store) close() error {
+	s.drain()
+	close(s.done)
+	if s.raft != nil {
+		s.raft.stop()
+	}
+	return s.storage.Close()
+}
+
+// NewStore opens a store with opts. Options for serving over the network,
+// such as ports and TLS, are ignored, and a store may not belong to a
+// cluster. The store owns the storage given with WithStorage, closing it
+// when the store is closed.
+func NewStore(opts ...ServerOption) (*Store, error) {
+	options := defaultServerOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.peers != nil {
+		return nil, fmt.Errorf("a clustered store must be served with New")
+	}
+	store, err := openStore(&options, newLoggerWithOptions(&options))
+	if err != nil {
+		return nil, err
+	}
+	go store.expireLeases()
+	return store, nil
+}
+
+// Close ends every watch and closes the store's storage.
+func (s *Store) Close() error {
+	return s.close()
+}
+
+// Get reads the record at name.
+func (s *Store) Get(ctx context.Context, name string) (*pb.Record, error) {
+	record, _, err := s.get(ctx, &pb.GetRecordRequest{Name: name})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Create creates a record, failing with codes.AlreadyExists if it exists.
+func (s *Store) Create(ctx context.Context, name string, value string) (*pb.Record, error) {
+	record, err := s.createRecord(ctx, &pb.CreateRecordRequest{Record: &pb.Record{Name: name, Value: value}})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Update replaces the value of a record, failing with codes.NotFound if it
+// does not exist.
+func (s *Store) Update(ctx context.Context, name string, value string) (*pb.Record, error) {
+	record, err := s.updateRecord(ctx, &pb.UpdateRecordRequest{Record: &pb.Record{Name: name, Value: value}})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// CompareAndSwap updates the record at name to value only if its
+// mod_revision is still modRevision, failing with codes.Aborted otherwise.
+func (s *Store) CompareAndSwap(ctx context.Context, name string, value string, modRevision int64) (*pb.Record, error) {
+	request := pb.UpdateRecordRequest{
+		Record:              &pb.Record{Name: name, Value: value},
+		ExpectedModRevision: modRevision,
+	}
+	record, err := s.updateRecord(ctx, &request)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Delete deletes a record and returns it as it was.
+func (s *Store) Delete(ctx context.Context, name string) (*pb.Record, error) {
+	record, err := s.deleteRecord(ctx, &pb.DeleteRecordRequest{Name: name})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// List returns every record matching request, following pages as needed.
+func (s *Store) List(ctx context.Context, request *pb.ListRecordsRequest) ([]*pb.Record, error) {
+	request = proto.Clone(request).(*pb.ListRecordsRequest)
+	var records []*pb.Record
+	for {
+		response, err := s.listRecords(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, response.Records...)
+		if response.NextPageToken == "" {
+			return records, nil
+		}
+		request.PageToken = response.NextPageToken
+	}
+}
+
+// Watch starts watching the records matching request. It fails with
+// codes.OutOfRange if the request's start revision is no longer retained.
+func (s *Store) Watch(ctx context.Context, request *pb.WatchRecordRequest) (*Watch, error) {
+	return s.watch(ctx, request)
+}
+
+// Watch delivers the events of a watch on a Store until it ends.
+type Watch struct {
+	events   chan *pb.Event
+	revision int64
+	cancel   context.CancelFunc
+	done     chan struct{}
+	err      error // Set before events is closed.
+}
+
+// Revision returns a revision after which every matching event is delivered.
+func (w *Watch) Revision() int64 {
+	return w.revision
+}
+
+// Events returns the watched events. It is closed when the watch ends.
+func (w *Watch) Events() <-chan *pb.Event {
+	return w.events
+}
+
+// Err returns why the watch ended once Events is closed. It is nil if the
+// watch was closed or its context ended.
+func (w *Watch) Err() error {
+	<-w.done
+	if w.err == context.Canceled || w.err == context.DeadlineExceeded {
+		return nil
+	}
+	return w.err
+}
+
+// Close ends the watch.
+func (w *Watch) Close() error {
+	w.cancel()
+	<-w.done
+	return nil
+}
+
+// storeServer implements the KeyValueStore service over a Store.
+type storeServer struct {
+	store *Store
+}
+
+// GetRecord sends the revision the read was served at in the
+// "read-revision" header.
+func (s *storeServer) GetRecord(ctx context.Context, request *pb.GetRecordRequest) (*pb.Record, error) {
+	record, revision, err := s.store.get(ctx, request)
+	if err != nil {
+		return &pb.Record{}, err
+	}
+	if err := grpc.SetHeader(ctx, metadata.Pairs("read-revision", strconv.FormatInt(revision, 10))); err != nil {
+		return &pb.Record{}, err
+	}
+	return record, nil
+}
+
+func (s *storeServer) CreateRecord(ctx context.Context, request *pb.CreateRecordRequest) (*pb.Record, error) {
+	return s.store.createRecord(ctx, request)
+}
+
+func (s *storeServer) UpdateRecord(ctx context.Context, request *pb.UpdateRecordRequest) (*pb.Record, error) {
+	return s.store.updateRecord(ctx, request)
+}
+
+func (s *storeServer) DeleteRecord(ctx context.Context, request *pb.DeleteRecordRequest) (*pb.Record, error) {
+	return s.store.deleteRecord(ctx, request)
+}
+
+func (s *storeServer) ListRecords(ctx context.Context, request *pb.ListRecordsRequest) (*pb.ListRecordsResponse, error) {
+	return s.store.listRecords(ctx, request)
+}
+
+func (s *storeServer) Txn(ctx context.Context, request *pb.TxnRequest) (*pb.TxnResponse, error) {
+	return s.store.txn(ctx, request)
+}
+
+func (s *storeServer) WatchRecord(request *pb.WatchRecordRequest, stream pb.KeyValueStore_WatchRecordServer) error {
+	return s.store.watchRecord(request, stream)
+}
+
+func (s *storeServer) GrantLease(ctx context.Context, request *pb.GrantLeaseRequest) (*pb.LeaseResponse, error) {
+	return s.store.grantLease(ctx, request)
+}
+
+func (s *storeServer) RevokeLease(ctx context.Context, request *pb.RevokeLeaseRequest) (*pb.LeaseResponse, error) {
+	return s.store.revokeLease(ctx, request)
+}
+
+func (s *storeServer) LeaseTimeToLive(ctx context.Context, request *pb.LeaseTimeToLiveRequest) (*pb.LeaseTimeToLiveResponse, error) {
+	return s.store.leaseTimeToLive(ctx, request)
+}
+
+func (s *storeServer) KeepAlive(stream pb.KeyValueStore_KeepAliveServer) error {
+	for {
+		request, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		response, err := s.store.keepAlive(stream.Context(), request)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(response); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+)
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// certReloader holds the server's certificate and the CAs trusted to sign
+// client certificates, reloading them whenever their files change.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	// The subjects of the client certificates accepted. Empty means any
+	// certificate signed by a trusted CA.
+	allowed map[string]bool
+
+	log *logger
+
+	mu     sync.Mutex
+	stamps []fileStamp
+	cert   *tls.Certificate
+	pool   *x509.CertPool
+}
+
+func newCertReloader(certFile string, keyFile string, caFile string, allowed []string, log *logger) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		allowed:  make(map[string]bool),
+		log:      log,
+	}
+	for _, subject := range allowed {
+		r.allowed[subject] = true
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) stampFiles() ([]fileStamp, error) {
+	files := []string{r.certFile, r.keyFile}
+	if r.caFile != "" {
+		files = append(files, r.caFile)
+	}
+	var stamps []fileStamp
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		stamps = append(stamps, fileStamp{modTime: info.ModTime(), size: info.Size()})
+	}
+	return stamps, nil
+}
+
+// reload reads the files. If they are invalid, for example because they are
+// partly written, the previous certificates are kept until the files change
+// again.
+func (r *certReloader) reload() error {
+	stamps, err := r.stampFiles()
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.stamps = stamps
+	r.mu.Unlock()
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		data, err := ioutil.ReadFile(r.caFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in '%s'", r.caFile)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.pool = pool
+	return nil
+}
+
+// current returns the certificate and CA pool, first reloading them if their
+// files have changed.
+func (r *certReloader) current() (*tls.Certificate, *x509.CertPool) {
+	stamps, err := r.stampFiles()
+	r.mu.Lock()
+	changed := err == nil && !reflect.DeepEqual(stamps, r.stamps)
+	r.mu.Unlock()
+	if changed {
+		if err := r.reload(); err != nil {
+			r.log.error(context.Background(), "Failed to reload TLS certificates", "error", err)
+		} else {
+			r.log.info(context.Background(), "Reloaded TLS certificates")
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert, r.pool
+}
+
+// serverConfig returns a TLS configuration for a listener that negotiates
+// one of nextProtos. Clients must present a certificate if there is a CA
+// file.
+func (r *certReloader) serverConfig(nextProtos ...string) *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+			config := &tls.Config{
+				Certificates: []tls.Certificate{*cert},
+				NextProtos:   nextProtos,
+				MinVersion:   tls.VersionTLS12,
+			}
+			if pool != nil {
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+				config.ClientCAs = pool
+				config.VerifyPeerCertificate = r.verifyClient
+			}
+			return config, nil
+		},
+	}
+}
+
+func (r *certReloader) verifyClient(_ [][]byte, chains [][]*x509.Certificate) error {
+	if len(r.allowed) == 0 {
+		return nil
+	}
+	subject := chains[0][0].Subject
+	if r.allowed[subject.CommonName] || r.allowed[subject.String()] {
+		return nil
+	}
+	return fmt.Errorf("client certificate subject '%s' is not allowed", subject)
+}
+
+// clientConfig returns a TLS configuration for dialing the other nodes of a
+// cluster, which presents this server's certificate. The CAs are those at
+// startup; only the certificate is reloaded.
+func (r *certReloader) clientConfig() *tls.Config {
+	_, pool := r.current()
+	return &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const tracerName = "github.com/gnossen/kvd/server"
+
+// propagator reads the trace context of incoming requests from their gRPC
+// metadata, in the W3C Trace Context and Baggage formats.
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// metadataCarrier adapts gRPC metadata to the propagator.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if values := metadata.MD(c).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key string, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// tracing starts a server span for each gRPC request, continuing the trace
+// of the client's call if it has one.
+type tracing struct {
+	tracer trace.Tracer
+}
+
+func (t *tracing) start(ctx context.Context, method string) (context.Context, trace.Span) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = propagator.Extract(ctx, metadataCarrier(md))
+	name := strings.TrimPrefix(method, "/")
+	attributes := []attribute.KeyValue{attribute.String("rpc.system", "grpc")}
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		attributes = append(attributes,
+			attribute.String("rpc.service", name[:i]),
+			attribute.String("rpc.method", name[i+1:]))
+	}
+	return t.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attributes...))
+}
+
+func endSpan(span trace.Span, err error) {
+	span.SetAttributes(attribute.Int64("rpc.grpc.status_code", int64(errorCode(err))))
+	if err != nil {
+		span.SetStatus(otelcodes.Error, status.Convert(err).Message())
+	}
+	span.End()
+}
+
+func (t *tracing) unaryInterceptor(ctx context.Context, request interface{},
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, span := t.start(ctx, info.FullMethod)
+	response, err := handler(ctx, request)
+	endSpan(span, err)
+	return response, err
+}
+
+func (t *tracing) streamInterceptor(srv interface{}, stream grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, span := t.start(stream.Context(), info.FullMethod)
+	err := handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+	endSpan(span, err)
+	return err
+}
+
+// startSpan starts a span for work done within a traced request. Outside of
+// one it starts nothing, so that background work, such as applying the
+// commands of other cluster members, does not start traces of its own.
+func (s *Store) startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	if ctx == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+	return s.tracer.Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// lock takes the store's write lock, tracing the wait for it.
+func (s *Store) lock(ctx context.Context) {
+	_, span := s.startSpan(ctx, "Store.Lock")
+	s.mu.Lock()
+	span.End()
+}
+
+// rlock takes the store's read lock, tracing the wait for it.
+func (s *Store) rlock(ctx context.Context) {
+	_, span := s.startSpan(ctx, "Store.RLock")
+	s.mu.RLock()
+	span.End()
+}
@@ -0,0 +1,312 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/gnossen/kvd/kvd"
+)
+
+const (
+	walFileName      = "wal"
+	snapshotFileName = "snapshot"
+
+	// Each frame is a little-endian payload length, the CRC-32C of the
+	// payload and the CRC-32C of those first eight bytes, followed by the
+	// marshalled payload. The header's own checksum tells a damaged length
+	// from a payload cut short by a torn write.
+	frameHeaderSize = 12
+	maxFrameSize    = 64 << 20
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errCorruptFrame = errors.New("corrupt frame")
+
+// writeAheadLog persists every mutation to an append-only log before it is
+// acknowledged. The log is periodically folded into a snapshot and truncated.
+type writeAheadLog struct {
+	dir              string
+	file             *os.File
+	entries          int
+	snapshotInterval int
+}
+
+// openWriteAheadLog recovers the contents of dir into mem by loading the latest
+// snapshot and replaying the log on top of it. A torn write at the end of the
+// log is discarded; damage anywhere else fails recovery.
+func openWriteAheadLog(dir string, snapshotInterval int, mem *memoryStorage) (*writeAheadLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := loadSnapshot(filepath.Join(dir, snapshotFileName), mem); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	w := &writeAheadLog{dir: dir, file: file, snapshotInterval: snapshotInterval}
+	if err := w.replay(mem); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *writeAheadLog) replay(mem *memoryStorage) error {
+	reader := bufio.NewReader(w.file)
+	var offset int64
+	for {
+		payload, err := readFrame(reader)
+		if err == io.EOF {
+			break
+		}
+		if err == errCorruptFrame {
+			return fmt.Errorf("corrupt log entry at offset %d: %v", offset, err)
+		}
+		if err == io.ErrUnexpectedEOF {
+			// The last write never completed. Drop it.
+			if err := w.file.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		events, err := decodeBatch(payload)
+		if err != nil {
+			return fmt.Errorf("corrupt log entry at offset %d: %v", offset, err)
+		}
+		for _, event := range events {
+			applyEvent(mem, event)
+		}
+		offset += int64(frameHeaderSize + len(payload))
+		w.entries++
+	}
+	_, err := w.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+// append durably records events as a single entry, so that either all or
+// none of them survive a crash. It returns only once the entry is on disk.
+func (w *writeAheadLog) append(events ...*pb.Event) error {
+	payload, err := encodeBatch(events)
+	if err != nil {
+		return err
+	}
+	offset, err := w.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if err := writeAndSync(w.file, frame(payload)); err != nil {
+		// Drop whatever part of the frame was written, so that the next
+		// entry does not follow a corrupt one.
+		rollBack(w.file, offset)
+		return err
+	}
+	w.entries++
+	return nil
+}
+
+func writeAndSync(file *os.File, buf []byte) error {
+	if _, err := file.Write(buf); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// rollBack truncates file to offset and moves back there. If it fails, the
+// partial write stays and recovery will refuse the log rather than lose
+// what follows.
+func rollBack(file *os.File, offset int64) {
+	if err := file.Truncate(offset); err == nil {
+		file.Seek(offset, io.SeekStart)
+	}
+}
+
+func (w *writeAheadLog) needsSnapshot() bool {
+	return w.snapshotInterval > 0 && w.entries >= w.snapshotInterval
+}
+
+// snapshot writes mem to a new snapshot file and truncates the log. Replaying
+// the log over a snapshot is idempotent, so a crash between the two steps is
+// harmless.
+//
+// The first frame of a snapshot holds the store revision. Each following
+// frame holds one record.
+func (w *writeAheadLog) snapshot(mem *memoryStorage) error {
+	path := filepath.Join(w.dir, snapshotFileName)
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmp)
+	var revision [8]byte
+	binary.LittleEndian.PutUint64(revision[:], uint64(mem.revision))
+	if _, err := writer.Write(frame(revision[:])); err != nil {
+		tmp.Close()
+		return err
+	}
+	for _, record := range mem.m {
+		payload, err := proto.Marshal(record)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		if _, err := writer.Write(frame(payload)); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	if err := syncDir(w.dir); err != nil {
+		return err
+	}
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	w.entries = 0
+	return w.file.Sync()
+}
+
+func (w *writeAheadLog) close() error {
+	return w.file.Close()
+}
+
+func loadSnapshot(path string, mem *memoryStorage) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	revision, err := readFrame(reader)
+	if err != nil || len(revision) != 8 {
+		return fmt.Errorf("corrupt snapshot %s: missing revision", path)
+	}
+	mem.observe(int64(binary.LittleEndian.Uint64(revision)))
+	for {
+		payload, err := readFrame(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// Snapshots are renamed into place only once complete, so any
+			// damage here is real corruption rather than a torn write.
+			return fmt.Errorf("corrupt snapshot %s: %v", path, err)
+		}
+		var record pb.Record
+		if err := proto.Unmarshal(payload, &record); err != nil {
+			return fmt.Errorf("corrupt snapshot %s: %v", path, err)
+		}
+		mem.put(&record)
+	}
+}
+
+// A log entry is a sequence of marshalled events, each preceded by its
+// length as a uvarint.
+func encodeBatch(events []*pb.Event) ([]byte, error) {
+	var buf []byte
+	var size [binary.MaxVarintLen64]byte
+	for _, event := range events {
+		payload, err := proto.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, size[:binary.PutUvarint(size[:], uint64(len(payload)))]...)
+		buf = append(buf, payload...)
+	}
+	return buf, nil
+}
+
+func decodeBatch(buf []byte) ([]*pb.Event, error) {
+	var events []*pb.Event
+	for len(buf) > 0 {
+		size, n := binary.Uvarint(buf)
+		if n <= 0 || uint64(len(buf)-n) < size {
+			return nil, errCorruptFrame
+		}
+		var event pb.Event
+		if err := proto.Unmarshal(buf[n:n+int(size)], &event); err != nil {
+			return nil, err
+		}
+		events = append(events, &event)
+		buf = buf[n+int(size):]
+	}
+	return events, nil
+}
+
+func frame(payload []byte) []byte {
+	buf := make([]byte, frameHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.Checksum(payload, crcTable))
+	binary.LittleEndian.PutUint32(buf[8:12], crc32.Checksum(buf[0:8], crcTable))
+	copy(buf[frameHeaderSize:], payload)
+	return buf
+}
+
+// readFrame returns the payload of the next frame. It fails with
+// io.ErrUnexpectedEOF only if the frame is cut short by the end of the file,
+// as a torn write leaves it, and with errCorruptFrame if it is damaged.
+func readFrame(reader io.Reader) ([]byte, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, err
+	}
+	if crc32.Checksum(header[0:8], crcTable) != binary.LittleEndian.Uint32(header[8:12]) {
+		return nil, errCorruptFrame
+	}
+	size := binary.LittleEndian.Uint32(header[0:4])
+	if size > maxFrameSize {
+		return nil, errCorruptFrame
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.LittleEndian.Uint32(header[4:8]) {
+		return nil, errCorruptFrame
+	}
+	return payload, nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
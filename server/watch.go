@@ -0,0 +1,201 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/gnossen/kvd/kvd"
+)
+
+// SlowConsumerPolicy decides what happens to a watcher whose queue of
+// undelivered events is full.
+type SlowConsumerPolicy int
+
+const (
+	// DisconnectSlowConsumers ends the watch with codes.ResourceExhausted.
+	DisconnectSlowConsumers SlowConsumerPolicy = iota
+
+	// ResyncSlowConsumers drops the queued events and sends a RESYNC event
+	// in their place, telling the client to re-read the watched records.
+	ResyncSlowConsumers
+
+	// CoalesceSlowConsumers keeps only the latest queued event for each
+	// record. If the queue is still full, it falls back to a resync.
+	CoalesceSlowConsumers
+)
+
+var slowConsumerPolicyNames = map[SlowConsumerPolicy]string{
+	DisconnectSlowConsumers: "disconnect",
+	ResyncSlowConsumers:     "resync",
+	CoalesceSlowConsumers:   "coalesce",
+}
+
+func (p SlowConsumerPolicy) String() string {
+	if name, ok := slowConsumerPolicyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("SlowConsumerPolicy(%d)", int(p))
+}
+
+// ParseSlowConsumerPolicy returns the policy named "disconnect", "resync" or
+// "coalesce".
+func ParseSlowConsumerPolicy(name string) (SlowConsumerPolicy, error) {
+	for policy, policyName := range slowConsumerPolicyNames {
+		if name == policyName {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown slow consumer policy '%s'", name)
+}
+
+// watcher buffers events for a single WatchRecord stream. Writers push
+// events while holding the store lock, so push never blocks; the stream
+// drains the queue on its own goroutine.
+type watcher struct {
+	key    string
+	limit  int
+	policy SlowConsumerPolicy
+
+	// Range watchers receive events for every name in [key, end). An empty
+	// end means no upper bound.
+	isRange bool
+	end     string
+
+	// Counts the events dropped by the slow consumer policy, if set.
+	dropped prometheus.Counter
+
+	mu    sync.Mutex
+	queue []*pb.Event
+	err   error
+	ready chan struct{}
+}
+
+func newWatcher(key string, limit int, policy SlowConsumerPolicy) *watcher {
+	return &watcher{
+		key:    key,
+		limit:  limit,
+		policy: policy,
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+func (w *watcher) contains(name string) bool {
+	if isReserved(name) {
+		return false
+	}
+	if !w.isRange {
+		return name == w.key
+	}
+	return name >= w.key && (w.end == "" || name < w.end)
+}
+
+func (w *watcher) push(event *pb.Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return
+	}
+	if len(w.queue) >= w.limit {
+		w.overflowLocked(event)
+	} else {
+		w.queue = append(w.queue, event)
+	}
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (w *watcher) overflowLocked(event *pb.Event) {
+	switch w.policy {
+	case DisconnectSlowConsumers:
+		w.countDropped(countEvents(w.queue) + 1)
+		w.queue = nil
+		w.err = status.Errorf(codes.ResourceExhausted,
+			"Watch on key '%s' fell more than %d events behind.",
+			w.key, w.limit)
+		return
+	case CoalesceSlowConsumers:
+		coalesced := w.queue[:0]
+		for _, queued := range w.queue {
+			if queued.Type == pb.Event_RESYNC || queued.Record.Name != event.Record.Name {
+				coalesced = append(coalesced, queued)
+			}
+		}
+		w.countDropped(len(w.queue) - len(coalesced))
+		w.queue = append(coalesced, event)
+		if len(w.queue) <= w.limit {
+			return
+		}
+	}
+	dropped := w.queue
+	if w.policy == CoalesceSlowConsumers {
+		// The new event is already queued, and is kept.
+		dropped = dropped[:len(dropped)-1]
+	}
+	w.countDropped(countEvents(dropped))
+	w.queue = []*pb.Event{
+		{Type: pb.Event_RESYNC, Record: &pb.Record{Name: w.key}},
+		event,
+	}
+}
+
+func (w *watcher) countDropped(n int) {
+	if w.dropped != nil {
+		w.dropped.Add(float64(n))
+	}
+}
+
+// countEvents counts the events other than RESYNC events.
+func countEvents(events []*pb.Event) int {
+	n := 0
+	for _, event := range events {
+		if event.Type != pb.Event_RESYNC {
+			n++
+		}
+	}
+	return n
+}
+
+// resync replaces the queued events with a RESYNC event.
+func (w *watcher) resync() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return
+	}
+	w.countDropped(countEvents(w.queue))
+	w.queue = []*pb.Event{{Type: pb.Event_RESYNC, Record: &pb.Record{Name: w.key}}}
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
+
+// fail ends the watch with err once the queued events have been sent.
+func (w *watcher) fail(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return
+	}
+	w.err = err
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
+
+// drain takes every queued event. A non-nil error means the watch must end
+// once the events have been sent.
+func (w *watcher) drain() ([]*pb.Event, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	events := w.queue
+	w.queue = nil
+	return events, w.err
+}
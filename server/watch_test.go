@@ -0,0 +1,123 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/gnossen/kvd/kvd"
+)
+
+func put(name string, value string) *pb.Event {
+	return &pb.Event{Type: pb.Event_PUT, Record: &pb.Record{Name: name, Value: value}}
+}
+
+func describe(events []*pb.Event) []string {
+	var descriptions []string
+	for _, event := range events {
+		descriptions = append(descriptions, event.Type.String()+" "+event.Record.Name+"="+event.Record.Value)
+	}
+	return descriptions
+}
+
+func expectEvents(t *testing.T, events []*pb.Event, expected ...string) {
+	got := describe(events)
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, got)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, got)
+		}
+	}
+}
+
+func TestDisconnectSlowConsumers(t *testing.T) {
+	w := newWatcher("foo", 2, DisconnectSlowConsumers)
+	w.push(put("foo", "1"))
+	w.push(put("foo", "2"))
+	w.push(put("foo", "3"))
+	w.push(put("foo", "4"))
+	events, err := w.drain()
+	if len(events) != 0 || status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("Expected ResourceExhausted and no events, got %v, %v", describe(events), err)
+	}
+}
+
+func TestResyncSlowConsumers(t *testing.T) {
+	w := newWatcher("foo", 2, ResyncSlowConsumers)
+	w.push(put("foo", "1"))
+	w.push(put("foo", "2"))
+	w.push(put("foo", "3"))
+	events, err := w.drain()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectEvents(t, events, "RESYNC foo=", "PUT foo=3")
+	w.push(put("foo", "4"))
+	events, _ = w.drain()
+	expectEvents(t, events, "PUT foo=4")
+}
+
+func TestCoalesceSlowConsumers(t *testing.T) {
+	w := newWatcher("foo", 2, CoalesceSlowConsumers)
+	w.push(put("foo", "1"))
+	w.push(put("foo", "2"))
+	w.push(put("foo", "3"))
+	events, err := w.drain()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectEvents(t, events, "PUT foo=3")
+}
+
+func TestCoalesceFallsBackToResync(t *testing.T) {
+	w := newWatcher("", 2, CoalesceSlowConsumers)
+	w.push(put("a", "1"))
+	w.push(put("b", "1"))
+	w.push(put("a", "2"))
+	events, _ := w.drain()
+	expectEvents(t, events, "PUT b=1", "PUT a=2")
+	w.push(put("a", "3"))
+	w.push(put("b", "2"))
+	w.push(put("c", "1"))
+	events, _ = w.drain()
+	expectEvents(t, events, "RESYNC =", "PUT c=1")
+}
+
+func TestDroppedEvents(t *testing.T) {
+	for _, test := range []struct {
+		policy   SlowConsumerPolicy
+		expected float64
+	}{
+		{DisconnectSlowConsumers, 3},
+		{ResyncSlowConsumers, 4},
+		{CoalesceSlowConsumers, 4},
+	} {
+		w := newWatcher("", 2, test.policy)
+		w.dropped = prometheus.NewCounter(prometheus.CounterOpts{Name: "dropped"})
+		w.push(put("a", "1"))
+		w.push(put("a", "2"))
+		w.push(put("a", "3"))
+		w.push(put("b", "1"))
+		w.push(put("c", "1"))
+		if dropped := testutil.ToFloat64(w.dropped); dropped != test.expected {
+			t.Fatalf("Expected %v to drop %v events, got %v", test.policy, test.expected, dropped)
+		}
+	}
+}
+
+func TestParseSlowConsumerPolicy(t *testing.T) {
+	for _, policy := range []SlowConsumerPolicy{DisconnectSlowConsumers, ResyncSlowConsumers, CoalesceSlowConsumers} {
+		parsed, err := ParseSlowConsumerPolicy(policy.String())
+		if err != nil || parsed != policy {
+			t.Fatalf("Expected %v, got %v, %v", policy, parsed, err)
+		}
+	}
+	if _, err := ParseSlowConsumerPolicy("block"); err == nil {
+		t.Fatalf("Expected an error for an unknown policy")
+	}
+}
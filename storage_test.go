@@ -0,0 +1,156 @@
+package kvd
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/gnossen/kvd/client"
+	pb "github.com/gnossen/kvd/kvd"
+	"github.com/gnossen/kvd/server"
+	"github.com/golang/protobuf/proto"
+)
+
+// engines lists every storage engine. Each returns a fresh, empty Storage
+// and a cleanup function.
+var engines = map[string]func(t *testing.T) (server.Storage, func()){
+	"memory": func(t *testing.T) (server.Storage, func()) {
+		return server.NewMemoryStorage(), func() {}
+	},
+	"disk": func(t *testing.T) (server.Storage, func()) {
+		dir := tempDir(t)
+		storage, err := server.OpenDiskStorage(dir, 4)
+		if err != nil {
+			t.Fatalf("failed to open disk storage: %v", err)
+		}
+		return storage, func() {
+			storage.Close()
+			os.RemoveAll(dir)
+		}
+	},
+}
+
+func rec(name string, value string, revision int64) *pb.Record {
+	return &pb.Record{Name: name, Value: value, CreateRevision: revision, ModRevision: revision, Version: 1}
+}
+
+func collectRange(t *testing.T, storage server.Storage, start string, end string) []string {
+	var names []string
+	err := storage.Range(start, end, func(record *pb.Record) bool {
+		names = append(names, record.Name)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("Range failed: %v", err)
+	}
+	return names
+}
+
+func testStorageConformance(t *testing.T, storage server.Storage) {
+	if _, exists, _ := storage.Get("a"); exists {
+		t.Fatalf("Expected empty storage")
+	}
+	if storage.Revision() != 0 {
+		t.Fatalf("Expected revision 0, got %d", storage.Revision())
+	}
+	if ok, err := storage.PutIfPresent(rec("a", "1", 1)); ok || err != nil {
+		t.Fatalf("PutIfPresent on missing key: %v, %v", ok, err)
+	}
+	if ok, err := storage.PutIfAbsent(rec("a", "1", 1)); !ok || err != nil {
+		t.Fatalf("PutIfAbsent on missing key: %v, %v", ok, err)
+	}
+	if ok, err := storage.PutIfAbsent(rec("a", "2", 2)); ok || err != nil {
+		t.Fatalf("PutIfAbsent on existing key: %v, %v", ok, err)
+	}
+	if record, exists, _ := storage.Get("a"); !exists || !proto.Equal(record, rec("a", "1", 1)) {
+		t.Fatalf("Expected '%v', got '%v' (%v)", rec("a", "1", 1), record, exists)
+	}
+	updated := &pb.Record{Name: "a", Value: "3", CreateRevision: 1, ModRevision: 2, Version: 2}
+	if ok, err := storage.PutIfPresent(updated); !ok || err != nil {
+		t.Fatalf("PutIfPresent on existing key: %v, %v", ok, err)
+	}
+	if record, _, _ := storage.Get("a"); !proto.Equal(record, updated) {
+		t.Fatalf("Expected '%v', got '%v'", updated, record)
+	}
+	for i, name := range []string{"d", "b", "c", "e"} {
+		storage.PutIfAbsent(rec(name, name, int64(i+3)))
+	}
+	if storage.Revision() != 6 {
+		t.Fatalf("Expected revision 6, got %d", storage.Revision())
+	}
+	expected := []string{"a", "b", "c", "d", "e"}
+	if names := collectRange(t, storage, "", ""); !reflect.DeepEqual(names, expected) {
+		t.Fatalf("Expected '%v', got '%v'", expected, names)
+	}
+	if names := collectRange(t, storage, "b", "d"); !reflect.DeepEqual(names, expected[1:3]) {
+		t.Fatalf("Expected '%v', got '%v'", expected[1:3], names)
+	}
+	count := 0
+	storage.Range("", "", func(record *pb.Record) bool {
+		count++
+		return count < 2
+	})
+	if count != 2 {
+		t.Fatalf("Expected Range to stop after 2 records, got %d", count)
+	}
+	if record, ok, err := storage.Delete("c", 7); !ok || err != nil || record.Value != "c" {
+		t.Fatalf("Delete on existing key: '%v', %v, %v", record, ok, err)
+	}
+	if _, ok, err := storage.Delete("c", 8); ok || err != nil {
+		t.Fatalf("Delete on missing key: %v, %v", ok, err)
+	}
+	if storage.Revision() != 7 {
+		t.Fatalf("Expected revision 7, got %d", storage.Revision())
+	}
+	err := storage.Commit([]*pb.Event{
+		{Type: pb.Event_PUT, Record: rec("f", "f", 8)},
+		{Type: pb.Event_DELETE, Record: &pb.Record{Name: "e", ModRevision: 8}},
+	})
+	if err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if storage.Revision() != 8 {
+		t.Fatalf("Expected revision 8, got %d", storage.Revision())
+	}
+	var snapshot []string
+	storage.Snapshot(func(record *pb.Record) bool {
+		snapshot = append(snapshot, record.Name)
+		return true
+	})
+	sort.Strings(snapshot)
+	expected = []string{"a", "b", "d", "f"}
+	if !reflect.DeepEqual(snapshot, expected) {
+		t.Fatalf("Expected '%v', got '%v'", expected, snapshot)
+	}
+}
+
+func TestStorageConformance(t *testing.T) {
+	for name, newStorage := range engines {
+		t.Run(name, func(t *testing.T) {
+			storage, cleanup := newStorage(t)
+			defer cleanup()
+			testStorageConformance(t, storage)
+		})
+	}
+}
+
+func TestServerStorage(t *testing.T) {
+	for name, newStorage := range engines {
+		t.Run(name, func(t *testing.T) {
+			storage, cleanup := newStorage(t)
+			defer cleanup()
+			withServer(t, []server.ServerOption{server.WithStorage(storage)}, func(cl pb.KeyValueStoreClient) {
+				client.Create(cl, "foo", "1")
+				client.Update(cl, "foo", "2")
+				client.Create(cl, "bar", "3")
+				client.Delete(cl, "bar")
+				expectValue(t, cl, "foo", "2")
+				expectMissing(t, cl, "bar")
+			})
+			if record, _, _ := storage.Get("foo"); record.Value != "2" {
+				t.Fatalf("Expected '2' in storage, got '%s'", record.Value)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package kvd
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/gnossen/kvd/client"
+	pb "github.com/gnossen/kvd/kvd"
+	"github.com/gnossen/kvd/server"
+)
+
+func expectStoreEvent(t *testing.T, w *server.Watch, eventType pb.Event_EventType, name string, value string) {
+	event, ok := <-w.Events()
+	if !ok {
+		t.Fatalf("Watch ended early: %v", w.Err())
+	}
+	if event.Type != eventType || event.Record.Name != name || event.Record.Value != value {
+		t.Fatalf("Expected %v of '%s' = '%s', got %v", eventType, name, value, event)
+	}
+}
+
+func TestStore(t *testing.T) {
+	s, err := server.NewStore()
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	ctx := context.Background()
+	w, err := s.Watch(ctx, &pb.WatchRecordRequest{Name: "foo/", Prefix: true})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	created, err := s.Create(ctx, "foo/a", "1")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := s.Create(ctx, "foo/a", "1"); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("Expected AlreadyExists, got %v", err)
+	}
+	if _, err := s.Update(ctx, "foo/a", "2"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if _, err := s.CompareAndSwap(ctx, "foo/a", "3", created.ModRevision); status.Code(err) != codes.Aborted {
+		t.Fatalf("Expected Aborted for a stale revision, got %v", err)
+	}
+	if _, err := s.Create(ctx, "foo/b", "1"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := s.Create(ctx, "bar", "1"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if record, err := s.Get(ctx, "foo/a"); err != nil || record.Value != "2" {
+		t.Fatalf("Expected '2' at 'foo/a', got %v, %v", record, err)
+	}
+	records, err := s.List(ctx, &pb.ListRecordsRequest{Prefix: "foo/", PageSize: 1})
+	if err != nil || len(records) != 2 {
+		t.Fatalf("Expected both records under 'foo/', got %v, %v", records, err)
+	}
+	if _, err := s.Delete(ctx, "foo/a"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := s.Get(ctx, "foo/a"); status.Code(err) != codes.NotFound {
+		t.Fatalf("Expected NotFound, got %v", err)
+	}
+	expectStoreEvent(t, w, pb.Event_PUT, "foo/a", "1")
+	expectStoreEvent(t, w, pb.Event_PUT, "foo/a", "2")
+	expectStoreEvent(t, w, pb.Event_PUT, "foo/b", "1")
+	expectStoreEvent(t, w, pb.Event_DELETE, "foo/a", "")
+	w.Close()
+	if _, ok := <-w.Events(); ok || w.Err() != nil {
+		t.Fatalf("Expected a closed watch to end cleanly, got %v", w.Err())
+	}
+
+	// Closing the store ends its watches.
+	w, err = s.Watch(ctx, &pb.WatchRecordRequest{Name: "bar"})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	for range w.Events() {
+	}
+	if status.Code(w.Err()) != codes.Unavailable {
+		t.Fatalf("Expected Unavailable once the store closed, got %v", w.Err())
+	}
+}
+
+func TestStoreOverBufconn(t *testing.T) {
+	lis := bufconn.Listen(1 << 20)
+	s, err := server.New(server.WithListener(lis))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	go s.Serve()
+	defer s.Stop()
+	dialer := func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}
+	conn, err := grpc.Dial("bufnet", grpc.WithContextDialer(dialer), grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		t.Fatalf("fail to dial: %v", err)
+	}
+	defer conn.Close()
+	c := client.NewClient(pb.NewKeyValueStoreClient(conn))
+
+	ctx := context.Background()
+	w, err := c.Watch(ctx, &pb.WatchRecordRequest{Name: "foo"})
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	defer w.Close()
+	// The process and the server's clients share the same records.
+	if _, err := s.Store().Create(ctx, "foo", "oof"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	expectEvent(t, w, pb.Event_PUT, "foo", "oof")
+	if _, err := c.Update(ctx, "foo", "bar"); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if record, err := s.Store().Get(ctx, "foo"); err != nil || record.Value != "bar" {
+		t.Fatalf("Expected 'bar' at 'foo', got %v, %v", record, err)
+	}
+}
+
+func TestStoreReservedNames(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	s, err := server.NewStore(server.WithDataDir(dir))
+	if err != nil {
+		t.Fatalf("NewStore failed: %v", err)
+	}
+	ctx := context.Background()
+	name := "\x00lease/next"
+	if _, err := s.Create(ctx, name, "garbage"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument creating '%s', got %v", name, err)
+	}
+	if _, err := s.Update(ctx, name, "garbage"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument updating '%s', got %v", name, err)
+	}
+	if _, err := s.Get(ctx, name); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument reading '%s', got %v", name, err)
+	}
+	if _, err := s.Delete(ctx, name); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument deleting '%s', got %v", name, err)
+	}
+	if _, err := s.List(ctx, &pb.ListRecordsRequest{Prefix: "\x00auth/"}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument listing reserved keys, got %v", err)
+	}
+	if _, err := s.Watch(ctx, &pb.WatchRecordRequest{Name: name}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument watching '%s', got %v", name, err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	// Nothing reserved was written, so the store opens again.
+	s, err = server.NewStore(server.WithDataDir(dir))
+	if err != nil {
+		t.Fatalf("NewStore failed after reserved writes were refused: %v", err)
+	}
+	s.Close()
+}
@@ -0,0 +1,186 @@
+package kvd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/gnossen/kvd/kvd"
+	"github.com/gnossen/kvd/server"
+)
+
+// testCA issues certificates for tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pool: pool,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue returns a PEM certificate and key for localhost, usable by both
+// servers and clients.
+func (ca *testCA) issue(t *testing.T, commonName string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatalf("failed to generate serial number: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func (ca *testCA) keyPair(t *testing.T, commonName string) tls.Certificate {
+	certPEM, keyPEM := ca.issue(t, commonName)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("failed to load key pair: %v", err)
+	}
+	return cert
+}
+
+func writeFile(t *testing.T, dir string, name string, data []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+	return path
+}
+
+// tryDial reports whether a GetRecord over a connection with the given
+// options gets an answer from the server.
+func tryDial(t *testing.T, opt grpc.DialOption) bool {
+	conn, err := grpc.Dial("localhost:1234", opt)
+	if err != nil {
+		t.Fatalf("fail to dial: %v", err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = pb.NewKeyValueStoreClient(conn).GetRecord(ctx, &pb.GetRecordRequest{Name: "foo"})
+	return status.Code(err) == codes.NotFound
+}
+
+func withTLS(pool *x509.CertPool, certs ...tls.Certificate) grpc.DialOption {
+	return grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: pool, Certificates: certs}))
+}
+
+func TestTLS(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, "ca")
+	certPEM, keyPEM := ca.issue(t, "server")
+	s := startServer(t,
+		server.WithTLS(writeFile(t, dir, "server.pem", certPEM), writeFile(t, dir, "server.key", keyPEM), writeFile(t, dir, "ca.pem", ca.pem)),
+		server.WithAllowedClients("alice"))
+	defer s.Stop()
+
+	if !tryDial(t, withTLS(ca.pool, ca.keyPair(t, "alice"))) {
+		t.Fatalf("Expected an allowed client to connect")
+	}
+	if tryDial(t, withTLS(ca.pool, ca.keyPair(t, "mallory"))) {
+		t.Fatalf("Expected a client that is not allowed to be refused")
+	}
+	if tryDial(t, withTLS(ca.pool, newTestCA(t, "other").keyPair(t, "alice"))) {
+		t.Fatalf("Expected a client signed by an unknown CA to be refused")
+	}
+	if tryDial(t, withTLS(ca.pool)) {
+		t.Fatalf("Expected a client without a certificate to be refused")
+	}
+	if tryDial(t, grpc.WithInsecure()) {
+		t.Fatalf("Expected an unencrypted client to be refused")
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t, "ca")
+	certPEM, keyPEM := ca.issue(t, "server")
+	certFile, keyFile := writeFile(t, dir, "server.pem", certPEM), writeFile(t, dir, "server.key", keyPEM)
+	s := startServer(t, server.WithTLS(certFile, keyFile, ""))
+	defer s.Stop()
+
+	if !tryDial(t, withTLS(ca.pool)) {
+		t.Fatalf("Expected the server's certificate to be trusted")
+	}
+	rotated := newTestCA(t, "rotated")
+	certPEM, keyPEM = rotated.issue(t, "server")
+	writeFile(t, dir, "server.pem", certPEM)
+	writeFile(t, dir, "server.key", keyPEM)
+	if !tryDial(t, withTLS(rotated.pool)) {
+		t.Fatalf("Expected the rotated certificate to be served")
+	}
+	if tryDial(t, withTLS(ca.pool)) {
+		t.Fatalf("Expected the old certificate to be replaced")
+	}
+}
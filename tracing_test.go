@@ -0,0 +1,93 @@
+package kvd
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+
+	"github.com/gnossen/kvd/client"
+	pb "github.com/gnossen/kvd/kvd"
+	"github.com/gnossen/kvd/server"
+)
+
+// findSpan returns the ended span with name and kind whose parent is parent.
+func findSpan(t *testing.T, recorder *tracetest.SpanRecorder, name string, kind trace.SpanKind, parent trace.SpanContext) sdktrace.ReadOnlySpan {
+	var spans []string
+	for _, span := range recorder.Ended() {
+		if span.Name() == name && span.SpanKind() == kind && span.Parent().SpanID() == parent.SpanID() {
+			return span
+		}
+		spans = append(spans, span.Name())
+	}
+	t.Fatalf("Expected a %v span %s under %v, got %v", kind, name, parent.SpanID(), spans)
+	return nil
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	withServer(t, []server.ServerOption{server.WithTracerProvider(provider)}, func(pb.KeyValueStoreClient) {
+		opts := append(client.WithTracing(provider), grpc.WithInsecure(), grpc.WithBlock())
+		conn, err := grpc.Dial("localhost:1234", opts...)
+		if err != nil {
+			t.Fatalf("fail to dial: %v", err)
+		}
+		defer conn.Close()
+		c := client.NewClient(pb.NewKeyValueStoreClient(conn))
+
+		ctx, root := provider.Tracer("test").Start(context.Background(), "test")
+		watchCtx, cancel := context.WithCancel(ctx)
+		w, err := c.Watch(watchCtx, &pb.WatchRecordRequest{Name: "foo"})
+		if err != nil {
+			t.Fatalf("Watch failed: %v", err)
+		}
+		if _, err := c.Create(ctx, "foo", "oof"); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		expectEvent(t, w, pb.Event_PUT, "foo", "oof")
+		if _, err := c.Get(ctx, "foo"); err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		cancel()
+		w.Close()
+		root.End()
+
+		rootContext := root.SpanContext()
+		for _, method := range []string{"GetRecord", "CreateRecord", "WatchRecord"} {
+			name := "key_value.KeyValueStore/" + method
+			clientSpan := findSpan(t, recorder, name, trace.SpanKindClient, rootContext)
+			// The server's span may end just after the client's.
+			var serverSpan sdktrace.ReadOnlySpan
+			eventually(t, func() error {
+				for _, span := range recorder.Ended() {
+					if span.Name() == name && span.SpanKind() == trace.SpanKindServer {
+						serverSpan = span
+						return nil
+					}
+				}
+				return fmt.Errorf("no server span %s", name)
+			})
+			if serverSpan.Parent().SpanID() != clientSpan.SpanContext().SpanID() ||
+				serverSpan.SpanContext().TraceID() != rootContext.TraceID() {
+				t.Fatalf("Expected the server's %s span to continue the client's trace", method)
+			}
+			switch method {
+			case "GetRecord":
+				findSpan(t, recorder, "Store.RLock", trace.SpanKindInternal, serverSpan.SpanContext())
+				findSpan(t, recorder, "storage.Get", trace.SpanKindInternal, serverSpan.SpanContext())
+			case "CreateRecord":
+				findSpan(t, recorder, "Store.Lock", trace.SpanKindInternal, serverSpan.SpanContext())
+				apply := findSpan(t, recorder, "Store.apply", trace.SpanKindInternal, serverSpan.SpanContext())
+				findSpan(t, recorder, "Store.notify", trace.SpanKindInternal, apply.SpanContext())
+			case "WatchRecord":
+				findSpan(t, recorder, "Store.deliver", trace.SpanKindInternal, serverSpan.SpanContext())
+			}
+		}
+	})
+	provider.Shutdown(context.Background())
+}